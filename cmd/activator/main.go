@@ -78,6 +78,18 @@ type config struct {
 	// TODO: run loadtests using these flags to determine optimal default values.
 	MaxIdleProxyConns        int `split_words:"true" default:"1000"`
 	MaxIdleProxyConnsPerHost int `split_words:"true" default:"100"`
+
+	// These configure the backoff of requests retrying to get a pod of a
+	// revision, e.g. when many of them wake a revision scaled to zero.
+	ThrottlerRetryInitialBackoff time.Duration `split_words:"true" default:"5ms"`
+	ThrottlerRetryMaxBackoff     time.Duration `split_words:"true" default:"1s"`
+	ThrottlerRetryBackoffFactor  float64       `split_words:"true" default:"2"`
+	ThrottlerRetryJitter         float64       `split_words:"true" default:"0.5"`
+
+	// StickyRoutingHeader, if set, makes requests with the same value of this
+	// header prefer the same pod of a revision, e.g. to make use of warm
+	// caches.
+	StickyRoutingHeader string `split_words:"true"`
 }
 
 func main() {
@@ -153,7 +165,16 @@ func main() {
 	}
 
 	// Start throttler.
-	throttler := activatornet.NewThrottler(ctx, env.PodIP)
+	throttlerOpts := []activatornet.ThrottlerOption{activatornet.WithRetryBackoff(activatornet.RetryBackoff{
+		Initial: env.ThrottlerRetryInitialBackoff,
+		Max:     env.ThrottlerRetryMaxBackoff,
+		Factor:  env.ThrottlerRetryBackoffFactor,
+		Jitter:  env.ThrottlerRetryJitter,
+	})}
+	if env.StickyRoutingHeader != "" {
+		throttlerOpts = append(throttlerOpts, activatornet.WithStickyRouting())
+	}
+	throttler := activatornet.NewThrottler(ctx, env.PodIP, throttlerOpts...)
 	go throttler.Run(ctx, transport, networkConfig.EnableMeshPodAddressability)
 
 	oct := tracing.NewOpenCensusTracer(tracing.WithExporterFull(networking.ActivatorServiceName, env.PodIP, logger))
@@ -188,6 +209,9 @@ func main() {
 	// Create activation handler chain
 	// Note: innermost handlers are specified first, ie. the last handler in the chain will be executed first
 	ah := activatorhandler.New(ctx, throttler, transport, networkConfig.EnableMeshPodAddressability, logger)
+	if env.StickyRoutingHeader != "" {
+		ah = activatorhandler.NewStickyHandler(env.StickyRoutingHeader, ah)
+	}
 	ah = concurrencyReporter.Handler(ah)
 	ah = activatorhandler.NewTracingHandler(ah)
 	reqLogHandler, err := pkghttp.NewRequestLogHandler(ah, logging.NewSyncFileWriter(os.Stdout), "",
@@ -48,6 +48,7 @@ import (
 	pkghttp "knative.dev/serving/pkg/http"
 	"knative.dev/serving/pkg/http/handler"
 	"knative.dev/serving/pkg/logging"
+	servingmetrics "knative.dev/serving/pkg/metrics"
 	"knative.dev/serving/pkg/networking"
 	"knative.dev/serving/pkg/queue"
 	"knative.dev/serving/pkg/queue/health"
@@ -62,6 +63,31 @@ const (
 	// This is to give networking a little bit more time to remove the pod
 	// from its configuration and propagate that to all loadbalancers and nodes.
 	drainSleepDuration = 30 * time.Second
+
+	// drainLogPeriod is the interval of time between logging the number of
+	// requests left while draining the breaker.
+	drainLogPeriod = 1 * time.Second
+
+	// defaultIdempotencyCacheEntries is the number of responses kept for
+	// replay when idempotency keys are enabled without an explicit size.
+	defaultIdempotencyCacheEntries = 1000
+
+	// defaultThinkTimeClients is the number of clients tracked when a minimum
+	// think time is enabled without an explicit size.
+	defaultThinkTimeClients = 10000
+
+	// defaultClientConcurrencyClients is the number of clients whose
+	// concurrency is tracked when not configured explicitly.
+	defaultClientConcurrencyClients = 10000
+
+	// defaultDebugCaptureEntries and defaultDebugCaptureBodyBytes bound the
+	// memory used by the debug capture sink when not configured explicitly.
+	defaultDebugCaptureEntries   = 100
+	defaultDebugCaptureBodyBytes = 64 << 10
+
+	// breakerLogWaitSamples is the number of recent admission waits the
+	// breaker keeps for the percentiles in its log summaries.
+	breakerLogWaitSamples = 1000
 )
 
 var (
@@ -72,13 +98,91 @@ var (
 )
 
 type config struct {
-	ContainerConcurrency     int    `split_words:"true" required:"true"`
-	QueueServingPort         string `split_words:"true" required:"true"`
-	UserPort                 string `split_words:"true" required:"true"`
-	RevisionTimeoutSeconds   int    `split_words:"true" required:"true"`
-	ServingReadinessProbe    string `split_words:"true" required:"true"`
-	EnableProfiling          bool   `split_words:"true"` // optional
-	EnableHTTP2AutoDetection bool   `split_words:"true"` // optional
+	ContainerConcurrency     int           `split_words:"true" required:"true"`
+	QueueServingPort         string        `split_words:"true" required:"true"`
+	UserPort                 string        `split_words:"true" required:"true"`
+	RevisionTimeoutSeconds   int           `split_words:"true" required:"true"`
+	ServingReadinessProbe    string        `split_words:"true" required:"true"`
+	EnableProfiling          bool          `split_words:"true"` // optional
+	EnableHTTP2AutoDetection bool          `split_words:"true"` // optional
+	EnableServerTimingHeader bool          `split_words:"true"` // optional
+	EnableQueueWaitHeader    bool          `split_words:"true"` // optional
+	MaxRequestURILength      int           `split_words:"true"` // optional
+	MaxRequestsPerConnection int           `split_words:"true"` // optional
+	UpstreamDownThreshold    int           `split_words:"true"` // optional
+	AfterRestartRequests     int           `split_words:"true"` // optional
+	UpstreamConnectRetries   int           `split_words:"true"` // optional
+	UpstreamConnectBackoff   time.Duration `split_words:"true"` // optional
+	QueueBurstCapacity       int           `split_words:"true"` // optional
+	NoDeadlineShedThreshold  float64       `split_words:"true"` // optional
+	MaxSlotTime              time.Duration `split_words:"true"` // optional
+	AdmissionRate            float64       `split_words:"true"` // optional
+	AdmissionBurst           int           `split_words:"true"` // optional
+	StatusRewrites           map[int]int   `split_words:"true"` // optional
+	BreakerLogPeriod         time.Duration `split_words:"true"` // optional
+	QueueTraceSampleRate     float64       `split_words:"true"` // optional
+	BreakerDrainTimeout      time.Duration `split_words:"true"` // optional
+	QueueDepthSamplePeriod   time.Duration `split_words:"true"` // optional
+	BreakerPartitionTags     []string      `split_words:"true"` // optional
+	EnableTagDrain           bool          `split_words:"true"` // optional
+	LoadShedLatencyTarget    time.Duration `split_words:"true"` // optional
+	SlowUpstreamQueueWait    time.Duration `split_words:"true"` // optional
+	SlowUpstreamServiceTime  time.Duration `split_words:"true"` // optional
+
+	// Upstream retry configuration, e.g. 3, 1s, 100ms, 502,503 and POST
+	UpstreamRetryAttempts       int           `split_words:"true"` // optional
+	UpstreamRetryAttemptTimeout time.Duration `split_words:"true"` // optional
+	UpstreamRetryBackoff        time.Duration `split_words:"true"` // optional
+	UpstreamRetryStatusCodes    []int         `split_words:"true"` // optional
+	UpstreamRetryMethods        []string      `split_words:"true"` // optional
+
+	// Per-method concurrency configuration, e.g. POST:2,PUT:2
+	MethodConcurrency map[string]int `split_words:"true"` // optional
+
+	// Method class concurrency configuration, e.g. 20, 200, 2, 20 and 20
+	SafeMethodConcurrency   int `split_words:"true"` // optional
+	SafeMethodQueueDepth    int `split_words:"true"` // optional
+	UnsafeMethodConcurrency int `split_words:"true"` // optional
+	UnsafeMethodQueueDepth  int `split_words:"true"` // optional
+	MethodClassMaxInFlight  int `split_words:"true"` // optional
+
+	// Fair queueing configuration, e.g. weighted-fair, X-Tenant and gold:2
+	QueueMode          string         `split_words:"true"` // optional
+	QueueTenantHeader  string         `split_words:"true"` // optional
+	QueueTenantWeights map[string]int `split_words:"true"` // optional
+	QueuePreallocate   bool           `split_words:"true"` // optional
+
+	// Required query parameters configuration
+	RequiredQueryParams           []string `split_words:"true"` // optional
+	RequiredQueryParamsAllowEmpty bool     `split_words:"true"` // optional
+
+	// Idempotency configuration
+	IdempotencyKeyTTL       time.Duration `split_words:"true"` // optional
+	IdempotencyCacheEntries int           `split_words:"true"` // optional
+	IdempotencyCoalescing   bool          `split_words:"true"` // optional
+
+	// Generation check configuration, e.g. K-Generation and 3
+	GenerationHeader  string `split_words:"true"` // optional
+	ServingGeneration string `split_words:"true"` // optional
+
+	// Large response configuration, in bytes
+	LargeResponseThreshold int64 `split_words:"true"` // optional
+
+	// Body buffering configuration
+	BodyBufferingBudget             int64 `split_words:"true"` // optional
+	BodyBufferingMaxBytes           int64 `split_words:"true"` // optional
+	BodyBufferingRejectOnExhaustion bool  `split_words:"true"` // optional
+
+	// Think time configuration
+	ClientThinkTime          time.Duration `split_words:"true"` // optional
+	ClientThinkTimeKeyHeader string        `split_words:"true"` // optional
+	ClientThinkTimeClients   int           `split_words:"true"` // optional
+
+	// Debug capture configuration
+	DebugCaptureSampleRate    float64  `split_words:"true"` // optional
+	DebugCaptureEntries       int      `split_words:"true"` // optional
+	DebugCaptureBodyBytes     int      `split_words:"true"` // optional
+	DebugCaptureRedactHeaders []string `split_words:"true"` // optional
 
 	// Logging configuration
 	ServingLoggingConfig         string `split_words:"true" required:"true"`
@@ -88,14 +192,53 @@ type config struct {
 	ServingEnableProbeRequestLog bool   `split_words:"true"` // optional
 
 	// Metrics configuration
-	ServingNamespace             string `split_words:"true" required:"true"`
-	ServingRevision              string `split_words:"true" required:"true"`
-	ServingConfiguration         string `split_words:"true" required:"true"`
-	ServingPodIP                 string `split_words:"true" required:"true"`
-	ServingPod                   string `split_words:"true" required:"true"`
-	ServingService               string `split_words:"true"` // optional
-	ServingRequestMetricsBackend string `split_words:"true"` // optional
-	MetricsCollectorAddress      string `split_words:"true"` // optional
+	ServingNamespace             string        `split_words:"true" required:"true"`
+	ServingRevision              string        `split_words:"true" required:"true"`
+	ServingConfiguration         string        `split_words:"true" required:"true"`
+	ServingPodIP                 string        `split_words:"true" required:"true"`
+	ServingPod                   string        `split_words:"true" required:"true"`
+	ServingService               string        `split_words:"true"` // optional
+	ServingRequestMetricsBackend string        `split_words:"true"` // optional
+	MetricsCollectorAddress      string        `split_words:"true"` // optional
+	CacheStatusHeader            string        `split_words:"true"` // optional
+	EnableConnectionReuseTag     bool          `split_words:"true"` // optional
+	EnableConnectionAge          bool          `split_words:"true"` // optional
+	EnableResponseFlushTime      bool          `split_words:"true"` // optional
+	EnableBodySizes              bool          `split_words:"true"` // optional
+	EnableUpstreamStatusTag      bool          `split_words:"true"` // optional
+	EnableRetryExhaustedTag      bool          `split_words:"true"` // optional
+	EnableUpstreamErrorTag       bool          `split_words:"true"` // optional
+	ContentTypeTagAllowlist      []string      `split_words:"true"` // optional
+	RouteTagAllowlist            []string      `split_words:"true"` // optional
+	ExpectedTrailers             []string      `split_words:"true"` // optional
+	TrailerValueTag              string        `split_words:"true"` // optional
+	TrailerValueTagAllowlist     []string      `split_words:"true"` // optional
+	EnableEdgeLatency            bool          `split_words:"true"` // optional
+	EnableUpstreamTTFB           bool          `split_words:"true"` // optional
+	EnableResponseTTFB           bool          `split_words:"true"` // optional
+	EnableStageDurations         bool          `split_words:"true"` // optional
+	EnableDeadlineFraction       bool          `split_words:"true"` // optional
+	EnableDeadlinePropagation    bool          `split_words:"true"` // optional
+	EnableAchievedConcurrency    bool          `split_words:"true"` // optional
+	EnableIdleTimeRatio          bool          `split_words:"true"` // optional
+	EnableAdmissionCASRetries    bool          `split_words:"true"` // optional
+	EnableQueueEmptyTransitions  bool          `split_words:"true"` // optional
+	EnableRejectionDiagnostics   bool          `split_words:"true"` // optional
+	EnableTCPRetransmits         bool          `split_words:"true"` // optional
+	EnableRequestCPUTime         bool          `split_words:"true"` // optional
+	MetricsEnvironment           string        `split_words:"true"` // optional
+	EnableMetricsHandlerErrors   bool          `split_words:"true"` // optional
+	EnableLatencyExemplars       bool          `split_words:"true"` // optional
+	EnableQueueTimeRatio         bool          `split_words:"true"` // optional
+	EnableGRPCStatus             bool          `split_words:"true"` // optional
+	EnableProtocolTag            bool          `split_words:"true"` // optional
+	ProtocolTagHeader            string        `split_words:"true"` // optional
+	FileDescriptorReportPeriod   time.Duration `split_words:"true"` // optional
+	GCPauseReportPeriod          time.Duration `split_words:"true"` // optional
+	ClientConcurrencyPeriod      time.Duration `split_words:"true"` // optional
+	ClientConcurrencyClients     int           `split_words:"true"` // optional
+	RequestLatencyUnit           string        `split_words:"true"` // optional
+	EnableQueuedLatencyTag       bool          `split_words:"true"` // optional
 
 	// Tracing configuration
 	TracingConfigDebug          bool                      `split_words:"true"` // optional
@@ -147,6 +290,14 @@ func main() {
 	// Report stats on Go memory usage every 30 seconds.
 	metrics.MemStatsOrDie(ctx)
 
+	// Label all queue metrics with the environment, before their reporting
+	// contexts are generated.
+	if env.MetricsEnvironment != "" {
+		if err := servingmetrics.SetEnvironment(env.MetricsEnvironment); err != nil {
+			logger.Fatalw("Invalid metrics environment", zap.Error(err))
+		}
+	}
+
 	// Setup reporters and processes to handle stat reporting.
 	promStatReporter, err := queue.NewPrometheusStatsReporter(
 		env.ServingNamespace, env.ServingConfiguration, env.ServingRevision,
@@ -173,10 +324,15 @@ func main() {
 	probe := buildProbe(logger, env)
 	healthState := health.NewState()
 
-	mainServer := buildServer(ctx, env, healthState, probe, stats, logger)
+	debugSink := buildDebugSink(env)
+	var tagDrain *queue.TagDrain
+	if env.EnableTagDrain {
+		tagDrain = queue.NewTagDrain()
+	}
+	mainServer, breaker := buildServer(ctx, env, healthState, probe, stats, debugSink, tagDrain, logger)
 	servers := map[string]*http.Server{
 		"main":    mainServer,
-		"admin":   buildAdminServer(logger, healthState),
+		"admin":   buildAdminServer(logger, healthState, debugSink, tagDrain),
 		"metrics": buildMetricsServer(promStatReporter, protoStatReporter),
 	}
 	if env.EnableProfiling {
@@ -196,6 +352,9 @@ func main() {
 			// Notify the unix socket setup that the tcp socket for the main server is ready.
 			if s == mainServer {
 				close(listenCh)
+				if env.EnableEdgeLatency {
+					l = queue.EdgeTimingListener(l)
+				}
 			}
 
 			// Don't forward ErrServerClosed as that indicates we're already shutting down.
@@ -236,8 +395,16 @@ func main() {
 	case <-ctx.Done():
 		logger.Info("Received TERM signal, attempting to gracefully shutdown servers.")
 		healthState.Shutdown(func() {
+			// The full sleep must come first: until the non-ready state
+			// propagated, new requests are still routed here, which a
+			// draining breaker would reject.
 			logger.Infof("Sleeping %v to allow K8s propagation of non-ready state", drainSleepDuration)
 			time.Sleep(drainSleepDuration)
+			if breaker != nil {
+				// Once no new requests are routed here, stop admitting
+				// requests and wait just as long as the pending ones need.
+				drainBreaker(logger, breaker, breakerDrainTimeout(env))
+			}
 
 			// Calling server.Shutdown() allows pending requests to
 			// complete, while no new work is accepted.
@@ -259,6 +426,44 @@ func main() {
 	}
 }
 
+// breakerDrainTimeout returns how long to wait for the breaker to drain during
+// shutdown, the revision timeout unless configured otherwise, as no request
+// takes longer than that.
+func breakerDrainTimeout(env config) time.Duration {
+	if env.BreakerDrainTimeout > 0 {
+		return env.BreakerDrainTimeout
+	}
+	return time.Duration(env.RevisionTimeoutSeconds) * time.Second
+}
+
+// drainBreaker stops the breaker from admitting requests and waits up to
+// timeout for the pending ones to finish, logging how many are left.
+func drainBreaker(logger *zap.SugaredLogger, breaker *queue.Breaker, timeout time.Duration) {
+	logger.Infof("Draining breaker for up to %v with %d pending requests", timeout, breaker.Pending())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() {
+		drained <- breaker.Drain(ctx)
+	}()
+
+	ticker := time.NewTicker(drainLogPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-drained:
+			if err != nil {
+				logger.Warnw("Breaker failed to drain in time", zap.Int("pending", breaker.Pending()), zap.Error(err))
+			} else {
+				logger.Info("Breaker drained")
+			}
+			return
+		case <-ticker.C:
+			logger.Infof("Waiting for %d pending requests to drain", breaker.Pending())
+		}
+	}
+}
+
 func buildProbe(logger *zap.SugaredLogger, env config) *readiness.Probe {
 	coreProbe, err := readiness.DecodeProbe(env.ServingReadinessProbe)
 	if err != nil {
@@ -271,7 +476,7 @@ func buildProbe(logger *zap.SugaredLogger, env config) *readiness.Probe {
 }
 
 func buildServer(ctx context.Context, env config, healthState *health.State, rp *readiness.Probe, stats *network.RequestStats,
-	logger *zap.SugaredLogger) *http.Server {
+	debugSink *queue.DebugSink, tagDrain *queue.TagDrain, logger *zap.SugaredLogger) (*http.Server, *queue.Breaker) {
 
 	maxIdleConns := 1000 // TODO: somewhat arbitrary value for CC=0, needs experimental validation.
 	if env.ContainerConcurrency > 0 {
@@ -285,9 +490,52 @@ func buildServer(ctx context.Context, env config, healthState *health.State, rp
 	httpProxy.ErrorHandler = pkgnet.ErrorHandler(logger)
 	httpProxy.BufferPool = network.NewBufferPool()
 	httpProxy.FlushInterval = network.FlushInterval
+	if env.EnableUpstreamStatusTag {
+		httpProxy.Transport = queue.UpstreamStatusTransport(httpProxy.Transport)
+	}
+	if env.EnableUpstreamErrorTag {
+		httpProxy.Transport = queue.UpstreamErrorTransport(httpProxy.Transport)
+	}
+	if env.EnableUpstreamTTFB || env.EnableResponseTTFB {
+		httpProxy.Transport = queue.UpstreamTTFBTransport(httpProxy.Transport)
+	}
+	if env.EnableDeadlinePropagation {
+		// Inside the retries so that every attempt sees the time left.
+		httpProxy.Transport = queue.DeadlinePropagationTransport(httpProxy.Transport)
+	}
+
+	// Fail requests fast after repeatedly failing to connect to the user
+	// container, until the readiness probe passes again.
+	probeContainer := rp.ProbeContainer
+	var upstream *queue.UpstreamTracker
+	if env.UpstreamDownThreshold > 0 {
+		upstream = queue.NewUpstreamTracker(env.UpstreamDownThreshold)
+		upstream.MarkAfterRestart(env.AfterRestartRequests)
+		httpProxy.Transport = upstream.Transport(httpProxy.Transport)
+		probeContainer = func() bool {
+			if !rp.ProbeContainer() {
+				return false
+			}
+			upstream.ReportSuccess()
+			return true
+		}
+	}
+	if env.UpstreamConnectRetries > 0 {
+		httpProxy.Transport = queue.RetryTransport(env.UpstreamConnectRetries, env.UpstreamConnectBackoff, httpProxy.Transport)
+	}
+	if env.UpstreamRetryAttempts > 1 {
+		httpProxy.Transport = upstreamRetryConfig(env).Transport(httpProxy.Transport)
+	}
+	if env.EnableStageDurations {
+		// Wraps the retries so that the upstream stage covers all attempts.
+		httpProxy.Transport = queue.StageTimingTransport(httpProxy.Transport)
+	}
 
-	breaker := buildBreaker(logger, env)
 	metricsSupported := supportsMetrics(ctx, logger, env)
+	breaker := buildBreaker(logger, env, metricsSupported)
+	if breaker != nil && env.BreakerLogPeriod > 0 {
+		go queue.NewBreakerLogReporter(breaker, logger).Run(ctx, env.BreakerLogPeriod)
+	}
 	tracingEnabled := env.TracingConfigBackend != tracingconfig.None
 	timeout := time.Duration(env.RevisionTimeoutSeconds) * time.Second
 
@@ -296,25 +544,104 @@ func buildServer(ctx context.Context, env config, healthState *health.State, rp
 	var composedHandler http.Handler = httpProxy
 	if metricsSupported {
 		composedHandler = requestAppMetricsHandler(logger, composedHandler, breaker, env)
+		if env.FileDescriptorReportPeriod > 0 {
+			reportFileDescriptors(ctx, logger, env)
+		}
+		if env.GCPauseReportPeriod > 0 {
+			reportGCPauses(ctx, logger, env)
+		}
+		if breaker != nil {
+			reportAdmissionRatio(ctx, logger, breaker, env)
+			sampleQueueDepth(ctx, logger, breaker, env)
+			if env.EnableAchievedConcurrency {
+				reportAchievedConcurrency(ctx, logger, breaker, env)
+			}
+			if env.EnableIdleTimeRatio {
+				reportIdleTimeRatio(ctx, logger, breaker, env)
+			}
+			if env.EnableAdmissionCASRetries {
+				reportAdmissionCASRetries(ctx, logger, breaker, env)
+			}
+			if env.EnableQueueEmptyTransitions {
+				reportQueueEmptyTransitions(ctx, logger, breaker, env)
+			}
+		}
+	}
+	var proxyOpts []queue.ProxyOption
+	if env.EnableServerTimingHeader {
+		proxyOpts = append(proxyOpts, queue.WithServerTiming())
+	}
+	if env.EnableQueueWaitHeader {
+		proxyOpts = append(proxyOpts, queue.WithQueueWaitHeader())
+	}
+	if env.EnableRejectionDiagnostics {
+		proxyOpts = append(proxyOpts, queue.WithRejectionDiagnostics())
+	}
+	if tagDrain != nil {
+		proxyOpts = append(proxyOpts, queue.WithTagDrain(tagDrain))
+	}
+	if env.QueueTenantHeader != "" {
+		proxyOpts = append(proxyOpts, queue.WithTenantHeader(env.QueueTenantHeader))
+	}
+	if breaker != nil && len(env.BreakerPartitionTags) > 0 {
+		proxyOpts = append(proxyOpts, queue.WithTagBreakers(buildTagBreakers(ctx, logger, env, metricsSupported)))
+	}
+	if metricsSupported && env.SlowUpstreamQueueWait > 0 && env.SlowUpstreamServiceTime > 0 {
+		proxyOpts = append(proxyOpts, queue.WithSlowUpstreamDetection(env.SlowUpstreamQueueWait, env.SlowUpstreamServiceTime))
+	}
+	composedHandler = queue.ProxyHandler(breaker, stats, tracingEnabled, composedHandler, proxyOpts...)
+	if len(env.StatusRewrites) > 0 {
+		composedHandler = queue.StatusRewriteHandler(env.StatusRewrites, composedHandler)
+	}
+	if env.BodyBufferingBudget > 0 && env.BodyBufferingMaxBytes > 0 {
+		budget := queue.NewBufferingBudget(env.BodyBufferingBudget)
+		if metricsSupported && env.UpstreamConnectRetries > 0 {
+			reportRetryBuffer(ctx, logger, budget, env)
+		}
+		composedHandler = queue.BodyBufferingHandler(budget,
+			env.BodyBufferingMaxBytes, env.BodyBufferingRejectOnExhaustion, composedHandler)
+	}
+	composedHandler = latencyShedHandler(logger, composedHandler, env)
+	if upstream != nil {
+		composedHandler = queue.UpstreamDownHandler(upstream, composedHandler)
+	}
+	if env.MaxRequestURILength > 0 {
+		composedHandler = queue.URILengthLimitHandler(env.MaxRequestURILength, composedHandler)
+	}
+	if len(env.RequiredQueryParams) > 0 {
+		composedHandler = queue.RequiredQueryParamsHandler(env.RequiredQueryParams,
+			env.RequiredQueryParamsAllowEmpty, composedHandler)
+	}
+	if metricsSupported && env.ClientConcurrencyPeriod > 0 {
+		composedHandler = clientConcurrencyHandler(ctx, logger, composedHandler, env)
+	}
+	composedHandler = thinkTimeHandler(logger, composedHandler, env)
+	if env.MaxRequestsPerConnection > 0 {
+		composedHandler = queue.ConnectionRequestLimitHandler(env.MaxRequestsPerConnection, composedHandler)
 	}
-	composedHandler = queue.ProxyHandler(breaker, stats, tracingEnabled, composedHandler)
 	composedHandler = queue.ForwardedShimHandler(composedHandler)
+	composedHandler = idempotencyHandler(logger, composedHandler, env, metricsSupported)
+	if debugSink != nil {
+		composedHandler = queue.DebugTeeHandler(debugSink, env.DebugCaptureSampleRate, composedHandler)
+	}
 	composedHandler = handler.NewTimeToFirstByteTimeoutHandler(composedHandler, "request timeout", timeout)
 
 	if metricsSupported {
-		composedHandler = requestMetricsHandler(logger, composedHandler, env)
+		composedHandler = requestMetricsHandler(ctx, logger, composedHandler, env)
 	}
 	if tracingEnabled {
 		composedHandler = tracing.HTTPSpanMiddleware(composedHandler)
 	}
 
-	composedHandler = health.ProbeHandler(healthState, rp.ProbeContainer, rp.IsAggressive(), tracingEnabled, composedHandler)
+	composedHandler = health.ProbeHandler(healthState, probeContainer, rp.IsAggressive(), tracingEnabled, composedHandler)
 	composedHandler = network.NewProbeHandler(composedHandler)
 	// We might want sometimes capture the probes/healthchecks in the request
 	// logs. Hence we need to have RequestLogHandler to be the first one.
 	composedHandler = pushRequestLogHandler(logger, composedHandler, env)
 
-	return pkgnet.NewServer(":"+env.QueueServingPort, composedHandler)
+	server := pkgnet.NewServer(":"+env.QueueServingPort, composedHandler)
+	server.ConnContext = queue.ConnContext
+	return server, breaker
 }
 
 func buildTransport(env config, logger *zap.SugaredLogger, maxConns int) http.RoundTripper {
@@ -339,7 +666,23 @@ func buildTransport(env config, logger *zap.SugaredLogger, maxConns int) http.Ro
 	}
 }
 
-func buildBreaker(logger *zap.SugaredLogger, env config) *queue.Breaker {
+// methodClassParams returns the breaker parameters of a method class with the
+// given concurrency, if any. Like the breaker's, the queue depth defaults to
+// ten times the concurrency.
+func methodClassParams(concurrency, queueDepth int) *queue.MethodClassParams {
+	if concurrency < 1 {
+		return nil
+	}
+	if queueDepth < 1 {
+		queueDepth = 10 * concurrency
+	}
+	return &queue.MethodClassParams{
+		MaxConcurrency: concurrency,
+		QueueDepth:     queueDepth,
+	}
+}
+
+func buildBreaker(logger *zap.SugaredLogger, env config, metricsSupported bool) *queue.Breaker {
 	if env.ContainerConcurrency < 1 {
 		return nil
 	}
@@ -351,11 +694,84 @@ func buildBreaker(logger *zap.SugaredLogger, env config) *queue.Breaker {
 		QueueDepth:      queueDepth,
 		MaxConcurrency:  env.ContainerConcurrency,
 		InitialCapacity: env.ContainerConcurrency,
+		BurstCapacity:   env.QueueBurstCapacity,
+
+		NoDeadlineShedThreshold: env.NoDeadlineShedThreshold,
+		MaxSlotTime:             env.MaxSlotTime,
+		MethodMaxConcurrency:    env.MethodConcurrency,
+		AdmissionRate:           env.AdmissionRate,
+		AdmissionBurst:          env.AdmissionBurst,
+		QueueMode:               queue.QueueMode(env.QueueMode),
+		QueueTenantWeights:      env.QueueTenantWeights,
+		PreallocateWaiters:      env.QueuePreallocate,
+	}
+	if params.AdmissionRate > 0 && params.AdmissionBurst < 1 {
+		// Without a burst configured, requests are admitted one by one.
+		params.AdmissionBurst = 1
+	}
+	params.SafeMethods = methodClassParams(env.SafeMethodConcurrency, env.SafeMethodQueueDepth)
+	params.UnsafeMethods = methodClassParams(env.UnsafeMethodConcurrency, env.UnsafeMethodQueueDepth)
+	params.MethodClassMaxInFlight = env.MethodClassMaxInFlight
+	if env.BreakerLogPeriod > 0 {
+		params.WaitSampleSize = breakerLogWaitSamples
+	}
+	// Both the breaker summaries and the rejection diagnostics report the
+	// queued requests.
+	params.TrackConcurrency = env.BreakerLogPeriod > 0 || env.EnableRejectionDiagnostics
+	if metricsSupported && env.EnableAchievedConcurrency {
+		params.TrackPeakConcurrency = true
+	}
+	if metricsSupported && env.EnableIdleTimeRatio {
+		params.TrackIdleTime = true
+	}
+	if metricsSupported && env.EnableAdmissionCASRetries {
+		params.CountCASRetries = true
+	}
+	if env.QueueTraceSampleRate > 0 {
+		params.QueueTraceLogger = logger.Named("queuetrace")
+		params.QueueTraceSampleRate = env.QueueTraceSampleRate
+	}
+	if metricsSupported {
+		record, err := queue.NewBreakerCapacityRecorder(env.ServingNamespace, env.ServingService,
+			env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+		if err != nil {
+			logger.Errorw("Error setting up breaker capacity metrics. Capacity metrics will be unavailable.", zap.Error(err))
+		} else {
+			params.OnCapacityChange = record
+		}
+		onWait, err := queue.NewBreakerWaitRecorder(env.ServingNamespace, env.ServingService,
+			env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+		if err != nil {
+			logger.Errorw("Error setting up breaker wait metrics. Wait metrics will be unavailable.", zap.Error(err))
+		} else {
+			params.OnWait = onWait
+		}
 	}
 	logger.Infof("Queue container is starting with BreakerParams = %#v", params)
 	return queue.NewBreaker(params)
 }
 
+// buildTagBreakers creates a breaker for each partitioned route tag, with the
+// same limits as the revision's breaker.
+func buildTagBreakers(ctx context.Context, logger *zap.SugaredLogger, env config, metricsSupported bool) *queue.TagBreakers {
+	tb := queue.NewTagBreakers(queue.BreakerParams{
+		QueueDepth:      10 * env.ContainerConcurrency,
+		MaxConcurrency:  env.ContainerConcurrency,
+		InitialCapacity: env.ContainerConcurrency,
+		BurstCapacity:   env.QueueBurstCapacity,
+	}, env.BreakerPartitionTags)
+	if metricsSupported {
+		r, err := queue.NewTagQueueSaturationReporter(tb, env.ServingNamespace, env.ServingService,
+			env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+		if err != nil {
+			logger.Errorw("Error setting up tag queue saturation metrics. Tag queue metrics will be unavailable.", zap.Error(err))
+		} else {
+			go r.Run(ctx, reportingPeriod)
+		}
+	}
+	return tb
+}
+
 func supportsMetrics(ctx context.Context, logger *zap.SugaredLogger, env config) bool {
 	// Setup request metrics reporting for end-user metrics.
 	if env.ServingRequestMetricsBackend == "" {
@@ -370,13 +786,20 @@ func supportsMetrics(ctx context.Context, logger *zap.SugaredLogger, env config)
 	return true
 }
 
-func buildAdminServer(logger *zap.SugaredLogger, healthState *health.State) *http.Server {
+func buildAdminServer(logger *zap.SugaredLogger, healthState *health.State, debugSink *queue.DebugSink,
+	tagDrain *queue.TagDrain) *http.Server {
 	adminMux := http.NewServeMux()
 	drainHandler := healthState.DrainHandlerFunc()
 	adminMux.HandleFunc(queue.RequestQueueDrainPath, func(w http.ResponseWriter, r *http.Request) {
 		logger.Info("Attached drain handler from user-container")
 		drainHandler(w, r)
 	})
+	if debugSink != nil {
+		adminMux.Handle(queue.DebugCapturesPath, debugSink)
+	}
+	if tagDrain != nil {
+		adminMux.Handle(queue.TagDrainPath, tagDrain)
+	}
 
 	return &http.Server{
 		Addr:    ":" + strconv.Itoa(networking.QueueAdminPort),
@@ -415,9 +838,194 @@ func pushRequestLogHandler(logger *zap.SugaredLogger, currentHandler http.Handle
 	return handler
 }
 
-func requestMetricsHandler(logger *zap.SugaredLogger, currentHandler http.Handler, env config) http.Handler {
+func latencyShedHandler(logger *zap.SugaredLogger, currentHandler http.Handler, env config) http.Handler {
+	if env.LoadShedLatencyTarget <= 0 {
+		return currentHandler
+	}
+
+	s, err := queue.NewLatencyShedder(env.LoadShedLatencyTarget, env.ServingNamespace,
+		env.ServingService, env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up latency based load shedding. Requests will not be shed.", zap.Error(err))
+		return currentHandler
+	}
+	return queue.LatencyShedHandler(s, currentHandler)
+}
+
+func thinkTimeHandler(logger *zap.SugaredLogger, currentHandler http.Handler, env config) http.Handler {
+	if env.ClientThinkTime <= 0 {
+		return currentHandler
+	}
+
+	clients := env.ClientThinkTimeClients
+	if clients <= 0 {
+		clients = defaultThinkTimeClients
+	}
+	h, err := queue.NewThinkTimeHandler(currentHandler, env.ClientThinkTime, env.ClientThinkTimeKeyHeader, clients)
+	if err != nil {
+		logger.Errorw("Error setting up think time handler. Client request rates will not be limited.", zap.Error(err))
+		return currentHandler
+	}
+	return h
+}
+
+func clientConcurrencyHandler(ctx context.Context, logger *zap.SugaredLogger, currentHandler http.Handler, env config) http.Handler {
+	clients := env.ClientConcurrencyClients
+	if clients <= 0 {
+		clients = defaultClientConcurrencyClients
+	}
+	r, err := queue.NewClientConcurrencyReporter(clients, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up client concurrency reporter. Client concurrency metrics will be unavailable.", zap.Error(err))
+		return currentHandler
+	}
+	go r.Run(ctx, env.ClientConcurrencyPeriod)
+	return r.Handler(currentHandler)
+}
+
+// buildDebugSink returns the sink for debug captures, or nil if capturing
+// is disabled.
+func buildDebugSink(env config) *queue.DebugSink {
+	if env.DebugCaptureSampleRate <= 0 {
+		return nil
+	}
+
+	entries := env.DebugCaptureEntries
+	if entries <= 0 {
+		entries = defaultDebugCaptureEntries
+	}
+	bodyBytes := env.DebugCaptureBodyBytes
+	if bodyBytes <= 0 {
+		bodyBytes = defaultDebugCaptureBodyBytes
+	}
+	return queue.NewDebugSink(entries, bodyBytes, env.DebugCaptureRedactHeaders)
+}
+
+func idempotencyHandler(logger *zap.SugaredLogger, currentHandler http.Handler, env config, metricsSupported bool) http.Handler {
+	if env.IdempotencyKeyTTL <= 0 {
+		return currentHandler
+	}
+
+	entries := env.IdempotencyCacheEntries
+	if entries <= 0 {
+		entries = defaultIdempotencyCacheEntries
+	}
+	var opts []queue.IdempotencyOption
+	if env.IdempotencyCoalescing {
+		var r *queue.CoalescingReporter
+		if metricsSupported {
+			var err error
+			r, err = queue.NewCoalescingReporter(env.ServingNamespace, env.ServingService,
+				env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+			if err != nil {
+				logger.Errorw("Error setting up coalescing reporter. Coalescing metrics will be unavailable.", zap.Error(err))
+			}
+		}
+		opts = append(opts, queue.WithCoalescing(r))
+	}
+	h, err := queue.NewIdempotencyHandler(currentHandler, env.IdempotencyKeyTTL, entries, opts...)
+	if err != nil {
+		logger.Errorw("Error setting up idempotency handler. Idempotency keys will be ignored.", zap.Error(err))
+		return currentHandler
+	}
+	return h
+}
+
+func requestMetricsHandler(ctx context.Context, logger *zap.SugaredLogger, currentHandler http.Handler, env config) http.Handler {
+	var opts []queue.RequestMetricsOption
+	if env.CacheStatusHeader != "" {
+		opts = append(opts, queue.WithCacheStatusHeader(env.CacheStatusHeader))
+	}
+	if env.RequestLatencyUnit != "" {
+		opts = append(opts, queue.WithLatencyUnit(env.RequestLatencyUnit))
+	}
+	if env.EnableQueuedLatencyTag {
+		opts = append(opts, queue.WithQueuedTag())
+	}
+	if env.EnableUpstreamErrorTag {
+		opts = append(opts, queue.WithUpstreamErrorTag())
+	}
+	if len(env.RouteTagAllowlist) > 0 {
+		opts = append(opts, queue.WithRouteTagAllowlist(env.RouteTagAllowlist))
+	}
+	if len(env.ContentTypeTagAllowlist) > 0 {
+		opts = append(opts, queue.WithContentTypeTag(env.ContentTypeTagAllowlist))
+	}
+	if env.EnableRetryExhaustedTag && (env.UpstreamConnectRetries > 0 || env.UpstreamRetryAttempts > 1) {
+		opts = append(opts, queue.WithRetryExhaustedTag())
+	}
+	if env.EnableConnectionReuseTag {
+		opts = append(opts, queue.WithConnectionReuseTag())
+	}
+	if env.EnableConnectionAge {
+		opts = append(opts, queue.WithConnectionAge())
+	}
+	if env.EnableResponseFlushTime {
+		opts = append(opts, queue.WithResponseFlushTime())
+	}
+	if env.EnableBodySizes {
+		opts = append(opts, queue.WithBodySizes())
+	}
+	if env.EnableUpstreamStatusTag {
+		opts = append(opts, queue.WithUpstreamStatusTag())
+	}
+	if env.EnableEdgeLatency {
+		opts = append(opts, queue.WithEdgeLatency())
+	}
+	if env.EnableUpstreamTTFB {
+		opts = append(opts, queue.WithUpstreamTTFB())
+	}
+	if env.EnableResponseTTFB {
+		opts = append(opts, queue.WithResponseTimeToFirstByte())
+	}
+	if env.EnableStageDurations {
+		opts = append(opts, queue.WithStageDurations())
+	}
+	if env.EnableDeadlineFraction {
+		opts = append(opts, queue.WithPreAdmissionDeadlineFraction())
+	}
+	if env.EnableDeadlinePropagation {
+		opts = append(opts, queue.WithDeadlinePropagation())
+	}
+	if env.EnableTCPRetransmits {
+		opts = append(opts, queue.WithTCPRetransmits(queue.NewSocketInfoProvider()))
+	}
+	if env.EnableRequestCPUTime {
+		opts = append(opts, queue.WithCPUTime())
+	}
+	if env.EnableMetricsHandlerErrors {
+		opts = append(opts, queue.WithMetricsHandlerErrors())
+	}
+	if env.EnableLatencyExemplars {
+		opts = append(opts, queue.WithLatencyExemplars())
+	}
+	if env.EnableGRPCStatus {
+		opts = append(opts, queue.WithGRPCStatus())
+	}
+	if env.EnableProtocolTag {
+		opts = append(opts, queue.WithProtocolTag(env.ProtocolTagHeader))
+	}
+	if env.GenerationHeader != "" && env.ServingGeneration != "" {
+		opts = append(opts, queue.WithGenerationMismatch(env.GenerationHeader, env.ServingGeneration))
+	}
+	if env.LargeResponseThreshold > 0 {
+		opts = append(opts, queue.WithLargeResponseThreshold(env.LargeResponseThreshold))
+	}
+	if env.EnableQueueTimeRatio {
+		if r := queueTimeRatioReporter(ctx, logger, env); r != nil {
+			opts = append(opts, queue.WithQueueTimeRatio(r))
+		}
+	}
+	if len(env.ExpectedTrailers) > 0 {
+		opts = append(opts, queue.WithExpectedTrailers(env.ExpectedTrailers))
+	}
+	if env.TrailerValueTag != "" {
+		opts = append(opts, queue.WithTrailerValueTag(env.TrailerValueTag, env.TrailerValueTagAllowlist))
+	}
 	h, err := queue.NewRequestMetricsHandler(currentHandler, env.ServingNamespace,
-		env.ServingService, env.ServingConfiguration, env.ServingRevision, env.ServingPod, map[string]string{}, map[string]string{})
+		env.ServingService, env.ServingConfiguration, env.ServingRevision, env.ServingPod, map[string]string{}, map[string]string{},
+		opts...)
 	if err != nil {
 		logger.Errorw("Error setting up request metrics reporter. Request metrics will be unavailable.", zap.Error(err))
 		return currentHandler
@@ -425,6 +1033,127 @@ func requestMetricsHandler(logger *zap.SugaredLogger, currentHandler http.Handle
 	return h
 }
 
+func queueTimeRatioReporter(ctx context.Context, logger *zap.SugaredLogger, env config) *queue.QueueTimeRatioReporter {
+	r, err := queue.NewQueueTimeRatioReporter(env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up queue time ratio reporter. Queue time ratio metrics will be unavailable.", zap.Error(err))
+		return nil
+	}
+	go r.Run(ctx, reportingPeriod)
+	return r
+}
+
+func reportFileDescriptors(ctx context.Context, logger *zap.SugaredLogger, env config) {
+	r, err := queue.NewFileDescriptorReporter(env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up file descriptor reporter. File descriptor metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, env.FileDescriptorReportPeriod)
+}
+
+func reportGCPauses(ctx context.Context, logger *zap.SugaredLogger, env config) {
+	r, err := queue.NewGCPauseReporter(env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up GC pause reporter. GC pause metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, env.GCPauseReportPeriod)
+}
+
+func reportAdmissionRatio(ctx context.Context, logger *zap.SugaredLogger, breaker *queue.Breaker, env config) {
+	r, err := queue.NewAdmissionRatioReporter(breaker, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up admission ratio reporter. Admission ratio metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, reportingPeriod)
+}
+
+func sampleQueueDepth(ctx context.Context, logger *zap.SugaredLogger, breaker *queue.Breaker, env config) {
+	s, err := queue.NewQueueDepthSampler(breaker, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up queue depth sampler. Queue depth will only be recorded on requests.", zap.Error(err))
+		return
+	}
+	go s.Run(ctx, env.QueueDepthSamplePeriod)
+}
+
+func reportAchievedConcurrency(ctx context.Context, logger *zap.SugaredLogger, breaker *queue.Breaker, env config) {
+	r, err := queue.NewAchievedConcurrencyReporter(breaker, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up achieved concurrency reporter. Achieved concurrency metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, reportingPeriod)
+}
+
+func reportIdleTimeRatio(ctx context.Context, logger *zap.SugaredLogger, breaker *queue.Breaker, env config) {
+	r, err := queue.NewIdleTimeRatioReporter(breaker, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up idle time ratio reporter. Idle time metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, reportingPeriod)
+}
+
+func reportAdmissionCASRetries(ctx context.Context, logger *zap.SugaredLogger, breaker *queue.Breaker, env config) {
+	r, err := queue.NewAdmissionCASRetriesReporter(breaker, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up admission CAS retries reporter. Admission contention metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, reportingPeriod)
+}
+
+func reportQueueEmptyTransitions(ctx context.Context, logger *zap.SugaredLogger, breaker *queue.Breaker, env config) {
+	r, err := queue.NewQueueEmptyTransitionsReporter(breaker, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up queue empty transitions reporter. Queue empty metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, reportingPeriod)
+}
+
+func reportRetryBuffer(ctx context.Context, logger *zap.SugaredLogger, budget *queue.BufferingBudget, env config) {
+	r, err := queue.NewRetryBufferReporter(budget, env.ServingNamespace, env.ServingService,
+		env.ServingConfiguration, env.ServingRevision, env.ServingPod)
+	if err != nil {
+		logger.Errorw("Error setting up retry buffer reporter. Retry buffer metrics will be unavailable.", zap.Error(err))
+		return
+	}
+	go r.Run(ctx, reportingPeriod)
+}
+
+func upstreamRetryConfig(env config) queue.RetryConfig {
+	cfg := queue.RetryConfig{
+		MaxAttempts:    env.UpstreamRetryAttempts,
+		AttemptTimeout: env.UpstreamRetryAttemptTimeout,
+		Backoff:        env.UpstreamRetryBackoff,
+		Methods:        env.UpstreamRetryMethods,
+	}
+	if len(env.UpstreamRetryStatusCodes) > 0 {
+		codes := make(map[int]struct{}, len(env.UpstreamRetryStatusCodes))
+		for _, code := range env.UpstreamRetryStatusCodes {
+			codes[code] = struct{}{}
+		}
+		cfg.RetryStatus = func(code int) bool {
+			_, ok := codes[code]
+			return ok
+		}
+	}
+	return cfg
+}
+
 func requestAppMetricsHandler(logger *zap.SugaredLogger, currentHandler http.Handler, breaker *queue.Breaker, env config) http.Handler {
 	h, err := queue.NewAppRequestMetricsHandler(currentHandler, breaker, env.ServingNamespace,
 		env.ServingService, env.ServingConfiguration, env.ServingRevision, env.ServingPod, map[string]string{}, map[string]string{})
@@ -188,3 +188,27 @@ func TestQueueTraceSpans(t *testing.T) {
 		})
 	}
 }
+
+func TestBreakerDrainTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		env  config
+		want time.Duration
+	}{{
+		name: "revision timeout by default",
+		env:  config{RevisionTimeoutSeconds: 300},
+		want: 300 * time.Second,
+	}, {
+		name: "configured",
+		env:  config{RevisionTimeoutSeconds: 300, BreakerDrainTimeout: time.Minute},
+		want: time.Minute,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := breakerDrainTimeout(test.env); got != test.want {
+				t.Errorf("breakerDrainTimeout() = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+
+	activatornet "knative.dev/serving/pkg/activator/net"
+)
+
+// NewStickyHandler attaches the value of the given request header as the
+// sticky routing key to the request's context, so that a throttler created
+// with activatornet.WithStickyRouting routes requests with the same value to
+// the same pod while it has capacity.
+func NewStickyHandler(header string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(header); key != "" {
+			r = r.WithContext(activatornet.WithStickyKey(r.Context(), key))
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	activatornet "knative.dev/serving/pkg/activator/net"
+)
+
+func TestStickyHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{{
+		name:   "with header",
+		header: "session-1",
+		want:   "session-1",
+	}, {
+		name: "without header",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+			handler := NewStickyHandler("X-Session", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = activatornet.StickyKeyFrom(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			if test.header != "" {
+				req.Header.Set("X-Session", test.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != test.want {
+				t.Errorf("sticky key = %q, want: %q", got, test.want)
+			}
+		})
+	}
+}
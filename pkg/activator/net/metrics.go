@@ -0,0 +1,131 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+)
+
+var (
+	throttlerCapacityM = stats.Int64(
+		"throttler_capacity",
+		"The number of requests the activator lets through to the revision concurrently",
+		stats.UnitDimensionless)
+	throttlerInFlightM = stats.Int64(
+		"throttler_in_flight_requests",
+		"The number of requests the activator currently lets through to the revision",
+		stats.UnitDimensionless)
+	throttlerQueuedM = stats.Int64(
+		"throttler_queued_requests",
+		"The number of requests waiting in the activator for capacity of the revision",
+		stats.UnitDimensionless)
+	throttlerStickyRequestsM = stats.Int64(
+		"throttler_sticky_requests",
+		"The number of requests with a sticky routing key by whether they got their preferred pod",
+		stats.UnitDimensionless)
+
+	// stickyHitKey tells whether a request with a sticky routing key got its
+	// preferred pod. The sticky hit ratio is the share of requests with it
+	// set to true.
+	stickyHitKey = tag.MustNewKey("sticky_hit")
+)
+
+func init() {
+	register()
+}
+
+func register() {
+	// Create views to see our measurements. This can return an error if
+	// a previously-registered view has the same name with a different value.
+	// View name defaults to the measure name if unspecified.
+	if err := pkgmetrics.RegisterResourceView(
+		&view.View{
+			Description: "The number of requests the activator lets through to the revision concurrently",
+			Measure:     throttlerCapacityM,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Description: "The number of requests the activator currently lets through to the revision",
+			Measure:     throttlerInFlightM,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Description: "The number of requests waiting in the activator for capacity of the revision",
+			Measure:     throttlerQueuedM,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Description: "The number of requests with a sticky routing key by whether they got their preferred pod",
+			Measure:     throttlerStickyRequestsM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{stickyHitKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// throttlerStats keeps the number of requests queued and in flight in a
+// revision throttler's breaker and records them along with its capacity.
+type throttlerStats struct {
+	// statsCtx is the revision's context to record the metrics with, they're
+	// not recorded if nil.
+	statsCtx context.Context
+
+	mux      sync.Mutex
+	queued   int64
+	inFlight int64
+}
+
+// setCapacity records the capacity of the breaker.
+func (s *throttlerStats) setCapacity(capacity int) {
+	if s.statsCtx == nil {
+		return
+	}
+	pkgmetrics.Record(s.statsCtx, throttlerCapacityM.M(int64(capacity)))
+}
+
+// add changes the number of queued and in-flight requests by the given deltas
+// and records the new numbers.
+func (s *throttlerStats) add(queued, inFlight int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.queued += queued
+	s.inFlight += inFlight
+	if s.statsCtx == nil {
+		return
+	}
+	pkgmetrics.RecordBatch(s.statsCtx, throttlerQueuedM.M(s.queued), throttlerInFlightM.M(s.inFlight))
+}
+
+// stickyRequest records whether a request with a sticky routing key got its
+// preferred pod.
+func (s *throttlerStats) stickyRequest(hit bool) {
+	if s.statsCtx == nil {
+		return
+	}
+	ctx, _ := tag.New(s.statsCtx, tag.Upsert(stickyHitKey, strconv.FormatBool(hit)))
+	pkgmetrics.Record(ctx, throttlerStickyRequestsM.M(1))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opencensus.io/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	pkgnet "knative.dev/networking/pkg/apis/networking"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/metrics/metricstest"
+	_ "knative.dev/pkg/metrics/testing"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestRevisionThrottlerMetrics(t *testing.T) {
+	// Start over from the metrics other tests recorded.
+	reset := func() {
+		metricstest.Unregister(throttlerCapacityM.Name(), throttlerInFlightM.Name(), throttlerQueuedM.Name(),
+			throttlerStickyRequestsM.Name())
+		register()
+	}
+	reset()
+	defer reset()
+
+	const revName = "throttler-metrics"
+	rt := newRevisionThrottler(types.NamespacedName{Namespace: testNamespace, Name: revName}, 1, /*cc*/
+		pkgnet.ServicePortNameHTTP1, testBreakerParams, TestLogger(t))
+	rt.stats.statsCtx = metrics.RevisionContext(testNamespace, "svc", "cfg", revName, nil, nil)
+	rt.clusterIPTracker = newPodTracker("10.0.0.1:8080", nil)
+	wantResource := &resource.Resource{
+		Type: "knative_revision",
+		Labels: map[string]string{
+			metrics.LabelRevisionName:      revName,
+			metrics.LabelNamespaceName:     testNamespace,
+			metrics.LabelServiceName:       "svc",
+			metrics.LabelConfigurationName: "cfg",
+		},
+	}
+	assertMetrics := func(capacity, inFlight, queued int64) {
+		t.Helper()
+		metricstest.AssertMetric(t,
+			metricstest.IntMetric("throttler_capacity", capacity, nil).WithResource(wantResource),
+			metricstest.IntMetric("throttler_in_flight_requests", inFlight, nil).WithResource(wantResource),
+			metricstest.IntMetric("throttler_queued_requests", queued, nil).WithResource(wantResource))
+	}
+
+	rt.updateCapacity(1)
+
+	// One request holds the capacity, the other one waits for it.
+	release := make(chan struct{})
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- rt.try(context.Background(), func(string) error {
+				<-release
+				return nil
+			})
+		}()
+	}
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		rt.stats.mux.Lock()
+		defer rt.stats.mux.Unlock()
+		return rt.stats.inFlight == 1 && rt.stats.queued == 1, nil
+	}); err != nil {
+		t.Fatal("Requests never got in flight and queued:", err)
+	}
+	assertMetrics(1, 1, 1)
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error("try() =", err)
+		}
+	}
+	assertMetrics(1, 0, 0)
+
+	// Capacity changes are recorded right away.
+	rt.updateCapacity(3)
+	assertMetrics(3, 0, 0)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryBackoff configures how long a request waits before it tries again to
+// get a destination after losing the race for a pod's capacity, which happens
+// a lot when many requests wake a revision scaled to zero at once. The waits
+// grow exponentially from Initial by Factor up to Max. Jitter randomly
+// shortens every wait by up to that fraction to spread the retries out.
+type RetryBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+}
+
+// DefaultRetryBackoff is the retry backoff of the throttler unless configured
+// otherwise.
+var DefaultRetryBackoff = RetryBackoff{
+	Initial: 5 * time.Millisecond,
+	Max:     time.Second,
+	Factor:  2,
+	Jitter:  0.5,
+}
+
+// ThrottlerOption configures optional behavior of the throttler.
+type ThrottlerOption func(*Throttler)
+
+// WithRetryBackoff makes the throttler back off with b between the attempts
+// of a request to get a destination.
+func WithRetryBackoff(b RetryBackoff) ThrottlerOption {
+	return func(t *Throttler) {
+		t.retryBackoff = b
+	}
+}
+
+// delay returns the wait before the given retry, counting from 0.
+func (b RetryBackoff) delay(retry int) time.Duration {
+	d := float64(b.Initial) * math.Pow(math.Max(b.Factor, 1), float64(retry))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d *= 1 - math.Min(b.Jitter, 1)*rand.Float64() //nolint:gosec // We don't need cryptographic randomness here.
+	}
+	return time.Duration(d)
+}
+
+// wait waits before the given retry, but no longer than ctx allows. It
+// returns the context's error if it's done before or while waiting, so that
+// requests fail right away once their deadline passed.
+func (b RetryBackoff) wait(ctx context.Context, retry int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d := b.delay(retry)
+	if d <= 0 {
+		return nil
+	}
+	// The deadline passing is reported right away rather than once the
+	// context notices, lest the request spin until then.
+	pastDeadline := false
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return context.DeadlineExceeded
+		}
+		if left <= d {
+			d, pastDeadline = left, true
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		if pastDeadline {
+			return context.DeadlineExceeded
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/types"
+
+	pkgnet "knative.dev/networking/pkg/apis/networking"
+	. "knative.dev/pkg/logging/testing"
+)
+
+func TestRetryBackoffDelay(t *testing.T) {
+	b := RetryBackoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Factor: 2}
+	for i, want := range []time.Duration{
+		10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond,
+		50 * time.Millisecond, 50 * time.Millisecond,
+	} {
+		if got := b.delay(i); got != want {
+			t.Errorf("delay(%d) = %v, want: %v", i, got, want)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := b.delay(1); got < 10*time.Millisecond || got > 20*time.Millisecond {
+			t.Fatalf("delay(1) = %v, want between 10ms and 20ms", got)
+		}
+	}
+}
+
+func TestRetryBackoffWaitDeadline(t *testing.T) {
+	b := RetryBackoff{Initial: time.Hour, Factor: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := b.wait(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wait() took %v, want it capped at the deadline", elapsed)
+	}
+
+	// Once past the deadline, there's no wait at all.
+	if err := b.wait(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
+
+// countingBreaker counts the calls to Maybe of the breaker it wraps.
+type countingBreaker struct {
+	breaker
+	calls atomic.Int32
+}
+
+func (b *countingBreaker) Maybe(ctx context.Context, thunk func()) error {
+	b.calls.Inc()
+	return b.breaker.Maybe(ctx, thunk)
+}
+
+func TestRevisionThrottlerRetryBackoff(t *testing.T) {
+	rt := newRevisionThrottler(types.NamespacedName{Namespace: "a", Name: "b"}, 1, /*cc*/
+		pkgnet.ServicePortNameHTTP1, testBreakerParams, TestLogger(t))
+	// Capacity without pods to send the requests to makes them retry.
+	rt.breaker.UpdateConcurrency(1)
+	cb := &countingBreaker{breaker: rt.breaker}
+	rt.breaker = cb
+	rt.retryBackoff = RetryBackoff{Initial: 20 * time.Millisecond, Max: time.Second, Factor: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := rt.try(ctx, func(string) error {
+		t.Error("Request reached a pod")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("try() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+	// The attempts after 0, 20, 60 and 100ms, whereas retrying right away
+	// would have made many more.
+	if got := cb.calls.Load(); got < 2 || got > 5 {
+		t.Errorf("Attempts = %d, want between 2 and 5", got)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"context"
+	"sort"
+)
+
+// stickyKey is the context key of the sticky routing key of a request.
+type stickyKey struct{}
+
+// WithStickyKey attaches the key requests prefer the same pod by to ctx, e.g.
+// the value of a session header, for throttlers created with
+// WithStickyRouting.
+func WithStickyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, stickyKey{}, key)
+}
+
+// StickyKeyFrom returns the sticky routing key attached to ctx, if any.
+func StickyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(stickyKey{}).(string)
+	return key
+}
+
+// WithStickyRouting makes the throttler route requests with the same sticky
+// key, see WithStickyKey, to the same pod of a revision while it has
+// capacity, e.g. to make use of warm caches. Requests whose preferred pod is
+// at capacity go to the least loaded pod with capacity instead. The pods are
+// chosen by rendezvous hashing, so that only the keys of pods going away, and
+// a fair share of keys for pods being added, move elsewhere as the revision
+// scales.
+func WithStickyRouting() ThrottlerOption {
+	return func(t *Throttler) {
+		t.stickyRouting = true
+	}
+}
+
+// newStickyPolicy wraps base in a policy that routes requests with a sticky
+// key to their preferred pod, falling back to the pod with the fewest
+// requests in flight if the preferred pod is at capacity. Requests without a
+// key are routed by base. To tell the requests in flight, the weight of the
+// pods is tracked, for requests routed by base only if trackBase is set,
+// since some policies track it themselves. record is called with whether
+// every request with a key got its preferred pod.
+func newStickyPolicy(base lbPolicy, trackBase bool, record func(hit bool)) lbPolicy {
+	return func(ctx context.Context, targets []*podTracker) (func(), *podTracker) {
+		key := StickyKeyFrom(ctx)
+		if key == "" || len(targets) == 0 {
+			cb, pick := base(ctx, targets)
+			if pick == nil || !trackBase {
+				return cb, pick
+			}
+			return weighted(cb, pick)
+		}
+
+		preferred := preferredTracker(key, targets)
+		if cb, ok := preferred.Reserve(ctx); ok {
+			record(true)
+			return weighted(cb, preferred)
+		}
+		record(false)
+		return leastLoaded(ctx, targets, preferred)
+	}
+}
+
+// weighted counts a request against the weight of pick until cb is called.
+func weighted(cb func(), pick *podTracker) (func(), *podTracker) {
+	pick.increaseWeight()
+	return func() {
+		cb()
+		pick.decreaseWeight()
+	}, pick
+}
+
+// leastLoaded reserves capacity on the target with the lowest weight that
+// has capacity, other than skip.
+func leastLoaded(ctx context.Context, targets []*podTracker, skip *podTracker) (func(), *podTracker) {
+	candidates := make([]*podTracker, 0, len(targets)-1)
+	for _, t := range targets {
+		if t != skip {
+			candidates = append(candidates, t)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].getWeight() < candidates[j].getWeight()
+	})
+	for _, t := range candidates {
+		if cb, ok := t.Reserve(ctx); ok {
+			return weighted(cb, t)
+		}
+	}
+	return noop, nil
+}
+
+// preferredTracker returns the target with the highest rendezvous hash of
+// key and its dest.
+func preferredTracker(key string, targets []*podTracker) *podTracker {
+	seed := fnvAdd(fnvOffset, key)
+	var (
+		pick *podTracker
+		max  uint64
+	)
+	for _, t := range targets {
+		if h := mix(fnvAdd(seed, t.dest)); pick == nil || h > max {
+			pick, max = t, h
+		}
+	}
+	return pick
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// fnvAdd continues the 64-bit FNV-1a hash h with s. Unlike hash/fnv it
+// doesn't allocate.
+func fnvAdd(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// mix scrambles the bits of h, so that dests differing in a few bytes only
+// still get unrelated hashes. This is the finalizer of MurmurHash3.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
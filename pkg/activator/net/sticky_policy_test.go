@@ -0,0 +1,199 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"go.opencensus.io/resource"
+
+	"knative.dev/pkg/metrics/metricstest"
+	_ "knative.dev/pkg/metrics/testing"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestStickyPolicyPrefersSamePod(t *testing.T) {
+	var hits []bool
+	policy := newStickyPolicy(firstAvailableLBPolicy, true, func(hit bool) { hits = append(hits, hit) })
+	podTrackers := makeTrackers(5, 10)
+	ctx := WithStickyKey(context.Background(), "session-1")
+
+	cb, want := policy(ctx, podTrackers)
+	t.Cleanup(cb)
+	for i := 0; i < 5; i++ {
+		cb, got := policy(ctx, podTrackers)
+		t.Cleanup(cb)
+		if got != want {
+			t.Fatalf("Request %d went to %s, want: %s", i, got, want)
+		}
+	}
+	if got, want := want.getWeight(), int32(6); got != want {
+		t.Errorf("weight = %d, want: %d", got, want)
+	}
+	for i, hit := range hits {
+		if !hit {
+			t.Errorf("Request %d was recorded as a miss", i)
+		}
+	}
+}
+
+func TestStickyPolicyFallsBackToLeastLoaded(t *testing.T) {
+	var hits []bool
+	policy := newStickyPolicy(firstAvailableLBPolicy, true, func(hit bool) { hits = append(hits, hit) })
+	podTrackers := makeTrackers(3, 1)
+	ctx := WithStickyKey(context.Background(), "session-1")
+
+	cb, preferred := policy(ctx, podTrackers)
+	defer cb()
+	// Make one of the other pods look busier.
+	var busy, idle *podTracker
+	for _, pt := range podTrackers {
+		switch {
+		case pt == preferred:
+		case busy == nil:
+			busy = pt
+		default:
+			idle = pt
+		}
+	}
+	busy.increaseWeight()
+	defer busy.decreaseWeight()
+
+	cb, got := policy(ctx, podTrackers)
+	if got != idle {
+		t.Fatalf("Request went to %v, want the least loaded pod %s", got, idle)
+	}
+	if got, want := idle.getWeight(), int32(1); got != want {
+		t.Errorf("weight = %d, want: %d", got, want)
+	}
+	cb()
+	if got, want := idle.getWeight(), int32(0); got != want {
+		t.Errorf("weight after release = %d, want: %d", got, want)
+	}
+	if want := []bool{true, false}; len(hits) != 2 || hits[0] != want[0] || hits[1] != want[1] {
+		t.Errorf("hits = %v, want: %v", hits, want)
+	}
+
+	// With all pods at capacity there is no pod to go to.
+	for i := 0; i < 3; i++ {
+		cb, got = policy(ctx, podTrackers)
+		defer cb()
+	}
+	if got != nil {
+		t.Errorf("Request went to %s, want no pod", got)
+	}
+}
+
+func TestStickyPolicyWithoutKey(t *testing.T) {
+	podTrackers := makeTrackers(2, 1)
+	record := func(bool) { t.Error("Request without a key was recorded") }
+
+	cb, got := newStickyPolicy(firstAvailableLBPolicy, true, record)(context.Background(), podTrackers)
+	if got != podTrackers[0] {
+		t.Fatalf("Request went to %v, want the base policy's pick %s", got, podTrackers[0])
+	}
+	if got, want := got.getWeight(), int32(1); got != want {
+		t.Errorf("weight = %d, want: %d", got, want)
+	}
+	cb()
+	if got, want := got.getWeight(), int32(0); got != want {
+		t.Errorf("weight after release = %d, want: %d", got, want)
+	}
+
+	// Policies tracking the weight themselves are left alone.
+	cb, got = newStickyPolicy(randomChoice2Policy, false, record)(context.Background(), makeTrackers(1, 0))
+	defer cb()
+	if got, want := got.getWeight(), int32(1); got != want {
+		t.Errorf("weight = %d, want: %d", got, want)
+	}
+}
+
+func TestPreferredTrackerScaling(t *testing.T) {
+	const keys = 1000
+	podTrackers := makeTrackers(10, 0)
+	before := make(map[string]*podTracker, keys)
+	perPod := make(map[*podTracker]int, len(podTrackers))
+	for i := 0; i < keys; i++ {
+		key := "session-" + strconv.Itoa(i)
+		before[key] = preferredTracker(key, podTrackers)
+		perPod[before[key]]++
+	}
+	for _, pt := range podTrackers {
+		// Expect about 100 keys per pod.
+		if n := perPod[pt]; n < 50 || n > 150 {
+			t.Errorf("Pod %s is preferred by %d keys, want about %d", pt, n, keys/len(podTrackers))
+		}
+	}
+
+	// Scaling in only moves the keys of the removed pod.
+	removed := podTrackers[3]
+	scaledIn := append(append([]*podTracker{}, podTrackers[:3]...), podTrackers[4:]...)
+	for key, pt := range before {
+		if got := preferredTracker(key, scaledIn); pt != removed && got != pt {
+			t.Errorf("Key %s moved from %s to %s after scaling in", key, pt, got)
+		}
+	}
+
+	// Scaling out only moves keys to the added pod.
+	added := newPodTracker("added", nil)
+	scaledOut := append(append([]*podTracker{}, podTrackers...), added)
+	moved := 0
+	for key, pt := range before {
+		if got := preferredTracker(key, scaledOut); got != pt {
+			moved++
+			if got != added {
+				t.Errorf("Key %s moved from %s to %s after scaling out", key, pt, got)
+			}
+		}
+	}
+	if moved == 0 {
+		t.Error("No key moved to the added pod")
+	}
+}
+
+func TestStickyRequestMetrics(t *testing.T) {
+	reset := func() {
+		metricstest.Unregister(throttlerCapacityM.Name(), throttlerInFlightM.Name(), throttlerQueuedM.Name(),
+			throttlerStickyRequestsM.Name())
+		register()
+	}
+	reset()
+	defer reset()
+
+	const revName = "sticky-metrics"
+	s := &throttlerStats{statsCtx: metrics.RevisionContext(testNamespace, "svc", "cfg", revName, nil, nil)}
+	s.stickyRequest(true)
+	s.stickyRequest(true)
+	s.stickyRequest(true)
+	s.stickyRequest(false)
+
+	wantResource := &resource.Resource{
+		Type: "knative_revision",
+		Labels: map[string]string{
+			metrics.LabelRevisionName:      revName,
+			metrics.LabelNamespaceName:     testNamespace,
+			metrics.LabelServiceName:       "svc",
+			metrics.LabelConfigurationName: "cfg",
+		},
+	}
+	want := metricstest.IntMetric("throttler_sticky_requests", 3, map[string]string{"sticky_hit": "true"}).WithResource(wantResource)
+	want.Values = append(want.Values,
+		metricstest.IntMetric("throttler_sticky_requests", 1, map[string]string{"sticky_hit": "false"}).Values...)
+	metricstest.AssertMetric(t, want)
+}
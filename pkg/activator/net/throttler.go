@@ -42,6 +42,7 @@ import (
 	v1 "knative.dev/serving/pkg/apis/serving/v1"
 	revisioninformer "knative.dev/serving/pkg/client/injection/informers/serving/v1/revision"
 	servinglisters "knative.dev/serving/pkg/client/listers/serving/v1"
+	"knative.dev/serving/pkg/metrics"
 	"knative.dev/serving/pkg/networking"
 	"knative.dev/serving/pkg/queue"
 )
@@ -146,6 +147,12 @@ type revisionThrottler struct {
 	// This is a breaker for the revision as a whole.
 	breaker breaker
 
+	// retryBackoff spaces out the attempts of a request to get a dest.
+	retryBackoff RetryBackoff
+
+	// stats records the state of the breaker.
+	stats throttlerStats
+
 	// This will be non-empty when we're able to use pod addressing.
 	podTrackers []*podTracker
 
@@ -194,6 +201,7 @@ func newRevisionThrottler(revID types.NamespacedName,
 		protocol:             proto,
 		activatorIndex:       *atomic.NewInt32(-1), // Start with unknown.
 		lbPolicy:             lbp,
+		retryBackoff:         DefaultRetryBackoff,
 	}
 }
 
@@ -214,13 +222,13 @@ func (rt *revisionThrottler) acquireDest(ctx context.Context) (func(), *podTrack
 func (rt *revisionThrottler) try(ctx context.Context, function func(string) error) error {
 	var ret error
 
-	// Retrying infinitely as long as we receive no dest. Outer semaphore and inner
-	// pod capacity are not changed atomically, hence they can race each other. We
-	// "reenqueue" requests should that happen.
-	reenqueue := true
-	for reenqueue {
-		reenqueue = false
-		if err := rt.breaker.Maybe(ctx, func() {
+	// Retrying as long as we receive no dest and the context allows. Outer
+	// semaphore and inner pod capacity are not changed atomically, hence they can
+	// race each other. We "reenqueue" requests should that happen, backing off so
+	// that the requests racing for a cold revision don't all retry at once.
+	for retry := 0; ; retry++ {
+		reenqueue := false
+		if err := rt.maybe(ctx, func() {
 			cb, tracker := rt.acquireDest(ctx)
 			if tracker == nil {
 				// This can happen if individual requests raced each other or if pod
@@ -234,8 +242,30 @@ func (rt *revisionThrottler) try(ctx context.Context, function func(string) erro
 		}); err != nil {
 			return err
 		}
+		if !reenqueue {
+			return ret
+		}
+		if err := rt.retryBackoff.wait(ctx, retry); err != nil {
+			return err
+		}
+	}
+}
+
+// maybe runs thunk through the breaker, keeping track of the requests queued
+// in and let through by the breaker.
+func (rt *revisionThrottler) maybe(ctx context.Context, thunk func()) error {
+	rt.stats.add(1, 0)
+	admitted := false
+	err := rt.breaker.Maybe(ctx, func() {
+		admitted = true
+		rt.stats.add(-1, 1)
+		defer rt.stats.add(0, -1)
+		thunk()
+	})
+	if !admitted {
+		rt.stats.add(-1, 0)
 	}
-	return ret
+	return err
 }
 
 func (rt *revisionThrottler) calculateCapacity(size, activatorCount int) int {
@@ -318,6 +348,7 @@ func (rt *revisionThrottler) updateCapacity(backendCount int) {
 
 	rt.backendCount = backendCount
 	rt.breaker.UpdateConcurrency(capacity)
+	rt.stats.setCapacity(capacity)
 }
 
 func (rt *revisionThrottler) updateThrottlerState(backendCount int, trackers []*podTracker, clusterIPDest *podTracker) {
@@ -454,10 +485,12 @@ type Throttler struct {
 	ipAddress               string // The IP address of this activator.
 	logger                  *zap.SugaredLogger
 	epsUpdateCh             chan *corev1.Endpoints
+	retryBackoff            RetryBackoff
+	stickyRouting           bool
 }
 
 // NewThrottler creates a new Throttler
-func NewThrottler(ctx context.Context, ipAddr string) *Throttler {
+func NewThrottler(ctx context.Context, ipAddr string, opts ...ThrottlerOption) *Throttler {
 	revisionInformer := revisioninformer.Get(ctx)
 	t := &Throttler{
 		revisionThrottlers: make(map[types.NamespacedName]*revisionThrottler),
@@ -465,6 +498,10 @@ func NewThrottler(ctx context.Context, ipAddr string) *Throttler {
 		ipAddress:          ipAddr,
 		logger:             logging.FromContext(ctx),
 		epsUpdateCh:        make(chan *corev1.Endpoints),
+		retryBackoff:       DefaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
 
 	// Watch revisions to create throttler with backlog immediately and delete
@@ -547,6 +584,16 @@ func (t *Throttler) getOrCreateRevisionThrottler(revID types.NamespacedName) (*r
 			queue.BreakerParams{QueueDepth: breakerQueueDepth, MaxConcurrency: revisionMaxConcurrency},
 			t.logger,
 		)
+		revThrottler.retryBackoff = t.retryBackoff
+		if t.stickyRouting {
+			// Only the round robin and first available policies leave the
+			// weight of the pods to us.
+			revThrottler.lbPolicy = newStickyPolicy(revThrottler.lbPolicy,
+				revThrottler.containerConcurrency > 0, revThrottler.stats.stickyRequest)
+		}
+		revThrottler.stats.statsCtx = metrics.RevisionContext(revID.Namespace,
+			rev.Labels[serving.ServiceLabelKey], rev.Labels[serving.ConfigurationLabelKey], revID.Name,
+			rev.Annotations, rev.Labels)
 		t.revisionThrottlers[revID] = revThrottler
 	}
 	return revThrottler, nil
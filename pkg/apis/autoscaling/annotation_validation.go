@@ -57,6 +57,8 @@ func ValidateAnnotations(ctx context.Context, config *autoscalerconfig.Config, a
 		Also(validateWindow(anns)).
 		Also(validateLastPodRetention(anns)).
 		Also(validateScaleDownDelay(anns)).
+		Also(validateWarmFloor(anns)).
+		Also(validateDryRun(anns)).
 		Also(validateMetric(anns)).
 		Also(validateAlgorithm(anns)).
 		Also(validateInitialScale(config, anns))
@@ -111,6 +113,12 @@ func validateFloats(annotations map[string]string) (errs *apis.FieldError) {
 		}
 	}
 
+	if v, ok := annotations[TargetRPSAnnotationKey]; ok {
+		if fv, err := strconv.ParseFloat(v, 64); err != nil || fv < TargetMin {
+			errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("targetRPS %s should be at least %g", v, TargetMin), TargetRPSAnnotationKey))
+		}
+	}
+
 	if v, ok := annotations[TargetUtilizationPercentageKey]; ok {
 		if fv, err := strconv.ParseFloat(v, 64); err != nil {
 			errs = errs.Also(apis.ErrInvalidValue(v, TargetUtilizationPercentageKey))
@@ -143,6 +151,34 @@ func validateScaleDownDelay(annotations map[string]string) *apis.FieldError {
 	return errs
 }
 
+func validateWarmFloor(annotations map[string]string) *apis.FieldError {
+	_, errs := getIntGE0(annotations, WarmFloorAnnotationKey)
+	if w, ok := annotations[WarmFloorCooldownAnnotationKey]; ok {
+		if d, err := time.ParseDuration(w); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(w, WarmFloorCooldownAnnotationKey))
+		} else if d < 0 || d > WindowMax {
+			errs = errs.Also(apis.ErrOutOfBoundsValue(w, 0*time.Second, WindowMax, WarmFloorCooldownAnnotationKey))
+		}
+	}
+	if d, ok := annotations[WarmFloorDecayAnnotationKey]; ok {
+		switch d {
+		case WarmFloorDecayLinear, WarmFloorDecayStep:
+		default:
+			errs = errs.Also(apis.ErrInvalidValue(d, WarmFloorDecayAnnotationKey))
+		}
+	}
+	return errs
+}
+
+func validateDryRun(annotations map[string]string) *apis.FieldError {
+	if v, ok := annotations[DryRunAnnotationKey]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return apis.ErrInvalidValue(v, DryRunAnnotationKey)
+		}
+	}
+	return nil
+}
+
 func validateLastPodRetention(annotations map[string]string) *apis.FieldError {
 	if w, ok := annotations[ScaleToZeroPodRetentionPeriodKey]; ok {
 		if d, err := time.ParseDuration(w); err != nil {
@@ -178,6 +214,7 @@ func validateMinMaxScale(config *autoscalerconfig.Config, annotations map[string
 	max, err := getIntGE0(annotations, MaxScaleAnnotationKey)
 	errs = errs.Also(err)
 
+	// Zero maxScale means unbounded, so any minScale is allowed with it.
 	if max != 0 && max < min {
 		errs = errs.Also(&apis.FieldError{
 			Message: fmt.Sprintf("maxScale=%d is less than minScale=%d", max, min),
@@ -243,6 +280,14 @@ func validateInitialScale(config *autoscalerconfig.Config, annotations map[strin
 		if err != nil || initScaleInt < 0 || (!config.AllowZeroInitialScale && initScaleInt == 0) {
 			return apis.ErrInvalidValue(initialScale, InitialScaleAnnotationKey)
 		}
+		// An invalid maxScale is reported by validateMinMaxScale.
+		if max, err := strconv.ParseInt(annotations[MaxScaleAnnotationKey], 10, 32); err == nil &&
+			max > 0 && int64(initScaleInt) > max {
+			return &apis.FieldError{
+				Message: fmt.Sprintf("initialScale=%d is greater than maxScale=%d", initScaleInt, max),
+				Paths:   []string{InitialScaleAnnotationKey, MaxScaleAnnotationKey},
+			}
+		}
 	}
 	return nil
 }
@@ -124,6 +124,18 @@ func TestValidateAnnotations(t *testing.T) {
 			config.MaxScaleLimit = 10
 			config.MaxScale = 11
 		},
+	}, {
+		name: "minScale is above the default MaxScale without maxScale",
+		configMutator: func(config *autoscalerconfig.Config) {
+			config.MaxScale = 5
+		},
+		annotations: map[string]string{MinScaleAnnotationKey: "8"},
+	}, {
+		name: "minScale is greater than 0 maxScale",
+		annotations: map[string]string{
+			MinScaleAnnotationKey: "11",
+			MaxScaleAnnotationKey: "0",
+		},
 	}, {
 		name: "maxScale is less than MaxScaleLimit",
 		configMutator: func(config *autoscalerconfig.Config) {
@@ -193,6 +205,17 @@ func TestValidateAnnotations(t *testing.T) {
 	}, {
 		name:        "target okay",
 		annotations: map[string]string{TargetAnnotationKey: "11"},
+	}, {
+		name:        "targetRPS 0",
+		annotations: map[string]string{TargetRPSAnnotationKey: "0"},
+		expectErr:   "targetRPS 0 should be at least 0.01: " + TargetRPSAnnotationKey,
+	}, {
+		name:        "targetRPS bad",
+		annotations: map[string]string{TargetRPSAnnotationKey: "fast"},
+		expectErr:   "targetRPS fast should be at least 0.01: " + TargetRPSAnnotationKey,
+	}, {
+		name:        "targetRPS okay",
+		annotations: map[string]string{TargetRPSAnnotationKey: "150"},
 	}, {
 		name:        "TBC negative",
 		annotations: map[string]string{TargetBurstCapacityKey: "-11"},
@@ -302,6 +325,32 @@ func TestValidateAnnotations(t *testing.T) {
 		name:        "invalid scale down delay",
 		annotations: map[string]string{ScaleDownDelayAnnotationKey: "twenty-two-minutes-and-five-seconds"},
 		expectErr:   "invalid value: twenty-two-minutes-and-five-seconds: " + ScaleDownDelayAnnotationKey,
+	}, {
+		name: "valid warm floor",
+		annotations: map[string]string{
+			WarmFloorAnnotationKey:         "5",
+			WarmFloorCooldownAnnotationKey: "10m",
+			WarmFloorDecayAnnotationKey:    WarmFloorDecayStep,
+		},
+	}, {
+		name:        "invalid warm floor",
+		annotations: map[string]string{WarmFloorAnnotationKey: "-1"},
+		expectErr:   "expected 0 <= -1 <= 2147483647: " + WarmFloorAnnotationKey,
+	}, {
+		name:        "invalid warm floor cooldown",
+		annotations: map[string]string{WarmFloorCooldownAnnotationKey: "2h"},
+		expectErr:   "expected 0s <= 2h <= 1h0m0s: " + WarmFloorCooldownAnnotationKey,
+	}, {
+		name:        "invalid warm floor decay",
+		annotations: map[string]string{WarmFloorDecayAnnotationKey: "exponential"},
+		expectErr:   "invalid value: exponential: " + WarmFloorDecayAnnotationKey,
+	}, {
+		name:        "dry run",
+		annotations: map[string]string{DryRunAnnotationKey: "true"},
+	}, {
+		name:        "invalid dry run",
+		annotations: map[string]string{DryRunAnnotationKey: "maybe"},
+		expectErr:   "invalid value: maybe: " + DryRunAnnotationKey,
 	}, {
 		name: "all together now fail",
 		annotations: map[string]string{
@@ -353,6 +402,25 @@ func TestValidateAnnotations(t *testing.T) {
 	}, {
 		name:        "initial scale is greater than 0",
 		annotations: map[string]string{InitialScaleAnnotationKey: "2"},
+	}, {
+		name: "initial scale is greater than maxScale",
+		annotations: map[string]string{
+			InitialScaleAnnotationKey: "5",
+			MaxScaleAnnotationKey:     "3",
+		},
+		expectErr: "initialScale=5 is greater than maxScale=3: " + InitialScaleAnnotationKey + ", " + MaxScaleAnnotationKey,
+	}, {
+		name: "initial scale equals maxScale",
+		annotations: map[string]string{
+			InitialScaleAnnotationKey: "3",
+			MaxScaleAnnotationKey:     "3",
+		},
+	}, {
+		name: "initial scale with unbounded maxScale",
+		annotations: map[string]string{
+			InitialScaleAnnotationKey: "30",
+			MaxScaleAnnotationKey:     "0",
+		},
 	}, {
 		name:        "initial scale non-parseable",
 		annotations: map[string]string{InitialScaleAnnotationKey: "invalid"},
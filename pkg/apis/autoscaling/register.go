@@ -52,8 +52,34 @@ const (
 	InitialScaleAnnotationKey = GroupName + "/initialScale"
 
 	// ScaleDownDelayAnnotationKey is the annotation to specify a scale down delay.
+	// The revision is only scaled down once the desired scale stayed below the
+	// current one for this long, scaling up is immediate. Scaling to zero is
+	// delayed as well, the scale to zero grace period only starts afterwards.
 	ScaleDownDelayAnnotationKey = GroupName + "/scaleDownDelay"
 
+	// WarmFloorAnnotationKey is the annotation to specify a minimum number of
+	// Pods kept after a scale up, decaying to the minimum scale over the
+	// warm floor cooldown. For example,
+	//   autoscaling.knative.dev/warmFloor: "5"
+	//   autoscaling.knative.dev/warmFloorCooldown: "10m"
+	WarmFloorAnnotationKey = GroupName + "/warmFloor"
+	// WarmFloorCooldownAnnotationKey is the annotation to specify the time the
+	// warm floor takes to decay to the minimum scale.
+	WarmFloorCooldownAnnotationKey = GroupName + "/warmFloorCooldown"
+	// WarmFloorDecayAnnotationKey is the annotation to specify how the warm
+	// floor decays, linearly (the default) or in a single step at the end of
+	// the cooldown.
+	WarmFloorDecayAnnotationKey = GroupName + "/warmFloorDecay"
+	// WarmFloorDecayLinear makes the warm floor decay linearly.
+	WarmFloorDecayLinear = "linear"
+	// WarmFloorDecayStep keeps the warm floor until the cooldown ended.
+	WarmFloorDecayStep = "step"
+
+	// DryRunAnnotationKey is the annotation to make the autoscaler compute and
+	// report the desired scale of the revision without applying it. For example,
+	//   autoscaling.knative.dev/dryRun: "true"
+	DryRunAnnotationKey = GroupName + "/dryRun"
+
 	// MetricAnnotationKey is the annotation to specify what metric the PodAutoscaler
 	// should be scaled on. For example,
 	//   autoscaling.knative.dev/metric: cpu
@@ -70,6 +96,13 @@ const (
 	//   autoscaling.knative.dev/metric: cpu
 	//   autoscaling.knative.dev/target: "75"   # target 75% cpu utilization
 	TargetAnnotationKey = GroupName + "/target"
+	// TargetRPSAnnotationKey is the annotation to specify the requests per
+	// second per pod the PodAutoscaler should attempt to maintain when scaling
+	// on RPS. It takes precedence over the target annotation, which thereby
+	// remains free to hold a concurrency target. For example,
+	//   autoscaling.knative.dev/metric: rps
+	//   autoscaling.knative.dev/targetRPS: "150"
+	TargetRPSAnnotationKey = GroupName + "/targetRPS"
 	// TargetMin is the minimum allowable target.
 	// This can be less than 1 due to the fact that with small container
 	// concurrencies and small target utilization values this can get
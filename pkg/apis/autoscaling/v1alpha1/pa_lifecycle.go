@@ -89,6 +89,8 @@ func (pa *PodAutoscaler) ScaleBounds(asConfig *autoscalerconfig.Config) (int32,
 		min, _ = pa.annotationInt32(autoscaling.MinScaleAnnotationKey)
 	}
 
+	// Without a maxScale annotation, max defaults to the configured max-scale,
+	// even if minScale is above it. Zero means unbounded.
 	max := asConfig.MaxScale
 	if paMax, ok := pa.annotationInt32(autoscaling.MaxScaleAnnotationKey); ok {
 		max = paMax
@@ -102,6 +104,13 @@ func (pa *PodAutoscaler) Target() (float64, bool) {
 	return pa.annotationFloat64(autoscaling.TargetAnnotationKey)
 }
 
+// TargetRPS returns the target requests per second per pod, if the
+// corresponding annotation is set.
+func (pa *PodAutoscaler) TargetRPS() (float64, bool) {
+	// The value is validated in the webhook.
+	return pa.annotationFloat64(autoscaling.TargetRPSAnnotationKey)
+}
+
 // TargetUtilization returns the target utilization percentage as a fraction, if
 // the corresponding annotation is set.
 func (pa *PodAutoscaler) TargetUtilization() (float64, bool) {
@@ -144,6 +153,34 @@ func (pa *PodAutoscaler) ScaleDownDelay() (time.Duration, bool) {
 	return pa.annotationDuration(autoscaling.ScaleDownDelayAnnotationKey)
 }
 
+// WarmFloor returns the warm floor annotation value, or false if not present.
+func (pa *PodAutoscaler) WarmFloor() (int32, bool) {
+	// The value is validated in the webhook.
+	return pa.annotationInt32(autoscaling.WarmFloorAnnotationKey)
+}
+
+// WarmFloorCooldown returns the warm floor cooldown annotation value, or false
+// if not present.
+func (pa *PodAutoscaler) WarmFloorCooldown() (time.Duration, bool) {
+	// The value is validated in the webhook.
+	return pa.annotationDuration(autoscaling.WarmFloorCooldownAnnotationKey)
+}
+
+// WarmFloorDecay returns the warm floor decay annotation value, or false if not
+// present.
+func (pa *PodAutoscaler) WarmFloorDecay() (string, bool) {
+	// The value is validated in the webhook.
+	d, ok := pa.Annotations[autoscaling.WarmFloorDecayAnnotationKey]
+	return d, ok
+}
+
+// DryRun returns whether the dry run annotation is set to true.
+func (pa *PodAutoscaler) DryRun() bool {
+	// The value is validated in the webhook.
+	dryRun, _ := strconv.ParseBool(pa.Annotations[autoscaling.DryRunAnnotationKey])
+	return dryRun
+}
+
 // PanicWindowPercentage returns the panic window annotation value, or false if not present.
 func (pa *PodAutoscaler) PanicWindowPercentage() (percentage float64, ok bool) {
 	// The value is validated in the webhook.
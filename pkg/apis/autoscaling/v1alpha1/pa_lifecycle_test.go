@@ -532,6 +532,53 @@ func TestTargetAnnotation(t *testing.T) {
 	}
 }
 
+func TestTargetRPSAnnotation(t *testing.T) {
+	cases := []struct {
+		name       string
+		pa         *PodAutoscaler
+		wantTarget float64
+		wantOK     bool
+	}{{
+		name:       "not present",
+		pa:         pa(map[string]string{}),
+		wantTarget: 0,
+		wantOK:     false,
+	}, {
+		name: "present",
+		pa: pa(map[string]string{
+			autoscaling.TargetRPSAnnotationKey: "150.5",
+		}),
+		wantTarget: 150.5,
+		wantOK:     true,
+	}, {
+		name: "generic target does not count",
+		pa: pa(map[string]string{
+			autoscaling.TargetAnnotationKey: "10",
+		}),
+		wantTarget: 0,
+		wantOK:     false,
+	}, {
+		name: "invalid format",
+		pa: pa(map[string]string{
+			autoscaling.TargetRPSAnnotationKey: "sandwich",
+		}),
+		wantTarget: 0,
+		wantOK:     false,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotTarget, gotOK := tc.pa.TargetRPS()
+			if gotTarget != tc.wantTarget {
+				t.Errorf("TargetRPS = %v; want: %v", gotTarget, tc.wantTarget)
+			}
+			if gotOK != tc.wantOK {
+				t.Errorf("OK = %v; want: %v", gotOK, tc.wantOK)
+			}
+		})
+	}
+}
+
 func TestScaleBounds(t *testing.T) {
 	cases := []struct {
 		name         string
@@ -564,6 +611,14 @@ func TestScaleBounds(t *testing.T) {
 		config: autoscalerconfig.Config{
 			MaxScale: 10,
 		},
+	}, {
+		name:    "only min, above the config keeps the config max",
+		min:     "20",
+		wantMin: 20,
+		wantMax: 10,
+		config: autoscalerconfig.Config{
+			MaxScale: 10,
+		},
 	}, {
 		name:    "max and config",
 		max:     "5",
@@ -727,6 +782,41 @@ func TestMetric(t *testing.T) {
 	}
 }
 
+func TestDryRunAnnotation(t *testing.T) {
+	cases := []struct {
+		name string
+		pa   *PodAutoscaler
+		want bool
+	}{{
+		name: "not present",
+		pa:   pa(map[string]string{}),
+	}, {
+		name: "true",
+		pa: pa(map[string]string{
+			autoscaling.DryRunAnnotationKey: "true",
+		}),
+		want: true,
+	}, {
+		name: "false",
+		pa: pa(map[string]string{
+			autoscaling.DryRunAnnotationKey: "false",
+		}),
+	}, {
+		name: "invalid format",
+		pa: pa(map[string]string{
+			autoscaling.DryRunAnnotationKey: "maybe",
+		}),
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.pa.DryRun(); got != tc.want {
+				t.Errorf("DryRun = %v; want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestScaleDownDelayAnnotation(t *testing.T) {
 	cases := []struct {
 		name      string
@@ -774,6 +864,34 @@ func TestScaleDownDelayAnnotation(t *testing.T) {
 	}
 }
 
+func TestWarmFloorAnnotations(t *testing.T) {
+	p := pa(map[string]string{})
+	if _, ok := p.WarmFloor(); ok {
+		t.Error("WarmFloor() = true without the annotation")
+	}
+	if _, ok := p.WarmFloorCooldown(); ok {
+		t.Error("WarmFloorCooldown() = true without the annotation")
+	}
+	if _, ok := p.WarmFloorDecay(); ok {
+		t.Error("WarmFloorDecay() = true without the annotation")
+	}
+
+	p = pa(map[string]string{
+		autoscaling.WarmFloorAnnotationKey:         "5",
+		autoscaling.WarmFloorCooldownAnnotationKey: "10m",
+		autoscaling.WarmFloorDecayAnnotationKey:    autoscaling.WarmFloorDecayStep,
+	})
+	if got, ok := p.WarmFloor(); !ok || got != 5 {
+		t.Errorf("WarmFloor() = %d, %v, want: 5, true", got, ok)
+	}
+	if got, ok := p.WarmFloorCooldown(); !ok || got != 10*time.Minute {
+		t.Errorf("WarmFloorCooldown() = %v, %v, want: 10m, true", got, ok)
+	}
+	if got, ok := p.WarmFloorDecay(); !ok || got != autoscaling.WarmFloorDecayStep {
+		t.Errorf("WarmFloorDecay() = %q, %v, want: %q, true", got, ok, autoscaling.WarmFloorDecayStep)
+	}
+}
+
 func TestWindowAnnotation(t *testing.T) {
 	cases := []struct {
 		name       string
@@ -115,6 +115,16 @@ const (
 	// It has to be in [0.1,100]
 	QueueSideCarResourcePercentageAnnotation = "queue.sidecar." + GroupName + "/resourcePercentage"
 
+	// QueueSideCarCPURequestAnnotation, QueueSideCarCPULimitAnnotation,
+	// QueueSideCarMemoryRequestAnnotation and QueueSideCarMemoryLimitAnnotation
+	// are resource quantities to use for queue-proxy of the revision. They
+	// take precedence over QueueSideCarResourcePercentageAnnotation and the
+	// queue sidecar resources of config-deployment.
+	QueueSideCarCPURequestAnnotation    = "queue.sidecar." + GroupName + "/cpuRequest"
+	QueueSideCarCPULimitAnnotation      = "queue.sidecar." + GroupName + "/cpuLimit"
+	QueueSideCarMemoryRequestAnnotation = "queue.sidecar." + GroupName + "/memoryRequest"
+	QueueSideCarMemoryLimitAnnotation   = "queue.sidecar." + GroupName + "/memoryLimit"
+
 	// VisibilityClusterLocal is the label value for VisibilityLabelKey
 	// that will result to the Route/KService getting a cluster local
 	// domain suffix.
@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"strings"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/api/validation"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/kmp"
@@ -68,6 +69,7 @@ func (rts *RevisionTemplateSpec) Validate(ctx context.Context) *apis.FieldError
 	// it follows the requirements on the name.
 	errs = errs.Also(validateRevisionName(ctx, rts.Name, rts.GenerateName))
 	errs = errs.Also(validateQueueSidecarAnnotation(rts.Annotations).ViaField("metadata.annotations"))
+	errs = errs.Also(validateQueueSidecarResourceAnnotations(rts.Annotations).ViaField("metadata.annotations"))
 	return errs
 }
 
@@ -177,6 +179,48 @@ func validateTimeoutSeconds(ctx context.Context, timeoutSeconds int64) *apis.Fie
 	return nil
 }
 
+// validateQueueSidecarResourceAnnotations validates the queue sidecar
+// resource quantity annotations, which must be non-negative quantities with
+// the requests not exceeding the limits.
+func validateQueueSidecarResourceAnnotations(annotations map[string]string) *apis.FieldError {
+	if len(annotations) == 0 {
+		return nil
+	}
+	var errs *apis.FieldError
+	for _, keys := range [][2]string{
+		{serving.QueueSideCarCPURequestAnnotation, serving.QueueSideCarCPULimitAnnotation},
+		{serving.QueueSideCarMemoryRequestAnnotation, serving.QueueSideCarMemoryLimitAnnotation},
+	} {
+		var quantities [2]*resource.Quantity
+		for i, key := range keys {
+			v, ok := annotations[key]
+			if !ok {
+				continue
+			}
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				errs = errs.Also(apis.ErrInvalidValue(v, apis.CurrentField).ViaKey(key))
+				continue
+			}
+			if q.Sign() < 0 {
+				err := apis.ErrInvalidValue(v, apis.CurrentField)
+				err.Details = "quantity must not be negative"
+				errs = errs.Also(err.ViaKey(key))
+				continue
+			}
+			quantities[i] = &q
+		}
+		// With only a limit set, a default request above it is lowered to the
+		// limit when building the queue-proxy container.
+		if request, limit := quantities[0], quantities[1]; request != nil && limit != nil && request.Cmp(*limit) > 0 {
+			errs = errs.Also(apis.ErrGeneric(
+				fmt.Sprintf("request %s must be less than or equal to limit %s", request, limit),
+				fmt.Sprintf("[%s]", keys[0]), fmt.Sprintf("[%s]", keys[1])))
+		}
+	}
+	return errs
+}
+
 // validateQueueSidecarAnnotation validates QueueSideCarResourcePercentageAnnotation
 func validateQueueSidecarAnnotation(annotations map[string]string) *apis.FieldError {
 	if len(annotations) == 0 {
@@ -884,6 +884,44 @@ func TestRevisionTemplateSpecValidation(t *testing.T) {
 		},
 		want: apis.ErrInvalidValue("covid-19", autoscaling.MaxScaleAnnotationKey).
 			ViaField("annotations").ViaField("metadata"),
+	}, {
+		name: "metadata.annotations with minScale above maxScale",
+		rts: &RevisionTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					autoscaling.MinScaleAnnotationKey: "5",
+					autoscaling.MaxScaleAnnotationKey: "2",
+				},
+			},
+			Spec: RevisionSpec{
+				PodSpec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: "helloworld",
+					}},
+				},
+			},
+		},
+		want: (&apis.FieldError{
+			Message: "maxScale=2 is less than minScale=5",
+			Paths:   []string{autoscaling.MaxScaleAnnotationKey, autoscaling.MinScaleAnnotationKey},
+		}).ViaField("annotations").ViaField("metadata"),
+	}, {
+		name: "metadata.annotations with minScale and unbounded maxScale",
+		rts: &RevisionTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					autoscaling.MinScaleAnnotationKey: "5",
+					autoscaling.MaxScaleAnnotationKey: "0",
+				},
+			},
+			Spec: RevisionSpec{
+				PodSpec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Image: "helloworld",
+					}},
+				},
+			},
+		},
 	}, {
 		name: "Queue sidecar resource percentage annotation more than 100",
 		rts: &RevisionTemplateSpec{
@@ -1054,6 +1092,64 @@ func TestValidateQueueSidecarAnnotation(t *testing.T) {
 	}
 }
 
+func TestValidateQueueSidecarResourceAnnotations(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        *apis.FieldError
+	}{{
+		name: "none",
+	}, {
+		name: "valid",
+		annotations: map[string]string{
+			serving.QueueSideCarCPURequestAnnotation:    "100m",
+			serving.QueueSideCarCPULimitAnnotation:      "1",
+			serving.QueueSideCarMemoryRequestAnnotation: "64Mi",
+			serving.QueueSideCarMemoryLimitAnnotation:   "128Mi",
+		},
+	}, {
+		name: "request equal to limit",
+		annotations: map[string]string{
+			serving.QueueSideCarCPURequestAnnotation: "500m",
+			serving.QueueSideCarCPULimitAnnotation:   "0.5",
+		},
+	}, {
+		name: "malformed",
+		annotations: map[string]string{
+			serving.QueueSideCarCPURequestAnnotation: "lots",
+		},
+		want: apis.ErrInvalidValue("lots", apis.CurrentField).ViaKey(serving.QueueSideCarCPURequestAnnotation),
+	}, {
+		name: "negative",
+		annotations: map[string]string{
+			serving.QueueSideCarMemoryLimitAnnotation: "-1Gi",
+		},
+		want: &apis.FieldError{
+			Message: "invalid value: -1Gi",
+			Paths:   []string{fmt.Sprintf("[%s]", serving.QueueSideCarMemoryLimitAnnotation)},
+			Details: "quantity must not be negative",
+		},
+	}, {
+		name: "request above limit",
+		annotations: map[string]string{
+			serving.QueueSideCarMemoryRequestAnnotation: "1Gi",
+			serving.QueueSideCarMemoryLimitAnnotation:   "512Mi",
+		},
+		want: apis.ErrGeneric("request 1Gi must be less than or equal to limit 512Mi",
+			fmt.Sprintf("[%s]", serving.QueueSideCarMemoryRequestAnnotation),
+			fmt.Sprintf("[%s]", serving.QueueSideCarMemoryLimitAnnotation)),
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := validateQueueSidecarResourceAnnotations(c.annotations)
+			if got, want := got.Error(), c.want.Error(); got != want {
+				t.Errorf("Got: %q want: %q", got, want)
+			}
+		})
+	}
+}
+
 func TestValidateTimeoutSecond(t *testing.T) {
 	cases := []struct {
 		name      string
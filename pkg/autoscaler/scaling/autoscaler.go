@@ -57,6 +57,12 @@ type autoscaler struct {
 	// window has passed at the reduced concurrency.
 	delayWindow *max.TimeWindow
 
+	// lastScaleUp is the time the desired pod count last increased, which
+	// starts the warm floor's decay, lastPodCount the desired pod count of the
+	// previous tick.
+	lastScaleUp  time.Time
+	lastPodCount int32
+
 	// specMux guards the current DeciderSpec.
 	specMux     sync.RWMutex
 	deciderSpec *DeciderSpec
@@ -121,6 +127,7 @@ func newAutoscaler(
 
 		panicTime:    pt,
 		maxPanicPods: int32(curC),
+		lastPodCount: int32(curC),
 	}
 }
 
@@ -217,12 +224,17 @@ func (a *autoscaler) Scale(logger *zap.SugaredLogger, now time.Time) ScaleResult
 	}
 
 	desiredPodCount := desiredStablePodCount
+	// mode is the mode the autoscaler operates in, window the window whose
+	// observed value the desired pod count is based on.
+	mode, window := "stable", "stable"
 	if !a.panicTime.IsZero() {
+		mode = "panic"
 		// In some edgecases stable window metric might be larger
 		// than panic one. And we should provision for stable as for panic,
 		// so pick the larger of the two.
 		if desiredPodCount < desiredPanicPodCount {
 			desiredPodCount = desiredPanicPodCount
+			window = "panic"
 		}
 		logger.Debug("Operating in panic mode.")
 		// We do not scale down while in panic mode. Only increases will be applied.
@@ -256,6 +268,21 @@ func (a *autoscaler) Scale(logger *zap.SugaredLogger, now time.Time) ScaleResult
 		}
 	}
 
+	// Keep warm capacity after a scale up, if a warm floor was specified.
+	if spec.WarmFloor > 0 && spec.WarmFloorCooldown > 0 {
+		if desiredPodCount > a.lastPodCount {
+			a.lastScaleUp = now
+		}
+		if floor := a.warmFloor(spec, now); desiredPodCount < floor {
+			if debugEnabled {
+				desugared.Debug(
+					fmt.Sprintf("Keeping warm floor of %d instead of %d", floor, desiredPodCount))
+			}
+			desiredPodCount = floor
+		}
+	}
+	a.lastPodCount = desiredPodCount
+
 	// Compute excess burst capacity
 	//
 	// the excess burst capacity is based on panic value, since we don't want to
@@ -278,6 +305,21 @@ func (a *autoscaler) Scale(logger *zap.SugaredLogger, now time.Time) ScaleResult
 			observedPanicValue, spec.TargetBurstCapacity, excessBCF))
 	}
 
+	if debugEnabled {
+		// One structured line per tick, the logger carries the revision key.
+		desugared.Debug("Scaling decision",
+			zap.String("metric", metricName),
+			zap.Float64("observedStableValue", observedStableValue),
+			zap.Float64("observedPanicValue", observedPanicValue),
+			zap.Float64("targetValue", spec.TargetValue),
+			zap.String("mode", mode),
+			zap.String("window", window),
+			zap.Duration("stableWindow", spec.StableWindow),
+			zap.Int("readyPodCount", originalReadyPodsCount),
+			zap.Int32("desiredPodCount", desiredPodCount),
+			zap.Float64("excessBurstCapacity", excessBCF))
+	}
+
 	switch spec.ScalingMetric {
 	case autoscaling.RPS:
 		pkgmetrics.RecordBatch(a.reporterCtx,
@@ -304,6 +346,22 @@ func (a *autoscaler) Scale(logger *zap.SugaredLogger, now time.Time) ScaleResult
 	}
 }
 
+// warmFloor returns the minimum pod count at the given time as the warm floor
+// decays from its initial value after the last scale up to the minimum scale.
+// Once the decay completed, it returns 0, leaving the minimum scale to be
+// enforced as without a warm floor.
+func (a *autoscaler) warmFloor(spec *DeciderSpec, now time.Time) int32 {
+	elapsed := now.Sub(a.lastScaleUp)
+	if a.lastScaleUp.IsZero() || elapsed >= spec.WarmFloorCooldown || spec.WarmFloor <= spec.MinScale {
+		return 0
+	}
+	if spec.WarmFloorDecay == autoscaling.WarmFloorDecayStep {
+		return spec.WarmFloor
+	}
+	decayed := float64(spec.WarmFloor-spec.MinScale) * float64(elapsed) / float64(spec.WarmFloorCooldown)
+	return int32(math.Ceil(float64(spec.WarmFloor) - decayed))
+}
+
 func (a *autoscaler) currentSpec() *DeciderSpec {
 	a.specMux.RLock()
 	defer a.specMux.RUnlock()
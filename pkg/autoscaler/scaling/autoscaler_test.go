@@ -17,7 +17,10 @@ limitations under the License.
 package scaling
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"math"
 	"testing"
@@ -25,13 +28,17 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"go.opencensus.io/resource"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"k8s.io/apimachinery/pkg/types"
 
+	"knative.dev/pkg/logging/logkey"
 	"knative.dev/pkg/metrics/metricstest"
 	servingmetrics "knative.dev/serving/pkg/metrics"
 
 	logtesting "knative.dev/pkg/logging/testing"
+	"knative.dev/serving/pkg/apis/autoscaling"
 	"knative.dev/serving/pkg/autoscaler/metrics"
 	"knative.dev/serving/pkg/resources"
 
@@ -130,6 +137,61 @@ func TestAutoscalerScaleDownDelay(t *testing.T) {
 	})
 }
 
+func TestAutoscalerScaleDownDelayOscillating(t *testing.T) {
+	pc := &fakePodCounter{}
+	metrics := &metricClient{}
+	spec := &DeciderSpec{
+		TargetValue:      10,
+		MaxScaleDownRate: 10,
+		MaxScaleUpRate:   10,
+		PanicThreshold:   100,
+		ScaleDownDelay:   time.Minute,
+	}
+	as := New(context.Background(), testNamespace, testRevision, metrics, pc, spec)
+
+	now := time.Time{}
+	tick := func(concurrency float64) ScaleResult {
+		now = now.Add(2 * time.Second)
+		metrics.SetStableAndPanicConcurrency(concurrency, concurrency)
+		return as.Scale(logtesting.TestLogger(t), now)
+	}
+
+	// Traffic dips every 30s for 20s, which is shorter than the delay, so
+	// the revision stays at the peak rather than flapping.
+	for i := 0; i < 150; i++ {
+		concurrency := 40.
+		if i%15 >= 5 {
+			concurrency = 10
+		}
+		if got, want := tick(concurrency).DesiredPodCount, int32(4); got != want {
+			t.Fatalf("DesiredPodCount at %v = %d, want: %d", now, got, want)
+		}
+	}
+
+	// Scaling up is applied right away.
+	if got, want := tick(60).DesiredPodCount, int32(6); got != want {
+		t.Fatalf("DesiredPodCount after the spike = %d, want: %d", got, want)
+	}
+
+	// Once the traffic stops for good, the revision stays at the peak for the
+	// delay and only then scales to zero, leaving it to the scale to zero
+	// grace period to remove the last pod.
+	spike := now
+	for now.Add(2*time.Second).Sub(spike) < time.Minute {
+		if got, want := tick(0).DesiredPodCount, int32(6); got != want {
+			t.Fatalf("DesiredPodCount %v after the spike = %d, want: %d", now.Sub(spike), got, want)
+		}
+	}
+	if got, want := tick(0).DesiredPodCount, int32(0); got != want {
+		t.Fatalf("DesiredPodCount after the delay = %d, want: %d", got, want)
+	}
+
+	// Traffic coming back scales up right away, too.
+	if got, want := tick(20).DesiredPodCount, int32(2); got != want {
+		t.Fatalf("DesiredPodCount after the traffic came back = %d, want: %d", got, want)
+	}
+}
+
 func TestAutoscalerScaleDownDelayZero(t *testing.T) {
 	pc := &fakePodCounter{}
 	metrics := &metricClient{}
@@ -245,6 +307,20 @@ func TestAutoscalerStableModeIncreaseWithRPS(t *testing.T) {
 	expectScale(t, a, time.Now(), ScaleResult{10, expectedEBC(10, 101, 99, 1), true})
 }
 
+func TestAutoscalerRPSMissingMetrics(t *testing.T) {
+	mc := &metricClient{StableRPS: 50, PanicRPS: 50}
+	a, _ := newTestAutoscalerWithScalingMetric(10, 101, mc, "rps", false /*startInPanic*/)
+	a.deciderSpec.PanicThreshold = 100
+	now := time.Now()
+	expectScale(t, a, now, ScaleResult{5, expectedEBC(10, 101, 50, 1), true})
+
+	// Missing metrics yield no decision, keeping the last one in place.
+	mc.ErrF = func(types.NamespacedName, time.Time) error {
+		return metrics.ErrNoData
+	}
+	expectScale(t, a, now.Add(time.Second), ScaleResult{0, 0, false})
+}
+
 func TestAutoscalerUnpanicAfterSlowIncrease(t *testing.T) {
 	// Do initial jump from 10 to 25 pods.
 	metrics := &metricClient{StableConcurrency: 11, PanicConcurrency: 25}
@@ -518,6 +594,163 @@ func TestAutoscalerRateLimitScaleDown(t *testing.T) {
 	expectScale(t, a, time.Now(), ScaleResult{1, expectedEBC(10, 61, 1, 10), true})
 }
 
+func TestAutoscalerWarmFloor(t *testing.T) {
+	const cooldown = 100 * time.Second
+	tests := []struct {
+		name  string
+		decay string
+		want  []int32
+	}{{
+		name: "linear",
+		want: []int32{5, 3, 2, 1},
+	}, {
+		name:  "step",
+		decay: autoscaling.WarmFloorDecayStep,
+		want:  []int32{5, 5, 5, 1},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := &metricClient{StableConcurrency: 50, PanicConcurrency: 50}
+			a := newTestAutoscalerNoPC(10, 101, metrics)
+			a.deciderSpec.PanicThreshold = 100
+			a.deciderSpec.MinScale = 1
+			a.deciderSpec.WarmFloor = 5
+			a.deciderSpec.WarmFloorCooldown = cooldown
+			a.deciderSpec.WarmFloorDecay = test.decay
+
+			// The burst scales up, then the traffic goes away.
+			now := time.Now()
+			logger := logtesting.TestLogger(t)
+			got := []int32{a.Scale(logger, now).DesiredPodCount}
+			metrics.SetStableAndPanicConcurrency(1, 1)
+			for _, elapsed := range []time.Duration{cooldown / 2, 3 * cooldown / 4, cooldown} {
+				got = append(got, a.Scale(logger, now.Add(elapsed)).DesiredPodCount)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("DesiredPodCount = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAutoscalerWarmFloorBelowMinScale(t *testing.T) {
+	metrics := &metricClient{StableConcurrency: 50, PanicConcurrency: 50}
+	a := newTestAutoscalerNoPC(10, 101, metrics)
+	a.deciderSpec.PanicThreshold = 100
+	a.deciderSpec.MinScale = 3
+	a.deciderSpec.WarmFloor = 2
+	a.deciderSpec.WarmFloorCooldown = time.Minute
+
+	// A warm floor not above the minimum scale has no effect.
+	now := time.Now()
+	a.Scale(logtesting.TestLogger(t), now)
+	metrics.SetStableAndPanicConcurrency(1, 1)
+	if got, want := a.Scale(logtesting.TestLogger(t), now.Add(time.Second)).DesiredPodCount, int32(1); got != want {
+		t.Errorf("DesiredPodCount = %d, want: %d", got, want)
+	}
+}
+
+func TestAutoscalerDecisionLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   zapcore.Level
+		metric  string
+		metrics *metricClient
+		want    map[string]interface{}
+	}{{
+		name:    "stable",
+		metric:  autoscaling.Concurrency,
+		level:   zapcore.DebugLevel,
+		metrics: &metricClient{StableConcurrency: 50, PanicConcurrency: 10},
+		want: map[string]interface{}{
+			logkey.Key:            testNamespace + "/" + testRevision,
+			"metric":              "concurrency",
+			"observedStableValue": 50.,
+			"observedPanicValue":  10.,
+			"mode":                "stable",
+			"window":              "stable",
+			"readyPodCount":       1.,
+			"desiredPodCount":     5.,
+		},
+	}, {
+		name:    "panic",
+		level:   zapcore.DebugLevel,
+		metric:  autoscaling.Concurrency,
+		metrics: &metricClient{StableConcurrency: 50, PanicConcurrency: 200},
+		want: map[string]interface{}{
+			logkey.Key:            testNamespace + "/" + testRevision,
+			"metric":              "concurrency",
+			"observedStableValue": 50.,
+			"observedPanicValue":  200.,
+			"mode":                "panic",
+			"window":              "panic",
+			"readyPodCount":       1.,
+			"desiredPodCount":     10.,
+		},
+	}, {
+		name:    "rps",
+		level:   zapcore.DebugLevel,
+		metric:  autoscaling.RPS,
+		metrics: &metricClient{StableRPS: 50, PanicRPS: 10},
+		want: map[string]interface{}{
+			logkey.Key:            testNamespace + "/" + testRevision,
+			"metric":              "rps",
+			"observedStableValue": 50.,
+			"observedPanicValue":  10.,
+			"mode":                "stable",
+			"window":              "stable",
+			"readyPodCount":       1.,
+			"desiredPodCount":     5.,
+		},
+	}, {
+		name:    "off by default",
+		level:   zapcore.InfoLevel,
+		metric:  autoscaling.Concurrency,
+		metrics: &metricClient{StableConcurrency: 50, PanicConcurrency: 10},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+				zapcore.AddSync(&buf), test.level)).With(zap.String(logkey.Key, testNamespace+"/"+testRevision))
+
+			a, _ := newTestAutoscalerWithScalingMetric(10, 101, test.metrics, test.metric, false /*startInPanic*/)
+			a.Scale(logger.Sugar(), time.Now())
+
+			var got map[string]interface{}
+			scanner := bufio.NewScanner(&buf)
+			for scanner.Scan() {
+				var line map[string]interface{}
+				if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+					t.Fatal("Failed to parse log line:", err)
+				}
+				if line["msg"] == "Scaling decision" {
+					if got != nil {
+						t.Error("Got more than one scaling decision per tick")
+					}
+					got = line
+				}
+			}
+			if test.want == nil {
+				if got != nil {
+					t.Error("Got a scaling decision logged:", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("No scaling decision logged")
+			}
+			for k, want := range test.want {
+				if got[k] != want {
+					t.Errorf("%s = %v, want: %v", k, got[k], want)
+				}
+			}
+		})
+	}
+}
+
 func TestCantCountPods(t *testing.T) {
 	metrics := &metricClient{StableConcurrency: 1000, PanicConcurrency: 888}
 	a, pc := newTestAutoscaler(10, 81, metrics)
@@ -68,7 +68,8 @@ type DeciderSpec struct {
 	// StableWindow is needed to determine when to exit panic mode.
 	StableWindow time.Duration
 	// ScaleDownDelay is the time that must pass at reduced concurrency before a
-	// scale-down decision is applied.
+	// scale-down decision is applied, including the decision to scale to zero.
+	// Scale-up decisions are applied right away.
 	ScaleDownDelay time.Duration
 	// InitialScale is the calculated initial scale of the revision, taking both
 	// revision initial scale and cluster initial scale into account. Revision initial
@@ -76,6 +77,15 @@ type DeciderSpec struct {
 	InitialScale int32
 	// Reachable describes whether the revision is referenced by any route.
 	Reachable bool
+	// MinScale is the minimum scale of the revision, which the warm floor decays
+	// to. It is enforced by the scaler, not by the decider.
+	MinScale int32
+	// WarmFloor, if greater than MinScale, is the minimum scale right after a
+	// scale up. It decays to MinScale over WarmFloorCooldown, following
+	// WarmFloorDecay, so that bursty traffic finds warm capacity.
+	WarmFloor         int32
+	WarmFloorCooldown time.Duration
+	WarmFloorDecay    string
 }
 
 // DeciderStatus is the current scale recommendation.
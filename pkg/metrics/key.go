@@ -40,6 +40,10 @@ const (
 	// LabelRevisionName is the label for the monitored revision
 	LabelRevisionName = "revision_name"
 
+	// LabelEnvironment is the label for the environment, e.g. staging, the
+	// monitored revision runs in
+	LabelEnvironment = "env"
+
 	// LabelNamespaceName is the label for immutable name of the namespace that the service is deployed
 	LabelNamespaceName = metricskey.LabelNamespaceName
 
@@ -73,4 +77,5 @@ var (
 	ResponseCodeKey      = tag.MustNewKey(LabelResponseCode)
 	ResponseCodeClassKey = tag.MustNewKey(LabelResponseCodeClass)
 	RouteTagKey          = tag.MustNewKey(LabelRouteTag)
+	EnvironmentKey       = tag.MustNewKey(LabelEnvironment)
 )
@@ -18,6 +18,7 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	lru "github.com/hashicorp/golang-lru"
 	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/metrics/metricskey"
@@ -27,6 +28,7 @@ import (
 
 	"go.opencensus.io/resource"
 	"go.opencensus.io/tag"
+	"go.uber.org/atomic"
 )
 
 var (
@@ -34,6 +36,10 @@ var (
 	// in an LRU cache.
 	// Hashicorp LRU cache is synchronized.
 	contextCache *lru.Cache
+
+	// environment is the env resource label of the contexts generated by
+	// PodRevisionContext, if set.
+	environment atomic.String
 )
 
 // This is a fairly arbitrary number but we want it to be higher than the
@@ -112,12 +118,32 @@ func PodRevisionContext(pod, container, ns, svc, cfg, rev string,
 			return rctx, err
 		}
 		rctx = AugmentWithRevision(rctx, ns, svc, cfg, rev, annotations, labels)
+		if env := environment.Load(); env != "" {
+			if rctx, err = AugmentWithEnvironment(rctx, env); err != nil {
+				return rctx, err
+			}
+		}
 		contextCache.Add(key, rctx)
 		ctx = rctx
 	}
 	return ctx.(context.Context), nil
 }
 
+// SetEnvironment makes PodRevisionContext label the resource of the contexts
+// it generates with the given constant environment, e.g. staging, so that
+// dashboards can tell the environments apart, or not if empty. It's meant to
+// be called once, before any of the contexts are generated.
+func SetEnvironment(env string) error {
+	if env != "" {
+		if _, err := AugmentWithEnvironment(context.Background(), env); err != nil {
+			return err
+		}
+	}
+	environment.Store(env)
+	contextCache.Purge()
+	return nil
+}
+
 // sanitizeRune converts anything that is not a letter or digit to an underscore
 // Taken from https://github.com/census-instrumentation/opencensus-go/blob/v0.23.0/internal/sanitize.go
 func sanitizeRune(r rune) rune {
@@ -148,6 +174,33 @@ func AugmentWithRevision(baseCtx context.Context, ns, svc, cfg, rev string,
 	return metricskey.WithResource(baseCtx, r)
 }
 
+// AugmentWithEnvironment augments the resource of the given context with a
+// constant environment label. The environment must be a valid tag value, like
+// those of PodContext.
+func AugmentWithEnvironment(baseCtx context.Context, env string) (context.Context, error) {
+	if env == "" {
+		return baseCtx, errors.New("environment must not be empty")
+	}
+	if _, err := tag.New(baseCtx, tag.Upsert(EnvironmentKey, env)); err != nil {
+		return baseCtx, err
+	}
+
+	// The resource might be shared with cached contexts, so copy it.
+	r := resource.Resource{
+		Type:   ResourceTypeKnativeRevision,
+		Labels: map[string]string{LabelEnvironment: env},
+	}
+	if base := metricskey.GetResource(baseCtx); base != nil {
+		r.Type = base.Type
+		for k, v := range base.Labels {
+			if k != LabelEnvironment {
+				r.Labels[k] = v
+			}
+		}
+	}
+	return metricskey.WithResource(baseCtx, r), nil
+}
+
 // AugmentWithResponse augments the given context with response-code specific tags.
 func AugmentWithResponse(baseCtx context.Context, responseCode int) context.Context {
 	ctx, _ := tag.New(
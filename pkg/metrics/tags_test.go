@@ -69,6 +69,15 @@ func TestContextsErrors(t *testing.T) {
 		if _, err := PodRevisionContext(v, v, v, v, v, v, map[string]string{v:v}, map[string]string{v:v}); err == nil {
 			t.Errorf("PodRevisionContext(%q) = nil, wanted an error", v)
 		}
+		if _, err := AugmentWithEnvironment(context.Background(), v); err == nil {
+			t.Errorf("AugmentWithEnvironment(%q) = nil, wanted an error", v)
+		}
+		if err := SetEnvironment(v); err == nil {
+			t.Errorf("SetEnvironment(%q) = nil, wanted an error", v)
+		}
+	}
+	if _, err := AugmentWithEnvironment(context.Background(), ""); err == nil {
+		t.Error("AugmentWithEnvironment(\"\") = nil, wanted an error")
 	}
 }
 
@@ -221,6 +230,54 @@ func TestContexts(t *testing.T) {
 				metricskey.LabelRevisionName:      "testrev",
 			},
 		},
+	}, {
+		name: "pod revision context with environment",
+		ctx: mustCtx(t, func() (context.Context, error) {
+			if err := SetEnvironment("staging"); err != nil {
+				return nil, err
+			}
+			defer SetEnvironment("")
+			return PodRevisionContext("testpod", "testcontainer", "testns", "testsvc", "testcfg",
+				"testrev", nil, nil)
+		}),
+		wantTags: map[string]string{
+			metricskey.PodName:       "testpod",
+			metricskey.ContainerName: "testcontainer",
+		},
+		wantResource: &resource.Resource{
+			Type: "knative_revision",
+			Labels: map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testcfg",
+				metricskey.LabelRevisionName:      "testrev",
+				LabelEnvironment:                  "staging",
+			},
+		},
+	}, {
+		name: "pod revision context augmented with environment",
+		ctx: mustCtx(t, func() (context.Context, error) {
+			ctx, err := PodRevisionContext("testpod", "testcontainer", "testns", "testsvc", "testcfg",
+				"testrev", nil, nil)
+			if err != nil {
+				return ctx, err
+			}
+			return AugmentWithEnvironment(ctx, "staging")
+		}),
+		wantTags: map[string]string{
+			metricskey.PodName:       "testpod",
+			metricskey.ContainerName: "testcontainer",
+		},
+		wantResource: &resource.Resource{
+			Type: "knative_revision",
+			Labels: map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testcfg",
+				metricskey.LabelRevisionName:      "testrev",
+				LabelEnvironment:                  "staging",
+			},
+		},
 	}}
 
 	for _, test := range tests {
@@ -0,0 +1,89 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var achievedConcurrencyMaxM = stats.Int64(
+	"achieved_concurrency_max",
+	"The highest number of requests the breaker admitted concurrently during the last reporting interval",
+	stats.UnitDimensionless)
+
+// AchievedConcurrencyReporter records the peak number of requests a breaker
+// admitted concurrently per reporting interval, which tells whether the
+// configured concurrency limit is ever reached.
+type AchievedConcurrencyReporter struct {
+	statsCtx context.Context
+	breaker  *Breaker
+}
+
+// NewAchievedConcurrencyReporter creates an AchievedConcurrencyReporter
+// recording the achieved_concurrency_max metric of the given breaker, which
+// must have been created with TrackPeakConcurrency.
+func NewAchievedConcurrencyReporter(b *Breaker, ns, service, config, rev, pod string) (*AchievedConcurrencyReporter, error) {
+	if b.peak == nil {
+		return nil, errors.New("the breaker doesn't track its peak concurrency")
+	}
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The highest number of requests the breaker admitted concurrently during the last reporting interval",
+		Measure:     achievedConcurrencyMaxM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AchievedConcurrencyReporter{
+		statsCtx: ctx,
+		breaker:  b,
+	}, nil
+}
+
+// Run records the peak concurrency of every period until ctx is done.
+func (r *AchievedConcurrencyReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the peak concurrency since the previous report and starts a
+// new interval at the current concurrency.
+func (r *AchievedConcurrencyReporter) report() {
+	pkgmetrics.Record(r.statsCtx, achievedConcurrencyMaxM.M(int64(r.breaker.peak.take())))
+}
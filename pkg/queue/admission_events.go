@@ -0,0 +1,56 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "time"
+
+// AdmissionEvent describes a request admitted or rejected by the breaker.
+type AdmissionEvent struct {
+	// Admitted is whether the request was admitted.
+	Admitted bool
+	// Wait is the time the request spent in the breaker until it was
+	// admitted or rejected.
+	Wait time.Duration
+	// Cost is the capacity the request took if admitted.
+	Cost int
+	// Reason is the error the request was rejected with, nil if admitted.
+	Reason error
+}
+
+// AdmissionEvents returns the channel the breaker sends its admission events
+// on, or nil if not enabled via BreakerParams.AdmissionEventBuffer. If the
+// channel is full, the oldest event is dropped to make room rather than
+// blocking the request.
+func (b *Breaker) AdmissionEvents() <-chan AdmissionEvent {
+	return b.events
+}
+
+// emit sends the event, dropping the oldest one if the channel is full. The
+// events have to be enabled.
+func (b *Breaker) emit(ev AdmissionEvent) {
+	for {
+		select {
+		case b.events <- ev:
+			return
+		default:
+		}
+		select {
+		case <-b.events:
+		default:
+		}
+	}
+}
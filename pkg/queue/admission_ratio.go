@@ -0,0 +1,97 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var admissionSuccessRatioM = stats.Float64(
+	"admission_success_ratio",
+	"The fraction of requests the breaker admitted during the last reporting interval",
+	stats.UnitDimensionless)
+
+// AdmissionRatioReporter records the fraction of requests admitted by a
+// breaker per reporting interval, a single signal that is easy to alert on.
+type AdmissionRatioReporter struct {
+	statsCtx context.Context
+	breaker  *Breaker
+
+	// The breaker's totals at the end of the previous interval.
+	admitted, rejected int64
+}
+
+// NewAdmissionRatioReporter creates an AdmissionRatioReporter recording the
+// admission_success_ratio metric of the given breaker.
+func NewAdmissionRatioReporter(b *Breaker, ns, service, config, rev, pod string) (*AdmissionRatioReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The fraction of requests the breaker admitted during the last reporting interval",
+		Measure:     admissionSuccessRatioM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AdmissionRatioReporter{
+		statsCtx: ctx,
+		breaker:  b,
+		admitted: b.admitted.Load(),
+		rejected: b.rejected.Load(),
+	}, nil
+}
+
+// Run records the admission ratio of every period until ctx is done.
+func (r *AdmissionRatioReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the admission ratio since the previous report and starts a
+// new interval. An interval without requests has nothing to complain about
+// and is recorded as fully successful.
+func (r *AdmissionRatioReporter) report() {
+	admitted, rejected := r.breaker.admitted.Load(), r.breaker.rejected.Load()
+	dAdmitted, dRejected := admitted-r.admitted, rejected-r.rejected
+	r.admitted, r.rejected = admitted, rejected
+
+	ratio := 1.
+	if total := dAdmitted + dRejected; total > 0 {
+		ratio = float64(dAdmitted) / float64(total)
+	}
+	pkgmetrics.Record(r.statsCtx, admissionSuccessRatioM.M(ratio))
+}
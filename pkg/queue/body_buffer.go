@@ -0,0 +1,180 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/atomic"
+	network "knative.dev/networking/pkg"
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+const (
+	// Values of the action tag of buffering_backpressure_count.
+	backpressureFallbackStream = "fallback_stream"
+	backpressureRejected       = "rejected"
+)
+
+var retryBufferBytesM = stats.Int64(
+	"retry_buffer_bytes",
+	"The number of bytes of request bodies buffered for replay on retries",
+	stats.UnitBytes)
+
+// BufferingBudget bounds the memory all requests together may use for
+// buffering their bodies.
+type BufferingBudget struct {
+	total     int64
+	available atomic.Int64
+}
+
+// NewBufferingBudget creates a BufferingBudget of the given number of bytes.
+func NewBufferingBudget(bytes int64) *BufferingBudget {
+	b := &BufferingBudget{total: bytes}
+	b.available.Store(bytes)
+	return b
+}
+
+// InUse returns the number of bytes currently buffered.
+func (b *BufferingBudget) InUse() int64 {
+	return b.total - b.available.Load()
+}
+
+// tryReserve reserves n bytes of the budget if available.
+func (b *BufferingBudget) tryReserve(n int64) bool {
+	for {
+		cur := b.available.Load()
+		if cur < n {
+			return false
+		}
+		if b.available.CAS(cur, cur-n) {
+			return true
+		}
+	}
+}
+
+// release returns n reserved bytes to the budget.
+func (b *BufferingBudget) release(n int64) {
+	b.available.Add(n)
+}
+
+// BodyBufferingHandler reads request bodies of up to maxBodyBytes completely
+// before passing the requests on, so that slow clients don't hold up the user
+// container and RetryTransport can replay them. The buffered bodies are
+// accounted against the shared budget until the request completes.
+// Once the budget is exhausted, requests are streamed as usual or, if
+// rejectOnExhaustion is set, rejected with a 503. Requests without a known
+// length or with larger bodies are always streamed.
+func BodyBufferingHandler(budget *BufferingBudget, maxBodyBytes int64, rejectOnExhaustion bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := r.ContentLength
+		if n <= 0 || n > maxBodyBytes || network.IsKubeletProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !budget.tryReserve(n) {
+			if rejectOnExhaustion {
+				recordBackpressure(r, backpressureRejected)
+				http.Error(w, "request body buffers exhausted", http.StatusServiceUnavailable)
+				return
+			}
+			recordBackpressure(r, backpressureFallbackStream)
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer budget.release(n)
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, n))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordBackpressure marks the request as affected by an exhausted buffering
+// budget, to be recorded by the request metrics handler.
+func recordBackpressure(r *http.Request, action string) {
+	if state := requestMetricsStateFrom(r.Context()); state != nil {
+		state.setBackpressureAction(action)
+	}
+}
+
+// RetryBufferReporter records the number of bytes buffered by the
+// BodyBufferingHandler for replay on retries.
+type RetryBufferReporter struct {
+	statsCtx context.Context
+	budget   *BufferingBudget
+}
+
+// NewRetryBufferReporter creates a RetryBufferReporter recording the
+// retry_buffer_bytes metric of the given budget for the given revision.
+func NewRetryBufferReporter(budget *BufferingBudget, ns, service, config, rev, pod string) (*RetryBufferReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The number of bytes of request bodies buffered for replay on retries",
+		Measure:     retryBufferBytesM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RetryBufferReporter{
+		statsCtx: ctx,
+		budget:   budget,
+	}, nil
+}
+
+// Run records the number of buffered bytes every period until ctx is done.
+func (r *RetryBufferReporter) Run(ctx context.Context, period time.Duration) {
+	r.report()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the current number of buffered bytes.
+func (r *RetryBufferReporter) report() {
+	pkgmetrics.Record(r.statsCtx, retryBufferBytesM.M(r.budget.InUse()))
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestBodyBufferingHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		reject   bool
+		wantCode int
+		wantBody string
+	}{{
+		name:     "fallback to streaming",
+		wantCode: http.StatusOK,
+		wantBody: "second",
+	}, {
+		name:     "reject",
+		reject:   true,
+		wantCode: http.StatusServiceUnavailable,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			// The first request holds most of the budget while the second
+			// one arrives.
+			var second *httptest.ResponseRecorder
+			var secondBody string
+			var handler http.Handler
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) == "first" {
+					second = httptest.NewRecorder()
+					handler.ServeHTTP(second, httptest.NewRequest(http.MethodPost, targetURI, strings.NewReader("second")))
+					return
+				}
+				secondBody = string(body)
+			})
+			buffering := BodyBufferingHandler(NewBufferingBudget(10), 100, test.reject, next)
+			var err error
+			handler, err = NewRequestMetricsHandler(buffering, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, targetURI, strings.NewReader("first")))
+			if second.Code != test.wantCode {
+				t.Errorf("Code = %d, want: %d", second.Code, test.wantCode)
+			}
+			if secondBody != test.wantBody {
+				t.Errorf("Upstream body = %q, want: %q", secondBody, test.wantBody)
+			}
+			action := backpressureFallbackStream
+			if test.reject {
+				action = backpressureRejected
+			}
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("buffering_backpressure_count", 1, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+				"action":                   action,
+			}))
+		})
+	}
+}
+
+func TestBodyBufferingHandlerReleasesBudget(t *testing.T) {
+	budget := NewBufferingBudget(10)
+	var got []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, string(body))
+	})
+	handler := BodyBufferingHandler(budget, 100, true /*rejectOnExhaustion*/, next)
+
+	// Sequential requests each get the whole budget.
+	for _, body := range []string{"0123456789", "0123456789"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, targetURI, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Code = %d, want: %d", rec.Code, http.StatusOK)
+		}
+	}
+	if got := budget.available.Load(); got != 10 {
+		t.Errorf("Available budget = %d, want: 10", got)
+	}
+
+	// Bodies larger than the budget's limit are streamed.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, targetURI, strings.NewReader(strings.Repeat("x", 200))))
+	if rec.Code != http.StatusOK || len(got) != 3 || len(got[2]) != 200 {
+		t.Errorf("Large body wasn't streamed, code = %d", rec.Code)
+	}
+}
+
+func TestRetryBufferReporter(t *testing.T) {
+	defer metricstest.Unregister(retryBufferBytesM.Name())
+
+	budget := NewBufferingBudget(100)
+	r, err := NewRetryBufferReporter(budget, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	assertBuffered := func(n int64) {
+		t.Helper()
+		r.report()
+		metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("retry_buffer_bytes", n, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}))
+	}
+
+	// While the requests are in flight their bodies are held for replay.
+	var handler http.Handler
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "outer" {
+			assertBuffered(5)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, targetURI, strings.NewReader("inner request")))
+			assertBuffered(5)
+			return
+		}
+		assertBuffered(5 + 13)
+
+		replay, err := r.GetBody()
+		if err != nil {
+			t.Fatal("GetBody() =", err)
+		}
+		if body, _ := ioutil.ReadAll(replay); string(body) != "inner request" {
+			t.Errorf("Replayed body = %q, want: %q", body, "inner request")
+		}
+	})
+	handler = BodyBufferingHandler(budget, 100, false /*rejectOnExhaustion*/, next)
+
+	assertBuffered(0)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, targetURI, strings.NewReader("outer")))
+	assertBuffered(0)
+}
@@ -21,15 +21,75 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/clock"
 )
 
 var (
 	// ErrRequestQueueFull indicates the breaker queue depth was exceeded.
 	ErrRequestQueueFull = errors.New("pending request queue full")
+
+	// ErrQueueTimeout indicates a request waited longer than the breaker's
+	// queue timeout for capacity.
+	ErrQueueTimeout = errors.New("timed out waiting in the pending request queue")
+
+	// ErrDraining indicates the breaker is draining and no longer admits
+	// requests.
+	ErrDraining = errors.New("breaker is draining")
+
+	// ErrNoDeadline indicates a request without a deadline was rejected
+	// because the breaker is saturated.
+	ErrNoDeadline = errors.New("request without a deadline rejected under load")
+
+	// ErrCapacityDenied indicates the breaker's capacity oracle denied the
+	// admission of a request.
+	ErrCapacityDenied = errors.New("admission denied by the capacity oracle")
+
+	// ErrDependencyUnhealthy indicates the breaker's dependency health
+	// provider reported the dependency down.
+	ErrDependencyUnhealthy = errors.New("dependency of the revision is unhealthy")
+
+	// ErrSlotTimeout indicates an admitted request held its slot for longer
+	// than the breaker's maximum slot time and had its context cancelled.
+	ErrSlotTimeout = errors.New("request exceeded the maximum slot time")
+
+	// ErrRequestCancelled indicates the request's context was cancelled, e.g.
+	// because the client went away, while it waited for capacity. It wraps
+	// context.Canceled.
+	ErrRequestCancelled = fmt.Errorf("%w: request cancelled while waiting for capacity", context.Canceled)
+
+	// errDrainedWhileQueued is returned to requests that were already waiting
+	// for capacity when the breaker started draining.
+	errDrainedWhileQueued = fmt.Errorf("%w: request removed from the queue", ErrDraining)
+
+	// errSemaphoreStopped is returned by the semaphore when waiting for
+	// capacity was aborted via the stop channel.
+	errSemaphoreStopped = errors.New("semaphore acquisition stopped")
 )
 
+// CapacityOracle is consulted by the breaker before admitting a request, so
+// that admission can account for limits shared with other pods, e.g. those
+// of a downstream service.
+type CapacityOracle interface {
+	// Admit returns whether the request with the given context may be
+	// admitted. An error makes the breaker fall back to its local decision.
+	Admit(ctx context.Context) (bool, error)
+}
+
+// CapacityOracleFunc adapts a function to a CapacityOracle.
+type CapacityOracleFunc func(ctx context.Context) (bool, error)
+
+// Admit calls f(ctx).
+func (f CapacityOracleFunc) Admit(ctx context.Context) (bool, error) {
+	return f(ctx)
+}
+
 // MaxBreakerCapacity is the largest valid value for the MaxConcurrency value of BreakerParams.
 // This is limited by the maximum size of a chan struct{} in the current implementation.
 const MaxBreakerCapacity = math.MaxInt32
@@ -39,6 +99,145 @@ type BreakerParams struct {
 	QueueDepth      int
 	MaxConcurrency  int
 	InitialCapacity int
+
+	// QueueTimeout bounds the time a request waits for capacity, if positive.
+	QueueTimeout time.Duration
+
+	// CostDeadlineScheduling makes the breaker account requests by their cost
+	// (see WithRequestCost) and admit queued requests that fit the free
+	// capacity in order of their context's deadline.
+	CostDeadlineScheduling bool
+
+	// CapacityStep, if positive, makes UpdateConcurrency move the capacity
+	// toward the requested value by at most CapacityStep every
+	// CapacityStepInterval instead of applying it right away.
+	CapacityStep         int
+	CapacityStepInterval time.Duration
+
+	// BurstCapacity is the number of requests admitted on top of the capacity
+	// to absorb short bursts.
+	BurstCapacity int
+
+	// OnCapacityChange, if set, is called with the capacity, not counting
+	// burst slots, whenever it's applied, starting with the initial capacity.
+	OnCapacityChange func(capacity int)
+
+	// OnWait, if set, is called with the time each request spent in Maybe
+	// until it was admitted or rejected. The method breakers call it, too.
+	OnWait func(wait time.Duration, admitted bool)
+
+	// WaitSampleSize, if positive, makes the breaker keep the waits for
+	// admission of that many recent requests for WaitPercentiles.
+	WaitSampleSize int
+
+	// TrackConcurrency makes the breaker track the number of requests holding
+	// capacity and its time weighted average, for StatsSnapshot and Queued.
+	// It adds a lock and a clock read to every admission and release.
+	TrackConcurrency bool
+
+	// TrackPeakConcurrency makes the breaker keep the highest number of
+	// requests holding capacity per interval, for
+	// AchievedConcurrencyReporter.
+	TrackPeakConcurrency bool
+
+	// TrackIdleTime makes the breaker accumulate the time no request holds
+	// capacity, for IdleTimeRatioReporter. It adds a lock and a clock read to
+	// every admission and release.
+	TrackIdleTime bool
+
+	// NoDeadlineShedThreshold, if positive, makes Maybe reject requests whose
+	// context has no deadline with ErrNoDeadline while at least this fraction
+	// of the breaker's slots, including the queue, is taken. Such requests
+	// can't be bounded and might hold a slot indefinitely, while requests
+	// with a deadline still queue.
+	NoDeadlineShedThreshold float64
+
+	// Oracle, if set, is consulted by Maybe before a request waits for local
+	// capacity. Requests it denies are rejected with ErrCapacityDenied. If it
+	// doesn't answer within OracleTimeout, whether or not it observes the
+	// context's deadline, or fails, the request is admitted as if there was
+	// no oracle.
+	Oracle        CapacityOracle
+	OracleTimeout time.Duration
+
+	// DependencyHealth, if set, is consulted by Maybe before a request
+	// queues. While it reports the dependency down, requests are rejected
+	// with ErrDependencyUnhealthy rather than waiting for capacity only to
+	// fail. If it fails, requests are admitted as if it reported healthy.
+	DependencyHealth DependencyHealthProvider
+
+	// MaxSlotTime, if positive, caps the time an admitted request holds its
+	// slot. Once exceeded, the context passed to the thunk is cancelled and
+	// Maybe returns ErrSlotTimeout after the thunk returns. The slot is only
+	// released then, so this frees the capacity held by hung requests as long
+	// as they observe the cancellation, regardless of the request's own
+	// deadline.
+	MaxSlotTime time.Duration
+
+	// MethodMaxConcurrency optionally maps HTTP methods to the maximum
+	// concurrency of a breaker of their own, see ForMethod, so that e.g.
+	// expensive mutations can be limited more tightly than reads. The method
+	// breakers share no capacity or queue with this breaker or each other
+	// and keep their capacity when this breaker's capacity is updated. The
+	// requests they admit and reject are counted in this breaker's stats.
+	MethodMaxConcurrency map[string]int
+
+	// SafeMethods and UnsafeMethods optionally give the safe HTTP methods
+	// (GET, HEAD, OPTIONS and TRACE) and the other methods a breaker of their
+	// own, see ForMethod, so that e.g. reads may burst while writes are
+	// strictly limited. Like the method breakers, they share no capacity or
+	// queue with this breaker, and methods with a breaker of their own in
+	// MethodMaxConcurrency keep it. A class without parameters is served by
+	// this breaker.
+	SafeMethods   *MethodClassParams
+	UnsafeMethods *MethodClassParams
+
+	// MethodClassMaxInFlight, if positive, bounds the number of requests
+	// holding capacity across the method class breakers. Requests admitted
+	// by their class wait in its queue for the cap, too.
+	MethodClassMaxInFlight int
+
+	// AdmissionRate, if positive, paces admissions to at most this many
+	// requests per second, however much capacity is free, to smooth the
+	// request rate to a sensitive upstream. Up to AdmissionBurst requests are
+	// admitted back to back. Paced requests wait in the queue without
+	// holding capacity. The method breakers share the pace.
+	AdmissionRate  float64
+	AdmissionBurst int
+
+	// QueueTraceLogger, if set, makes the breaker log every request entering
+	// and leaving its queue at debug level, along with the time it happened,
+	// for a QueueTraceSampleRate fraction of the requests. This is meant for
+	// debugging only.
+	QueueTraceLogger     *zap.SugaredLogger
+	QueueTraceSampleRate float64
+
+	// AdmissionEventBuffer, if positive, makes the breaker send an
+	// AdmissionEvent for every request it admits or rejects on the channel
+	// returned by AdmissionEvents, buffering this many events.
+	AdmissionEventBuffer int
+
+	// QueueMode selects the order queued requests are admitted in,
+	// QueueModeFIFO if empty. In QueueModeWeightedFair, QueueTenantWeights
+	// maps tenants to their weights, tenants missing from it weigh 1. It
+	// can't be combined with CostDeadlineScheduling.
+	QueueMode          QueueMode
+	QueueTenantWeights map[string]int
+
+	// PreallocateWaiters makes a breaker using CostDeadlineScheduling or
+	// QueueModeWeightedFair allocate the structures of the requests waiting
+	// for capacity up front, for as many requests as its queue holds, and
+	// reuse them, so that queueing requests doesn't add to the garbage
+	// collector's work under steady load. Other breakers queue requests
+	// without allocating regardless.
+	PreallocateWaiters bool
+
+	// CountCASRetries makes the breaker count the retries of the atomic
+	// compare-and-swap loops admitting and releasing requests, which tell
+	// how contended admission is, for AdmissionCASRetriesReporter. This is
+	// meant for debugging only, as it adds an atomic increment to every
+	// retry.
+	CountCASRetries bool
 }
 
 // Breaker is a component that enforces a concurrency limit on the
@@ -46,9 +245,93 @@ type BreakerParams struct {
 // executions in excess of the concurrency limit. Function call attempts
 // beyond the limit of the queue are failed immediately.
 type Breaker struct {
-	inFlight   atomic.Int64
-	totalSlots int64
-	sem        *semaphore
+	inFlight     atomic.Int64
+	totalSlots   atomic.Int64
+	sem          *semaphore
+	sched        *costDeadlineScheduler
+	smoother     *capacitySmoother
+	queueTimeout atomic.Duration
+	maxSlotTime  time.Duration
+
+	// maxConcurrency is the capacity the breaker was created for, not
+	// counting burst slots.
+	maxConcurrency int
+
+	oracle        CapacityOracle
+	oracleTimeout time.Duration
+	dependency    DependencyHealthProvider
+
+	// noDeadlineShed is the saturation at and above which requests without a
+	// deadline are rejected, if positive.
+	noDeadlineShed atomic.Float64
+
+	// burst is the number of slots on top of the capacity, active the number
+	// of requests holding a slot in Maybe, tracked only with burst slots.
+	burst  int
+	active atomic.Int64
+
+	onCapacityChange func(int)
+
+	// concurrency tracks the requests holding capacity, if enabled, admitted
+	// and rejected count the requests let in and turned away, for
+	// StatsSnapshot. The counters are shared with the method breakers.
+	concurrency *concurrencyTracker
+	admitted    *atomic.Int64
+	rejected    *atomic.Int64
+
+	// peak tracks the highest number of requests holding capacity, including
+	// those of the method breakers, for AchievedConcurrencyReporter, if
+	// enabled.
+	peak *concurrencyPeak
+
+	// idle tracks the time no request holds capacity, including those of the
+	// method breakers, for IdleTimeRatioReporter, if enabled.
+	idle *idleTracker
+
+	// casRetries counts the retries of the compare-and-swap loops, including
+	// those of the method breakers, if enabled.
+	casRetries *atomic.Int64
+
+	// queue tracks the requests waiting for capacity, including those of the
+	// method breakers, for QueueEmptyTransitionsReporter.
+	queue *queueTracker
+
+	// resizes tells waits caused by shrinking the capacity apart.
+	resizes resizeTracker
+
+	// waits samples the recent waits for admission, if enabled.
+	waits *waitSample
+
+	// onWait, if set, is called with the time each request spent in Maybe
+	// until it was admitted or rejected.
+	onWait func(wait time.Duration, admitted bool)
+
+	// draining is closed once Drain is called, drained once all pending
+	// requests have left the breaker after that.
+	draining    chan struct{}
+	drained     chan struct{}
+	drainOnce   sync.Once
+	drainedOnce sync.Once
+
+	// pacer paces admissions, if set.
+	pacer *rate.Limiter
+
+	// tracer logs requests entering and leaving the queue, if set.
+	tracer *queueTracer
+
+	// events receives the admission events, if enabled.
+	events chan AdmissionEvent
+
+	// methods are the breakers dedicated to HTTP methods, if any.
+	methods map[string]*Breaker
+
+	// safe and unsafe are the breakers of the method classes, if any.
+	safe   *Breaker
+	unsafe *Breaker
+
+	// inFlightCap bounds the requests holding capacity across the method
+	// class breakers, if set.
+	inFlightCap *semaphore
 
 	// release is the callback function returned to callers by Reserve to
 	// allow the reservation made by Reserve to be released.
@@ -67,15 +350,128 @@ func NewBreaker(params BreakerParams) *Breaker {
 	if params.InitialCapacity < 0 || params.InitialCapacity > params.MaxConcurrency {
 		panic(fmt.Sprintf("Initial capacity must be between 0 and max concurrency. Got %v.", params.InitialCapacity))
 	}
+	if params.BurstCapacity < 0 {
+		panic(fmt.Sprintf("Burst capacity must be 0 or greater. Got %v.", params.BurstCapacity))
+	}
+	if params.CapacityStep > 0 && params.CapacityStepInterval <= 0 {
+		panic(fmt.Sprintf("Capacity step interval must be greater than 0 with a capacity step. Got %v.", params.CapacityStepInterval))
+	}
+	if params.AdmissionRate < 0 {
+		panic(fmt.Sprintf("Admission rate must be 0 or greater. Got %v.", params.AdmissionRate))
+	}
+	if params.AdmissionRate > 0 && params.AdmissionBurst < 1 {
+		panic(fmt.Sprintf("Admission burst must be greater than 0 with an admission rate. Got %v.", params.AdmissionBurst))
+	}
+	switch params.QueueMode {
+	case "", QueueModeFIFO:
+	case QueueModeWeightedFair:
+		if params.CostDeadlineScheduling {
+			panic("Weighted fair queueing can't be combined with cost-deadline scheduling.")
+		}
+	default:
+		panic(fmt.Sprintf("Unknown queue mode %q.", params.QueueMode))
+	}
+	for tenant, w := range params.QueueTenantWeights {
+		if w < 1 {
+			panic(fmt.Sprintf("Weight of tenant %s must be greater than 0. Got %v.", tenant, w))
+		}
+	}
+	for method, c := range params.MethodMaxConcurrency {
+		if c < 1 {
+			panic(fmt.Sprintf("Max concurrency of method %s must be greater than 0. Got %v.", method, c))
+		}
+	}
+	validateMethodClass("safe", params.SafeMethods)
+	validateMethodClass("unsafe", params.UnsafeMethods)
+	if params.MethodClassMaxInFlight < 0 {
+		panic(fmt.Sprintf("Max in-flight requests of the method classes must be 0 or greater. Got %v.", params.MethodClassMaxInFlight))
+	}
 
 	b := &Breaker{
-		totalSlots: int64(params.QueueDepth + params.MaxConcurrency),
-		sem:        newSemaphore(params.MaxConcurrency, params.InitialCapacity),
+		sem:         newSemaphore(params.MaxConcurrency+params.BurstCapacity, params.InitialCapacity+params.BurstCapacity),
+		maxSlotTime: params.MaxSlotTime,
+		burst:       params.BurstCapacity,
+		draining:    make(chan struct{}),
+		drained:     make(chan struct{}),
+
+		maxConcurrency:   params.MaxConcurrency,
+		onCapacityChange: params.OnCapacityChange,
+		onWait:           params.OnWait,
+		admitted:         atomic.NewInt64(0),
+		rejected:         atomic.NewInt64(0),
+		queue:            &queueTracker{},
+		oracle:           params.Oracle,
+		oracleTimeout:    params.OracleTimeout,
+		dependency:       params.DependencyHealth,
+	}
+	b.setQueueDepth(params.QueueDepth)
+	b.queueTimeout.Store(params.QueueTimeout)
+	b.noDeadlineShed.Store(params.NoDeadlineShedThreshold)
+	if params.CostDeadlineScheduling || params.QueueMode == QueueModeWeightedFair {
+		b.sched = newCostDeadlineScheduler(params.MaxConcurrency+params.BurstCapacity,
+			params.InitialCapacity+params.BurstCapacity)
+		b.sched.queue = b.queue
+		if params.PreallocateWaiters {
+			b.sched.preallocate(params.QueueDepth + params.MaxConcurrency + params.BurstCapacity)
+		}
+	}
+	if params.QueueMode == QueueModeWeightedFair {
+		b.sched.fair = newFairShare(params.QueueTenantWeights)
+	}
+	if params.AdmissionRate > 0 {
+		b.pacer = rate.NewLimiter(rate.Limit(params.AdmissionRate), params.AdmissionBurst)
+	}
+	if params.QueueTraceLogger != nil && params.QueueTraceSampleRate > 0 {
+		b.tracer = newQueueTracer(params.QueueTraceLogger, params.QueueTraceSampleRate)
+	}
+	if params.AdmissionEventBuffer > 0 {
+		b.events = make(chan AdmissionEvent, params.AdmissionEventBuffer)
+	}
+	if params.WaitSampleSize > 0 {
+		b.waits = newWaitSample(params.WaitSampleSize)
+	}
+	if params.TrackConcurrency {
+		b.concurrency = newConcurrencyTracker(clock.RealClock{})
+	}
+	if params.TrackPeakConcurrency {
+		b.peak = &concurrencyPeak{}
+	}
+	if params.TrackIdleTime {
+		b.idle = newIdleTracker(clock.RealClock{})
+	}
+	if params.CountCASRetries {
+		b.setCASRetries(atomic.NewInt64(0))
+	}
+	if params.CapacityStep > 0 {
+		b.smoother = newCapacitySmoother(params.CapacityStep, params.CapacityStepInterval,
+			clock.RealClock{}, params.InitialCapacity, b.setCapacity)
+	}
+
+	if b.onCapacityChange != nil {
+		b.onCapacityChange(params.InitialCapacity)
+	}
+
+	if len(params.MethodMaxConcurrency) > 0 {
+		b.methods = make(map[string]*Breaker, len(params.MethodMaxConcurrency))
+		for method, c := range params.MethodMaxConcurrency {
+			methodParams := params
+			methodParams.MaxConcurrency, methodParams.InitialCapacity = c, c
+			b.methods[strings.ToUpper(method)] = b.newSubBreaker(methodParams)
+		}
+	}
+
+	var inFlightCap *semaphore
+	if params.MethodClassMaxInFlight > 0 {
+		inFlightCap = newSemaphore(params.MethodClassMaxInFlight, params.MethodClassMaxInFlight)
+		inFlightCap.retries = b.casRetries
 	}
+	b.safe = b.newMethodClassBreaker(params, params.SafeMethods, inFlightCap)
+	b.unsafe = b.newMethodClassBreaker(params, params.UnsafeMethods, inFlightCap)
 
 	// Allocating the closure returned by Reserve here avoids an allocation in Reserve.
 	b.release = func() {
-		b.sem.release()
+		b.leave()
+		b.releaseCapacity(1)
 		b.releasePending()
 	}
 
@@ -86,7 +482,7 @@ func NewBreaker(params BreakerParams) *Breaker {
 func (b *Breaker) tryAcquirePending() bool {
 	// This is an atomic version of:
 	//
-	// if inFlight == totalSlots {
+	// if inFlight >= totalSlots {
 	//   return false
 	// } else {
 	//   inFlight++
@@ -100,18 +496,55 @@ func (b *Breaker) tryAcquirePending() bool {
 	// anymore.
 	for {
 		cur := b.inFlight.Load()
-		if cur == b.totalSlots {
+		// The queue depth might have been reduced below the number of
+		// pending requests.
+		if cur >= b.totalSlots.Load() {
 			return false
 		}
 		if b.inFlight.CAS(cur, cur+1) {
 			return true
 		}
+		countCASRetry(b.casRetries)
+	}
+}
+
+// setCASRetries makes the breaker count the retries of its compare-and-swap
+// loops in retries.
+func (b *Breaker) setCASRetries(retries *atomic.Int64) {
+	b.casRetries = retries
+	b.sem.retries = retries
+}
+
+// countCASRetry counts a retry of a compare-and-swap loop, if counting is
+// enabled.
+func countCASRetry(retries *atomic.Int64) {
+	if retries != nil {
+		retries.Inc()
 	}
 }
 
 // releasePending releases a slot on the pending "queue".
 func (b *Breaker) releasePending() {
-	b.inFlight.Dec()
+	if b.inFlight.Dec() == 0 && b.isDraining() {
+		b.markDrained()
+	}
+}
+
+// isDraining returns whether Drain has been called on the breaker.
+func (b *Breaker) isDraining() bool {
+	select {
+	case <-b.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// markDrained signals waiters in Drain that no requests are pending anymore.
+func (b *Breaker) markDrained() {
+	b.drainedOnce.Do(func() {
+		close(b.drained)
+	})
 }
 
 // Reserve reserves an execution slot in the breaker, to permit
@@ -119,57 +552,488 @@ func (b *Breaker) releasePending() {
 // The caller on success must execute the callback when done with work.
 func (b *Breaker) Reserve(ctx context.Context) (func(), bool) {
 	if !b.tryAcquirePending() {
+		b.rejected.Inc()
 		return nil, false
 	}
 
+	if b.isDraining() || (b.pacer != nil && !b.pacer.Allow()) {
+		b.releasePending()
+		b.rejected.Inc()
+		return nil, false
+	}
+
+	if b.sched != nil {
+		cost := b.sched.costOf(ctx)
+		if !b.sched.tryAcquire(cost) {
+			b.releasePending()
+			b.rejected.Inc()
+			return nil, false
+		}
+		if !b.tryAcquireInFlightCap() {
+			b.sched.release(cost)
+			b.releasePending()
+			b.rejected.Inc()
+			return nil, false
+		}
+		b.admit()
+		return func() {
+			b.leave()
+			b.releaseCapacity(cost)
+			b.releasePending()
+		}, true
+	}
+
 	if !b.sem.tryAcquire() {
 		b.releasePending()
+		b.rejected.Inc()
+		return nil, false
+	}
+	if !b.tryAcquireInFlightCap() {
+		b.sem.release()
+		b.releasePending()
+		b.rejected.Inc()
 		return nil, false
 	}
 
+	b.admit()
 	return b.release, true
 }
 
+// admit accounts for a request that acquired capacity.
+func (b *Breaker) admit() {
+	b.admitted.Inc()
+	if b.concurrency != nil {
+		b.concurrency.add(1)
+	}
+	if b.peak != nil {
+		b.peak.add(1)
+	}
+	if b.idle != nil {
+		b.idle.add(1)
+	}
+}
+
+// leave accounts for an admitted request releasing its capacity.
+func (b *Breaker) leave() {
+	if b.concurrency != nil {
+		b.concurrency.add(-1)
+	}
+	if b.peak != nil {
+		b.peak.add(-1)
+	}
+	if b.idle != nil {
+		b.idle.add(-1)
+	}
+}
+
 // Maybe conditionally executes thunk based on the Breaker concurrency
 // and queue parameters. If the concurrency limit and queue capacity are
 // already consumed, Maybe returns immediately without calling thunk. If
 // the thunk was executed, Maybe returns nil, else error.
 func (b *Breaker) Maybe(ctx context.Context, thunk func()) error {
+	return b.MaybeWithContext(ctx, func(context.Context) {
+		thunk()
+	})
+}
+
+// MaybeWithContext is like Maybe, but passes thunk the context it has to
+// observe to be stopped once it exceeds the maximum slot time. If it did,
+// MaybeWithContext returns ErrSlotTimeout although thunk was executed.
+func (b *Breaker) MaybeWithContext(ctx context.Context, thunk func(context.Context)) error {
+	var start time.Time
+	if b.waits != nil || b.onWait != nil || b.events != nil {
+		start = time.Now()
+	}
+
+	if b.shedWithoutDeadline(ctx) {
+		return b.reject(start, ErrNoDeadline)
+	}
+
+	if !b.dependencyHealthy(ctx) {
+		return b.reject(start, ErrDependencyUnhealthy)
+	}
+
 	if !b.tryAcquirePending() {
-		return ErrRequestQueueFull
+		return b.reject(start, ErrRequestQueueFull)
 	}
 
 	defer b.releasePending()
+	trace := b.tracer.enqueue(ctx)
+
+	// Checking after acquiring the pending slot guarantees that Drain either
+	// sees this request as pending or this request sees the breaker draining.
+	if b.isDraining() {
+		trace.dequeue(ErrDraining)
+		return b.reject(start, ErrDraining)
+	}
+
+	// Consult the oracle before taking local capacity, so that no slot is
+	// held while waiting for its answer.
+	if !b.oracleAdmits(ctx) {
+		trace.dequeue(ErrCapacityDenied)
+		return b.reject(start, ErrCapacityDenied)
+	}
 
 	// Wait for capacity in the active queue.
-	if err := b.sem.acquire(ctx); err != nil {
-		return err
+	resize := b.resizes.snapshot(b.inFlight.Load())
+	cost, queued, err := b.acquire(ctx)
+	if err != nil {
+		trace.dequeue(err)
+		return b.reject(start, err)
+	}
+	if b.waits != nil {
+		b.waits.add(time.Since(start))
+	}
+	trace.dequeue(nil)
+	if b.onWait != nil {
+		b.onWait(time.Since(start), true /*admitted*/)
+	}
+	if b.events != nil {
+		b.emit(AdmissionEvent{Admitted: true, Wait: time.Since(start), Cost: cost})
+	}
+	if state := requestMetricsStateFrom(ctx); state != nil {
+		state.setCost(cost)
+		if queued {
+			state.setQueued()
+			if b.resizes.induced(resize) {
+				state.setResizeInducedWait()
+			}
+		}
 	}
+	return b.hold(ctx, cost, thunk)
+}
+
+// hold executes thunk while holding the capacity acquired at the given cost
+// and releases it once thunk returns. With a maximum slot time, it cancels the
+// context passed to thunk once exceeded, but still waits for thunk to return
+// before releasing the capacity, so that the breaker never admits more
+// requests than it has capacity for.
+func (b *Breaker) hold(ctx context.Context, cost int, thunk func(context.Context)) error {
 	// Defer releasing capacity in the active.
 	// It's safe to ignore the error returned by release since we
 	// make sure the semaphore is only manipulated here and acquire
 	// + release calls are equally paired.
-	defer b.sem.release()
+	defer b.releaseCapacity(cost)
+	b.admit()
+	defer b.leave()
+
+	if b.burst > 0 {
+		if b.active.Inc() > int64(b.Capacity()) {
+			if state := requestMetricsStateFrom(ctx); state != nil {
+				state.setBurstAdmission()
+			}
+		}
+		defer b.active.Dec()
+	}
+
+	if b.maxSlotTime <= 0 {
+		// Do the thing.
+		thunk(ctx)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := time.AfterFunc(b.maxSlotTime, cancel)
+	thunk(ctx)
+	// Stop fails if the timer already fired.
+	if !timer.Stop() {
+		return ErrSlotTimeout
+	}
+	return nil
+}
+
+// reject counts a request turned away with err after trying to get admitted
+// since start and returns err.
+func (b *Breaker) reject(start time.Time, err error) error {
+	b.rejected.Inc()
+	if b.onWait != nil {
+		b.onWait(time.Since(start), false /*admitted*/)
+	}
+	if b.events != nil {
+		b.emit(AdmissionEvent{Wait: time.Since(start), Reason: err})
+	}
+	return err
+}
+
+// oracleAdmits returns whether the capacity oracle, if any, admits a request
+// with the given context. Without an answer in time it does.
+func (b *Breaker) oracleAdmits(ctx context.Context) bool {
+	if b.oracle == nil {
+		return true
+	}
+	if b.oracleTimeout <= 0 {
+		ok, err := b.oracle.Admit(ctx)
+		return ok || err != nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.oracleTimeout)
+	defer cancel()
+	// The oracle runs on its own, so that one ignoring the context can't hold
+	// up admission. Buffered, so it can always deliver its late answer.
+	admitted := make(chan bool, 1)
+	go func() {
+		ok, err := b.oracle.Admit(ctx)
+		admitted <- ok || err != nil
+	}()
+	select {
+	case ok := <-admitted:
+		return ok
+	case <-ctx.Done():
+		return true
+	}
+}
+
+// shedWithoutDeadline returns whether a request with the given context is to
+// be rejected for lacking a deadline while the breaker is saturated.
+func (b *Breaker) shedWithoutDeadline(ctx context.Context) bool {
+	threshold := b.noDeadlineShed.Load()
+	if threshold <= 0 {
+		return false
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return false
+	}
+	return float64(b.inFlight.Load())/float64(b.totalSlots.Load()) >= threshold
+}
+
+// acquire waits for capacity in the semaphore, giving up if the context is
+// done, the queue timeout passes or the breaker starts draining. On success
+// it returns the cost to pass to releaseCapacity and whether the request had
+// to wait for capacity.
+func (b *Breaker) acquire(ctx context.Context) (int, bool, error) {
+	// The request's own deadline determines its priority, not the queue timeout.
+	deadline, _ := ctx.Deadline()
+
+	waitCtx := ctx
+	if timeout := b.queueTimeout.Load(); timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cost := 1
+	// Pacing comes first so that paced requests don't hold capacity.
+	queued, err := b.pace(waitCtx)
+	switch {
+	case err != nil:
+	case b.sched != nil:
+		cost = b.sched.costOf(ctx)
+		var waited bool
+		waited, err = b.sched.acquireQueued(waitCtx, cost, deadline, b.draining)
+		queued = queued || waited
+	case !b.sem.tryAcquire():
+		queued = true
+		b.queue.enter()
+		err = b.sem.acquireUntil(waitCtx, b.draining)
+		b.queue.leave()
+	}
+	if err == nil && !b.tryAcquireInFlightCap() {
+		queued = true
+		b.queue.enter()
+		if err = b.inFlightCap.acquireUntil(waitCtx, b.draining); err != nil {
+			b.releaseOwnCapacity(cost)
+		}
+		b.queue.leave()
+	}
+
+	switch {
+	case err == nil:
+		return cost, queued, nil
+	case errors.Is(err, errSemaphoreStopped):
+		return 0, false, errDrainedWhileQueued
+	case waitCtx != ctx && ctx.Err() == nil:
+		// Only the queue timeout expired, not the request's own context.
+		return 0, false, ErrQueueTimeout
+	case errors.Is(err, context.Canceled):
+		return 0, false, ErrRequestCancelled
+	default:
+		return 0, false, err
+	}
+}
+
+// pace waits for the pacer, if any, to let the request through, giving up
+// like the semaphore if the context is done or the breaker starts draining.
+// It returns whether the request had to wait.
+func (b *Breaker) pace(ctx context.Context) (bool, error) {
+	if b.pacer == nil {
+		return false, nil
+	}
+	r := b.pacer.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return false, nil
+	}
+	b.queue.enter()
+	defer b.queue.leave()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		r.Cancel()
+		return true, ctx.Err()
+	case <-b.draining:
+		r.Cancel()
+		return true, errSemaphoreStopped
+	}
+}
 
-	// Do the thing.
-	thunk()
-	// Report success
+// releaseCapacity releases capacity acquired by acquire.
+func (b *Breaker) releaseCapacity(cost int) {
+	if b.inFlightCap != nil {
+		b.inFlightCap.release()
+	}
+	b.releaseOwnCapacity(cost)
+}
+
+// releaseOwnCapacity releases the capacity of the breaker itself, leaving
+// the cap of the requests in flight across the method classes alone.
+func (b *Breaker) releaseOwnCapacity(cost int) {
+	if b.sched != nil {
+		b.sched.release(cost)
+		return
+	}
+	b.sem.release()
+}
+
+// Drain stops the breaker and its method and method class breakers from
+// admitting new requests and removes requests waiting for capacity from the
+// queues. Requests already holding capacity are allowed to finish. Drain
+// returns once no requests are left in the breakers or when ctx is done,
+// whichever happens first. Pending tells how many requests are left
+// meanwhile.
+func (b *Breaker) Drain(ctx context.Context) error {
+	b.startDrain()
+	subs := b.subBreakers()
+	for _, mb := range subs {
+		mb.startDrain()
+	}
+
+	if err := b.awaitDrained(ctx); err != nil {
+		return err
+	}
+	for _, mb := range subs {
+		if err := mb.awaitDrained(ctx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// startDrain stops the breaker from admitting new requests.
+func (b *Breaker) startDrain() {
+	b.drainOnce.Do(func() {
+		close(b.draining)
+	})
+	if b.inFlight.Load() == 0 {
+		b.markDrained()
+	}
+}
+
+// awaitDrained waits until no requests are left in the breaker after
+// startDrain or until ctx is done.
+func (b *Breaker) awaitDrained(ctx context.Context) error {
+	select {
+	case <-b.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns the number of requests holding or waiting for capacity in
+// the breaker and its method and method class breakers.
+func (b *Breaker) Pending() int {
+	pending := b.InFlight()
+	for _, mb := range b.subBreakers() {
+		pending += mb.InFlight()
+	}
+	return pending
+}
+
+// ForMethod returns the breaker dedicated to the given HTTP method, else the
+// breaker of its method class, or the breaker itself if there is neither.
+func (b *Breaker) ForMethod(method string) *Breaker {
+	if mb, ok := b.methods[method]; ok {
+		return mb
+	}
+	cb := b.unsafe
+	if isSafeMethod(method) {
+		cb = b.safe
+	}
+	if cb != nil {
+		return cb
+	}
+	return b
+}
+
 // InFlight returns the number of requests currently in flight in this breaker.
 func (b *Breaker) InFlight() int {
 	return int(b.inFlight.Load())
 }
 
-// UpdateConcurrency updates the maximum number of in-flight requests.
+// UpdateConcurrency updates the maximum number of in-flight requests. With a
+// capacity step configured, the capacity only gradually approaches size.
+//
+// Growing admits waiting requests right away. Shrinking never evicts requests
+// already in flight, new requests only get capacity once enough of them
+// finished to get below size. Until then, they wait in the queue. Since
+// QueueDepth counts on top of the maximum concurrency rather than the current
+// capacity, up to QueueDepth plus the slots taken away wait before requests
+// fail with ErrRequestQueueFull. A size of 0 admits no requests at all,
+// negative sizes are treated as 0 and sizes above the maximum concurrency as
+// the maximum. Capacity returns the capacity in effect.
 func (b *Breaker) UpdateConcurrency(size int) {
-	b.sem.updateCapacity(size)
+	switch {
+	case size < 0:
+		size = 0
+	case size > b.maxConcurrency:
+		size = b.maxConcurrency
+	}
+	if b.smoother != nil {
+		b.smoother.setTarget(size)
+		return
+	}
+	b.setCapacity(size)
+}
+
+// setQueueDepth sets the number of requests allowed to wait for capacity.
+func (b *Breaker) setQueueDepth(depth int) {
+	b.totalSlots.Store(int64(depth + b.maxConcurrency + b.burst))
+}
+
+// setCapacity applies the given capacity right away.
+func (b *Breaker) setCapacity(size int) {
+	b.resizes.resized(b.Capacity(), size)
+	if b.sched != nil {
+		b.sched.updateCapacity(size + b.burst)
+	} else {
+		b.sem.updateCapacity(size + b.burst)
+	}
+	if b.onCapacityChange != nil {
+		b.onCapacityChange(size)
+	}
 }
 
-// Capacity returns the number of allowed in-flight requests on this breaker.
+// TargetCapacity returns the capacity last requested via UpdateConcurrency,
+// which differs from Capacity while the breaker smoothes a capacity change.
+func (b *Breaker) TargetCapacity() int {
+	if b.smoother != nil {
+		return b.smoother.getTarget()
+	}
+	return b.Capacity()
+}
+
+// Capacity returns the number of allowed in-flight requests on this breaker,
+// not counting burst slots.
 func (b *Breaker) Capacity() int {
-	return b.sem.Capacity()
+	if b.sched != nil {
+		return b.sched.Capacity() - b.burst
+	}
+	return b.sem.Capacity() - b.burst
 }
 
 // newSemaphore creates a semaphore with the desired initial capacity.
@@ -193,6 +1057,9 @@ func newSemaphore(maxCapacity, initialCapacity int) *semaphore {
 type semaphore struct {
 	state atomic.Uint64
 	queue chan struct{}
+
+	// retries counts the retries of the compare-and-swap loops, if set.
+	retries *atomic.Int64
 }
 
 // tryAcquire receives a token from the semaphore if there is one otherwise returns false.
@@ -207,11 +1074,18 @@ func (s *semaphore) tryAcquire() bool {
 		if s.state.CAS(old, pack(capacity, in)) {
 			return true
 		}
+		countCASRetry(s.retries)
 	}
 }
 
 // acquire acquires capacity from the semaphore.
 func (s *semaphore) acquire(ctx context.Context) error {
+	return s.acquireUntil(ctx, nil)
+}
+
+// acquireUntil acquires capacity from the semaphore like acquire, but also
+// gives up once stop is closed. A nil stop channel never stops.
+func (s *semaphore) acquireUntil(ctx context.Context, stop <-chan struct{}) error {
 	for {
 		old := s.state.Load()
 		capacity, in := unpack(old)
@@ -220,6 +1094,8 @@ func (s *semaphore) acquire(ctx context.Context) error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
+			case <-stop:
+				return errSemaphoreStopped
 			case <-s.queue:
 			}
 			// Force reload state.
@@ -230,6 +1106,7 @@ func (s *semaphore) acquire(ctx context.Context) error {
 		if s.state.CAS(old, pack(capacity, in)) {
 			return nil
 		}
+		countCASRetry(s.retries)
 	}
 }
 
@@ -260,6 +1137,7 @@ func (s *semaphore) release() {
 			}
 			return
 		}
+		countCASRetry(s.retries)
 	}
 }
 
@@ -287,6 +1165,7 @@ func (s *semaphore) updateCapacity(size int) {
 			}
 			return
 		}
+		countCASRetry(s.retries)
 	}
 }
 
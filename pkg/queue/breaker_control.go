@@ -0,0 +1,108 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BreakerUpdate is a configuration change applied by Breaker.Control. Fields
+// left nil keep their current value.
+type BreakerUpdate struct {
+	// Capacity is the number of allowed in-flight requests as passed to
+	// UpdateConcurrency, between 0 and the breaker's maximum concurrency.
+	Capacity *int
+
+	// QueueDepth is the number of requests allowed to wait for capacity,
+	// greater than 0. Reducing it doesn't reject requests already waiting.
+	QueueDepth *int
+
+	// QueueTimeout is how long requests wait for capacity at most, or 0 for
+	// no limit. It applies to requests starting to wait afterwards.
+	QueueTimeout *time.Duration
+
+	// NoDeadlineShedThreshold is the saturation at and above which requests
+	// without a deadline are rejected, between 0 and 1. 0 disables shedding.
+	NoDeadlineShedThreshold *float64
+
+	// Reply, if set, receives nil once the update was applied or the error
+	// it was rejected with. Control waits for the reply to be received.
+	Reply chan<- error
+}
+
+// Control applies the updates received from the given channel, one at a time
+// in order, until the channel is closed or ctx is done. Each update is
+// validated as a whole and rejected without changing anything if any of its
+// fields is invalid.
+func (b *Breaker) Control(ctx context.Context, updates <-chan BreakerUpdate) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			err := b.validateUpdate(u)
+			if err == nil {
+				b.applyUpdate(u)
+			}
+			if u.Reply != nil {
+				select {
+				case u.Reply <- err:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// validateUpdate returns an error describing the first invalid field of u.
+func (b *Breaker) validateUpdate(u BreakerUpdate) error {
+	if u.Capacity != nil && (*u.Capacity < 0 || *u.Capacity > b.maxConcurrency) {
+		return fmt.Errorf("capacity must be between 0 and %d, got %d", b.maxConcurrency, *u.Capacity)
+	}
+	if u.QueueDepth != nil && *u.QueueDepth <= 0 {
+		return fmt.Errorf("queue depth must be greater than 0, got %d", *u.QueueDepth)
+	}
+	if u.QueueTimeout != nil && *u.QueueTimeout < 0 {
+		return fmt.Errorf("queue timeout must not be negative, got %v", *u.QueueTimeout)
+	}
+	if u.NoDeadlineShedThreshold != nil && (*u.NoDeadlineShedThreshold < 0 || *u.NoDeadlineShedThreshold > 1) {
+		return fmt.Errorf("no deadline shed threshold must be between 0 and 1, got %v", *u.NoDeadlineShedThreshold)
+	}
+	return nil
+}
+
+// applyUpdate applies the set fields of a valid update.
+func (b *Breaker) applyUpdate(u BreakerUpdate) {
+	if u.QueueDepth != nil {
+		b.setQueueDepth(*u.QueueDepth)
+	}
+	if u.QueueTimeout != nil {
+		b.queueTimeout.Store(*u.QueueTimeout)
+	}
+	if u.NoDeadlineShedThreshold != nil {
+		b.noDeadlineShed.Store(*u.NoDeadlineShedThreshold)
+	}
+	if u.Capacity != nil {
+		b.UpdateConcurrency(*u.Capacity)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerControl(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 4, InitialCapacity: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan BreakerUpdate)
+	go b.Control(ctx, updates)
+
+	send := func(u BreakerUpdate) error {
+		t.Helper()
+		reply := make(chan error)
+		u.Reply = reply
+		updates <- u
+		return <-reply
+	}
+	intPtr := func(i int) *int { return &i }
+	floatPtr := func(f float64) *float64 { return &f }
+	durationPtr := func(d time.Duration) *time.Duration { return &d }
+
+	// A valid update is applied as a whole.
+	if err := send(BreakerUpdate{
+		Capacity:                intPtr(3),
+		QueueDepth:              intPtr(2),
+		QueueTimeout:            durationPtr(time.Minute),
+		NoDeadlineShedThreshold: floatPtr(0.5),
+	}); err != nil {
+		t.Fatal("Valid update was rejected:", err)
+	}
+	if got, want := b.Capacity(), 3; got != want {
+		t.Errorf("Capacity() = %d, want: %d", got, want)
+	}
+	if got, want := b.totalSlots.Load(), int64(2+4); got != want {
+		t.Errorf("Total slots = %d, want: %d", got, want)
+	}
+	if got, want := b.queueTimeout.Load(), time.Minute; got != want {
+		t.Errorf("Queue timeout = %v, want: %v", got, want)
+	}
+	if got, want := b.noDeadlineShed.Load(), 0.5; got != want {
+		t.Errorf("No deadline shed threshold = %v, want: %v", got, want)
+	}
+
+	// An update with any invalid field changes nothing.
+	for name, u := range map[string]BreakerUpdate{
+		"capacity too large":   {Capacity: intPtr(5), QueueDepth: intPtr(10)},
+		"negative capacity":    {Capacity: intPtr(-1)},
+		"zero queue depth":     {Capacity: intPtr(1), QueueDepth: intPtr(0)},
+		"negative timeout":     {QueueTimeout: durationPtr(-time.Second)},
+		"threshold above one":  {NoDeadlineShedThreshold: floatPtr(1.5)},
+		"negative threshold":   {NoDeadlineShedThreshold: floatPtr(-0.5)},
+		"capacity and timeout": {Capacity: intPtr(2), QueueTimeout: durationPtr(-time.Second)},
+	} {
+		if err := send(u); err == nil {
+			t.Errorf("%s: update wasn't rejected", name)
+		}
+	}
+	if got, want := b.Capacity(), 3; got != want {
+		t.Errorf("Capacity() = %d, want: %d", got, want)
+	}
+	if got, want := b.totalSlots.Load(), int64(2+4); got != want {
+		t.Errorf("Total slots = %d, want: %d", got, want)
+	}
+
+	// A reduced queue depth applies to new requests only.
+	if err := send(BreakerUpdate{NoDeadlineShedThreshold: floatPtr(0)}); err != nil {
+		t.Fatal("Valid update was rejected:", err)
+	}
+	release := make(chan struct{})
+	results := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		go func() {
+			results <- b.Maybe(context.Background(), func() { <-release })
+		}()
+	}
+	for b.InFlight() != 6 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := send(BreakerUpdate{QueueDepth: intPtr(1)}); err != nil {
+		t.Fatal("Valid update was rejected:", err)
+	}
+	if err := b.Maybe(context.Background(), func() {}); !errors.Is(err, ErrRequestQueueFull) {
+		t.Errorf("Maybe() = %v, want: %v", err, ErrRequestQueueFull)
+	}
+	close(release)
+	for i := 0; i < 6; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Maybe() = %v, want: nil", err)
+		}
+	}
+
+	// Control stops once the channel is closed.
+	close(updates)
+}
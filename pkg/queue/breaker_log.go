@@ -0,0 +1,84 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BreakerLogReporter periodically logs a structured summary of a breaker, for
+// environments that scrape logs rather than metrics.
+type BreakerLogReporter struct {
+	logger  *zap.SugaredLogger
+	breaker *Breaker
+
+	// The breaker's totals at the end of the previous interval.
+	admitted, rejected int64
+}
+
+// NewBreakerLogReporter creates a BreakerLogReporter logging the given
+// breaker's summary to logger. The queued and in-flight requests are logged
+// as zero unless the breaker was created with TrackConcurrency.
+func NewBreakerLogReporter(b *Breaker, logger *zap.SugaredLogger) *BreakerLogReporter {
+	return &BreakerLogReporter{
+		logger:   logger,
+		breaker:  b,
+		admitted: b.admitted.Load(),
+		rejected: b.rejected.Load(),
+	}
+}
+
+// Run logs the breaker's summary every period until ctx is done.
+func (r *BreakerLogReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report logs the breaker's current state along with the requests admitted
+// and rejected since the previous report. Unlike StatsSnapshot it doesn't
+// reset the breaker's average concurrency.
+func (r *BreakerLogReporter) report() {
+	admitted, rejected := r.breaker.admitted.Load(), r.breaker.rejected.Load()
+	dAdmitted, dRejected := admitted-r.admitted, rejected-r.rejected
+	r.admitted, r.rejected = admitted, rejected
+
+	var inFlight int
+	if r.breaker.concurrency != nil {
+		inFlight = r.breaker.concurrency.load()
+	}
+	p50, p90, p99 := r.breaker.WaitPercentiles()
+	r.logger.Infow("Breaker summary",
+		"queued", r.breaker.queued(inFlight),
+		"capacity", r.breaker.Capacity(),
+		"inFlight", inFlight,
+		"admitted", dAdmitted,
+		"rejected", dRejected,
+		"waitP50", p50,
+		"waitP90", p90,
+		"waitP99", p99)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestBreakerLogReporter(t *testing.T) {
+	var buf bytes.Buffer
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = ""
+	encoderConfig.EncodeDuration = zapcore.StringDurationEncoder
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(&buf), zap.InfoLevel))
+
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, WaitSampleSize: 10,
+		TrackConcurrency: true})
+	r := NewBreakerLogReporter(b, logger.Sugar())
+
+	// Two requests are admitted, one of them still holds capacity, another
+	// one queues behind it and the last one is rejected.
+	b.Maybe(context.Background(), func() {})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	held := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Maybe(context.Background(), func() {
+			close(held)
+			<-release
+		})
+	}()
+	<-held
+	go b.Maybe(context.Background(), func() {})
+	for b.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	b.Maybe(context.Background(), func() {})
+
+	report := func() map[string]interface{} {
+		t.Helper()
+		buf.Reset()
+		r.report()
+		var got map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("Failed to parse log line %q: %v", buf.String(), err)
+		}
+		return got
+	}
+
+	want := map[string]interface{}{
+		"level":    "info",
+		"msg":      "Breaker summary",
+		"queued":   1.,
+		"capacity": 1.,
+		"inFlight": 1.,
+		"admitted": 2.,
+		"rejected": 1.,
+		"waitP50":  "0s",
+		"waitP90":  "0s",
+		"waitP99":  "0s",
+	}
+	got := report()
+	// The waits are too short to compare, just make sure they're reported.
+	for _, k := range []string{"waitP50", "waitP90", "waitP99"} {
+		if _, ok := got[k]; ok {
+			got[k] = "0s"
+		}
+	}
+	if !cmp.Equal(got, want) {
+		t.Error("Summary differs (-want, +got):", cmp.Diff(want, got))
+	}
+
+	// The next summary only covers the requests since.
+	close(release)
+	<-done
+	for b.InFlight() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	got = report()
+	if got["admitted"] != 1. || got["rejected"] != 0. || got["queued"] != 0. || got["inFlight"] != 0. {
+		t.Errorf("Summary = %v, want 1 admitted and nothing rejected, queued or in flight", got)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// BreakerStats is a point in time view of a Breaker, meant to be served as is
+// by stats endpoints.
+type BreakerStats struct {
+	// InFlight is the number of requests holding capacity.
+	InFlight int `json:"inFlight"`
+	// Queued is the number of requests waiting for capacity.
+	Queued int `json:"queued"`
+	// Capacity is the number of requests admitted concurrently, not counting
+	// burst slots.
+	Capacity int `json:"capacity"`
+	// AverageConcurrency is the time weighted average of InFlight since the
+	// previous snapshot.
+	AverageConcurrency float64 `json:"averageConcurrency"`
+	// AdmittedTotal is the number of requests ever admitted.
+	AdmittedTotal int64 `json:"admittedTotal"`
+	// RejectedTotal is the number of requests ever turned away, be it because
+	// the queue was full, they gave up waiting or the breaker was draining.
+	RejectedTotal int64 `json:"rejectedTotal"`
+}
+
+// StatsSnapshot returns the current stats of the breaker. The average
+// concurrency covers the time since the previous call, so a single consumer,
+// like the autoscaler's scrape, is expected to call this. InFlight, Queued
+// and AverageConcurrency are zero unless the breaker was created with
+// TrackConcurrency.
+func (b *Breaker) StatsSnapshot() BreakerStats {
+	stats := BreakerStats{
+		Capacity:      b.Capacity(),
+		AdmittedTotal: b.admitted.Load(),
+		RejectedTotal: b.rejected.Load(),
+	}
+	if b.concurrency != nil {
+		inFlight, avg := b.concurrency.snapshot()
+		stats.InFlight, stats.Queued, stats.AverageConcurrency = inFlight, b.queued(inFlight), avg
+	}
+	return stats
+}
+
+// WaitPercentiles returns the median, 90th and 99th percentile of the time
+// recently admitted requests waited for capacity. It returns zeros unless
+// the breaker was created with a WaitSampleSize.
+func (b *Breaker) WaitPercentiles() (p50, p90, p99 time.Duration) {
+	if b.waits == nil {
+		return 0, 0, 0
+	}
+	ps := b.waits.percentiles(0.5, 0.9, 0.99)
+	return ps[0], ps[1], ps[2]
+}
+
+// Queued returns the number of requests waiting for capacity. It returns zero
+// unless the breaker was created with TrackConcurrency.
+func (b *Breaker) Queued() int {
+	if b.concurrency == nil {
+		return 0
+	}
+	return b.queued(b.concurrency.load())
+}
+
+// queued returns the number of pending requests not among the inFlight ones
+// holding capacity. Both are updated independently, so the difference is
+// clamped.
+func (b *Breaker) queued(inFlight int) int {
+	if queued := b.InFlight() - inFlight; queued > 0 {
+		return queued
+	}
+	return 0
+}
+
+// concurrencyTracker keeps the number of requests holding capacity and its
+// time weighted average.
+type concurrencyTracker struct {
+	clock clock.PassiveClock
+
+	mux         sync.Mutex
+	current     int
+	lastChange  time.Time
+	windowStart time.Time
+	// weighted is the sum of the concurrency multiplied by the time spent at
+	// it since windowStart, up to lastChange.
+	weighted float64
+}
+
+func newConcurrencyTracker(clock clock.PassiveClock) *concurrencyTracker {
+	now := clock.Now()
+	return &concurrencyTracker{
+		clock:       clock,
+		lastChange:  now,
+		windowStart: now,
+	}
+}
+
+// add changes the concurrency by delta.
+func (t *concurrencyTracker) add(delta int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.advance(t.clock.Now())
+	t.current += delta
+}
+
+// load returns the current concurrency.
+func (t *concurrencyTracker) load() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.current
+}
+
+// snapshot returns the current concurrency and its average since the
+// previous snapshot, starting a new averaging window.
+func (t *concurrencyTracker) snapshot() (int, float64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	now := t.clock.Now()
+	t.advance(now)
+
+	avg := float64(t.current)
+	if window := now.Sub(t.windowStart); window > 0 {
+		avg = t.weighted / window.Seconds()
+	}
+	t.windowStart = now
+	t.weighted = 0
+	return t.current, avg
+}
+
+// advance accounts for the time spent at the current concurrency until now.
+// The caller must hold the lock.
+func (t *concurrencyTracker) advance(now time.Time) {
+	t.weighted += float64(t.current) * now.Sub(t.lastChange).Seconds()
+	t.lastChange = now
+}
+
+// concurrencyPeak keeps the number of requests holding capacity and the
+// highest it reached since the previous take.
+type concurrencyPeak struct {
+	mux     sync.Mutex
+	current int
+	max     int
+}
+
+// add changes the concurrency by delta.
+func (p *concurrencyPeak) add(delta int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.current += delta
+	if p.current > p.max {
+		p.max = p.current
+	}
+}
+
+// take returns the highest concurrency since the previous take and starts
+// over from the current concurrency.
+func (p *concurrencyPeak) take() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	max := p.max
+	p.max = p.current
+	return max
+}
@@ -18,9 +18,22 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
 )
 
 const (
@@ -49,6 +62,29 @@ func TestBreakerInvalidConstructor(t *testing.T) {
 	}, {
 		name:    "InitialCapacity out-of-bounds",
 		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 5, InitialCapacity: 6},
+	}, {
+		name:    "BurstCapacity negative",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, BurstCapacity: -1},
+	}, {
+		name:    "CapacityStep without interval",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, CapacityStep: 1},
+	}, {
+		name:    "AdmissionRate negative",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, AdmissionRate: -1},
+	}, {
+		name:    "AdmissionRate without burst",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, AdmissionRate: 1},
+	}, {
+		name:    "QueueMode unknown",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, QueueMode: "lifo"},
+	}, {
+		name: "QueueMode weighted fair with cost-deadline scheduling",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+			QueueMode: QueueModeWeightedFair, CostDeadlineScheduling: true},
+	}, {
+		name: "QueueTenantWeights non-positive",
+		options: BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+			QueueMode: QueueModeWeightedFair, QueueTenantWeights: map[string]int{"a": 0}},
 	}}
 
 	for _, test := range tests {
@@ -208,6 +244,470 @@ func TestBreakerCancel(t *testing.T) {
 	reqs.processSuccessfully(t)
 }
 
+func TestBreakerCancelWhileQueued(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	release, ok := b.Reserve(context.Background())
+	if !ok {
+		t.Fatal("Reserve() failed")
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- b.Maybe(ctx, func() {})
+	}()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.InFlight() == 2, nil
+	}); err != nil {
+		t.Fatal("Request never got queued:", err)
+	}
+
+	// The cancelled request returns right away, releasing its spot in the
+	// queue while the capacity is still held.
+	cancel()
+	err := <-result
+	if !errors.Is(err, ErrRequestCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Maybe() = %v, want: %v", err, ErrRequestCancelled)
+	}
+	if got, want := b.InFlight(), 1; got != want {
+		t.Errorf("InFlight() = %d, want: %d", got, want)
+	}
+}
+
+func TestBreakerQueueTimeout(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0,
+		QueueTimeout: 10 * time.Millisecond})
+	if err := b.Maybe(context.Background(), func() {}); !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("Maybe() = %v, want: %v", err, ErrQueueTimeout)
+	}
+
+	// The request's own deadline takes precedence if it's shorter.
+	b = NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0,
+		QueueTimeout: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Maybe(ctx, func() {}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Maybe() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+	if got := b.InFlight(); got != 0 {
+		t.Errorf("InFlight() = %d, want: 0", got)
+	}
+}
+
+func TestBreakerNoDeadlineShed(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 2, MaxConcurrency: 1, InitialCapacity: 1,
+		NoDeadlineShedThreshold: 0.5})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	release := make(chan struct{})
+	results := make(chan error, 3)
+	maybe := func(ctx context.Context) {
+		results <- b.Maybe(ctx, func() { <-release })
+	}
+	waitInFlight := func(want int) {
+		t.Helper()
+		if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+			return b.InFlight() == want, nil
+		}); err != nil {
+			t.Fatalf("InFlight() = %d, want: %d", b.InFlight(), want)
+		}
+	}
+
+	// Below the threshold, requests without a deadline are admitted and
+	// queued as usual.
+	go maybe(context.Background())
+	waitInFlight(1)
+	go maybe(ctx)
+	waitInFlight(2)
+
+	// At the threshold, requests without a deadline are shed while those with
+	// one still queue.
+	if err := b.Maybe(context.Background(), func() {}); !errors.Is(err, ErrNoDeadline) {
+		t.Errorf("Maybe() = %v, want: %v", err, ErrNoDeadline)
+	}
+	go maybe(ctx)
+	waitInFlight(3)
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Maybe() = %v, want: nil", err)
+		}
+	}
+}
+
+func TestBreakerMaxSlotTime(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 2, MaxConcurrency: 1, InitialCapacity: 1,
+		MaxSlotTime: 50 * time.Millisecond})
+
+	// run counts the thunks running and fails if they exceed the capacity.
+	var running atomic.Int32
+	run := func(thunk func()) {
+		if n := running.Inc(); n > 1 {
+			t.Errorf("%d requests in flight, want at most 1", n)
+		}
+		defer running.Dec()
+		thunk()
+	}
+
+	// A hung request is cancelled, but keeps its slot until it returns.
+	cancelled := make(chan struct{})
+	hang := make(chan struct{})
+	hung := make(chan error)
+	go func() {
+		hung <- b.MaybeWithContext(context.Background(), func(ctx context.Context) {
+			run(func() {
+				<-ctx.Done()
+				close(cancelled)
+				<-hang
+			})
+		})
+	}()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Hung request was never cancelled")
+	}
+	queued := make(chan error)
+	go func() {
+		queued <- b.Maybe(context.Background(), func() { run(func() {}) })
+	}()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.InFlight() == 2, nil
+	}); err != nil {
+		t.Fatal("Request never queued behind the hung request:", err)
+	}
+
+	close(hang)
+	if err := <-hung; !errors.Is(err, ErrSlotTimeout) {
+		t.Errorf("MaybeWithContext() = %v, want: %v", err, ErrSlotTimeout)
+	}
+	if err := <-queued; err != nil {
+		t.Errorf("Maybe() = %v, want: nil", err)
+	}
+	if got, want := b.InFlight(), 0; got != want {
+		t.Errorf("InFlight() = %d, want: %d", got, want)
+	}
+
+	// Requests finishing in time are unaffected.
+	if err := b.MaybeWithContext(context.Background(), func(ctx context.Context) {
+		if ctx.Err() != nil {
+			t.Error("Context cancelled early:", ctx.Err())
+		}
+	}); err != nil {
+		t.Errorf("MaybeWithContext() = %v, want: nil", err)
+	}
+}
+
+func TestBreakerMethodMaxConcurrency(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 2, InitialCapacity: 2,
+		MethodMaxConcurrency: map[string]int{"post": 1}})
+	post := b.ForMethod(http.MethodPost)
+	if post == b {
+		t.Fatal("ForMethod(POST) returned the breaker itself")
+	}
+	if got := b.ForMethod(http.MethodGet); got != b {
+		t.Error("ForMethod(GET) didn't return the breaker itself")
+	}
+
+	// Saturate the POST breaker.
+	release := make(chan struct{})
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- post.Maybe(context.Background(), func() { <-release })
+		}()
+	}
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return post.InFlight() == 2, nil
+	}); err != nil {
+		t.Fatal("POST requests never got pending:", err)
+	}
+	if err := post.Maybe(context.Background(), func() {}); !errors.Is(err, ErrRequestQueueFull) {
+		t.Errorf("POST Maybe() = %v, want: %v", err, ErrRequestQueueFull)
+	}
+
+	// Other methods are unaffected.
+	for i := 0; i < 2; i++ {
+		if err := b.Maybe(context.Background(), func() {}); err != nil {
+			t.Errorf("GET Maybe() = %v, want: nil", err)
+		}
+	}
+	if got, want := b.InFlight(), 0; got != want {
+		t.Errorf("InFlight() = %d, want: %d", got, want)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("POST Maybe() = %v, want: nil", err)
+		}
+	}
+	stats := b.StatsSnapshot()
+	if got, want := stats.AdmittedTotal, int64(4); got != want {
+		t.Errorf("AdmittedTotal = %d, want: %d", got, want)
+	}
+	if got, want := stats.RejectedTotal, int64(1); got != want {
+		t.Errorf("RejectedTotal = %d, want: %d", got, want)
+	}
+}
+
+func TestBreakerOracle(t *testing.T) {
+	// stuck holds up oracles that ignore their context until the test ends.
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	tests := []struct {
+		name   string
+		oracle CapacityOracleFunc
+		want   error
+	}{{
+		name: "allows",
+		oracle: func(context.Context) (bool, error) {
+			return true, nil
+		},
+	}, {
+		name: "denies",
+		oracle: func(context.Context) (bool, error) {
+			return false, nil
+		},
+		want: ErrCapacityDenied,
+	}, {
+		name: "times out",
+		oracle: func(ctx context.Context) (bool, error) {
+			<-ctx.Done()
+			return false, ctx.Err()
+		},
+	}, {
+		name: "ignores the context",
+		oracle: func(context.Context) (bool, error) {
+			<-stuck
+			return false, nil
+		},
+	}, {
+		name: "fails",
+		oracle: func(context.Context) (bool, error) {
+			return false, errors.New("oracle unavailable")
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+				Oracle: test.oracle, OracleTimeout: 10 * time.Millisecond})
+
+			for i := 0; i < 2; i++ {
+				ran := false
+				if err := b.Maybe(context.Background(), func() { ran = true }); !errors.Is(err, test.want) {
+					t.Errorf("Maybe() = %v, want: %v", err, test.want)
+				}
+				if want := test.want == nil; ran != want {
+					t.Errorf("Thunk ran = %v, want: %v", ran, want)
+				}
+				// Denied requests leave no capacity behind.
+				if got := b.InFlight(); got != 0 {
+					t.Errorf("InFlight() = %d, want: 0", got)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerOracleHoldsNoCapacity(t *testing.T) {
+	consulted, answer := make(chan struct{}), make(chan struct{})
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		Oracle: CapacityOracleFunc(func(context.Context) (bool, error) {
+			close(consulted)
+			<-answer
+			return true, nil
+		}),
+		OracleTimeout: time.Minute})
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- b.Maybe(context.Background(), func() {})
+	}()
+	<-consulted
+
+	// The capacity stays free while the oracle is deciding.
+	release, ok := b.Reserve(context.Background())
+	if !ok {
+		t.Fatal("Reserve() failed while the oracle was consulted")
+	}
+	release()
+
+	close(answer)
+	if err := <-errCh; err != nil {
+		t.Error("Maybe() =", err)
+	}
+}
+
+func TestBreakerDrain(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	reqs := newRequestor(b)
+
+	// One request holds the capacity, the other one waits in the queue.
+	reqs.request()
+	reqs.request()
+	for b.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := b.Pending(), 2; got != want {
+		t.Errorf("Pending() = %d, want: %d", got, want)
+	}
+
+	drained := make(chan error)
+	go func() {
+		drained <- b.Drain(context.Background())
+	}()
+
+	// The queued request is removed from the queue.
+	reqs.expectFailure(t)
+	if got, want := b.Pending(), 1; got != want {
+		t.Errorf("Pending() = %d, want: %d", got, want)
+	}
+
+	// New requests are rejected.
+	if err := b.Maybe(context.Background(), func() {}); !errors.Is(err, ErrDraining) {
+		t.Errorf("Maybe() = %v, want: %v", err, ErrDraining)
+	}
+	if _, ok := b.Reserve(context.Background()); ok {
+		t.Error("Reserve() succeeded on a draining breaker")
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatal("Drain returned while a request was still in flight:", err)
+	case <-time.After(semNoChangeTimeout):
+	}
+
+	// The request holding capacity finishes and Drain returns.
+	reqs.processSuccessfully(t)
+	if err := <-drained; err != nil {
+		t.Error("Drain() =", err)
+	}
+
+	// Draining again returns immediately.
+	if err := b.Drain(context.Background()); err != nil {
+		t.Error("Drain() =", err)
+	}
+}
+
+func TestBreakerAdmissionRate(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10,
+		AdmissionRate: float64(time.Second / interval), AdmissionBurst: 2})
+
+	// A burst of requests is admitted one interval after the other once the
+	// bucket's burst is used up, despite plenty of free capacity.
+	const requests = 6
+	admissions := make(chan time.Time, requests)
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	start := time.Now()
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer wg.Done()
+			if err := b.Maybe(context.Background(), func() { admissions <- time.Now() }); err != nil {
+				t.Error("Maybe() =", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(admissions)
+
+	times := make([]time.Time, 0, requests)
+	for at := range admissions {
+		times = append(times, at)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	if got, min := times[1].Sub(start), interval; got >= min {
+		t.Errorf("Second admission after %v, want it within the burst", got)
+	}
+	// Allow for some timer slack.
+	if got, min := times[requests-1].Sub(start), (requests-2)*interval*9/10; got < min {
+		t.Errorf("Last admission after %v, want at least %v", got, min)
+	}
+
+	// Waiting for the pace gives up with the request's context.
+	for b.pacer.Allow() {
+		// Use up the tokens refilled meanwhile.
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), interval/10)
+	defer cancel()
+	if err := b.Maybe(ctx, func() {}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Maybe() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBreakerDrainMethodBreakers(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		MethodMaxConcurrency: map[string]int{"POST": 1}})
+	post := b.ForMethod(http.MethodPost)
+
+	// A POST request holds the capacity of the POST breaker.
+	release := make(chan struct{})
+	result := make(chan error)
+	go func() {
+		result <- post.Maybe(context.Background(), func() { <-release })
+	}()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.Pending() == 1, nil
+	}); err != nil {
+		t.Fatal("POST request never got pending:", err)
+	}
+
+	drained := make(chan error)
+	go func() {
+		drained <- b.Drain(context.Background())
+	}()
+
+	// New requests are rejected by the method breakers, too.
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return errors.Is(post.Maybe(context.Background(), func() {}), ErrDraining), nil
+	}); err != nil {
+		t.Error("POST breaker never started draining:", err)
+	}
+	if err := b.Maybe(context.Background(), func() {}); !errors.Is(err, ErrDraining) {
+		t.Errorf("Maybe() = %v, want: %v", err, ErrDraining)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatal("Drain returned while a POST request was still in flight:", err)
+	case <-time.After(semNoChangeTimeout):
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Error("POST Maybe() =", err)
+	}
+	if err := <-drained; err != nil {
+		t.Error("Drain() =", err)
+	}
+	if got, want := b.Pending(), 0; got != want {
+		t.Errorf("Pending() = %d, want: %d", got, want)
+	}
+}
+
+func TestBreakerDrainContextDone(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	reqs := newRequestor(b)
+	reqs.request()
+	for b.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+	reqs.processSuccessfully(t)
+}
+
 func TestBreakerUpdateConcurrency(t *testing.T) {
 	params := BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0}
 	b := NewBreaker(params)
@@ -221,6 +721,66 @@ func TestBreakerUpdateConcurrency(t *testing.T) {
 		t.Errorf("Capacity() = %d, want: %d", got, want)
 	}
 
+	b.UpdateConcurrency(2)
+	if got, want := b.Capacity(), 1; got != want {
+		t.Errorf("Capacity() above max = %d, want: %d", got, want)
+	}
+
+	b.UpdateConcurrency(-1)
+	if got, want := b.Capacity(), 0; got != want {
+		t.Errorf("Capacity() below 0 = %d, want: %d", got, want)
+	}
+}
+
+func TestBreakerShrinkKeepsInFlight(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 2, InitialCapacity: 2})
+
+	admitted := make(chan int, 3)
+	release := []chan struct{}{make(chan struct{}), make(chan struct{}), make(chan struct{})}
+	errs := make(chan error, 3)
+	for i := range release {
+		i := i
+		go func() {
+			errs <- b.Maybe(context.Background(), func() {
+				admitted <- i
+				<-release[i]
+			})
+		}()
+		// Two requests hold the capacity, the third one waits.
+		if i < 2 {
+			<-admitted
+		}
+	}
+	for b.InFlight() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Shrinking doesn't evict the requests in flight.
+	b.UpdateConcurrency(1)
+	if got, want := b.Capacity(), 1; got != want {
+		t.Errorf("Capacity() = %d, want: %d", got, want)
+	}
+	if got, want := b.InFlight(), 3; got != want {
+		t.Errorf("InFlight() = %d, want: %d", got, want)
+	}
+
+	// The waiting request only gets capacity once both finished.
+	close(release[0])
+	select {
+	case <-admitted:
+		t.Error("Request admitted above the shrunken capacity")
+	case <-time.After(semNoChangeTimeout):
+	}
+	close(release[1])
+	if got, want := <-admitted, 2; got != want {
+		t.Errorf("Admitted request %d, want: %d", got, want)
+	}
+	close(release[2])
+	for range release {
+		if err := <-errs; err != nil {
+			t.Error("Maybe() =", err)
+		}
+	}
 }
 
 // Test empty semaphore, token cannot be acquired
@@ -396,6 +956,44 @@ func BenchmarkBreakerMaybe(b *testing.B) {
 	}
 }
 
+// BenchmarkBreakerMaybeTracking measures the cost the optional trackers add
+// to every admission, compared to BenchmarkBreakerMaybe.
+func BenchmarkBreakerMaybeTracking(b *testing.B) {
+	op := func() {}
+
+	for _, tc := range []struct {
+		name   string
+		params BreakerParams
+	}{{
+		name:   "concurrency",
+		params: BreakerParams{TrackConcurrency: true},
+	}, {
+		name:   "peak-concurrency",
+		params: BreakerParams{TrackPeakConcurrency: true},
+	}, {
+		name:   "idle-time",
+		params: BreakerParams{TrackIdleTime: true},
+	}} {
+		params := tc.params
+		params.QueueDepth, params.MaxConcurrency, params.InitialCapacity = 10000000, 100, 100
+		breaker := NewBreaker(params)
+
+		b.Run(tc.name+"-sequential", func(b *testing.B) {
+			for j := 0; j < b.N; j++ {
+				breaker.Maybe(context.Background(), op)
+			}
+		})
+
+		b.Run(tc.name+"-parallel", func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					breaker.Maybe(context.Background(), op)
+				}
+			})
+		})
+	}
+}
+
 func BenchmarkBreakerReserve(b *testing.B) {
 	op := func() {}
 	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 10000000, InitialCapacity: 10000000})
@@ -422,3 +1020,605 @@ func BenchmarkBreakerReserve(b *testing.B) {
 		})
 	})
 }
+
+func TestBreakerStatsSnapshot(t *testing.T) {
+	fc := clock.NewFakeClock(time.Now())
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 2, InitialCapacity: 2, TrackConcurrency: true})
+	b.concurrency = newConcurrencyTracker(fc)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Maybe(context.Background(), func() {
+				entered <- struct{}{}
+				<-release
+			})
+		}()
+	}
+	<-entered
+	<-entered
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.InFlight() == 3, nil
+	}); err != nil {
+		t.Fatal("Third request never queued:", err)
+	}
+	if err := b.Maybe(context.Background(), func() {}); err != ErrRequestQueueFull {
+		t.Fatalf("Maybe() = %v, want: %v", err, ErrRequestQueueFull)
+	}
+
+	fc.Step(time.Second)
+	want := BreakerStats{
+		InFlight:           2,
+		Queued:             1,
+		Capacity:           2,
+		AverageConcurrency: 2,
+		AdmittedTotal:      2,
+		RejectedTotal:      1,
+	}
+	got := b.StatsSnapshot()
+	if !cmp.Equal(got, want) {
+		t.Error("StatsSnapshot() (-want, +got):", cmp.Diff(want, got))
+	}
+
+	// The snapshot serializes as is.
+	raw, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal("Failed to marshal snapshot:", err)
+	}
+	const wantJSON = `{"inFlight":2,"queued":1,"capacity":2,"averageConcurrency":2,"admittedTotal":2,"rejectedTotal":1}`
+	if string(raw) != wantJSON {
+		t.Errorf("json.Marshal() = %s, want: %s", raw, wantJSON)
+	}
+
+	// One request left halfway through the next window.
+	release <- struct{}{}
+	<-entered
+	fc.Step(time.Second)
+	close(release)
+	wg.Wait()
+	fc.Step(time.Second)
+
+	want = BreakerStats{
+		Capacity:           2,
+		AverageConcurrency: 1,
+		AdmittedTotal:      3,
+		RejectedTotal:      1,
+	}
+	if got := b.StatsSnapshot(); !cmp.Equal(got, want) {
+		t.Error("StatsSnapshot() (-want, +got):", cmp.Diff(want, got))
+	}
+}
+
+func TestBreakerStatsSnapshotReserve(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1, TrackConcurrency: true})
+
+	release, ok := b.Reserve(context.Background())
+	if !ok {
+		t.Fatal("Reserve() failed")
+	}
+	if _, ok := b.Reserve(context.Background()); ok {
+		t.Fatal("Reserve() succeeded beyond capacity")
+	}
+	if got := b.StatsSnapshot(); got.InFlight != 1 || got.AdmittedTotal != 1 || got.RejectedTotal != 1 {
+		t.Errorf("StatsSnapshot() = %+v, want 1 in flight, admitted and rejected", got)
+	}
+
+	release()
+	if got := b.StatsSnapshot(); got.InFlight != 0 {
+		t.Errorf("InFlight = %d, want: 0", got.InFlight)
+	}
+}
+
+func TestBreakerStatsSnapshotUntracked(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+
+	release, ok := b.Reserve(context.Background())
+	if !ok {
+		t.Fatal("Reserve() failed")
+	}
+	defer release()
+
+	want := BreakerStats{Capacity: 1, AdmittedTotal: 1}
+	if got := b.StatsSnapshot(); !cmp.Equal(got, want) {
+		t.Error("StatsSnapshot() (-want, +got):", cmp.Diff(want, got))
+	}
+	if got := b.Queued(); got != 0 {
+		t.Errorf("Queued() = %d, want: 0", got)
+	}
+}
+
+func TestAdmissionRatioReporter(t *testing.T) {
+	defer metricstest.Unregister(admissionSuccessRatioM.Name())
+
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	r, err := NewAdmissionRatioReporter(b, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	// drive makes the breaker admit and reject the given number of requests.
+	drive := func(admit, reject int) {
+		for i := 0; i < admit; i++ {
+			b.Maybe(context.Background(), func() {})
+		}
+		if reject > 0 {
+			release, ok := b.Reserve(context.Background())
+			if !ok {
+				t.Fatal("Reserve() failed")
+			}
+			for i := 0; i < reject; i++ {
+				b.Reserve(context.Background())
+			}
+			release()
+		}
+	}
+	assertRatio := func(want float64) {
+		t.Helper()
+		r.report()
+		metricstest.AssertMetricRequiredOnly(t, metricstest.FloatMetric("admission_success_ratio", want, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}))
+	}
+
+	// No traffic is no reason to alert.
+	assertRatio(1)
+
+	// The reservation used to reject requests counts as admitted.
+	drive(2, 3)
+	assertRatio(0.5)
+
+	// Every interval starts afresh.
+	drive(3, 0)
+	assertRatio(1)
+	drive(0, 3)
+	assertRatio(0.25)
+	assertRatio(1)
+}
+
+func TestAchievedConcurrencyDisabled(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 5, InitialCapacity: 5})
+	if b.peak != nil {
+		t.Error("peak is set without TrackPeakConcurrency")
+	}
+	if _, err := NewAchievedConcurrencyReporter(b, "ns", "svc", "cfg", "rev", "pod"); err == nil {
+		t.Error("NewAchievedConcurrencyReporter() = nil error, want an error")
+	}
+}
+
+func TestAchievedConcurrencyReporter(t *testing.T) {
+	defer metricstest.Unregister(achievedConcurrencyMaxM.Name())
+
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 5, InitialCapacity: 5,
+		TrackPeakConcurrency: true})
+	r, err := NewAchievedConcurrencyReporter(b, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	// hold admits n requests holding capacity until the returned function is
+	// called.
+	hold := func(n int) func() {
+		releases := make([]func(), 0, n)
+		for i := 0; i < n; i++ {
+			release, ok := b.Reserve(context.Background())
+			if !ok {
+				t.Fatal("Reserve() failed")
+			}
+			releases = append(releases, release)
+		}
+		return func() {
+			for _, release := range releases {
+				release()
+			}
+		}
+	}
+	assertPeak := func(want int64) {
+		t.Helper()
+		r.report()
+		metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("achieved_concurrency_max", want, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}))
+	}
+
+	// No traffic reaches no concurrency.
+	assertPeak(0)
+
+	// The peak outlives the requests reaching it.
+	release := hold(4)
+	release()
+	hold(2)()
+	assertPeak(4)
+
+	// Every interval starts from the requests still in flight.
+	release = hold(1)
+	assertPeak(1)
+	hold(2)()
+	assertPeak(3)
+	release()
+	assertPeak(1)
+	assertPeak(0)
+}
+
+func TestQueueDepthSampler(t *testing.T) {
+	defer metricstest.Unregister(queueDepthM.Name())
+
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1})
+	s, err := NewQueueDepthSampler(b, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create sampler:", err)
+	}
+
+	// One request holds the capacity while two more wait.
+	release := make(chan struct{})
+	done := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			b.Maybe(context.Background(), func() { <-release })
+			done <- struct{}{}
+		}()
+	}
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.Pending() == 3, nil
+	}); err != nil {
+		t.Fatal("Requests never got pending:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx, 5*time.Millisecond)
+		close(stopped)
+	}()
+	// depthIs returns whether the latest sample is the given depth.
+	depthIs := func(want int64) wait.ConditionFunc {
+		return func() (bool, error) {
+			m := metricstest.GetMetric(queueDepthM.Name())
+			return len(m) == 1 && *m[0].Values[0].Int64 == want, nil
+		}
+	}
+
+	// The depth is sampled without any request arriving.
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, depthIs(3)); err != nil {
+		t.Fatal("Queue depth never sampled as 3:", err)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, depthIs(0)); err != nil {
+		t.Fatal("Queue depth never sampled as 0:", err)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return once the context was done")
+	}
+}
+
+func TestBreakerAdmissionEvents(t *testing.T) {
+	healthy := atomic.NewBool(true)
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		AdmissionEventBuffer: 10,
+		DependencyHealth: DependencyHealthFunc(func(context.Context) (bool, error) {
+			return healthy.Load(), nil
+		})})
+
+	if err := b.Maybe(context.Background(), func() {}); err != nil {
+		t.Fatal("Maybe() =", err)
+	}
+	healthy.Store(false)
+	if err := b.Maybe(context.Background(), func() {}); !errors.Is(err, ErrDependencyUnhealthy) {
+		t.Fatalf("Maybe() = %v, want: %v", err, ErrDependencyUnhealthy)
+	}
+
+	if ev := <-b.AdmissionEvents(); !ev.Admitted || ev.Cost != 1 || ev.Reason != nil {
+		t.Errorf("Event = %+v, want admitted at cost 1", ev)
+	}
+	if ev := <-b.AdmissionEvents(); ev.Admitted || ev.Cost != 0 || !errors.Is(ev.Reason, ErrDependencyUnhealthy) {
+		t.Errorf("Event = %+v, want rejected with %v", ev, ErrDependencyUnhealthy)
+	}
+	select {
+	case ev := <-b.AdmissionEvents():
+		t.Errorf("Unexpected event %+v", ev)
+	default:
+	}
+}
+
+func TestBreakerAdmissionEventsDropOldest(t *testing.T) {
+	healthy := atomic.NewBool(true)
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		AdmissionEventBuffer: 2,
+		DependencyHealth: DependencyHealthFunc(func(context.Context) (bool, error) {
+			return healthy.Load(), nil
+		})})
+
+	// Nobody consumes the events, which must not block the requests.
+	for _, admit := range []bool{true, false, true} {
+		healthy.Store(admit)
+		b.Maybe(context.Background(), func() {})
+	}
+
+	// The first event was dropped for the last one.
+	for _, want := range []bool{false, true} {
+		if ev := <-b.AdmissionEvents(); ev.Admitted != want {
+			t.Errorf("Admitted = %v, want: %v", ev.Admitted, want)
+		}
+	}
+}
+
+func TestBreakerAdmissionEventsDisabled(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	if err := b.Maybe(context.Background(), func() {}); err != nil {
+		t.Fatal("Maybe() =", err)
+	}
+	if ch := b.AdmissionEvents(); ch != nil {
+		t.Errorf("AdmissionEvents() = %v, want: nil", ch)
+	}
+}
+
+func TestIdleTimeRatioDisabled(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 5, InitialCapacity: 5})
+	if b.idle != nil {
+		t.Error("idle is set without TrackIdleTime")
+	}
+	if _, err := NewIdleTimeRatioReporter(b, "ns", "svc", "cfg", "rev", "pod"); err == nil {
+		t.Error("NewIdleTimeRatioReporter() = nil error, want an error")
+	}
+}
+
+func TestIdleTimeRatioReporter(t *testing.T) {
+	defer metricstest.Unregister(idleTimeRatioM.Name())
+
+	fc := clock.NewFakeClock(time.Now())
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 5, InitialCapacity: 5, TrackIdleTime: true})
+	b.idle = newIdleTracker(fc)
+	r, err := NewIdleTimeRatioReporter(b, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	// busy keeps n requests in flight for d.
+	busy := func(n int, d time.Duration) {
+		releases := make([]func(), 0, n)
+		for i := 0; i < n; i++ {
+			release, ok := b.Reserve(context.Background())
+			if !ok {
+				t.Fatal("Reserve() failed")
+			}
+			releases = append(releases, release)
+		}
+		fc.Step(d)
+		for _, release := range releases {
+			release()
+		}
+	}
+	assertRatio := func(want float64) {
+		t.Helper()
+		r.report()
+		metricstest.AssertMetricRequiredOnly(t, metricstest.FloatMetric("idle_time_ratio", want, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}))
+	}
+
+	// No traffic at all is fully idle.
+	fc.Step(10 * time.Second)
+	assertRatio(1)
+
+	// Alternating busy and idle periods.
+	busy(1, 1*time.Second)
+	fc.Step(3 * time.Second)
+	busy(2, 2*time.Second)
+	fc.Step(2 * time.Second)
+	assertRatio(5.0 / 8)
+
+	// Overlapping requests count as busy once.
+	release, _ := b.Reserve(context.Background())
+	fc.Step(2 * time.Second)
+	busy(3, 2*time.Second)
+	release()
+	fc.Step(1 * time.Second)
+	assertRatio(1.0 / 5)
+
+	// A request spanning whole intervals keeps them busy.
+	release, _ = b.Reserve(context.Background())
+	fc.Step(4 * time.Second)
+	assertRatio(0)
+	fc.Step(2 * time.Second)
+	release()
+	fc.Step(2 * time.Second)
+	assertRatio(0.5)
+}
+
+func TestIdleTimeRatioMethodBreakers(t *testing.T) {
+	b := NewBreaker(BreakerParams{
+		QueueDepth:           10,
+		MaxConcurrency:       5,
+		InitialCapacity:      5,
+		MethodMaxConcurrency: map[string]int{"POST": 1},
+		TrackIdleTime:        true,
+	})
+	if b.ForMethod("POST").idle != b.idle {
+		t.Error("Method breaker does not share the idle time of its parent")
+	}
+}
+
+func TestAdmissionCASRetriesDisabled(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 5, InitialCapacity: 5})
+	if b.casRetries != nil {
+		t.Error("casRetries is set without CountCASRetries")
+	}
+	if _, err := NewAdmissionCASRetriesReporter(b, "ns", "svc", "cfg", "rev", "pod"); err == nil {
+		t.Error("NewAdmissionCASRetriesReporter() = nil error, want an error")
+	}
+}
+
+func TestAdmissionCASRetriesUnderContention(t *testing.T) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		t.Skip("Contention needs more than one processor")
+	}
+	defer metricstest.Unregister(admissionCASRetriesM.Name())
+
+	const (
+		workers  = 64
+		requests = 1000
+		rounds   = 10
+	)
+	b := NewBreaker(BreakerParams{
+		QueueDepth:      workers,
+		MaxConcurrency:  workers,
+		InitialCapacity: workers,
+		CountCASRetries: true,
+		MethodMaxConcurrency: map[string]int{
+			"POST": workers,
+		},
+	})
+	r, err := NewAdmissionCASRetriesReporter(b, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	if got := b.ForMethod("POST").casRetries; got != b.casRetries {
+		t.Error("The method breaker doesn't share the retries counter")
+	}
+
+	// Hammer the breaker and its method breaker until the compare-and-swap
+	// loops had to retry, which is all but certain in the first round.
+	for i := 0; i < rounds && b.casRetries.Load() == 0; i++ {
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for w := 0; w < workers; w++ {
+			mb := b
+			if w%2 == 0 {
+				mb = b.ForMethod("POST")
+			}
+			go func() {
+				defer wg.Done()
+				for j := 0; j < requests; j++ {
+					mb.Maybe(context.Background(), func() {})
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	retries := b.casRetries.Load()
+	if retries == 0 {
+		t.Fatalf("No compare-and-swap retries were counted after %d rounds", rounds)
+	}
+	r.report()
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("admission_cas_retries", retries, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+	if got := b.casRetries.Load(); got != 0 {
+		t.Errorf("Retries after report = %d, want 0", got)
+	}
+}
+
+func TestQueueEmptyTransitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		params BreakerParams
+		method string
+	}{{
+		name:   "semaphore",
+		params: BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1},
+	}, {
+		name: "scheduler",
+		params: BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1,
+			CostDeadlineScheduling: true},
+	}, {
+		name: "method breaker",
+		params: BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10,
+			MethodMaxConcurrency: map[string]int{"POST": 1}},
+		method: "POST",
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBreaker(tc.params)
+			mb := b
+			if tc.method != "" {
+				mb = b.ForMethod(tc.method)
+			}
+
+			const (
+				cycles  = 3
+				waiters = 4
+			)
+			for c := 1; c <= cycles; c++ {
+				drainCycle(t, mb, waiters)
+				if got := b.queue.emptied.Load(); got != int64(c) {
+					t.Fatalf("Transitions after cycle %d = %d, want: %d", c, got, c)
+				}
+			}
+
+			// Requests admitted right away never enter the queue.
+			for i := 0; i < waiters; i++ {
+				mb.Maybe(context.Background(), func() {})
+			}
+			if got := b.queue.emptied.Load(); got != cycles {
+				t.Errorf("Transitions without queueing = %d, want: %d", got, cycles)
+			}
+		})
+	}
+}
+
+func TestQueueEmptyTransitionsReporter(t *testing.T) {
+	defer metricstest.Unregister(queueEmptyTransitionsM.Name())
+
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1})
+	r, err := NewQueueEmptyTransitionsReporter(b, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	drainCycle(t, b, 2)
+	drainCycle(t, b, 3)
+	r.report()
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("queue_empty_transitions", 2, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+	if got := b.queue.emptied.Load(); got != 0 {
+		t.Errorf("Transitions after report = %d, want: 0", got)
+	}
+}
+
+// drainCycle holds the only slot of b until n requests wait in its queue and
+// then lets them all drain.
+func drainCycle(t *testing.T, b *Breaker, n int) {
+	t.Helper()
+	hold, held := make(chan struct{}), make(chan struct{})
+	go b.Maybe(context.Background(), func() {
+		close(held)
+		<-hold
+	})
+	<-held
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			if err := b.Maybe(context.Background(), func() {}); err != nil {
+				t.Error("Maybe() =", err)
+			}
+		}()
+	}
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.queue.waiting.Load() == int64(n), nil
+	}); err != nil {
+		t.Fatalf("Waiting = %d, want: %d", b.queue.waiting.Load(), n)
+	}
+	close(hold)
+	wg.Wait()
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// queueTracer logs a sample of the requests entering and leaving a breaker's
+// queue, so that its exact behavior can be reconstructed when debugging an
+// incident. The entries are logged at debug level, zap's most verbose.
+type queueTracer struct {
+	logger     *zap.SugaredLogger
+	sampleRate float64
+	random     func() float64
+
+	// seq numbers the traced requests, identifying the entries of a request
+	// without a trace.
+	seq atomic.Uint64
+}
+
+func newQueueTracer(logger *zap.SugaredLogger, sampleRate float64) *queueTracer {
+	return &queueTracer{
+		logger:     logger,
+		sampleRate: sampleRate,
+		random:     rand.Float64,
+	}
+}
+
+// queueTrace is a traced request in the queue.
+type queueTrace struct {
+	tracer   *queueTracer
+	id       uint64
+	traceID  string
+	enqueued time.Time
+}
+
+// enqueue logs the request with the given context entering the queue if it's
+// sampled and returns its trace, or nil if it isn't. The tracer may be nil.
+func (t *queueTracer) enqueue(ctx context.Context) *queueTrace {
+	if t == nil || t.random() >= t.sampleRate {
+		return nil
+	}
+
+	qt := &queueTrace{
+		tracer:   t,
+		id:       t.seq.Inc(),
+		enqueued: time.Now(),
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		qt.traceID = span.SpanContext().TraceID.String()
+	}
+	t.logger.Debugw("Request enqueued",
+		"request", qt.id,
+		"traceID", qt.traceID,
+		"time", qt.enqueued)
+	return qt
+}
+
+// dequeue logs the request leaving the queue, admitted if err is nil or
+// rejected with err otherwise. The trace may be nil.
+func (qt *queueTrace) dequeue(err error) {
+	if qt == nil {
+		return
+	}
+
+	now := time.Now()
+	qt.tracer.logger.Debugw("Request dequeued",
+		"request", qt.id,
+		"traceID", qt.traceID,
+		"time", now,
+		"wait", now.Sub(qt.enqueued),
+		"admitted", err == nil,
+		zap.Error(err))
+}
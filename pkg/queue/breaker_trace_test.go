@@ -0,0 +1,166 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestBreakerQueueTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.Lock(zapcore.AddSync(&buf)), zap.DebugLevel))
+
+	b := NewBreaker(BreakerParams{QueueDepth: 2, MaxConcurrency: 1, InitialCapacity: 1,
+		QueueTraceLogger: logger.Sugar(), QueueTraceSampleRate: 1})
+
+	// The first request is admitted right away and holds the capacity, the
+	// second one is admitted once it's released and the third one gives up
+	// while queued.
+	release := make(chan struct{})
+	held := make(chan struct{})
+	done := make(chan struct{}, 2)
+	go func() {
+		b.Maybe(context.Background(), func() {
+			close(held)
+			<-release
+		})
+		done <- struct{}{}
+	}()
+	<-held
+	go func() {
+		b.Maybe(context.Background(), func() {})
+		done <- struct{}{}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		cancelled <- b.Maybe(ctx, func() {})
+	}()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.InFlight() == 3, nil
+	}); err != nil {
+		t.Fatal("Requests never got queued:", err)
+	}
+	cancel()
+	<-cancelled
+	close(release)
+	<-done
+	<-done
+
+	type entry struct {
+		Msg      string
+		Request  uint64
+		Time     float64
+		Admitted *bool
+	}
+	enqueued := map[uint64]entry{}
+	dequeued := map[uint64]entry{}
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var e entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("Failed to parse log line %q: %v", s.Text(), err)
+		}
+		switch e.Msg {
+		case "Request enqueued":
+			if _, ok := enqueued[e.Request]; ok {
+				t.Errorf("Request %d enqueued twice", e.Request)
+			}
+			enqueued[e.Request] = e
+		case "Request dequeued":
+			if _, ok := enqueued[e.Request]; !ok {
+				t.Errorf("Request %d dequeued before it was enqueued", e.Request)
+			}
+			if _, ok := dequeued[e.Request]; ok {
+				t.Errorf("Request %d dequeued twice", e.Request)
+			}
+			dequeued[e.Request] = e
+		default:
+			t.Errorf("Unexpected log line %q", s.Text())
+		}
+	}
+
+	if got, want := len(enqueued), 3; got != want {
+		t.Errorf("Enqueued requests = %d, want: %d", got, want)
+	}
+	var admitted, rejected int
+	for id, in := range enqueued {
+		out, ok := dequeued[id]
+		if !ok {
+			t.Errorf("Request %d never dequeued", id)
+			continue
+		}
+		if out.Time < in.Time {
+			t.Errorf("Request %d dequeued at %v before it was enqueued at %v", id, out.Time, in.Time)
+		}
+		if out.Admitted == nil {
+			t.Errorf("Request %d dequeued without outcome", id)
+		} else if *out.Admitted {
+			admitted++
+		} else {
+			rejected++
+		}
+	}
+	if admitted != 2 || rejected != 1 {
+		t.Errorf("Dequeued %d admitted and %d rejected requests, want 2 and 1", admitted, rejected)
+	}
+}
+
+func TestBreakerQueueTraceSampled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(&buf), zap.DebugLevel))
+
+	// Tracing is off by default.
+	if b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1}); b.tracer != nil {
+		t.Error("Breaker traces its queue without a logger")
+	}
+	if b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		QueueTraceLogger: logger.Sugar()}); b.tracer != nil {
+		t.Error("Breaker traces its queue without a sample rate")
+	}
+
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		QueueTraceLogger: logger.Sugar(), QueueTraceSampleRate: 0.5})
+	// Every other request is sampled.
+	randoms := []float64{0.1, 0.9}
+	var n int
+	b.tracer.random = func() float64 {
+		n++
+		return randoms[n%len(randoms)]
+	}
+	for i := 0; i < 4; i++ {
+		b.Maybe(context.Background(), func() {})
+	}
+
+	if got, want := bytes.Count(buf.Bytes(), []byte("Request enqueued")), 2; got != want {
+		t.Errorf("Enqueued entries = %d, want: %d", got, want)
+	}
+	if got, want := bytes.Count(buf.Bytes(), []byte("Request dequeued")), 2; got != want {
+		t.Errorf("Dequeued entries = %d, want: %d", got, want)
+	}
+}
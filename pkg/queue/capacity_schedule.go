@@ -0,0 +1,92 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// ScheduledCapacity is a capacity to apply to a breaker at a point in time.
+type ScheduledCapacity struct {
+	At       time.Time
+	Capacity int
+}
+
+// CapacityPredictor returns the capacity the breaker should have at the given
+// time, or false to leave it as is.
+type CapacityPredictor func(now time.Time) (int, bool)
+
+// CapacityScheduler resizes a breaker ahead of predictable load, so that the
+// capacity is already in place when a spike arrives.
+type CapacityScheduler struct {
+	breaker *Breaker
+	clock   clock.Clock
+}
+
+// NewCapacityScheduler creates a CapacityScheduler resizing the given
+// breaker.
+func NewCapacityScheduler(b *Breaker) *CapacityScheduler {
+	return newCapacityScheduler(b, clock.RealClock{})
+}
+
+func newCapacityScheduler(b *Breaker, clock clock.Clock) *CapacityScheduler {
+	return &CapacityScheduler{
+		breaker: b,
+		clock:   clock,
+	}
+}
+
+// RunSchedule applies the capacities of the schedule at their time, until the
+// schedule is exhausted or ctx is done. Capacities scheduled in the past are
+// applied right away, in order.
+func (s *CapacityScheduler) RunSchedule(ctx context.Context, schedule []ScheduledCapacity) {
+	sorted := make([]ScheduledCapacity, len(schedule))
+	copy(sorted, schedule)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].At.Before(sorted[j].At)
+	})
+
+	for _, sc := range sorted {
+		if wait := sc.At.Sub(s.clock.Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.clock.After(wait):
+			}
+		}
+		s.breaker.UpdateConcurrency(sc.Capacity)
+	}
+}
+
+// RunPredictor asks predict for the capacity every interval and applies it,
+// until ctx is done.
+func (s *CapacityScheduler) RunPredictor(ctx context.Context, interval time.Duration, predict CapacityPredictor) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-s.clock.After(interval):
+			if capacity, ok := predict(now); ok {
+				s.breaker.UpdateConcurrency(capacity)
+			}
+		}
+	}
+}
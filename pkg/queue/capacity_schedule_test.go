@@ -0,0 +1,104 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// stepWhenWaiting waits for the scheduler to wait on the fake clock before
+// stepping it.
+func stepWhenWaiting(t *testing.T, fc *clock.FakeClock, d time.Duration) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return fc.HasWaiters(), nil
+	}); err != nil {
+		t.Fatal("Scheduler never waited:", err)
+	}
+	fc.Step(d)
+}
+
+func assertCapacity(t *testing.T, b *Breaker, want int) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return b.Capacity() == want, nil
+	}); err != nil {
+		t.Fatalf("Capacity = %d, want: %d", b.Capacity(), want)
+	}
+}
+
+func TestCapacitySchedulerSchedule(t *testing.T) {
+	fc := clock.NewFakeClock(time.Now())
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 10, InitialCapacity: 1})
+	s := newCapacityScheduler(b, fc)
+
+	now := fc.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Out of order on purpose, the past entry applies right away.
+		s.RunSchedule(context.Background(), []ScheduledCapacity{
+			{At: now.Add(2 * time.Minute), Capacity: 2},
+			{At: now.Add(-time.Minute), Capacity: 5},
+			{At: now.Add(time.Minute), Capacity: 10},
+		})
+	}()
+
+	assertCapacity(t, b, 5)
+	stepWhenWaiting(t, fc, time.Minute-time.Second)
+	// Not time yet.
+	assertCapacity(t, b, 5)
+	stepWhenWaiting(t, fc, time.Second)
+	assertCapacity(t, b, 10)
+	stepWhenWaiting(t, fc, time.Minute)
+	assertCapacity(t, b, 2)
+	<-done
+}
+
+func TestCapacitySchedulerPredictor(t *testing.T) {
+	fc := clock.NewFakeClock(time.Now())
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 10, InitialCapacity: 1})
+	s := newCapacityScheduler(b, fc)
+
+	start := fc.Now()
+	predict := func(now time.Time) (int, bool) {
+		// Expect a spike after two minutes, no opinion before.
+		if now.Sub(start) >= 2*time.Minute {
+			return 8, true
+		}
+		return 0, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.RunPredictor(ctx, time.Minute, predict)
+	}()
+
+	stepWhenWaiting(t, fc, time.Minute)
+	assertCapacity(t, b, 1)
+	stepWhenWaiting(t, fc, time.Minute)
+	assertCapacity(t, b, 8)
+
+	cancel()
+	<-done
+}
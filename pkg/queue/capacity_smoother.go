@@ -0,0 +1,98 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// capacitySmoother moves a capacity toward a target by at most step every
+// interval, so that frequent resizes don't cause admission jitter. It only
+// runs a goroutine while the capacity hasn't reached the target yet.
+type capacitySmoother struct {
+	step     int
+	interval time.Duration
+	clock    clock.Clock
+	// apply sets the effective capacity. It's called with the lock held to
+	// keep updates ordered.
+	apply func(int)
+
+	mux     sync.Mutex
+	current int
+	target  int
+	running bool
+}
+
+func newCapacitySmoother(step int, interval time.Duration, clock clock.Clock, initial int, apply func(int)) *capacitySmoother {
+	return &capacitySmoother{
+		step:     step,
+		interval: interval,
+		clock:    clock,
+		apply:    apply,
+		current:  initial,
+		target:   initial,
+	}
+}
+
+// setTarget sets the capacity to move toward.
+func (s *capacitySmoother) setTarget(target int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.target = target
+	if s.running || s.current == target {
+		return
+	}
+	s.running = true
+	go s.run()
+}
+
+// getTarget returns the capacity the smoother moves toward.
+func (s *capacitySmoother) getTarget() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.target
+}
+
+func (s *capacitySmoother) run() {
+	for {
+		<-s.clock.After(s.interval)
+		if !s.stepOnce() {
+			return
+		}
+	}
+}
+
+// stepOnce moves the capacity a step toward the target and returns whether
+// the target is still to be reached.
+func (s *capacitySmoother) stepOnce() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch diff := s.target - s.current; {
+	case diff > s.step:
+		s.current += s.step
+	case diff < -s.step:
+		s.current -= s.step
+	default:
+		s.current = s.target
+	}
+	s.apply(s.current)
+	s.running = s.current != s.target
+	return s.running
+}
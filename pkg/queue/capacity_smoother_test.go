@@ -0,0 +1,114 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestCapacitySmoother(t *testing.T) {
+	const (
+		step     = 3
+		interval = time.Second
+	)
+	fc := clock.NewFakeClock(time.Now())
+	applied := make(chan int, 10)
+	s := newCapacitySmoother(step, interval, fc, 0, func(c int) { applied <- c })
+
+	// tick advances the clock by an interval and returns the applied capacity.
+	tick := func() int {
+		t.Helper()
+		for !fc.HasWaiters() {
+			time.Sleep(time.Millisecond)
+		}
+		fc.Step(interval)
+		select {
+		case c := <-applied:
+			return c
+		case <-time.After(semAcquireTimeout):
+			t.Fatal("Timed out waiting for a capacity change")
+			return 0
+		}
+	}
+
+	// idle waits for the smoother to stop once it reached the target.
+	idle := func() {
+		for fc.HasWaiters() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	s.setTarget(10)
+	if got, want := s.getTarget(), 10; got != want {
+		t.Errorf("getTarget() = %d, want: %d", got, want)
+	}
+	for _, want := range []int{3, 6, 9, 10} {
+		if got := tick(); got != want {
+			t.Errorf("Capacity = %d, want: %d", got, want)
+		}
+	}
+	idle()
+
+	// Rapid changes of the target only move the capacity a step at a time,
+	// toward whatever the target is at that time.
+	s.setTarget(2)
+	if got, want := tick(), 7; got != want {
+		t.Errorf("Capacity = %d, want: %d", got, want)
+	}
+	s.setTarget(8)
+	s.setTarget(20)
+	s.setTarget(4)
+	if got, want := tick(), 4; got != want {
+		t.Errorf("Capacity = %d, want: %d", got, want)
+	}
+
+	// Once the target is reached the smoother stops.
+	idle()
+	select {
+	case c := <-applied:
+		t.Errorf("Unexpected capacity change to %d", c)
+	default:
+	}
+}
+
+func TestBreakerCapacityStep(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 10, InitialCapacity: 1,
+		CapacityStep: 2, CapacityStepInterval: 10 * time.Millisecond})
+
+	b.UpdateConcurrency(7)
+	if got, want := b.TargetCapacity(), 7; got != want {
+		t.Errorf("TargetCapacity() = %d, want: %d", got, want)
+	}
+	// The capacity isn't changed right away but moves up in steps.
+	prev := b.Capacity()
+	if prev != 1 {
+		t.Errorf("Capacity() = %d, want: 1", prev)
+	}
+	for start := time.Now(); prev != 7 && time.Since(start) < semAcquireTimeout; time.Sleep(time.Millisecond) {
+		c := b.Capacity()
+		if c < prev || c-prev > 2 {
+			t.Fatalf("Capacity moved from %d to %d, want steps of at most 2", prev, c)
+		}
+		prev = c
+	}
+	if prev != 7 {
+		t.Errorf("Capacity() = %d, want: 7", prev)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var admissionCASRetriesM = stats.Int64(
+	"admission_cas_retries",
+	"The number of retries of the breaker's compare-and-swap loops caused by contended admission",
+	stats.UnitDimensionless)
+
+// AdmissionCASRetriesReporter records the retries of the compare-and-swap
+// loops of a breaker created with CountCASRetries, which tell how much
+// concurrent requests contend for admission.
+type AdmissionCASRetriesReporter struct {
+	statsCtx context.Context
+	breaker  *Breaker
+}
+
+// NewAdmissionCASRetriesReporter creates an AdmissionCASRetriesReporter
+// recording the admission_cas_retries metric of the given breaker.
+func NewAdmissionCASRetriesReporter(b *Breaker, ns, service, config, rev, pod string) (*AdmissionCASRetriesReporter, error) {
+	if b.casRetries == nil {
+		return nil, errors.New("the breaker doesn't count compare-and-swap retries")
+	}
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The number of retries of the breaker's compare-and-swap loops caused by contended admission",
+		Measure:     admissionCASRetriesM,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AdmissionCASRetriesReporter{
+		statsCtx: ctx,
+		breaker:  b,
+	}, nil
+}
+
+// Run records the retries of every period until ctx is done.
+func (r *AdmissionCASRetriesReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the retries since the previous report.
+func (r *AdmissionCASRetriesReporter) report() {
+	pkgmetrics.Record(r.statsCtx, admissionCASRetriesM.M(r.breaker.casRetries.Swap(0)))
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	network "knative.dev/networking/pkg"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var (
+	perClientConcurrencyM = stats.Int64(
+		"per_client_concurrency",
+		"The number of concurrent requests of each client IP with requests in flight",
+		stats.UnitDimensionless)
+
+	perClientConcurrencyDistribution = view.Distribution(1, 2, 4, 8, 16, 32, 64, 128, 256)
+)
+
+// ClientConcurrencyReporter tracks the number of concurrent requests of each
+// client IP and periodically samples them into a distribution, which tells
+// whether a few clients dominate. Only clients with requests in flight are
+// tracked, at most maxClients of them, the least recently seen ones are
+// forgotten.
+type ClientConcurrencyReporter struct {
+	statsCtx context.Context
+
+	mux sync.Mutex
+	// clients maps client IPs to their number of requests in flight.
+	clients *lru.Cache
+}
+
+// NewClientConcurrencyReporter creates a ClientConcurrencyReporter recording
+// the per_client_concurrency metric.
+func NewClientConcurrencyReporter(maxClients int, ns, service, config, rev, pod string) (*ClientConcurrencyReporter, error) {
+	clients, err := lru.New(maxClients)
+	if err != nil {
+		return nil, err
+	}
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The number of concurrent requests of each client IP with requests in flight",
+		Measure:     perClientConcurrencyM,
+		Aggregation: perClientConcurrencyDistribution,
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientConcurrencyReporter{
+		statsCtx: ctx,
+		clients:  clients,
+	}, nil
+}
+
+// Handler returns an http.Handler counting the requests passed to next
+// towards their client's concurrency.
+func (r *ClientConcurrencyReporter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if network.IsKubeletProbe(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		key := clientIP(req)
+		r.inc(key)
+		defer r.dec(key)
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (r *ClientConcurrencyReporter) inc(key string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if v, ok := r.clients.Get(key); ok {
+		*v.(*int)++
+		return
+	}
+	n := 1
+	r.clients.Add(key, &n)
+}
+
+// dec removes clients without requests in flight, so that the cache only
+// holds active clients.
+func (r *ClientConcurrencyReporter) dec(key string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	v, ok := r.clients.Peek(key)
+	if !ok {
+		// Forgotten while in flight.
+		return
+	}
+	if n := v.(*int); *n > 1 {
+		*n--
+	} else {
+		r.clients.Remove(key)
+	}
+}
+
+// Run samples the clients' concurrency every period until ctx is done.
+func (r *ClientConcurrencyReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the current concurrency of every client with requests in
+// flight.
+func (r *ClientConcurrencyReporter) report() {
+	r.mux.Lock()
+	samples := make([]stats.Measurement, 0, r.clients.Len())
+	for _, key := range r.clients.Keys() {
+		if v, ok := r.clients.Peek(key); ok {
+			samples = append(samples, perClientConcurrencyM.M(int64(*v.(*int))))
+		}
+	}
+	r.mux.Unlock()
+
+	for _, m := range samples {
+		pkgmetrics.Record(r.statsCtx, m)
+	}
+}
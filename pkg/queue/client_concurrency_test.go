@@ -0,0 +1,106 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"knative.dev/pkg/metrics/metricstest"
+)
+
+func TestClientConcurrencyReporter(t *testing.T) {
+	defer metricstest.Unregister(perClientConcurrencyM.Name())
+
+	r, err := NewClientConcurrencyReporter(10, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	release := make(chan struct{})
+	var entered sync.WaitGroup
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered.Done()
+		<-release
+	}))
+
+	// One client holds 3 requests, two others one each.
+	var done sync.WaitGroup
+	for _, addr := range []string{"10.0.0.1:1", "10.0.0.1:2", "10.0.0.1:3", "10.0.0.2:1", "10.0.0.3:1"} {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		req.RemoteAddr = addr
+		entered.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	entered.Wait()
+
+	r.report()
+	metricstest.EnsureRecorded()
+	d := metricstest.GetOneMetric("per_client_concurrency").Values[0].Distribution
+	if got, want := d.Count, int64(3); got != want {
+		t.Errorf("per_client_concurrency count = %d, want: %d", got, want)
+	}
+	if got, want := d.Sum, 5.; got != want {
+		t.Errorf("per_client_concurrency sum = %v, want: %v", got, want)
+	}
+	// Buckets: [0, 1), [1, 2), [2, 4), ...
+	if got, want := d.Buckets[1].Count, int64(2); got != want {
+		t.Errorf("Clients with a single request = %d, want: %d", got, want)
+	}
+	if got, want := d.Buckets[2].Count, int64(1); got != want {
+		t.Errorf("Clients with 2-3 requests = %d, want: %d", got, want)
+	}
+
+	// Idle clients are forgotten and not sampled.
+	close(release)
+	done.Wait()
+	if got := r.clients.Len(); got != 0 {
+		t.Errorf("Tracked clients = %d, want: 0", got)
+	}
+	r.report()
+	metricstest.EnsureRecorded()
+	if got, want := metricstest.GetOneMetric("per_client_concurrency").Values[0].Distribution.Count, int64(3); got != want {
+		t.Errorf("per_client_concurrency count = %d, want: %d", got, want)
+	}
+}
+
+func TestClientConcurrencyReporterBounded(t *testing.T) {
+	defer metricstest.Unregister(perClientConcurrencyM.Name())
+
+	r, err := NewClientConcurrencyReporter(2, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		r.inc(key)
+	}
+	if got, want := r.clients.Len(), 2; got != want {
+		t.Errorf("Tracked clients = %d, want: %d", got, want)
+	}
+	// The forgotten client's requests finishing is fine.
+	r.dec("a")
+	r.dec("b")
+	r.dec("c")
+	if got := r.clients.Len(); got != 0 {
+		t.Errorf("Tracked clients = %d, want: 0", got)
+	}
+}
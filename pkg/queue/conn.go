@@ -0,0 +1,120 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+type connInfoKey struct{}
+
+// connInfo holds information about the connection a request is served on.
+type connInfo struct {
+	// accepted is the time the connection was accepted by the server.
+	accepted time.Time
+	// requests is the number of requests received on the connection.
+	requests atomic.Int64
+	// limited is the number of requests counted against the connection's
+	// request limit.
+	limited atomic.Int64
+	// edge tracks when requests on the connection started to arrive, if the
+	// connection was accepted by an EdgeTimingListener.
+	edge *edgeConn
+	// conn is the connection as accepted from the network, for reading its
+	// socket statistics. retransmits is the number of retransmitted segments
+	// already recorded.
+	conn        net.Conn
+	retransmits atomic.Uint32
+}
+
+// ConnContext is meant to be set as the ConnContext of the http.Server serving
+// requests. It attaches information about the connection to the context of
+// every request served on it, which enables per-connection request metrics.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	edge, _ := c.(*edgeConn)
+	conn := c
+	if edge != nil {
+		conn = edge.Conn
+	}
+	return context.WithValue(ctx, connInfoKey{}, &connInfo{
+		accepted: time.Now(),
+		edge:     edge,
+		conn:     conn,
+	})
+}
+
+// connInfoFrom returns the connection information attached by ConnContext,
+// or nil if there is none.
+func connInfoFrom(ctx context.Context) *connInfo {
+	info, _ := ctx.Value(connInfoKey{}).(*connInfo)
+	return info
+}
+
+// EdgeTimingListener wraps the listener of the http.Server serving requests so
+// that the time spent before a request reaches the handlers, e.g. reading and
+// parsing its headers, can be measured. This requires ConnContext to be set on
+// the server as well.
+func EdgeTimingListener(l net.Listener) net.Listener {
+	return &edgeListener{Listener: l}
+}
+
+type edgeListener struct {
+	net.Listener
+}
+
+func (l *edgeListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ec := &edgeConn{Conn: c}
+	// The first request on the connection is timed from the accept on.
+	ec.start.Store(time.Now().UnixNano())
+	return ec, nil
+}
+
+// edgeConn records when the first byte of the current request was received.
+type edgeConn struct {
+	net.Conn
+
+	// idle is set between requests, the next byte read starts a request.
+	idle atomic.Bool
+	// start is the time the current request started to arrive, in unix nanos.
+	start atomic.Int64
+}
+
+func (c *edgeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.idle.CAS(true, false) {
+		c.start.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// requestStart returns the time the current request started to arrive.
+func (c *edgeConn) requestStart() time.Time {
+	return time.Unix(0, c.start.Load())
+}
+
+// markIdle denotes the current request as complete.
+func (c *edgeConn) markIdle() {
+	c.idle.Store(true)
+}
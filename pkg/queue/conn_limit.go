@@ -0,0 +1,38 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+)
+
+// ConnectionRequestLimitHandler makes the server close HTTP/1 connections
+// after they served maxRequests requests, by responding to the last one with
+// a "Connection: close" header. This makes clients rotate their connections
+// regularly. This requires ConnContext to be set on the server, requests on
+// untracked connections pass through untouched.
+func ConnectionRequestLimitHandler(maxRequests int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HTTP/2 multiplexes requests and forbids connection-specific headers.
+		if r.ProtoMajor == 1 {
+			if conn := connInfoFrom(r.Context()); conn != nil && conn.limited.Inc() >= int64(maxRequests) {
+				w.Header().Set("Connection", "close")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestConnectionRequestLimitHandler(t *testing.T) {
+	// The handler reports the number of the request on its connection.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Conn-Request", strconv.FormatInt(connInfoFrom(r.Context()).limited.Load(), 10))
+	})
+	server, client := newConnTrackingServer(t, ConnectionRequestLimitHandler(2, next))
+
+	tests := []struct {
+		wantRequest string
+		wantClose   bool
+	}{
+		{"1", false},
+		{"2", true},
+		// The connection was closed, the next request opens a new one.
+		{"1", false},
+		{"2", true},
+	}
+	for i, test := range tests {
+		resp := get(t, client, server.URL)
+		if got := resp.Header.Get("X-Conn-Request"); got != test.wantRequest {
+			t.Errorf("Request %d: request on connection = %s, want: %s", i, got, test.wantRequest)
+		}
+		if resp.Close != test.wantClose {
+			t.Errorf("Request %d: Close = %v, want: %v", i, resp.Close, test.wantClose)
+		}
+	}
+}
+
+func TestConnectionRequestLimitHandlerWithoutTracking(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := ConnectionRequestLimitHandler(1, next)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got := rec.Header().Get("Connection"); got != "" {
+		t.Errorf("Connection = %q, want none", got)
+	}
+}
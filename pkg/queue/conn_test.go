@@ -0,0 +1,250 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+// newConnTrackingServer starts a server tracking its connections via
+// ConnContext and a client reusing a single connection to it.
+func newConnTrackingServer(t *testing.T, h http.Handler) (*httptest.Server, *http.Client) {
+	server := httptest.NewUnstartedServer(h)
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client := server.Client()
+	client.Transport.(*http.Transport).MaxConnsPerHost = 1
+	return server, client
+}
+
+// get issues a GET request and drains the response so the connection can be
+// reused.
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestConnectionAge(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithConnectionAge())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	server, client := newConnTrackingServer(t, handler)
+
+	const pause = 150 * time.Millisecond
+	get(t, client, server.URL)
+	time.Sleep(pause)
+	get(t, client, server.URL)
+
+	metricstest.EnsureRecorded()
+	values := metricstest.GetOneMetric("connection_age").Values
+	if len(values) != 1 {
+		t.Fatalf("Got %d connection_age time series, want 1", len(values))
+	}
+	d := values[0].Distribution
+	if got, want := d.Count, int64(2); got != want {
+		t.Fatalf("connection_age count = %d, want: %d", got, want)
+	}
+	// The first request arrives on a fresh connection, the second one on the
+	// same connection later on.
+	if got := d.Buckets[0].Count; got != 1 {
+		t.Errorf("Requests on connections younger than 100ms = %d, want: 1", got)
+	}
+	if got, want := d.Sum, pause.Seconds(); got < want {
+		t.Errorf("connection_age sum = %vs, want at least %vs", got, want)
+	}
+}
+
+func TestConnectionAgeWithoutTracking(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithConnectionAge())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.AssertNoMetric(t, "connection_age")
+}
+
+func TestConnectionAgeDisabled(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	server, client := newConnTrackingServer(t, handler)
+
+	get(t, client, server.URL)
+	metricstest.AssertNoMetric(t, "connection_age")
+}
+
+func TestConnectionReuseTag(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithConnectionReuseTag())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	server, client := newConnTrackingServer(t, handler)
+
+	for i := 0; i < 3; i++ {
+		get(t, client, server.URL)
+	}
+
+	tags := func(connection string) map[string]string {
+		return map[string]string{
+			metrics.LabelPodName:           "pod",
+			metrics.LabelContainerName:     "queue-proxy",
+			metrics.LabelResponseCode:      "200",
+			metrics.LabelResponseCodeClass: "2xx",
+			metrics.LabelRouteTag:          disabledTagName,
+			"connection":                   connection,
+		}
+	}
+	metricstest.AssertMetricRequiredOnly(t,
+		metricstest.IntMetric("request_count", 1, tags(connectionNew)),
+		metricstest.IntMetric("request_count", 2, tags(connectionReused)))
+}
+
+// fakeSocketInfo returns the given retransmit totals, one per call.
+type fakeSocketInfo struct {
+	mux    sync.Mutex
+	totals []uint32
+	conns  []net.Conn
+}
+
+func (f *fakeSocketInfo) Retransmits(c net.Conn) (uint32, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.conns = append(f.conns, c)
+	if len(f.totals) == 0 {
+		return 0, false
+	}
+	total := f.totals[0]
+	f.totals = f.totals[1:]
+	return total, true
+}
+
+func TestTCPRetransmits(t *testing.T) {
+	defer reset()
+	socketInfo := &fakeSocketInfo{totals: []uint32{2, 2, 5}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithTCPRetransmits(socketInfo))
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	server, client := newConnTrackingServer(t, handler)
+
+	// The fourth request finds no statistics and isn't recorded.
+	for i := 0; i < 4; i++ {
+		get(t, client, server.URL)
+	}
+
+	metricstest.EnsureRecorded()
+	d := metricstest.GetOneMetric("tcp_retransmits").Values[0].Distribution
+	if got, want := d.Count, int64(3); got != want {
+		t.Errorf("tcp_retransmits count = %d, want: %d", got, want)
+	}
+	// Each request records the retransmits since the previous one.
+	if got, want := d.Sum, 5.; got != want {
+		t.Errorf("tcp_retransmits sum = %v, want: %v", got, want)
+	}
+	for _, c := range socketInfo.conns {
+		if _, ok := c.(*net.TCPConn); !ok {
+			t.Errorf("Socket statistics read from a %T, want the *net.TCPConn", c)
+		}
+	}
+}
+
+func TestEdgeLatency(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithEdgeLatency())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = EdgeTimingListener(server.Listener)
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal("Failed to dial:", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Both requests trickle in slowly, which happens before the handlers run.
+	const pause = 100 * time.Millisecond
+	for i := 0; i < 2; i++ {
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n")
+		time.Sleep(pause)
+		io.WriteString(conn, "\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal("Failed to read response:", err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		// Idle time between requests doesn't count.
+		time.Sleep(pause)
+	}
+
+	metricstest.EnsureRecorded()
+	edge := metricstest.GetOneMetric("edge_latency").Values[0].Distribution
+	latency := metricstest.GetOneMetric("request_latencies").Values[0].Distribution
+	if got, want := edge.Count, int64(2); got != want {
+		t.Fatalf("edge_latency count = %d, want: %d", got, want)
+	}
+	preHandler := float64((2 * pause).Milliseconds())
+	if got, want := edge.Sum-latency.Sum, preHandler; got < want {
+		t.Errorf("edge_latency exceeds request_latencies by %vms, want at least %vms", got, want)
+	}
+	if got, want := edge.Sum-latency.Sum, 2*preHandler; got >= want {
+		t.Errorf("edge_latency exceeds request_latencies by %vms, want less than %vms", got, want)
+	}
+}
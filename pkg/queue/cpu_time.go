@@ -0,0 +1,61 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"time"
+)
+
+// cpuTimer measures the CPU time the goroutine serving a request uses. The
+// goroutine is locked to its OS thread in the meantime, so that the thread's
+// CPU time is the goroutine's. CPU time used by other goroutines on behalf of
+// the request isn't measured.
+type cpuTimer struct {
+	start time.Duration
+	ok    bool
+}
+
+// startCPUTimer locks the calling goroutine to its OS thread and starts
+// measuring its CPU time, if the platform supports it. stop must be called
+// from the same goroutine.
+func startCPUTimer() cpuTimer {
+	if !cpuTimeSupported {
+		return cpuTimer{}
+	}
+	runtime.LockOSThread()
+	start, ok := threadCPUTime()
+	if !ok {
+		runtime.UnlockOSThread()
+	}
+	return cpuTimer{start: start, ok: ok}
+}
+
+// stop returns the CPU time used since the timer started and unlocks the
+// goroutine from its OS thread. It returns false if the CPU time couldn't be
+// measured.
+func (t cpuTimer) stop() (time.Duration, bool) {
+	if !t.ok {
+		return 0, false
+	}
+	defer runtime.UnlockOSThread()
+	end, ok := threadCPUTime()
+	if !ok || end < t.start {
+		return 0, false
+	}
+	return end - t.start, true
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "time"
+
+// cpuTimeSupported tells whether threadCPUTime can measure the CPU time,
+// which needs getrusage with RUSAGE_THREAD, a Linux only feature.
+const cpuTimeSupported = false
+
+// threadCPUTime never measures any CPU time.
+func threadCPUTime() (time.Duration, bool) {
+	return 0, false
+}
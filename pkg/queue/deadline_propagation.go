@@ -0,0 +1,103 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opencensus.io/stats"
+	pkgnet "knative.dev/pkg/network"
+)
+
+const (
+	// RequestTimeoutHeader carries the time left until the request's
+	// deadline, in milliseconds, to the user container.
+	RequestTimeoutHeader = "X-Request-Timeout-Ms"
+
+	// RequestDeadlineHeader carries the request's deadline to the user
+	// container as an RFC 3339 timestamp. A deadline the client passed in it,
+	// either as such a timestamp or in milliseconds since the epoch, is kept
+	// if it's earlier than the request's own.
+	RequestDeadlineHeader = "X-Request-Deadline"
+)
+
+// requestDeadlineFormat is RFC 3339 with millisecond precision.
+const requestDeadlineFormat = "2006-01-02T15:04:05.000Z07:00"
+
+const (
+	// Whether the deadline of a request reaching the user container was
+	// passed to it.
+	deadlinePropagated    = "propagated"
+	deadlineNotPropagated = "not_propagated"
+)
+
+var (
+	deadlinePropagatedCountM = stats.Int64(
+		"deadline_propagated_count",
+		"The number of requests whose remaining deadline was passed to the user-container",
+		stats.UnitDimensionless)
+	deadlineNotPropagatedCountM = stats.Int64(
+		"deadline_not_propagated_count",
+		"The number of requests passed to the user-container without a deadline",
+		stats.UnitDimensionless)
+)
+
+// DeadlinePropagationTransport wraps the transport to the user container to
+// pass the request's deadline, if any, in the RequestDeadlineHeader and the
+// time left until it in the RequestTimeoutHeader, and tells the request
+// metrics handler whether it did. The deadline is the earlier of the
+// request's context and the RequestDeadlineHeader sent by the client.
+// Requests whose deadline already passed aren't given the headers.
+func DeadlinePropagationTransport(next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		propagation := deadlineNotPropagated
+		deadline, ok := r.Context().Deadline()
+		if d, dok := parseRequestDeadline(r.Header.Get(RequestDeadlineHeader)); dok && (!ok || d.Before(deadline)) {
+			deadline, ok = d, true
+		}
+		if ok {
+			if remaining := time.Until(deadline); remaining > 0 {
+				// RoundTrippers must not modify the passed request.
+				r = r.Clone(r.Context())
+				r.Header.Set(RequestDeadlineHeader, deadline.UTC().Format(requestDeadlineFormat))
+				r.Header.Set(RequestTimeoutHeader, strconv.FormatInt(remaining.Milliseconds(), 10))
+				propagation = deadlinePropagated
+			}
+		}
+		if state := requestMetricsStateFrom(r.Context()); state != nil {
+			state.setDeadlinePropagation(propagation)
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+// parseRequestDeadline parses the value of a RequestDeadlineHeader, either an
+// RFC 3339 timestamp or milliseconds since the epoch.
+func parseRequestDeadline(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"knative.dev/pkg/metrics/metricstest"
+	pkgnet "knative.dev/pkg/network"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestDeadlinePropagationTransport(t *testing.T) {
+	defer reset()
+
+	headers := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get(RequestTimeoutHeader)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal("Failed to parse upstream URL:", err)
+	}
+
+	transport := DeadlinePropagationTransport(http.DefaultTransport)
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		out.URL, out.RequestURI = upstreamURL, ""
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+	})
+	h, err := NewRequestMetricsHandler(app, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithDeadlinePropagation())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	wantTags := map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}
+
+	// A request without a deadline isn't given one.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got := <-headers; got != "" {
+		t.Errorf("%s = %q, want none", RequestTimeoutHeader, got)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("deadline_not_propagated_count", 1, wantTags))
+	metricstest.AssertNoMetric(t, "deadline_propagated_count")
+
+	// A request with a deadline passes on the time left.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil).WithContext(ctx))
+	got, err := strconv.ParseInt(<-headers, 10, 64)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", RequestTimeoutHeader, err)
+	}
+	if max := time.Minute.Milliseconds(); got <= 0 || got > max {
+		t.Errorf("%s = %d, want in (0, %d]", RequestTimeoutHeader, got, max)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("deadline_propagated_count", 1, wantTags))
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("deadline_not_propagated_count", 1, wantTags))
+}
+
+func TestDeadlinePropagationTransportRequestDeadline(t *testing.T) {
+	var got *http.Request
+	transport := DeadlinePropagationTransport(pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	now := time.Now().Truncate(time.Millisecond)
+	soon, later := now.Add(time.Minute), now.Add(time.Hour)
+	tests := []struct {
+		name     string
+		deadline time.Time
+		header   string
+		want     string
+	}{{
+		name: "no deadline",
+	}, {
+		name:     "request deadline",
+		deadline: soon,
+		want:     soon.UTC().Format(requestDeadlineFormat),
+	}, {
+		name:     "earlier header",
+		deadline: later,
+		header:   soon.Format(time.RFC3339Nano),
+		want:     soon.UTC().Format(requestDeadlineFormat),
+	}, {
+		name:     "later header",
+		deadline: soon,
+		header:   strconv.FormatInt(later.UnixNano()/int64(time.Millisecond), 10),
+		want:     soon.UTC().Format(requestDeadlineFormat),
+	}, {
+		name:   "header in milliseconds only",
+		header: strconv.FormatInt(soon.UnixNano()/int64(time.Millisecond), 10),
+		want:   soon.UTC().Format(requestDeadlineFormat),
+	}, {
+		name:     "invalid header",
+		deadline: soon,
+		header:   "tomorrow",
+		want:     soon.UTC().Format(requestDeadlineFormat),
+	}, {
+		name:   "invalid header only",
+		header: "tomorrow",
+		want:   "tomorrow",
+	}, {
+		name:   "expired header",
+		header: now.Add(-time.Minute).Format(time.RFC3339),
+		want:   now.Add(-time.Minute).Format(time.RFC3339),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if !test.deadline.IsZero() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, test.deadline)
+				defer cancel()
+			}
+			r := httptest.NewRequest(http.MethodGet, targetURI, nil).WithContext(ctx)
+			if test.header != "" {
+				r.Header.Set(RequestDeadlineHeader, test.header)
+			}
+
+			if _, err := transport.RoundTrip(r); err != nil {
+				t.Fatal("RoundTrip() =", err)
+			}
+			if got := got.Header.Get(RequestDeadlineHeader); got != test.want {
+				t.Errorf("%s = %q, want: %q", RequestDeadlineHeader, got, test.want)
+			}
+			if got, want := r.Header.Get(RequestDeadlineHeader), test.header; got != want {
+				t.Errorf("Passed request's %s = %q, want it unchanged: %q", RequestDeadlineHeader, got, want)
+			}
+		})
+	}
+}
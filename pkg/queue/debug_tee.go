@@ -0,0 +1,250 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"knative.dev/pkg/websocket"
+)
+
+const (
+	// DebugCapturesPath is the path of the admin endpoint serving the
+	// captures of the DebugTeeHandler.
+	DebugCapturesPath = "/debug/captures"
+
+	redactedValue = "REDACTED"
+)
+
+// DebugCapture is a copy of a request and its response taken by the
+// DebugTeeHandler. Bodies are cut off after the sink's body size limit.
+type DebugCapture struct {
+	Time              time.Time   `json:"time"`
+	Method            string      `json:"method"`
+	URL               string      `json:"url"`
+	RequestHeader     http.Header `json:"requestHeader"`
+	RequestBody       string      `json:"requestBody"`
+	RequestTruncated  bool        `json:"requestTruncated,omitempty"`
+	ResponseCode      int         `json:"responseCode"`
+	ResponseHeader    http.Header `json:"responseHeader"`
+	ResponseBody      string      `json:"responseBody"`
+	ResponseTruncated bool        `json:"responseTruncated,omitempty"`
+}
+
+// DebugSink keeps the most recent captures of the DebugTeeHandler in memory.
+// It holds at most maxCaptures captures with bodies of at most maxBodyBytes
+// each, which bounds its memory usage.
+type DebugSink struct {
+	maxCaptures  int
+	maxBodyBytes int
+	redact       []string
+
+	mux      sync.Mutex
+	captures []*DebugCapture
+	next     int
+}
+
+// NewDebugSink creates a DebugSink keeping maxCaptures captures with bodies
+// of at most maxBodyBytes and the values of the redact headers removed.
+func NewDebugSink(maxCaptures, maxBodyBytes int, redact []string) *DebugSink {
+	canonical := make([]string, len(redact))
+	for i, h := range redact {
+		canonical[i] = http.CanonicalHeaderKey(h)
+	}
+	return &DebugSink{
+		maxCaptures:  maxCaptures,
+		maxBodyBytes: maxBodyBytes,
+		redact:       canonical,
+		captures:     make([]*DebugCapture, 0, maxCaptures),
+	}
+}
+
+// add stores a capture, evicting the oldest one if the sink is full.
+func (s *DebugSink) add(c *DebugCapture) {
+	if s.maxCaptures <= 0 {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.captures) < s.maxCaptures {
+		s.captures = append(s.captures, c)
+		return
+	}
+	s.captures[s.next] = c
+	s.next = (s.next + 1) % s.maxCaptures
+}
+
+// Captures returns the stored captures, oldest first.
+func (s *DebugSink) Captures() []*DebugCapture {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ret := make([]*DebugCapture, 0, len(s.captures))
+	ret = append(ret, s.captures[s.next:]...)
+	return append(ret, s.captures[:s.next]...)
+}
+
+// ServeHTTP serves the stored captures as JSON.
+func (s *DebugSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Captures())
+}
+
+// redacted returns a copy of h with the values of the redacted headers
+// replaced.
+func (s *DebugSink) redacted(h http.Header) http.Header {
+	c := h.Clone()
+	for _, k := range s.redact {
+		if _, ok := c[k]; ok {
+			c[k] = []string{redactedValue}
+		}
+	}
+	return c
+}
+
+// DebugTeeHandler copies the request and response bodies of the given fraction
+// of requests to the sink. The request and response are passed through
+// unchanged.
+func DebugTeeHandler(sink *DebugSink, sampleRate float64, next http.Handler) http.Handler {
+	return debugTeeHandler(sink, sampleRate, rand.Float64, next)
+}
+
+func debugTeeHandler(sink *DebugSink, sampleRate float64, random func() float64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if random() >= sampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c := &DebugCapture{
+			Time:          time.Now(),
+			Method:        r.Method,
+			URL:           r.URL.String(),
+			RequestHeader: sink.redacted(r.Header),
+		}
+		reqBody := &boundedBuffer{max: sink.maxBodyBytes}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &teeReadCloser{ReadCloser: r.Body, w: reqBody}
+		}
+		tw := &teeResponseWriter{
+			ResponseWriter: w,
+			code:           http.StatusOK,
+		}
+		tw.body.max = sink.maxBodyBytes
+
+		defer func() {
+			c.RequestBody, c.RequestTruncated = reqBody.contents()
+			c.ResponseCode = tw.code
+			c.ResponseHeader = sink.redacted(w.Header())
+			c.ResponseBody, c.ResponseTruncated = tw.body.contents()
+			sink.add(c)
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// boundedBuffer keeps up to max bytes written to it and drops the rest. The
+// transport might still read the request body when the handler returns, hence
+// the locking.
+type boundedBuffer struct {
+	max int
+
+	mux       sync.Mutex
+	buf       []byte
+	truncated bool
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if room := b.max - len(b.buf); len(p) > room {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// contents returns what was kept and whether anything was dropped.
+func (b *boundedBuffer) contents() (string, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return string(b.buf), b.truncated
+}
+
+// teeReadCloser copies what's read from the body to w.
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.w.Write(p[:n])
+	}
+	return n, err
+}
+
+var (
+	_ http.Flusher  = (*teeResponseWriter)(nil)
+	_ http.Hijacker = (*teeResponseWriter)(nil)
+)
+
+// teeResponseWriter copies the response status and body.
+type teeResponseWriter struct {
+	http.ResponseWriter
+
+	code        int
+	wroteHeader bool
+	body        boundedBuffer
+}
+
+func (w *teeResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *teeResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+// Flush flushes the buffer to the client.
+func (w *teeResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack calls Hijack() on the wrapped http.ResponseWriter if it implements
+// http.Hijacker interface.
+func (w *teeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return websocket.HijackIfPossible(w.ResponseWriter)
+}
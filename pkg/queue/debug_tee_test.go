@@ -0,0 +1,144 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// echoHandler responds with the request body and a secret header.
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Set-Cookie", "secret")
+	w.Header().Set("X-Echo", "yes")
+	w.WriteHeader(http.StatusCreated)
+	io.Copy(w, r.Body)
+})
+
+func TestDebugTeeHandler(t *testing.T) {
+	sink := NewDebugSink(10, 1024, []string{"authorization", "set-cookie"})
+	handler := debugTeeHandler(sink, 0.5, func() float64 { return 0.1 }, echoHandler)
+
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/echo?q=1", strings.NewReader("hello"))
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-Request", "yes")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The live response is the same as without the tee.
+	got, want := serve(handler), serve(echoHandler)
+	if got.Code != want.Code {
+		t.Errorf("Code = %d, want: %d", got.Code, want.Code)
+	}
+	if !cmp.Equal(got.Header(), want.Header()) {
+		t.Error("Headers differ (-want, +got):", cmp.Diff(want.Header(), got.Header()))
+	}
+	if !bytes.Equal(got.Body.Bytes(), want.Body.Bytes()) {
+		t.Errorf("Body = %q, want: %q", got.Body.Bytes(), want.Body.Bytes())
+	}
+
+	captures := sink.Captures()
+	if len(captures) != 1 {
+		t.Fatalf("Got %d captures, want 1", len(captures))
+	}
+	c := captures[0]
+	c.Time = c.Time.UTC()
+	if c.Method != http.MethodPost || c.URL != "http://example.com/echo?q=1" {
+		t.Errorf("Captured %s %s, want POST http://example.com/echo?q=1", c.Method, c.URL)
+	}
+	if c.RequestBody != "hello" || c.ResponseBody != "hello" {
+		t.Errorf("Captured bodies %q and %q, want: %q", c.RequestBody, c.ResponseBody, "hello")
+	}
+	if c.ResponseCode != http.StatusCreated {
+		t.Errorf("Captured code = %d, want: %d", c.ResponseCode, http.StatusCreated)
+	}
+	if got, want := c.RequestHeader.Get("Authorization"), redactedValue; got != want {
+		t.Errorf("Captured Authorization = %q, want: %q", got, want)
+	}
+	if got, want := c.RequestHeader.Get("X-Request"), "yes"; got != want {
+		t.Errorf("Captured X-Request = %q, want: %q", got, want)
+	}
+	if got, want := c.ResponseHeader.Get("Set-Cookie"), redactedValue; got != want {
+		t.Errorf("Captured Set-Cookie = %q, want: %q", got, want)
+	}
+	if got, want := c.ResponseHeader.Get("X-Echo"), "yes"; got != want {
+		t.Errorf("Captured X-Echo = %q, want: %q", got, want)
+	}
+}
+
+func TestDebugTeeHandlerNotSampled(t *testing.T) {
+	sink := NewDebugSink(10, 1024, nil)
+	handler := debugTeeHandler(sink, 0.5, func() float64 { return 0.5 }, echoHandler)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("hello")))
+	if got, want := rec.Body.String(), "hello"; got != want {
+		t.Errorf("Body = %q, want: %q", got, want)
+	}
+	if got := len(sink.Captures()); got != 0 {
+		t.Errorf("Got %d captures, want 0", got)
+	}
+}
+
+func TestDebugSinkBounds(t *testing.T) {
+	sink := NewDebugSink(2, 4, nil)
+	handler := DebugTeeHandler(sink, 1, echoHandler)
+
+	for _, body := range []string{"first", "second", "third"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(body)))
+		// The live response is never cut off.
+		if got := rec.Body.String(); got != body {
+			t.Errorf("Body = %q, want: %q", got, body)
+		}
+	}
+
+	// Only the latest captures are kept, with their bodies cut off.
+	var got []string
+	for _, c := range sink.Captures() {
+		if !c.RequestTruncated || !c.ResponseTruncated {
+			t.Errorf("Capture of %q not marked as truncated", c.RequestBody)
+		}
+		got = append(got, c.RequestBody, c.ResponseBody)
+	}
+	if want := []string{"seco", "seco", "thir", "thir"}; !cmp.Equal(got, want) {
+		t.Errorf("Captured bodies = %q, want: %q", got, want)
+	}
+
+	// The captures are served as JSON.
+	rec := httptest.NewRecorder()
+	sink.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DebugCapturesPath, nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	var served []DebugCapture
+	if err := json.Unmarshal(body, &served); err != nil {
+		t.Fatal("Failed to decode captures:", err)
+	}
+	if got, want := len(served), 2; got != want {
+		t.Errorf("Served %d captures, want %d", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "context"
+
+// dropReasonDependencyUnhealthy is the dropped_request_count reason for
+// requests rejected because a dependency of the revision is down.
+const dropReasonDependencyUnhealthy = "dependency_unhealthy"
+
+// DependencyHealthProvider reports the health of a dependency of the
+// revision, e.g. its database, to the breaker.
+type DependencyHealthProvider interface {
+	// Healthy returns whether the dependency is up. An error makes the
+	// breaker admit requests as if it was.
+	Healthy(ctx context.Context) (bool, error)
+}
+
+// DependencyHealthFunc adapts a function to a DependencyHealthProvider.
+type DependencyHealthFunc func(ctx context.Context) (bool, error)
+
+// Healthy calls f(ctx).
+func (f DependencyHealthFunc) Healthy(ctx context.Context) (bool, error) {
+	return f(ctx)
+}
+
+// dependencyHealthy returns whether the breaker's dependency, if any, is
+// healthy. If its health can't be determined, it's assumed to be.
+func (b *Breaker) dependencyHealthy(ctx context.Context) bool {
+	if b.dependency == nil {
+		return true
+	}
+	ok, err := b.dependency.Healthy(ctx)
+	return ok || err != nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	network "knative.dev/networking/pkg"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestBreakerDependencyHealth(t *testing.T) {
+	tests := []struct {
+		name   string
+		health DependencyHealthFunc
+		want   error
+	}{{
+		name: "healthy",
+		health: func(context.Context) (bool, error) {
+			return true, nil
+		},
+	}, {
+		name: "unhealthy",
+		health: func(context.Context) (bool, error) {
+			return false, nil
+		},
+		want: ErrDependencyUnhealthy,
+	}, {
+		name: "provider error",
+		health: func(context.Context) (bool, error) {
+			return false, errors.New("health unknown")
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+				DependencyHealth: test.health})
+
+			ran := false
+			if err := b.Maybe(context.Background(), func() { ran = true }); !errors.Is(err, test.want) {
+				t.Errorf("Maybe() = %v, want: %v", err, test.want)
+			}
+			if want := test.want == nil; ran != want {
+				t.Errorf("Thunk ran = %v, want: %v", ran, want)
+			}
+			if got := b.InFlight(); got != 0 {
+				t.Errorf("InFlight() = %d, want: 0", got)
+			}
+		})
+	}
+}
+
+func TestHandlerDependencyUnhealthy(t *testing.T) {
+	defer reset()
+	healthy := true
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		DependencyHealth: DependencyHealthFunc(func(context.Context) (bool, error) {
+			return healthy, nil
+		})})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("Code while healthy = %d, want: %d", got, want)
+	}
+	metricstest.AssertNoMetric(t, "dropped_request_count")
+
+	healthy = false
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("Code while unhealthy = %d, want: %d", got, want)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      disabledTagName,
+		"reason":                   dropReasonDependencyUnhealthy,
+	}))
+}
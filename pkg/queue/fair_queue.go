@@ -0,0 +1,105 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "context"
+
+// QueueMode selects the order in which a breaker admits queued requests.
+type QueueMode string
+
+const (
+	// QueueModeFIFO admits queued requests in order of arrival. This is the
+	// default.
+	QueueModeFIFO QueueMode = "fifo"
+
+	// QueueModeWeightedFair shares the capacity among the tenants with queued
+	// requests in proportion to their weights, see WithRequestTenant, so that
+	// a tenant sending many requests can't starve the others. Requests of the
+	// same tenant are admitted in order of arrival.
+	QueueModeWeightedFair QueueMode = "weighted-fair"
+)
+
+type requestTenantKey struct{}
+
+// WithRequestTenant returns a context marking the request as sent by the
+// given tenant, for breakers in QueueModeWeightedFair. Requests without a
+// tenant share the default tenant "".
+func WithRequestTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, requestTenantKey{}, tenant)
+}
+
+// requestTenant returns the tenant attached to ctx by WithRequestTenant or "".
+func requestTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(requestTenantKey{}).(string)
+	return tenant
+}
+
+// fairShare tags queued requests for self-clocked weighted fair queueing.
+// Every request is tagged with the virtual time at which its tenant would be
+// done with it if every tenant was served in proportion to its weight, and
+// the request with the lowest tag is admitted first. The virtual time is the
+// tag of the last admitted request, so a tenant returning from idle starts
+// from the current virtual time instead of claiming the share it didn't use.
+type fairShare struct {
+	weights map[string]int
+	vtime   float64
+
+	// finish is the tag of the last queued request of every tenant.
+	finish map[string]float64
+}
+
+func newFairShare(weights map[string]int) *fairShare {
+	return &fairShare{
+		weights: weights,
+		finish:  make(map[string]float64),
+	}
+}
+
+// weight returns the weight of the given tenant, 1 unless configured.
+func (f *fairShare) weight(tenant string) int {
+	if w, ok := f.weights[tenant]; ok {
+		return w
+	}
+	return 1
+}
+
+// tag returns the tag of a request of the given tenant and cost queued now.
+func (f *fairShare) tag(tenant string, cost int) float64 {
+	start := f.vtime
+	if finish := f.finish[tenant]; finish > start {
+		start = finish
+	}
+	finish := start + float64(cost)/float64(f.weight(tenant))
+	f.finish[tenant] = finish
+	return finish
+}
+
+// admit advances the virtual time to the tag of an admitted request.
+func (f *fairShare) admit(tag float64) {
+	if tag > f.vtime {
+		f.vtime = tag
+	}
+}
+
+// idle restarts the virtual time once no request is queued anymore, which
+// makes it forget the tenants no longer sending requests.
+func (f *fairShare) idle() {
+	f.vtime = 0
+	for tenant := range f.finish {
+		delete(f.finish, tenant)
+	}
+}
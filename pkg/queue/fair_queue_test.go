@@ -0,0 +1,174 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/atomic"
+)
+
+func TestCostDeadlineSchedulerFairShare(t *testing.T) {
+	s := newCostDeadlineScheduler(1, 1)
+	s.fair = newFairShare(map[string]int{"gold": 2})
+	s.enqueue(1, time.Time{}, "hold")
+
+	// The big tenant queues first, the others join later.
+	var order []string
+	names := map[*schedulerWaiter]string{}
+	queue := func(tenant string, n int) {
+		for i := 1; i <= n; i++ {
+			names[s.enqueue(1, time.Time{}, tenant)] = fmt.Sprint(tenant, i)
+		}
+	}
+	queue("big", 4)
+	queue("", 2)
+	queue("gold", 2)
+
+	for len(names) > 0 {
+		s.release(1)
+		for w, name := range names {
+			if w.admitted {
+				order = append(order, name)
+				delete(names, w)
+			}
+		}
+	}
+	// The tenants are served in proportion to their weights rather than in
+	// order of arrival.
+	want := []string{"gold1", "big1", "1", "gold2", "big2", "2", "big3", "big4"}
+	if !cmp.Equal(order, want) {
+		t.Errorf("Admission order = %v, want: %v", order, want)
+	}
+
+	// Once the queue is empty, the tenants start over.
+	if got := len(s.fair.finish); got != 0 {
+		t.Errorf("len(finish) = %d, want: 0", got)
+	}
+}
+
+func TestCostDeadlineSchedulerFairShareCancel(t *testing.T) {
+	s := newCostDeadlineScheduler(1, 0)
+	s.fair = newFairShare(nil)
+
+	ctx, cancel := context.WithCancel(WithRequestTenant(context.Background(), "a"))
+	cancel()
+	if err := s.acquireUntil(ctx, 1, time.Time{}, nil); err != context.Canceled {
+		t.Fatalf("acquireUntil() = %v, want: %v", err, context.Canceled)
+	}
+
+	// The abandoned request doesn't count against its tenant once the queue
+	// is empty.
+	if got := len(s.fair.finish); got != 0 {
+		t.Errorf("len(finish) = %d, want: 0", got)
+	}
+}
+
+func TestBreakerWeightedFairQueue(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 0,
+		QueueMode: QueueModeWeightedFair})
+
+	order := make(chan string, 4)
+	request := func(tenant string, i int) {
+		ctx := context.Background()
+		if tenant != "" {
+			ctx = WithRequestTenant(ctx, tenant)
+		}
+		if err := b.Maybe(ctx, func() {
+			order <- fmt.Sprint(tenant, i)
+		}); err != nil {
+			t.Errorf("Maybe(%s%d) = %v", tenant, i, err)
+		}
+	}
+
+	// Queue the requests one by one to make their order deterministic.
+	for i, r := range []struct {
+		tenant string
+		i      int
+	}{{"big", 1}, {"big", 2}, {"big", 3}, {"", 1}} {
+		go request(r.tenant, r.i)
+		for b.InFlight() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	b.UpdateConcurrency(1)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, <-order)
+	}
+	// Requests without a tenant get a share of their own.
+	if want := []string{"big1", "1", "big2", "big3"}; !cmp.Equal(got, want) {
+		t.Errorf("Admission order = %v, want: %v", got, want)
+	}
+}
+
+func TestBreakerQueueModeFIFO(t *testing.T) {
+	for _, mode := range []QueueMode{"", QueueModeFIFO} {
+		if b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+			QueueMode: mode}); b.sched != nil {
+			t.Errorf("Breaker in queue mode %q schedules requests", mode)
+		}
+	}
+}
+
+// BenchmarkBreakerFairness compares the share of the capacity a tenant gets
+// while another tenant with nine times as many clients competes for it. Both
+// tenants keep requests queued, so a fair breaker serves them equally.
+func BenchmarkBreakerFairness(b *testing.B) {
+	for _, mode := range []QueueMode{QueueModeFIFO, QueueModeWeightedFair} {
+		b.Run(string(mode), func(b *testing.B) {
+			breaker := NewBreaker(BreakerParams{QueueDepth: 100, MaxConcurrency: 2, InitialCapacity: 2,
+				QueueMode: mode})
+			remaining := atomic.NewInt64(int64(b.N))
+			var small atomic.Int64
+
+			var wg sync.WaitGroup
+			client := func(tenant string) {
+				defer wg.Done()
+				ctx := WithRequestTenant(context.Background(), tenant)
+				for remaining.Dec() >= 0 {
+					breaker.Maybe(ctx, func() {
+						if tenant == "small" {
+							small.Inc()
+						}
+						runtime.Gosched()
+					})
+					// Give the queued requests a chance to take the slot.
+					runtime.Gosched()
+				}
+			}
+
+			b.ResetTimer()
+			wg.Add(30)
+			for i := 0; i < 3; i++ {
+				go client("small")
+			}
+			for i := 0; i < 27; i++ {
+				go client("big")
+			}
+			wg.Wait()
+			b.ReportMetric(float64(small.Load())/float64(b.N), "small-share")
+		})
+	}
+}
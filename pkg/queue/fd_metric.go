@@ -0,0 +1,113 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+// procSelfFD lists the open file descriptors of the current process on Linux.
+const procSelfFD = "/proc/self/fd"
+
+var openFileDescriptorsM = stats.Int64(
+	"open_file_descriptors",
+	"The number of file descriptors opened by queue-proxy",
+	stats.UnitDimensionless)
+
+// FileDescriptorReporter records the number of file descriptors opened by the
+// process.
+type FileDescriptorReporter struct {
+	statsCtx context.Context
+	fdDir    string
+}
+
+// NewFileDescriptorReporter creates a FileDescriptorReporter recording
+// the open_file_descriptors metric for the given revision.
+func NewFileDescriptorReporter(ns, service, config, rev, pod string) (*FileDescriptorReporter, error) {
+	return newFileDescriptorReporter(ns, service, config, rev, pod, procSelfFD)
+}
+
+func newFileDescriptorReporter(ns, service, config, rev, pod, fdDir string) (*FileDescriptorReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The number of file descriptors opened by queue-proxy",
+		Measure:     openFileDescriptorsM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDescriptorReporter{
+		statsCtx: ctx,
+		fdDir:    fdDir,
+	}, nil
+}
+
+// Run records the number of open file descriptors every period until ctx is
+// done. It returns right away if the number can't be determined, e.g. on
+// platforms other than Linux.
+func (r *FileDescriptorReporter) Run(ctx context.Context, period time.Duration) {
+	if err := r.report(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the current number of open file descriptors.
+func (r *FileDescriptorReporter) report() error {
+	n, err := countDirEntries(r.fdDir)
+	if err != nil {
+		return err
+	}
+	pkgmetrics.Record(r.statsCtx, openFileDescriptorsM.M(int64(n)))
+	return nil
+}
+
+// countDirEntries returns the number of entries in the given directory. For
+// /proc/self/fd this includes the descriptor used to list the directory.
+func countDirEntries(dir string) (int, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	return len(names), err
+}
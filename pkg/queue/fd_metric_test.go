@@ -0,0 +1,105 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.opencensus.io/resource"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestFileDescriptorReporter(t *testing.T) {
+	defer metricstest.Unregister(openFileDescriptorsM.Name())
+
+	// Fake /proc/self/fd with three open descriptors.
+	dir, err := ioutil.TempDir("", "fd")
+	if err != nil {
+		t.Fatal("Failed to create fixture:", err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), nil, 0644); err != nil {
+			t.Fatal("Failed to create fixture:", err)
+		}
+	}
+
+	r, err := newFileDescriptorReporter("ns", "svc", "cfg", "rev", "pod", dir)
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx, time.Millisecond)
+	}()
+
+	wantResource := &resource.Resource{
+		Type: "knative_revision",
+		Labels: map[string]string{
+			metrics.LabelNamespaceName:     "ns",
+			metrics.LabelRevisionName:      "rev",
+			metrics.LabelServiceName:       "svc",
+			metrics.LabelConfigurationName: "cfg",
+		},
+	}
+	assertOpen := func(n int64) {
+		t.Helper()
+		// Wait for the reporter to pick up the change.
+		for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(time.Millisecond) {
+			metricstest.EnsureRecorded()
+			if m := metricstest.GetMetric("open_file_descriptors"); len(m) == 1 && *m[0].Values[0].Int64 == n {
+				break
+			}
+		}
+		metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("open_file_descriptors", n, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}).WithResource(wantResource))
+	}
+	assertOpen(3)
+
+	// The gauge follows the number of descriptors.
+	if err := ioutil.WriteFile(filepath.Join(dir, "3"), nil, 0644); err != nil {
+		t.Fatal("Failed to update fixture:", err)
+	}
+	assertOpen(4)
+
+	cancel()
+	<-done
+}
+
+func TestFileDescriptorReporterUnsupported(t *testing.T) {
+	defer metricstest.Unregister(openFileDescriptorsM.Name())
+
+	r, err := newFileDescriptorReporter("ns", "svc", "cfg", "rev", "pod", filepath.Join(os.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	// Run returns right away if descriptors can't be counted.
+	r.Run(context.Background(), time.Millisecond)
+	metricstest.AssertNoMetric(t, "open_file_descriptors")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"knative.dev/pkg/websocket"
+)
+
+var (
+	_ http.Flusher  = (*flushTimer)(nil)
+	_ http.Hijacker = (*flushTimer)(nil)
+)
+
+// flushTimer accumulates the time spent in Write and Flush calls, which block
+// when the client doesn't consume the response fast enough.
+type flushTimer struct {
+	http.ResponseWriter
+
+	elapsed time.Duration
+}
+
+func (w *flushTimer) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.ResponseWriter.Write(p)
+	w.elapsed += time.Since(start)
+	return n, err
+}
+
+// Flush flushes the buffer to the client.
+func (w *flushTimer) Flush() {
+	start := time.Now()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	w.elapsed += time.Since(start)
+}
+
+// Hijack calls Hijack() on the wrapped http.ResponseWriter if it implements
+// http.Hijacker interface.
+func (w *flushTimer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return websocket.HijackIfPossible(w.ResponseWriter)
+}
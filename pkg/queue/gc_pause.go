@@ -0,0 +1,108 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var gcPauseSecondsM = stats.Float64(
+	"gc_pause_seconds",
+	"The duration of the stop-the-world garbage collection pauses of queue-proxy",
+	"s")
+
+// GCPauseReporter records the garbage collection pauses of the process, so
+// latency spikes caused by the queue-proxy itself can be told apart from a
+// slow application.
+type GCPauseReporter struct {
+	statsCtx     context.Context
+	readMemStats func(*runtime.MemStats)
+
+	// numGC is the number of garbage collections already reported.
+	numGC uint32
+}
+
+// NewGCPauseReporter creates a GCPauseReporter recording the gc_pause_seconds
+// metric for the given revision.
+func NewGCPauseReporter(ns, service, config, rev, pod string) (*GCPauseReporter, error) {
+	return newGCPauseReporter(ns, service, config, rev, pod, runtime.ReadMemStats)
+}
+
+func newGCPauseReporter(ns, service, config, rev, pod string, readMemStats func(*runtime.MemStats)) (*GCPauseReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The duration of the stop-the-world garbage collection pauses of queue-proxy",
+		Measure:     gcPauseSecondsM,
+		Aggregation: view.Distribution(0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &GCPauseReporter{
+		statsCtx:     ctx,
+		readMemStats: readMemStats,
+	}, nil
+}
+
+// Run records the pauses of the garbage collections completed in every period
+// until ctx is done. Reading the memory statistics briefly stops the world, so
+// period shouldn't be too short.
+func (r *GCPauseReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the pauses of the garbage collections completed since the
+// previous report. The runtime only keeps the most recent pauses, so older
+// ones are lost if more collections than that happened in between.
+func (r *GCPauseReporter) report() {
+	var ms runtime.MemStats
+	r.readMemStats(&ms)
+
+	n := ms.NumGC - r.numGC
+	if max := uint32(len(ms.PauseNs)); n > max {
+		n = max
+	}
+	for i := ms.NumGC - n; i < ms.NumGC; i++ {
+		// The pause of the i-th collection, counting from 0, is kept at
+		// PauseNs[i%256].
+		pause := time.Duration(ms.PauseNs[i%uint32(len(ms.PauseNs))])
+		pkgmetrics.Record(r.statsCtx, gcPauseSecondsM.M(pause.Seconds()))
+	}
+	r.numGC = ms.NumGC
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"knative.dev/pkg/metrics/metricstest"
+)
+
+func TestGCPauseReporter(t *testing.T) {
+	defer metricstest.Unregister(gcPauseSecondsM.Name())
+
+	// Fake memory statistics the test completes collections in.
+	var ms runtime.MemStats
+	gc := func(pauses ...time.Duration) {
+		for _, p := range pauses {
+			ms.PauseNs[ms.NumGC%uint32(len(ms.PauseNs))] = uint64(p)
+			ms.NumGC++
+		}
+	}
+	r, err := newGCPauseReporter("ns", "svc", "cfg", "rev", "pod", func(m *runtime.MemStats) {
+		*m = ms
+	})
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	assertPauses := func(count int64, sum time.Duration) {
+		t.Helper()
+		r.report()
+		metricstest.EnsureRecorded()
+		if count == 0 {
+			metricstest.AssertNoMetric(t, "gc_pause_seconds")
+			return
+		}
+		d := metricstest.GetOneMetric("gc_pause_seconds").Values[0].Distribution
+		if got := d.Count; got != count {
+			t.Errorf("gc_pause_seconds count = %d, want: %d", got, count)
+		}
+		if got, want := time.Duration(d.Sum*float64(time.Second)).Round(time.Microsecond), sum; got != want {
+			t.Errorf("gc_pause_seconds sum = %v, want: %v", got, want)
+		}
+	}
+
+	// No collections, no pauses.
+	assertPauses(0, 0)
+
+	// Every collection is reported once.
+	gc(time.Millisecond, 2*time.Millisecond)
+	assertPauses(2, 3*time.Millisecond)
+	assertPauses(2, 3*time.Millisecond)
+	gc(5 * time.Millisecond)
+	assertPauses(3, 8*time.Millisecond)
+
+	// Only the pauses the runtime still keeps are reported after many
+	// collections.
+	for i := 0; i < 300; i++ {
+		gc(10 * time.Microsecond)
+	}
+	assertPauses(3+256, 8*time.Millisecond+256*10*time.Microsecond)
+}
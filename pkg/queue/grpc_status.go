@@ -0,0 +1,77 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Values of the protocol tag.
+	protocolHTTP = "http"
+	protocolGRPC = "grpc"
+
+	// grpcStatusTrailer carries the status code of a gRPC response.
+	grpcStatusTrailer = "Grpc-Status"
+)
+
+// grpcHTTPStatus maps the gRPC status codes to the HTTP status codes
+// conventionally used for them, e.g. by grpc-gateway, to classify them.
+var grpcHTTPStatus = [...]int{
+	http.StatusOK,                  // OK
+	499,                            // Canceled
+	http.StatusInternalServerError, // Unknown
+	http.StatusBadRequest,          // InvalidArgument
+	http.StatusGatewayTimeout,      // DeadlineExceeded
+	http.StatusNotFound,            // NotFound
+	http.StatusConflict,            // AlreadyExists
+	http.StatusForbidden,           // PermissionDenied
+	http.StatusTooManyRequests,     // ResourceExhausted
+	http.StatusBadRequest,          // FailedPrecondition
+	http.StatusConflict,            // Aborted
+	http.StatusBadRequest,          // OutOfRange
+	http.StatusNotImplemented,      // Unimplemented
+	http.StatusInternalServerError, // Internal
+	http.StatusServiceUnavailable,  // Unavailable
+	http.StatusInternalServerError, // DataLoss
+	http.StatusUnauthorized,        // Unauthenticated
+}
+
+// isGRPC returns whether the request is a gRPC request, as told by its
+// content type application/grpc, optionally followed by the message format.
+func isGRPC(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+"))
+}
+
+// grpcStatus returns the gRPC status code of the response whose header map
+// the handlers wrote to, along with the HTTP status code it corresponds to, if
+// it has a valid one.
+func grpcStatus(header http.Header) (int, int, bool) {
+	v, ok := trailer(header, grpcStatusTrailer)
+	if !ok {
+		return 0, 0, false
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil || code < 0 || code >= len(grpcHTTPStatus) {
+		return 0, 0, false
+	}
+	return code, grpcHTTPStatus[code], true
+}
@@ -27,9 +27,112 @@ import (
 	"knative.dev/serving/pkg/activator"
 )
 
+const (
+	// Causes for a request leaving the breaker's queue before being admitted.
+	cancellationCauseClient       = "client_cancelled"
+	cancellationCauseDeadline     = "deadline"
+	cancellationCauseDrain        = "drain"
+	cancellationCauseQueueTimeout = "queue_timeout"
+
+	// dropReasonNoDeadline is the dropped_request_count reason for requests
+	// rejected by the breaker for lacking a deadline under load.
+	dropReasonNoDeadline = "no_deadline_under_load"
+
+	// dropReasonSlotTimeout is the dropped_request_count reason for requests
+	// cancelled for holding their slot beyond the breaker's maximum slot time.
+	dropReasonSlotTimeout = "slot_timeout"
+
+	// statusClientClosedRequest is the non-standard status code proxies log
+	// for requests the client cancelled before they were served.
+	statusClientClosedRequest = 499
+)
+
+// ProxyOption configures optional behavior of the ProxyHandler.
+type ProxyOption func(*proxyOptions)
+
+type proxyOptions struct {
+	serverTiming bool
+	queueWait    bool
+	slowUpstream *slowUpstreamDetector
+	tagBreakers  *TagBreakers
+	tagDrain     *TagDrain
+	tenantHeader string
+	diagnostics  bool
+}
+
+// WithServerTiming makes the ProxyHandler report the time spent waiting in the
+// breaker and the time the application took to respond in a Server-Timing
+// response header.
+func WithServerTiming() ProxyOption {
+	return func(o *proxyOptions) {
+		o.serverTiming = true
+	}
+}
+
+// WithQueueWaitHeader makes the ProxyHandler report the time the request
+// waited in the breaker's queue in an X-Estimated-Queue-Wait response header,
+// both on successful and rejected requests.
+func WithQueueWaitHeader() ProxyOption {
+	return func(o *proxyOptions) {
+		o.queueWait = true
+	}
+}
+
+// WithSlowUpstreamDetection makes the ProxyHandler mark requests that waited at
+// least queueWait for admission while the admitted requests took at least
+// serviceTime on average, so that the request metrics handler counts them as
+// queued because of a slow user container.
+func WithSlowUpstreamDetection(queueWait, serviceTime time.Duration) ProxyOption {
+	return func(o *proxyOptions) {
+		o.slowUpstream = &slowUpstreamDetector{queueWait: queueWait, serviceTime: serviceTime}
+	}
+}
+
+// WithTagBreakers makes the ProxyHandler enforce the limits of the breaker
+// dedicated to the request's route tag, if any, instead of the passed breaker.
+func WithTagBreakers(tb *TagBreakers) ProxyOption {
+	return func(o *proxyOptions) {
+		o.tagBreakers = tb
+	}
+}
+
+// WithTenantHeader makes the ProxyHandler mark requests with the value of the
+// given header as their tenant, see WithRequestTenant, for breakers sharing
+// their capacity fairly among tenants.
+func WithTenantHeader(header string) ProxyOption {
+	return func(o *proxyOptions) {
+		o.tenantHeader = header
+	}
+}
+
+// WithTagDrain makes the ProxyHandler reject requests whose route tag is
+// draining, including queued requests once they would be admitted.
+func WithTagDrain(d *TagDrain) ProxyOption {
+	return func(o *proxyOptions) {
+		o.tagDrain = d
+	}
+}
+
+// WithRejectionDiagnostics makes the ProxyHandler explain the 503s served for
+// requests the breaker rejected: why, how many requests are queued if the
+// breaker tracks its concurrency, the current capacity and, if the breaker
+// samples waits, when to retry. The body
+// is JSON if the client accepts it, plain text otherwise. This is meant for
+// developers hitting a revision directly, not for production.
+func WithRejectionDiagnostics() ProxyOption {
+	return func(o *proxyOptions) {
+		o.diagnostics = true
+	}
+}
+
 // ProxyHandler sends requests to the `next` handler at a rate controlled by
 // the passed `breaker`, while recording stats to `stats`.
-func ProxyHandler(breaker *Breaker, stats *network.RequestStats, tracingEnabled bool, next http.Handler) http.HandlerFunc {
+func ProxyHandler(breaker *Breaker, stats *network.RequestStats, tracingEnabled bool, next http.Handler, opts ...ProxyOption) http.HandlerFunc {
+	var options proxyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		if network.IsKubeletProbe(r) {
 			next.ServeHTTP(w, r)
@@ -53,26 +156,119 @@ func ProxyHandler(breaker *Breaker, stats *network.RequestStats, tracingEnabled
 		}()
 		network.RewriteHostOut(r)
 
+		state := requestMetricsStateFrom(r.Context())
+		state.markStage(markProxyEntered)
+		if deadline, ok := r.Context().Deadline(); ok {
+			state.setDeadline(deadline)
+		}
+
+		timing := newTimingWriter(w, options)
+		if timing != nil {
+			w = timing
+		}
+
+		draining := func() bool {
+			return options.tagDrain != nil && options.tagDrain.Draining(GetRouteTagNameFromRequest(r))
+		}
+		if draining() {
+			rejectTagDraining(w, r)
+			return
+		}
+
 		// Enforce queuing and concurrency limits.
+		breaker := breaker
+		if options.tagBreakers != nil {
+			if b := options.tagBreakers.breakerFor(r); b != nil {
+				breaker = b
+			}
+		}
 		if breaker != nil {
+			breaker = breaker.ForMethod(r.Method)
+			if options.tenantHeader != "" {
+				if tenant := r.Header.Get(options.tenantHeader); tenant != "" {
+					r = r.WithContext(WithRequestTenant(r.Context(), tenant))
+				}
+			}
 			var waitSpan *trace.Span
 			if tracingEnabled {
 				_, waitSpan = trace.StartSpan(r.Context(), "queue_wait")
 			}
-			if err := breaker.Maybe(r.Context(), func() {
+			queued := time.Now()
+			if err := breaker.MaybeWithContext(r.Context(), func(ctx context.Context) {
 				waitSpan.End()
-				next.ServeHTTP(w, r)
+				// The tag may have started draining while queued.
+				if draining() {
+					rejectTagDraining(w, r)
+					return
+				}
+				timing.admit()
+				state.markStage(markAdmitted)
+				if d := options.slowUpstream; d != nil {
+					admitted := time.Now()
+					if d.slow(admitted.Sub(queued)) && state != nil {
+						state.setSlowUpstreamQueueing()
+					}
+					defer func() {
+						d.observe(time.Since(admitted))
+					}()
+				}
+				next.ServeHTTP(w, r.WithContext(ctx))
+				timing.finish()
 			}); err != nil {
+				if errors.Is(err, ErrSlotTimeout) {
+					// The request was served, if only with an error.
+					recordDrop(r, dropReasonSlotTimeout)
+					return
+				}
 				waitSpan.End()
-				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrRequestQueueFull) {
-					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				if cause := queueCancellationCause(err); cause != "" && state != nil {
+					state.setCancellationCause(cause)
+				}
+				if errors.Is(err, ErrNoDeadline) {
+					recordDrop(r, dropReasonNoDeadline)
+				}
+				if errors.Is(err, ErrDependencyUnhealthy) {
+					recordDrop(r, dropReasonDependencyUnhealthy)
+				}
+				if errors.Is(err, ErrRequestCancelled) {
+					// The client is most likely gone already, this is for
+					// the logs.
+					w.WriteHeader(statusClientClosedRequest)
+				} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrRequestQueueFull) ||
+					errors.Is(err, ErrQueueTimeout) || errors.Is(err, ErrDraining) || errors.Is(err, ErrNoDeadline) ||
+					errors.Is(err, ErrCapacityDenied) || errors.Is(err, ErrDependencyUnhealthy) {
+					if options.diagnostics {
+						newRejectionDiagnostics(breaker, err).write(w, r)
+					} else {
+						http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					}
 				} else {
 					// This line is most likely untestable :-).
 					w.WriteHeader(http.StatusInternalServerError)
 				}
 			}
 		} else {
+			timing.admit()
+			state.markStage(markAdmitted)
 			next.ServeHTTP(w, r)
+			timing.finish()
 		}
 	}
 }
+
+// queueCancellationCause returns why a request was removed from the breaker's
+// queue before admission, or an empty string if err doesn't denote that.
+func queueCancellationCause(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return cancellationCauseClient
+	case errors.Is(err, context.DeadlineExceeded):
+		return cancellationCauseDeadline
+	case errors.Is(err, errDrainedWhileQueued):
+		return cancellationCauseDrain
+	case errors.Is(err, ErrQueueTimeout):
+		return cancellationCauseQueueTimeout
+	default:
+		return ""
+	}
+}
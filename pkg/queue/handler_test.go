@@ -18,18 +18,28 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/wait"
 	network "knative.dev/networking/pkg"
+	"knative.dev/pkg/metrics/metricstest"
 	"knative.dev/serving/pkg/activator"
+	"knative.dev/serving/pkg/metrics"
 )
 
 const (
@@ -120,6 +130,360 @@ func TestHandlerBreakerTimeout(t *testing.T) {
 	}
 }
 
+func TestHandlerClientCancelled(t *testing.T) {
+	seen := make(chan struct{})
+	resp := make(chan struct{})
+	defer close(resp)
+	blockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- struct{}{}
+		<-resp
+	})
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	h := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, blockHandler)
+
+	go func() {
+		h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil))
+	}()
+	<-seen
+
+	// The client goes away while its request waits for capacity.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for breaker.InFlight() != 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil).WithContext(ctx))
+	if got, want := rec.Code, statusClientClosedRequest; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+}
+
+func TestHandlerNoDeadlineShed(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0,
+		NoDeadlineShedThreshold: 0.5})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// A request with a deadline saturates the breaker.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	queued := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil).WithContext(ctx))
+		queued <- rec.Code
+	}()
+	for breaker.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("Code without deadline = %d, want: %d", got, want)
+	}
+
+	breaker.UpdateConcurrency(1)
+	if got, want := <-queued, http.StatusOK; got != want {
+		t.Errorf("Code with deadline = %d, want: %d", got, want)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      disabledTagName,
+		"reason":                   dropReasonNoDeadline,
+	}))
+}
+
+func TestHandlerSlotTimeout(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		MaxSlotTime: 50 * time.Millisecond})
+	hang := make(chan struct{})
+	defer close(hang)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Hang") != "" {
+			<-r.Context().Done()
+			w.WriteHeader(http.StatusBadGateway)
+			<-hang
+		}
+	})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// The hung request is cancelled, but keeps its slot until it returns, so
+	// the next request queues behind it.
+	hung := make(chan struct{})
+	go func() {
+		defer close(hung)
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		req.Header.Set("Hang", "true")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	for breaker.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	code := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+		code <- rec.Code
+	}()
+	for breaker.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	hang <- struct{}{}
+	<-hung
+	if got, want := <-code, http.StatusOK; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      disabledTagName,
+		"reason":                   dropReasonSlotTimeout,
+	}))
+}
+
+func TestHandlerMethodMaxConcurrency(t *testing.T) {
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		MethodMaxConcurrency: map[string]int{http.MethodPost: 1}})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			entered <- struct{}{}
+			<-release
+		}
+	})
+	h := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+
+	// One POST request is served, one queues.
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, targetURI, nil))
+			done <- rec.Code
+		}()
+	}
+	<-entered
+	post := breaker.ForMethod(http.MethodPost)
+	for post.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Further POST requests are rejected, while GET requests are served.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, targetURI, nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("POST code = %d, want: %d", got, want)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("GET code = %d, want: %d", got, want)
+	}
+
+	release <- struct{}{}
+	<-entered
+	close(release)
+	for i := 0; i < 2; i++ {
+		if got, want := <-done, http.StatusOK; got != want {
+			t.Errorf("Queued POST code = %d, want: %d", got, want)
+		}
+	}
+	if got, want := breaker.StatsSnapshot().RejectedTotal, int64(1); got != want {
+		t.Errorf("RejectedTotal = %d, want: %d", got, want)
+	}
+}
+
+func TestHandlerTenantHeader(t *testing.T) {
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		QueueMode: QueueModeWeightedFair})
+	var tenant string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = requestTenant(r.Context())
+	})
+	h := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next,
+		WithTenantHeader("X-Tenant"))
+
+	for _, want := range []string{"acme", ""} {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		if want != "" {
+			req.Header.Set("X-Tenant", want)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if tenant != want {
+			t.Errorf("Tenant = %q, want: %q", tenant, want)
+		}
+	}
+}
+
+func TestHandlerServerTiming(t *testing.T) {
+	defer reset()
+	const appTime = 20 * time.Millisecond
+
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Block") != "" {
+			<-release
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		time.Sleep(appTime)
+		w.Write([]byte("hello"))
+	})
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	app, err := NewAppRequestMetricsHandler(next, breaker, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create app metrics handler:", err)
+	}
+	h, err := NewRequestMetricsHandler(
+		ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, app, WithServerTiming()),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create metrics handler:", err)
+	}
+
+	// Occupy the breaker so that the measured request has to queue.
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil)
+		req.Header.Set("Block", "true")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	for breaker.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	const queueTime = 30 * time.Millisecond
+	time.AfterFunc(queueTime, func() { close(release) })
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil))
+	<-blocked
+
+	header := rec.Header().Get(ServerTimingHeader)
+	match := regexp.MustCompile(`^queue;dur=([0-9.]+), app;dur=([0-9.]+)$`).FindStringSubmatch(header)
+	if match == nil {
+		t.Fatalf("%s = %q, doesn't match the expected format", ServerTimingHeader, header)
+	}
+	queue, _ := strconv.ParseFloat(match[1], 64)
+	service, _ := strconv.ParseFloat(match[2], 64)
+	if queue < float64(queueTime.Milliseconds()) {
+		t.Errorf("queue duration = %vms, want at least %v", queue, queueTime)
+	}
+	if service < float64(appTime.Milliseconds()) {
+		t.Errorf("app duration = %vms, want at least %v", service, appTime)
+	}
+
+	// The header is consistent with the recorded latencies. The metrics
+	// are recorded with millisecond granularity, hence the tolerance.
+	const tolerance = 10
+	metricstest.EnsureRecorded()
+	appLatency := metricstest.GetOneMetric("app_request_latencies").Values
+	if got := distributionMean(appLatency, "200"); math.Abs(got-service) > tolerance {
+		t.Errorf("app_request_latencies = %vms, header app duration = %vms", got, service)
+	}
+	latency := metricstest.GetOneMetric("request_latencies").Values
+	if got := distributionMean(latency, "200"); math.Abs(got-(queue+service)) > tolerance {
+		t.Errorf("request_latencies = %vms, header total duration = %vms", got, queue+service)
+	}
+}
+
+func TestHandlerServerTimingDisabled(t *testing.T) {
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil))
+	if got := rec.Header().Get(ServerTimingHeader); got != "" {
+		t.Errorf("%s = %q, want no header", ServerTimingHeader, got)
+	}
+}
+
+func TestHandlerQueueWaitHeader(t *testing.T) {
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Block") != "" {
+			<-release
+		}
+	})
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	h := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next, WithQueueWaitHeader())
+
+	wait := func(rec *httptest.ResponseRecorder) float64 {
+		t.Helper()
+		v, err := strconv.ParseFloat(rec.Header().Get(QueueWaitHeader), 64)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", QueueWaitHeader, err)
+		}
+		return v
+	}
+
+	// A request admitted right away barely waited.
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil))
+	if got := wait(rec); got > 10 {
+		t.Errorf("%s = %vms, want close to 0", QueueWaitHeader, got)
+	}
+
+	// Occupy the breaker so that the next request has to queue.
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil)
+		req.Header.Set("Block", "true")
+		h(httptest.NewRecorder(), req)
+	}()
+	for breaker.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	const queueTime = 30 * time.Millisecond
+	time.AfterFunc(queueTime, func() { close(release) })
+
+	start := time.Now()
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8081/time", nil))
+	measured := time.Since(start)
+	<-blocked
+
+	if got, min, max := wait(rec), float64(queueTime.Milliseconds()), float64(measured.Milliseconds())+1; got < min || got > max {
+		t.Errorf("%s = %vms, want between %vms and %vms", QueueWaitHeader, got, min, max)
+	}
+}
+
+func TestServerTimingValue(t *testing.T) {
+	if got, want := serverTimingValue(1500*time.Microsecond, 2*time.Second), "queue;dur=1.5, app;dur=2000"; got != want {
+		t.Errorf("serverTimingValue = %q, want: %q", got, want)
+	}
+}
+
+// distributionMean returns the mean of the distribution recorded for the
+// given response code.
+func distributionMean(values []metricstest.Value, code string) float64 {
+	for _, v := range values {
+		if v.Tags[metrics.LabelResponseCode] == code && v.Distribution != nil && v.Distribution.Count > 0 {
+			return v.Distribution.Sum / float64(v.Distribution.Count)
+		}
+	}
+	return 0
+}
+
 func TestHandlerReqEvent(t *testing.T) {
 	params := BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10}
 	breaker := NewBreaker(params)
@@ -295,3 +659,281 @@ func BenchmarkProxyHandler(b *testing.B) {
 		reportTicker.Stop()
 	}
 }
+
+func TestSlowUpstreamDetector(t *testing.T) {
+	tests := []struct {
+		name         string
+		serviceTimes []time.Duration
+		wait         time.Duration
+		want         bool
+	}{{
+		name:         "slow upstream with a backed up queue",
+		serviceTimes: []time.Duration{time.Second, time.Second},
+		wait:         time.Second,
+		want:         true,
+	}, {
+		name:         "fast upstream with a backed up queue",
+		serviceTimes: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond},
+		wait:         time.Second,
+	}, {
+		name:         "slow upstream without queueing",
+		serviceTimes: []time.Duration{time.Second},
+		wait:         time.Millisecond,
+	}, {
+		name:         "upstream recovered",
+		serviceTimes: []time.Duration{time.Second, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond},
+		wait:         time.Second,
+	}, {
+		name: "nothing served yet",
+		wait: time.Second,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &slowUpstreamDetector{queueWait: 100 * time.Millisecond, serviceTime: 500 * time.Millisecond}
+			for _, st := range test.serviceTimes {
+				d.observe(st)
+			}
+			if got := d.slow(test.wait); got != test.want {
+				t.Errorf("slow(%v) = %v, want: %v", test.wait, got, test.want)
+			}
+		})
+	}
+}
+
+func TestResizeTracker(t *testing.T) {
+	var tracker resizeTracker
+
+	// Waiting without a shrink is due to the load.
+	s := tracker.snapshot(3)
+	if tracker.induced(s) {
+		t.Error("induced() = true without a shrink")
+	}
+
+	// A shrink while waiting extends the wait.
+	tracker.resized(4, 2)
+	if !tracker.induced(s) {
+		t.Error("induced() = false after a shrink while waiting")
+	}
+
+	// Requests that would have fit the capacity before the shrink wait
+	// because of it, others because of the load.
+	if !tracker.induced(tracker.snapshot(4)) {
+		t.Error("induced() = false for a request fitting the old capacity")
+	}
+	if tracker.induced(tracker.snapshot(5)) {
+		t.Error("induced() = true for a request exceeding the old capacity")
+	}
+
+	// Smaller steps still compare against the original capacity.
+	tracker.resized(2, 1)
+	if !tracker.induced(tracker.snapshot(4)) {
+		t.Error("induced() = false for a request fitting the original capacity")
+	}
+
+	// Growing back forgets the shrink.
+	tracker.resized(1, 4)
+	if tracker.induced(tracker.snapshot(2)) {
+		t.Error("induced() = true after growing back")
+	}
+}
+
+func TestHandlerResizeInducedWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		shrinkTo int
+		requests int
+		want     int64
+	}{{
+		name:     "no shrink",
+		capacity: 1,
+		shrinkTo: 1,
+		requests: 3,
+	}, {
+		name:     "shrink",
+		capacity: 3,
+		shrinkTo: 1,
+		requests: 3,
+		want:     2,
+	}, {
+		name:     "shrink and load",
+		capacity: 2,
+		shrinkTo: 1,
+		requests: 4,
+		want:     1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+			breaker := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: test.capacity,
+				InitialCapacity: test.capacity})
+			breaker.UpdateConcurrency(test.shrinkTo)
+
+			release := make(chan struct{})
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			})
+			proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+			h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			// Requests enter the breaker one after the other, the first is
+			// admitted and the others queue behind it.
+			var wg sync.WaitGroup
+			for i := 1; i <= test.requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+				}()
+				for breaker.InFlight() < i {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			close(release)
+			wg.Wait()
+
+			if test.want == 0 {
+				metricstest.AssertNoMetric(t, "resize_induced_wait_count")
+				return
+			}
+			metricstest.AssertMetric(t, metricstest.IntMetric("resize_induced_wait_count", test.want, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+			}))
+		})
+	}
+}
+
+func TestRejectionDiagnostics(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []ProxyOption
+		accept    string
+		wantType  string
+		wantBody  string
+		wantRetry string
+	}{{
+		name:     "disabled",
+		wantType: "text/plain; charset=utf-8",
+		wantBody: ErrRequestQueueFull.Error() + "\n",
+	}, {
+		name:      "plain text",
+		opts:      []ProxyOption{WithRejectionDiagnostics()},
+		wantType:  "text/plain; charset=utf-8",
+		wantBody:  "reason: queue_full\nqueued: 2\ncapacity: 0\nretry after: 2s\n",
+		wantRetry: "2",
+	}, {
+		name:      "json",
+		opts:      []ProxyOption{WithRejectionDiagnostics()},
+		accept:    "application/json, text/plain",
+		wantType:  "application/json",
+		wantBody:  `{"reason":"queue_full","queued":2,"capacity":0,"retryAfterSeconds":2}` + "\n",
+		wantRetry: "2",
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Without capacity, the first two requests wait until cancelled
+			// and the third is rejected.
+			breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0,
+				WaitSampleSize: 10, TrackConcurrency: true})
+			breaker.waits.add(1500 * time.Millisecond)
+			h := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false, /*tracingEnabled*/
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), tc.opts...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer cancel()
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil).WithContext(ctx))
+				}()
+			}
+			if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+				return breaker.InFlight() == 2, nil
+			}); err != nil {
+				t.Fatal("Requests never got queued:", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+
+			if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+				t.Errorf("Code = %d, want: %d", got, want)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tc.wantType {
+				t.Errorf("Content-Type = %q, want: %q", got, tc.wantType)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tc.wantRetry {
+				t.Errorf("Retry-After = %q, want: %q", got, tc.wantRetry)
+			}
+			if got := rec.Body.String(); got != tc.wantBody {
+				t.Errorf("Body = %q, want: %q", got, tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestRejectionDiagnosticsContents(t *testing.T) {
+	breaker := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 5, InitialCapacity: 3})
+
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{{
+		err:  ErrRequestQueueFull,
+		want: "queue_full",
+	}, {
+		err:  ErrQueueTimeout,
+		want: "queue_timeout",
+	}, {
+		err:  ErrDraining,
+		want: "draining",
+	}, {
+		err:  ErrNoDeadline,
+		want: "no_deadline_under_load",
+	}, {
+		err:  ErrCapacityDenied,
+		want: "capacity_denied",
+	}, {
+		err:  ErrDependencyUnhealthy,
+		want: "dependency_unhealthy",
+	}, {
+		err:  context.DeadlineExceeded,
+		want: "deadline_exceeded",
+	}, {
+		err:  errors.New("secret token abc123 at 10.0.0.1"),
+		want: "unknown",
+	}} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		req.Header.Set("Accept", "application/json")
+		newRejectionDiagnostics(breaker, tc.err).write(rec, req)
+
+		// Only the fields about the breaker are served, never the error
+		// itself, which might carry details of the request.
+		var got map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Failed to unmarshal %q: %v", rec.Body.String(), err)
+		}
+		want := map[string]interface{}{"reason": tc.want, "queued": 0.0, "capacity": 3.0}
+		if !cmp.Equal(got, want) {
+			t.Errorf("Diagnostics for %v (-want, +got): %s", tc.err, cmp.Diff(want, got))
+		}
+		if strings.Contains(rec.Body.String(), tc.err.Error()) {
+			t.Errorf("Diagnostics for %v contain the error: %s", tc.err, rec.Body.String())
+		}
+	}
+}
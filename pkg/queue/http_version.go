@@ -0,0 +1,51 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"strings"
+)
+
+// protocolOther is the protocol tag of requests of an unexpected HTTP
+// version.
+const protocolOther = "other"
+
+// httpVersion returns the HTTP version of the request as the protocol tag,
+// e.g. HTTP/3.0. The version is taken from the given header if set, since
+// protocols like HTTP/3 end at the edge, and from the request otherwise.
+// Unexpected versions are bucketed as other to bound the tag's values.
+func httpVersion(r *http.Request, header string) string {
+	proto := r.Proto
+	if header != "" {
+		if v := r.Header.Get(header); v != "" {
+			proto = v
+		}
+	}
+	switch strings.ToUpper(strings.TrimSpace(proto)) {
+	case "HTTP/1.0":
+		return "HTTP/1.0"
+	case "HTTP/1.1":
+		return "HTTP/1.1"
+	case "HTTP/2", "HTTP/2.0", "H2", "H2C":
+		return "HTTP/2.0"
+	case "HTTP/3", "HTTP/3.0", "H3":
+		return "HTTP/3.0"
+	default:
+		return protocolOther
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+const (
+	// IdempotencyKeyHeader is the header clients set to mark retries of
+	// the same unsafe request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// maxIdempotentResponseBytes is the largest response body we keep for
+	// replay. Larger responses are passed through but not cached.
+	maxIdempotentResponseBytes = 1 << 20
+)
+
+var (
+	coalescedRequestCountM = stats.Int64(
+		"coalesced_request_count",
+		"The number of requests served with the response of a concurrent request with the same idempotency key",
+		stats.UnitDimensionless)
+	coalesceGroupSizeM = stats.Int64(
+		"coalesce_group_size",
+		"The number of requests sharing a single upstream call, including the one making it",
+		stats.UnitDimensionless)
+)
+
+type idempotencyKey struct {
+	method, path, key string
+}
+
+type idempotentResponse struct {
+	code    int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+type idempotencyHandler struct {
+	next  http.Handler
+	ttl   time.Duration
+	clock clock.Clock
+
+	// cache holds the replayable responses. The LRU is synchronized.
+	cache *lru.Cache
+
+	// coalesce makes duplicates of a request in progress wait for its
+	// response rather than fail, see WithCoalescing.
+	coalesce  bool
+	coalesced *CoalescingReporter
+
+	mux      sync.Mutex
+	inFlight map[idempotencyKey]*coalesceGroup
+}
+
+// coalesceGroup is a request in progress along with the duplicates waiting
+// for its response.
+type coalesceGroup struct {
+	done chan struct{}
+	// followers is the number of duplicates that joined, including those
+	// that gave up waiting.
+	followers int
+	// resp is the response of the request, nil if it can't be replayed. It's
+	// set before done is closed.
+	resp *idempotentResponse
+}
+
+// IdempotencyOption configures optional behavior of the idempotency handler.
+type IdempotencyOption func(*idempotencyHandler)
+
+// WithCoalescing makes the idempotency handler coalesce concurrent requests
+// with the same idempotency key: rather than being rejected with 409 Conflict,
+// duplicates of a request in progress wait for it and get its response, so
+// that the upstream is called once. The coalesced requests are recorded by r,
+// if not nil.
+func WithCoalescing(r *CoalescingReporter) IdempotencyOption {
+	return func(h *idempotencyHandler) {
+		h.coalesce = true
+		h.coalesced = r
+	}
+}
+
+// NewIdempotencyHandler returns an http.Handler that caches the responses of
+// POST, PUT and PATCH requests carrying an Idempotency-Key header for ttl and
+// replays them on retries instead of invoking next again. At most maxEntries
+// responses are kept. Requests without the header pass through untouched.
+func NewIdempotencyHandler(next http.Handler, ttl time.Duration, maxEntries int, opts ...IdempotencyOption) (http.Handler, error) {
+	return newIdempotencyHandler(next, ttl, maxEntries, clock.RealClock{}, opts...)
+}
+
+func newIdempotencyHandler(next http.Handler, ttl time.Duration, maxEntries int, clock clock.Clock,
+	opts ...IdempotencyOption) (*idempotencyHandler, error) {
+	cache, err := lru.New(maxEntries)
+	if err != nil {
+		return nil, err
+	}
+	h := &idempotencyHandler{
+		next:     next,
+		ttl:      ttl,
+		clock:    clock,
+		cache:    cache,
+		inFlight: make(map[idempotencyKey]*coalesceGroup),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h, nil
+}
+
+func (h *idempotencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" || !isUnsafeMethod(r.Method) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	k := idempotencyKey{method: r.Method, path: r.URL.Path, key: key}
+	if v, ok := h.cache.Get(k); ok {
+		resp := v.(*idempotentResponse)
+		if h.clock.Now().Before(resp.expires) {
+			resp.replay(w)
+			return
+		}
+		h.cache.Remove(k)
+	}
+
+	// Concurrent retries of a request that is still being processed must
+	// not reach the upstream a second time.
+	h.mux.Lock()
+	if g, ok := h.inFlight[k]; ok {
+		if !h.coalesce {
+			h.mux.Unlock()
+			http.Error(w, "request with the same idempotency key is in progress", http.StatusConflict)
+			return
+		}
+		g.followers++
+		h.mux.Unlock()
+		h.follow(w, r, g)
+		return
+	}
+	g := &coalesceGroup{done: make(chan struct{})}
+	h.inFlight[k] = g
+	h.mux.Unlock()
+
+	defer func() {
+		h.mux.Lock()
+		delete(h.inFlight, k)
+		followers := g.followers
+		h.mux.Unlock()
+		close(g.done)
+		if h.coalesce {
+			h.coalesced.group(followers + 1)
+		}
+	}()
+
+	rec := &idempotencyRecorder{ResponseWriter: w, code: http.StatusOK}
+	h.next.ServeHTTP(rec, r)
+
+	if rec.overflow {
+		return
+	}
+	g.resp = &idempotentResponse{
+		code:    rec.code,
+		header:  w.Header().Clone(),
+		body:    rec.body.Bytes(),
+		expires: h.clock.Now().Add(h.ttl),
+	}
+	// Server errors are not cached so that the client may retry them.
+	if rec.code >= http.StatusInternalServerError {
+		return
+	}
+	h.cache.Add(k, g.resp)
+}
+
+// follow serves a duplicate of the request in progress of g with its response.
+// Waiting duplicates share the response even if it's a server error, as they
+// would have raced the upstream call otherwise.
+func (h *idempotencyHandler) follow(w http.ResponseWriter, r *http.Request, g *coalesceGroup) {
+	select {
+	case <-g.done:
+	case <-r.Context().Done():
+		return
+	}
+	if g.resp == nil {
+		http.Error(w, "response of the request with the same idempotency key can't be replayed", http.StatusConflict)
+		return
+	}
+	h.coalesced.coalesced()
+	g.resp.replay(w)
+}
+
+func (r *idempotentResponse) replay(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.code)
+	w.Write(r.body)
+}
+
+// CoalescingReporter records the requests coalesced by the idempotency
+// handler, see WithCoalescing.
+type CoalescingReporter struct {
+	statsCtx context.Context
+}
+
+// NewCoalescingReporter creates a CoalescingReporter recording the
+// coalesced_request_count and coalesce_group_size metrics for the given
+// revision.
+func NewCoalescingReporter(ns, service, config, rev, pod string) (*CoalescingReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The number of requests served with the response of a concurrent request with the same idempotency key",
+		Measure:     coalescedRequestCountM,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}, &view.View{
+		Description: "The number of requests sharing a single upstream call, including the one making it",
+		Measure:     coalesceGroupSizeM,
+		Aggregation: view.Distribution(1, 2, 4, 8, 16, 32, 64, 128),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CoalescingReporter{statsCtx: ctx}, nil
+}
+
+// coalesced records a request served with the response of another.
+func (r *CoalescingReporter) coalesced() {
+	if r == nil {
+		return
+	}
+	pkgmetrics.Record(r.statsCtx, coalescedRequestCountM.M(1))
+}
+
+// group records the number of requests that shared an upstream call.
+func (r *CoalescingReporter) group(size int) {
+	if r == nil {
+		return
+	}
+	pkgmetrics.Record(r.statsCtx, coalesceGroupSizeM.M(int64(size)))
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// idempotencyRecorder passes the response through to the client while keeping
+// a copy of the status and body for replay.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+var _ http.Flusher = (*idempotencyRecorder)(nil)
+
+func (r *idempotencyRecorder) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *idempotencyRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	if !r.overflow {
+		if r.body.Len()+len(p) > maxIdempotentResponseBytes {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush flushes the buffer to the client.
+func (r *idempotencyRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
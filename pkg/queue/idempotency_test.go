@@ -0,0 +1,275 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestIdempotencyHandler(t *testing.T) {
+	const ttl = time.Minute
+
+	calls := atomic.NewInt32(0)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Inc()
+		w.Header().Set("X-Call", fmt.Sprint(n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "call ", n)
+	})
+	fc := clock.NewFakeClock(time.Now())
+	h, err := newIdempotencyHandler(next, ttl, 10, fc)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	serve := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+path, nil)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	assert := func(rec *httptest.ResponseRecorder, wantBody string) {
+		t.Helper()
+		if got, want := rec.Code, http.StatusCreated; got != want {
+			t.Errorf("Code = %d, want: %d", got, want)
+		}
+		if got := rec.Body.String(); got != wantBody {
+			t.Errorf("Body = %q, want: %q", got, wantBody)
+		}
+	}
+
+	// The first request reaches the upstream and is cached.
+	assert(serve(http.MethodPost, "/a", "k1"), "call 1")
+
+	// A retry is replayed, including the headers.
+	rec := serve(http.MethodPost, "/a", "k1")
+	assert(rec, "call 1")
+	if got, want := rec.Header().Get("X-Call"), "1"; got != want {
+		t.Errorf("X-Call = %q, want: %q", got, want)
+	}
+
+	// A different path, method or key is a different request.
+	assert(serve(http.MethodPost, "/b", "k1"), "call 2")
+	assert(serve(http.MethodPut, "/a", "k1"), "call 3")
+	assert(serve(http.MethodPost, "/a", "k2"), "call 4")
+
+	// Safe methods and requests without a key are never cached.
+	assert(serve(http.MethodGet, "/a", "k1"), "call 5")
+	assert(serve(http.MethodGet, "/a", "k1"), "call 6")
+	assert(serve(http.MethodPost, "/a", ""), "call 7")
+	assert(serve(http.MethodPost, "/a", ""), "call 8")
+
+	// Once the TTL expires the upstream is invoked again.
+	fc.Step(ttl - time.Second)
+	assert(serve(http.MethodPost, "/a", "k1"), "call 1")
+	fc.Step(time.Second)
+	assert(serve(http.MethodPost, "/a", "k1"), "call 9")
+	assert(serve(http.MethodPost, "/a", "k1"), "call 9")
+}
+
+func TestIdempotencyHandlerServerError(t *testing.T) {
+	calls := atomic.NewInt32(0)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Inc()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	h, err := NewIdempotencyHandler(next, time.Minute, 10)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if got, want := calls.Load(), int32(2); got != want {
+		t.Errorf("Upstream calls = %d, want: %d", got, want)
+	}
+}
+
+func TestIdempotencyHandlerInProgress(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	h, err := NewIdempotencyHandler(next, time.Minute, 10)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	newReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		return req
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), newReq())
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newReq())
+	if got, want := rec.Code, http.StatusConflict; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+	close(release)
+	<-done
+}
+
+func TestIdempotencyHandlerCoalescing(t *testing.T) {
+	defer metricstest.Unregister(coalescedRequestCountM.Name(), coalesceGroupSizeM.Name())
+
+	const requests = 5
+	calls := atomic.NewInt32(0)
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Inc()
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "call ", n)
+	})
+	r, err := NewCoalescingReporter("ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	h, err := newIdempotencyHandler(next, time.Minute, 10, clock.RealClock{}, WithCoalescing(r))
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	recs := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+			req.Header.Set(IdempotencyKeyHeader, "key")
+			h.ServeHTTP(rec, req)
+		}(recs[i])
+	}
+
+	// Hold the leader until all the others joined it.
+	waitForFollowers(t, h, requests-1)
+	close(release)
+	wg.Wait()
+
+	if got, want := calls.Load(), int32(1); got != want {
+		t.Errorf("Upstream calls = %d, want: %d", got, want)
+	}
+	for i, rec := range recs {
+		if got, want := rec.Code, http.StatusCreated; got != want {
+			t.Errorf("Code of request %d = %d, want: %d", i, got, want)
+		}
+		if got, want := rec.Body.String(), "call 1"; got != want {
+			t.Errorf("Body of request %d = %q, want: %q", i, got, want)
+		}
+	}
+
+	tags := map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}
+	metricstest.AssertMetric(t, metricstest.IntMetric("coalesced_request_count", requests-1, tags))
+	metricstest.AssertMetric(t, metricstest.DistributionCountOnlyMetric("coalesce_group_size", 1, tags))
+	if got, want := metricstest.GetOneMetric("coalesce_group_size").Values[0].Distribution.Sum, float64(requests); got != want {
+		t.Errorf("Group size = %v, want: %v", got, want)
+	}
+}
+
+func TestIdempotencyHandlerCoalescingOverflow(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write(make([]byte, maxIdempotentResponseBytes+1))
+	})
+	h, err := newIdempotencyHandler(next, time.Minute, 10, clock.RealClock{}, WithCoalescing(nil))
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	newReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		return req
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), newReq())
+	}()
+	<-entered
+
+	// A response too large to replay can't be shared either.
+	rec := httptest.NewRecorder()
+	followed := make(chan struct{})
+	go func() {
+		defer close(followed)
+		h.ServeHTTP(rec, newReq())
+	}()
+	waitForFollowers(t, h, 1)
+	close(release)
+	<-done
+	<-followed
+	if got, want := rec.Code, http.StatusConflict; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+}
+
+// waitForFollowers waits until n requests wait for the single request in
+// progress of h.
+func waitForFollowers(t *testing.T, h *idempotencyHandler, n int) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		for _, g := range h.inFlight {
+			return g.followers == n, nil
+		}
+		return false, nil
+	}); err != nil {
+		t.Fatal("The requests never coalesced:", err)
+	}
+}
+
+func TestIdempotencyHandlerInvalidSize(t *testing.T) {
+	if _, err := NewIdempotencyHandler(nil /*next*/, time.Minute, 0); err == nil {
+		t.Error("Expected an error for a zero sized cache")
+	}
+}
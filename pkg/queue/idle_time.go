@@ -0,0 +1,146 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var idleTimeRatioM = stats.Float64(
+	"idle_time_ratio",
+	"The fraction of the last reporting interval the breaker had no requests in flight",
+	stats.UnitDimensionless)
+
+// idleTracker accumulates the time no request holds capacity of a breaker.
+type idleTracker struct {
+	clock clock.PassiveClock
+
+	mux     sync.Mutex
+	current int
+	// idle is the idle time of the current interval up to since.
+	idle time.Duration
+	// since is when the breaker last became idle, or when the interval
+	// started if it has been idle since.
+	since time.Time
+	start time.Time
+}
+
+func newIdleTracker(c clock.PassiveClock) *idleTracker {
+	now := c.Now()
+	return &idleTracker{
+		clock: c,
+		since: now,
+		start: now,
+	}
+}
+
+// add changes the number of requests in flight by delta.
+func (t *idleTracker) add(delta int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	now := t.clock.Now()
+	if t.current == 0 {
+		t.idle += now.Sub(t.since)
+	}
+	t.current += delta
+	if t.current == 0 {
+		t.since = now
+	}
+}
+
+// take returns the fraction of time since the previous take without requests
+// in flight and starts a new interval.
+func (t *idleTracker) take() float64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	now := t.clock.Now()
+	if t.current == 0 {
+		t.idle += now.Sub(t.since)
+		t.since = now
+	}
+	interval := now.Sub(t.start)
+	idle := t.idle
+	t.idle, t.start = 0, now
+	if interval <= 0 {
+		return 0
+	}
+	return float64(idle) / float64(interval)
+}
+
+// IdleTimeRatioReporter records the fraction of every reporting interval a
+// breaker had no requests in flight, which tells how much of the time a pod
+// sits unused.
+type IdleTimeRatioReporter struct {
+	statsCtx context.Context
+	breaker  *Breaker
+}
+
+// NewIdleTimeRatioReporter creates an IdleTimeRatioReporter recording the
+// idle_time_ratio metric of the given breaker, which must have been created
+// with TrackIdleTime.
+func NewIdleTimeRatioReporter(b *Breaker, ns, service, config, rev, pod string) (*IdleTimeRatioReporter, error) {
+	if b.idle == nil {
+		return nil, errors.New("the breaker doesn't track its idle time")
+	}
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The fraction of the last reporting interval the breaker had no requests in flight",
+		Measure:     idleTimeRatioM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &IdleTimeRatioReporter{
+		statsCtx: ctx,
+		breaker:  b,
+	}, nil
+}
+
+// Run records the idle time ratio of every period until ctx is done.
+func (r *IdleTimeRatioReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the idle time ratio since the previous report.
+func (r *IdleTimeRatioReporter) report() {
+	pkgmetrics.Record(r.statsCtx, idleTimeRatioM.M(r.breaker.idle.take()))
+}
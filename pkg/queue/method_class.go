@@ -0,0 +1,109 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MethodClassParams configures the breaker of a class of HTTP methods, see
+// BreakerParams.SafeMethods.
+type MethodClassParams struct {
+	// MaxConcurrency is the concurrency limit of the class.
+	MaxConcurrency int
+	// QueueDepth is the number of requests of the class allowed to wait for
+	// capacity.
+	QueueDepth int
+}
+
+// isSafeMethod returns whether the given HTTP method is safe as defined by
+// RFC 7231, i.e. read-only.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// validateMethodClass panics if the given class parameters are invalid.
+func validateMethodClass(class string, p *MethodClassParams) {
+	if p == nil {
+		return
+	}
+	if p.MaxConcurrency < 1 {
+		panic(fmt.Sprintf("Max concurrency of %s methods must be greater than 0. Got %v.", class, p.MaxConcurrency))
+	}
+	if p.QueueDepth <= 0 {
+		panic(fmt.Sprintf("Queue depth of %s methods must be greater than 0. Got %v.", class, p.QueueDepth))
+	}
+}
+
+// newMethodClassBreaker creates the breaker of a method class from the
+// parameters of its parent breaker b. The class breakers share the cap of
+// the requests in flight across the classes, if any.
+func (b *Breaker) newMethodClassBreaker(params BreakerParams, class *MethodClassParams, inFlightCap *semaphore) *Breaker {
+	if class == nil {
+		return nil
+	}
+	params.MaxConcurrency, params.InitialCapacity = class.MaxConcurrency, class.MaxConcurrency
+	params.QueueDepth = class.QueueDepth
+	cb := b.newSubBreaker(params)
+	cb.inFlightCap = inFlightCap
+	return cb
+}
+
+// newSubBreaker creates a breaker dedicated to a subset of the requests of b,
+// whose requests count in b's stats.
+func (b *Breaker) newSubBreaker(params BreakerParams) *Breaker {
+	params.OnCapacityChange = nil
+	params.MethodMaxConcurrency = nil
+	params.SafeMethods, params.UnsafeMethods = nil, nil
+	params.MethodClassMaxInFlight = 0
+	sb := NewBreaker(params)
+	sb.admitted, sb.rejected, sb.peak, sb.idle = b.admitted, b.rejected, b.peak, b.idle
+	sb.queue = b.queue
+	if sb.sched != nil {
+		sb.sched.queue = b.queue
+	}
+	sb.pacer, sb.tracer, sb.events = b.pacer, b.tracer, b.events
+	if b.casRetries != nil {
+		sb.setCASRetries(b.casRetries)
+	}
+	return sb
+}
+
+// subBreakers returns the method and method class breakers of b.
+func (b *Breaker) subBreakers() []*Breaker {
+	subs := make([]*Breaker, 0, len(b.methods)+2)
+	for _, mb := range b.methods {
+		subs = append(subs, mb)
+	}
+	for _, cb := range []*Breaker{b.safe, b.unsafe} {
+		if cb != nil {
+			subs = append(subs, cb)
+		}
+	}
+	return subs
+}
+
+// tryAcquireInFlightCap takes a slot of the cap of the requests in flight
+// across the method classes without waiting, if there is a cap.
+func (b *Breaker) tryAcquireInFlightCap() bool {
+	return b.inFlightCap == nil || b.inFlightCap.tryAcquire()
+}
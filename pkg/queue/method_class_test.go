@@ -0,0 +1,167 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreakerMethodClassesForMethod(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		MethodMaxConcurrency: map[string]int{"patch": 1},
+		SafeMethods:          &MethodClassParams{MaxConcurrency: 5, QueueDepth: 5}})
+	safe := b.ForMethod(http.MethodGet)
+	if safe == b {
+		t.Fatal("ForMethod(GET) returned the breaker itself")
+	}
+	if got := b.ForMethod(http.MethodHead); got != safe {
+		t.Error("ForMethod(HEAD) didn't return the safe method breaker")
+	}
+	// Without parameters, the unsafe methods are served by the breaker itself.
+	if got := b.ForMethod(http.MethodPost); got != b {
+		t.Error("ForMethod(POST) didn't return the breaker itself")
+	}
+	// A breaker of its own wins over the class.
+	if got := b.ForMethod(http.MethodPatch); got == b || got == safe {
+		t.Error("ForMethod(PATCH) didn't return the method's breaker")
+	}
+}
+
+func TestBreakerMethodClassesOnWait(t *testing.T) {
+	var admitted, rejected int
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		MethodMaxConcurrency: map[string]int{"patch": 1},
+		SafeMethods:          &MethodClassParams{MaxConcurrency: 1, QueueDepth: 1},
+		OnWait: func(_ time.Duration, ok bool) {
+			if ok {
+				admitted++
+			} else {
+				rejected++
+			}
+		}})
+
+	// Every breaker reports the waits of the requests it admits.
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodPost} {
+		if err := b.ForMethod(method).Maybe(context.Background(), func() {}); err != nil {
+			t.Errorf("%s Maybe() = %v, want: nil", method, err)
+		}
+	}
+	if admitted != 3 || rejected != 0 {
+		t.Errorf("OnWait got %d admitted and %d rejected requests, want 3 and 0", admitted, rejected)
+	}
+}
+
+func TestBreakerMethodClasses(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1,
+		SafeMethods:            &MethodClassParams{MaxConcurrency: 3, QueueDepth: 3},
+		UnsafeMethods:          &MethodClassParams{MaxConcurrency: 1, QueueDepth: 1},
+		MethodClassMaxInFlight: 3})
+	reads, writes := b.ForMethod(http.MethodGet), b.ForMethod(http.MethodPost)
+	if reads == b || writes == b || reads == writes {
+		t.Fatal("ForMethod() didn't return distinct method class breakers")
+	}
+
+	// Writes are limited on their own.
+	releaseWrite, ok := writes.Reserve(context.Background())
+	if !ok {
+		t.Fatal("POST Reserve() failed")
+	}
+	if _, ok := writes.Reserve(context.Background()); ok {
+		t.Error("POST Reserve() succeeded beyond the unsafe method concurrency")
+	}
+
+	// Reads aren't, up to the combined cap.
+	var releaseReads []func()
+	for i := 0; i < 2; i++ {
+		release, ok := reads.Reserve(context.Background())
+		if !ok {
+			t.Fatal("GET Reserve() failed")
+		}
+		releaseReads = append(releaseReads, release)
+	}
+	if _, ok := reads.Reserve(context.Background()); ok {
+		t.Error("GET Reserve() succeeded beyond the combined cap")
+	}
+
+	// A read waits for the cap and gets admitted once a write finishes.
+	done := make(chan error)
+	go func() {
+		done <- reads.Maybe(context.Background(), func() {})
+	}()
+	select {
+	case err := <-done:
+		t.Fatal("GET Maybe() returned before the cap freed up:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got, want := b.Pending(), 4; got != want {
+		t.Errorf("Pending() = %d, want: %d", got, want)
+	}
+	releaseWrite()
+	if err := <-done; err != nil {
+		t.Error("GET Maybe() =", err)
+	}
+
+	// The freed capacity went back to the cap.
+	release, ok := writes.Reserve(context.Background())
+	if !ok {
+		t.Fatal("POST Reserve() failed after the cap freed up")
+	}
+	release()
+	for _, release := range releaseReads {
+		release()
+	}
+	if got, want := b.Pending(), 0; got != want {
+		t.Errorf("Pending() = %d, want: %d", got, want)
+	}
+	if got, want := b.StatsSnapshot().AdmittedTotal, int64(5); got != want {
+		t.Errorf("AdmittedTotal = %d, want: %d", got, want)
+	}
+}
+
+func TestBreakerMethodClassesQueueDepth(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10,
+		UnsafeMethods: &MethodClassParams{MaxConcurrency: 1, QueueDepth: 1}})
+	writes := b.ForMethod(http.MethodDelete)
+
+	// One write holds the capacity, another one waits in the queue.
+	release := make(chan struct{})
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- writes.Maybe(context.Background(), func() { <-release })
+		}()
+	}
+	for writes.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := writes.Maybe(context.Background(), func() {}); err != ErrRequestQueueFull {
+		t.Errorf("DELETE Maybe() = %v, want: %v", err, ErrRequestQueueFull)
+	}
+	// Reads are served by the breaker itself, unaffected.
+	if err := b.ForMethod(http.MethodGet).Maybe(context.Background(), func() {}); err != nil {
+		t.Error("GET Maybe() =", err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error("DELETE Maybe() =", err)
+		}
+	}
+}
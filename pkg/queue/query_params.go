@@ -0,0 +1,60 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+
+	network "knative.dev/networking/pkg"
+)
+
+// dropReasonMissingQueryParam is the dropped_request_count reason for
+// requests rejected by the RequiredQueryParamsHandler.
+const dropReasonMissingQueryParam = "missing_query_param"
+
+// RequiredQueryParamsHandler rejects requests lacking any of the given query
+// parameters with a 400. Parameter names are matched exactly. A parameter
+// with an empty value, e.g. "?a" or "?a=", only counts as present if
+// allowEmpty is set.
+func RequiredQueryParamsHandler(params []string, allowEmpty bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if network.IsKubeletProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		for _, p := range params {
+			values, ok := query[p]
+			if ok && !allowEmpty {
+				ok = false
+				for _, v := range values {
+					if v != "" {
+						ok = true
+						break
+					}
+				}
+			}
+			if !ok {
+				recordDrop(r, dropReasonMissingQueryParam)
+				http.Error(w, "missing required query parameter "+p, http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestRequiredQueryParamsHandler(t *testing.T) {
+	params := []string{"tenant", "version"}
+
+	tests := []struct {
+		name       string
+		query      string
+		allowEmpty bool
+		wantCode   int
+	}{{
+		name:     "present",
+		query:    "?tenant=a&version=1&other=x",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "missing",
+		query:    "?tenant=a",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "no query",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "name differs in case",
+		query:    "?tenant=a&Version=1",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "empty value",
+		query:    "?tenant=a&version=",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "no value",
+		query:    "?tenant=a&version",
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "empty and non-empty value",
+		query:    "?tenant=a&version=&version=1",
+		wantCode: http.StatusOK,
+	}, {
+		name:       "empty value allowed",
+		query:      "?tenant=a&version=",
+		allowEmpty: true,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "no value allowed",
+		query:      "?tenant=a&version",
+		allowEmpty: true,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "missing with empty values allowed",
+		query:      "?tenant=",
+		allowEmpty: true,
+		wantCode:   http.StatusBadRequest,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			handler, err := NewRequestMetricsHandler(RequiredQueryParamsHandler(params, test.allowEmpty, next),
+				"ns", "svc", "cfg", "rev", "pod", nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/"+test.query, nil))
+			if rec.Code != test.wantCode {
+				t.Errorf("Code = %d, want: %d", rec.Code, test.wantCode)
+			}
+
+			if test.wantCode == http.StatusOK {
+				metricstest.AssertNoMetric(t, "dropped_request_count")
+				return
+			}
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+				metrics.LabelRouteTag:      disabledTagName,
+				"reason":                   dropReasonMissingQueryParam,
+			}))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+// DefaultQueueDepthSamplePeriod is the interval QueueDepthSampler samples the
+// queue depth at unless configured otherwise.
+const DefaultQueueDepthSamplePeriod = time.Second
+
+// queueDepthView returns the view of queueDepthM, recorded both by the app
+// request metrics handler and by QueueDepthSampler.
+func queueDepthView() *view.View {
+	return &view.View{
+		Description: "The number of items queued at this queue proxy.",
+		Measure:     queueDepthM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, metrics.ResponseCodeKey, metrics.ResponseCodeClassKey},
+	}
+}
+
+// QueueDepthSampler records the number of requests pending in a breaker as
+// queue_depth on an interval. Unlike the app request metrics handler, which
+// records it as requests arrive, it keeps the metric current while a
+// backed-up queue receives no new requests.
+type QueueDepthSampler struct {
+	statsCtx context.Context
+	breaker  *Breaker
+}
+
+// NewQueueDepthSampler creates a QueueDepthSampler recording the queue_depth
+// metric of the given breaker.
+func NewQueueDepthSampler(b *Breaker, ns, service, config, rev, pod string) (*QueueDepthSampler, error) {
+	if err := pkgmetrics.RegisterResourceView(queueDepthView()); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueDepthSampler{
+		statsCtx: ctx,
+		breaker:  b,
+	}, nil
+}
+
+// Run records the queue depth every period, DefaultQueueDepthSamplePeriod if
+// not positive, until ctx is done.
+func (s *QueueDepthSampler) Run(ctx context.Context, period time.Duration) {
+	if period <= 0 {
+		period = DefaultQueueDepthSamplePeriod
+	}
+	s.sample()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+// sample records the current queue depth.
+func (s *QueueDepthSampler) sample() {
+	pkgmetrics.Record(s.statsCtx, queueDepthM.M(int64(s.breaker.Pending())))
+}
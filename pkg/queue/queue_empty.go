@@ -0,0 +1,104 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/atomic"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var queueEmptyTransitionsM = stats.Int64(
+	"queue_empty_transitions",
+	"The number of times the last request waiting for capacity left the breaker's queue",
+	stats.UnitDimensionless)
+
+// queueTracker keeps the number of requests waiting for capacity of a breaker
+// and counts the times the queue became empty.
+type queueTracker struct {
+	waiting atomic.Int64
+	emptied atomic.Int64
+}
+
+// enter marks a request as waiting.
+func (t *queueTracker) enter() {
+	t.waiting.Inc()
+}
+
+// leave marks a request as done waiting, be it admitted or not.
+func (t *queueTracker) leave() {
+	if t.waiting.Dec() == 0 {
+		t.emptied.Inc()
+	}
+}
+
+// QueueEmptyTransitionsReporter records the number of times a breaker's queue
+// went from holding requests to being empty. Along with the
+// IdleTimeRatioReporter, this tells pods that were only briefly busy from
+// those that never caught up.
+type QueueEmptyTransitionsReporter struct {
+	statsCtx context.Context
+	breaker  *Breaker
+}
+
+// NewQueueEmptyTransitionsReporter creates a QueueEmptyTransitionsReporter
+// recording the queue_empty_transitions metric of the given breaker.
+func NewQueueEmptyTransitionsReporter(b *Breaker, ns, service, config, rev, pod string) (*QueueEmptyTransitionsReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The number of times the last request waiting for capacity left the breaker's queue",
+		Measure:     queueEmptyTransitionsM,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueEmptyTransitionsReporter{
+		statsCtx: ctx,
+		breaker:  b,
+	}, nil
+}
+
+// Run records the transitions of every period until ctx is done.
+func (r *QueueEmptyTransitionsReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the transitions since the previous report.
+func (r *QueueEmptyTransitionsReporter) report() {
+	pkgmetrics.Record(r.statsCtx, queueEmptyTransitionsM.M(r.breaker.queue.emptied.Swap(0)))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var aggregateQueueTimeRatioM = stats.Float64(
+	"aggregate_queue_time_ratio",
+	"The fraction of the time of the requests completed during the last reporting interval spent waiting in the breaker's queue",
+	stats.UnitDimensionless)
+
+// QueueTimeRatioReporter records the total time requests waited in the
+// breaker's queue over the total time they took, per reporting interval, as
+// a single indicator of how saturated the revision is. The requests are
+// observed by the request metrics handler, see WithQueueTimeRatio.
+type QueueTimeRatioReporter struct {
+	statsCtx context.Context
+
+	mux         sync.Mutex
+	wait, total time.Duration
+}
+
+// NewQueueTimeRatioReporter creates a QueueTimeRatioReporter recording the
+// aggregate_queue_time_ratio metric for the given revision.
+func NewQueueTimeRatioReporter(ns, service, config, rev, pod string) (*QueueTimeRatioReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The fraction of the time of the requests completed during the last reporting interval spent waiting in the breaker's queue",
+		Measure:     aggregateQueueTimeRatioM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueTimeRatioReporter{statsCtx: ctx}, nil
+}
+
+// Run records the ratio of every period until ctx is done.
+func (r *QueueTimeRatioReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// observe adds a request that waited for wait out of its total time.
+func (r *QueueTimeRatioReporter) observe(wait, total time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.wait += wait
+	r.total += total
+}
+
+// report records the ratio of the requests observed since the previous
+// report, if any, and starts a new interval.
+func (r *QueueTimeRatioReporter) report() {
+	r.mux.Lock()
+	wait, total := r.wait, r.total
+	r.wait, r.total = 0, 0
+	r.mux.Unlock()
+
+	if total <= 0 {
+		return
+	}
+	pkgmetrics.Record(r.statsCtx, aggregateQueueTimeRatioM.M(float64(wait)/float64(total)))
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Reasons for the breaker rejecting a request, as served by the rejection
+// diagnostics.
+const (
+	rejectionReasonQueueFull           = "queue_full"
+	rejectionReasonQueueTimeout        = "queue_timeout"
+	rejectionReasonDeadline            = "deadline_exceeded"
+	rejectionReasonDraining            = "draining"
+	rejectionReasonNoDeadline          = "no_deadline_under_load"
+	rejectionReasonCapacityDenied      = "capacity_denied"
+	rejectionReasonDependencyUnhealthy = "dependency_unhealthy"
+)
+
+// rejectionDiagnostics is the body of the 503s served for requests the
+// breaker rejected, see WithRejectionDiagnostics. It deliberately only carries
+// the state of the breaker, nothing about the pod, the revision or other
+// requests.
+type rejectionDiagnostics struct {
+	// Reason is why the request was rejected.
+	Reason string `json:"reason"`
+	// Queued is the number of requests waiting for capacity.
+	Queued int `json:"queued"`
+	// Capacity is the number of requests admitted concurrently.
+	Capacity int `json:"capacity"`
+	// RetryAfterSeconds estimates when to retry from the time recently
+	// admitted requests waited, if the breaker samples them.
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+}
+
+// newRejectionDiagnostics returns the diagnostics of b rejecting a request
+// with err.
+func newRejectionDiagnostics(b *Breaker, err error) rejectionDiagnostics {
+	d := rejectionDiagnostics{
+		Reason:   rejectionReason(err),
+		Queued:   b.Queued(),
+		Capacity: b.Capacity(),
+	}
+	if _, p90, _ := b.WaitPercentiles(); p90 > 0 {
+		d.RetryAfterSeconds = int(math.Ceil(p90.Seconds()))
+	}
+	return d
+}
+
+// rejectionReason returns the reason served for the breaker error err.
+func rejectionReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRequestQueueFull):
+		return rejectionReasonQueueFull
+	case errors.Is(err, ErrQueueTimeout):
+		return rejectionReasonQueueTimeout
+	case errors.Is(err, ErrDraining):
+		return rejectionReasonDraining
+	case errors.Is(err, ErrNoDeadline):
+		return rejectionReasonNoDeadline
+	case errors.Is(err, ErrCapacityDenied):
+		return rejectionReasonCapacityDenied
+	case errors.Is(err, ErrDependencyUnhealthy):
+		return rejectionReasonDependencyUnhealthy
+	case errors.Is(err, context.DeadlineExceeded):
+		return rejectionReasonDeadline
+	default:
+		// Not reached for the errors the ProxyHandler serves 503s for.
+		return "unknown"
+	}
+}
+
+// write serves the diagnostics with a 503, as JSON if the client accepts it
+// and as plain text otherwise.
+func (d rejectionDiagnostics) write(w http.ResponseWriter, r *http.Request) {
+	if d.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(d.RetryAfterSeconds))
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(d)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintf(w, "reason: %s\nqueued: %d\ncapacity: %d\n", d.Reason, d.Queued, d.Capacity)
+	if d.RetryAfterSeconds > 0 {
+		fmt.Fprintf(w, "retry after: %ds\n", d.RetryAfterSeconds)
+	}
+}
@@ -18,15 +18,30 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"go.uber.org/atomic"
+	"golang.org/x/net/http/httpguts"
+	"k8s.io/apimachinery/pkg/util/clock"
 
 	network "knative.dev/networking/pkg"
 	pkgmetrics "knative.dev/pkg/metrics"
+	pkgnet "knative.dev/pkg/network"
 	pkghttp "knative.dev/serving/pkg/http"
 	"knative.dev/serving/pkg/metrics"
 )
@@ -34,19 +49,55 @@ import (
 var (
 	// NOTE: 0 should not be used as boundary. See
 	// https://github.com/census-ecosystem/opencensus-go-exporter-stackdriver/issues/98
-	defaultLatencyDistribution = view.Distribution(
+	defaultLatencyBounds = []float64{
 		5, 10, 20, 40, 60, 80, 100, 150, 200, 250, 300, 350, 400, 450, 500, 600,
-		700, 800, 900, 1000, 2000, 5000, 10000, 20000, 50000, 100000)
+		700, 800, 900, 1000, 2000, 5000, 10000, 20000, 50000, 100000}
+	defaultLatencyDistribution = view.Distribution(defaultLatencyBounds...)
+
+	// microsLatencyDistribution has the same buckets as the default latency
+	// distribution, in microseconds.
+	microsLatencyDistribution = view.Distribution(scaleBounds(defaultLatencyBounds, 1000)...)
+
+	// connectionAgeDistribution covers connections from freshly accepted
+	// ones to long-lived keep-alive connections, in seconds.
+	connectionAgeDistribution = view.Distribution(
+		0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 14400, 43200, 86400)
+
+	// breakerCapacityDistribution covers the usual container concurrency
+	// settings.
+	breakerCapacityDistribution = view.Distribution(
+		1, 2, 5, 10, 20, 50, 100, 200, 500, 1000)
+
+	// requestCostDistribution covers request costs from a single slot to
+	// large fractions of the usual container concurrency settings.
+	requestCostDistribution = view.Distribution(1, 2, 4, 8, 16, 32, 64, 128)
+
+	// retransmitsDistribution covers the retransmits seen while serving a
+	// request, from single lost segments to badly lossy networks.
+	retransmitsDistribution = view.Distribution(1, 2, 5, 10, 20, 50, 100)
+
+	// bytesDistribution covers bodies from a few bytes to large uploads and
+	// downloads, in powers of 4.
+	bytesDistribution = view.Distribution(
+		64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864)
 
 	// Metric counters.
 	requestCountM = stats.Int64(
 		"request_count",
 		"The number of requests that are routed to queue-proxy",
 		stats.UnitDimensionless)
+	probeRequestCountM = stats.Int64(
+		"probe_request_count",
+		"The number of probe requests that are routed to queue-proxy",
+		stats.UnitDimensionless)
 	responseTimeInMsecM = stats.Float64(
 		"request_latencies",
 		"The response time in millisecond",
 		stats.UnitMilliseconds)
+	responseTimeInUsecM = stats.Float64(
+		"request_latencies_us",
+		"The response time in microsecond",
+		LatencyUnitMicroseconds)
 	appRequestCountM = stats.Int64(
 		"app_request_count",
 		"The number of requests that are routed to user-container",
@@ -59,36 +110,1298 @@ var (
 		"queue_depth",
 		"The current number of items in the serving and waiting queue, or not reported if unlimited concurrency.",
 		stats.UnitDimensionless)
+	breakerCapacityM = stats.Int64(
+		"breaker_capacity",
+		"The current number of requests the breaker admits concurrently",
+		stats.UnitDimensionless)
+	breakerTargetCapacityM = stats.Int64(
+		"breaker_target_capacity",
+		"The number of requests the breaker is to admit concurrently once done resizing",
+		stats.UnitDimensionless)
+	breakerCapacityChangesM = stats.Int64(
+		"breaker_capacity_distribution",
+		"The capacities applied to the breaker",
+		stats.UnitDimensionless)
+	queueCancellationCountM = stats.Int64(
+		"queue_cancellation_count",
+		"The number of requests that left the queue before being admitted",
+		stats.UnitDimensionless)
+	droppedRequestCountM = stats.Int64(
+		"dropped_request_count",
+		"The number of requests rejected by queue-proxy before reaching user-container",
+		stats.UnitDimensionless)
+	responseFlushTimeInMsecM = stats.Float64(
+		"response_flush_time",
+		"The time spent handing the response to the client in millisecond",
+		stats.UnitMilliseconds)
+	burstAdmissionCountM = stats.Int64(
+		"burst_admission_count",
+		"The number of requests admitted using the breaker's burst capacity",
+		stats.UnitDimensionless)
+	afterRestartRequestCountM = stats.Int64(
+		"after_restart_request_count",
+		"The number of requests among the first ones served after the user-container restarted",
+		stats.UnitDimensionless)
+	proxyRetriesM = stats.Int64(
+		"proxy_retries",
+		"The number of times requests were sent to the user-container again after a retryable failure",
+		stats.UnitDimensionless)
+	clientCloseRequestedCountM = stats.Int64(
+		"client_close_requested_count",
+		"The number of requests whose client asked for the connection to be closed afterwards",
+		stats.UnitDimensionless)
+	slowUpstreamQueueingCountM = stats.Int64(
+		"slow_upstream_queueing_count",
+		"The number of requests that queued because the user-container was slow to serve the admitted requests",
+		stats.UnitDimensionless)
+	generationMismatchCountM = stats.Int64(
+		"generation_mismatch_count",
+		"The number of requests meant for another generation of the revision than the pod's",
+		stats.UnitDimensionless)
+	requestCPUTimeM = stats.Float64(
+		"request_cpu_time",
+		"The CPU time the queue-proxy used serving the request in millisecond",
+		stats.UnitMilliseconds)
+	largeResponseCountM = stats.Int64(
+		"large_response_count",
+		"The number of responses whose body exceeded the configured size",
+		stats.UnitDimensionless)
+	resizeInducedWaitCountM = stats.Int64(
+		"resize_induced_wait_count",
+		"The number of requests that queued because the breaker's capacity was reduced",
+		stats.UnitDimensionless)
+	latencyAnomalyCountM = stats.Int64(
+		"latency_anomaly_count",
+		"The number of requests with a negative measured latency, recorded as zero",
+		stats.UnitDimensionless)
+	bufferingBackpressureCountM = stats.Int64(
+		"buffering_backpressure_count",
+		"The number of requests whose body wasn't buffered because the buffering budget was exhausted",
+		stats.UnitDimensionless)
+	trailersMissingCountM = stats.Int64(
+		"trailers_missing_count",
+		"The number of responses lacking an expected trailer",
+		stats.UnitDimensionless)
+	statusRewriteCountM = stats.Int64(
+		"status_rewrite_count",
+		"The number of responses whose status code was rewritten by queue-proxy",
+		stats.UnitDimensionless)
+	upstreamTTFBM = stats.Float64(
+		"upstream_ttfb",
+		"The time from sending the request to the user-container to receiving the first response byte in millisecond",
+		stats.UnitMilliseconds)
+	responseTTFBM = stats.Float64(
+		"response_time_to_first_byte",
+		"The time from getting a connection to the user-container, dialing it if needed, to receiving the first response byte in millisecond",
+		stats.UnitMilliseconds)
+	requestCostM = stats.Int64(
+		"request_cost",
+		"The number of concurrency slots an admitted request consumed",
+		stats.UnitDimensionless)
+	edgeLatencyM = stats.Float64(
+		"edge_latency",
+		"The time from the request starting to arrive on its connection to the response completing in millisecond",
+		stats.UnitMilliseconds)
+	breakerWaitLatenciesM = stats.Float64(
+		"breaker_wait_latencies",
+		"The time requests spent in the breaker until being admitted or rejected in millisecond",
+		stats.UnitMilliseconds)
+	requestBytesM = stats.Int64(
+		"request_bytes",
+		"The size of the request body read from the client",
+		stats.UnitBytes)
+	responseBytesM = stats.Int64(
+		"response_bytes",
+		"The size of the response body written to the client",
+		stats.UnitBytes)
+	tcpRetransmitsM = stats.Int64(
+		"tcp_retransmits",
+		"The number of TCP segments retransmitted on the connection while serving the request",
+		stats.UnitDimensionless)
+	metricsHandlerErrorsM = stats.Int64(
+		"metrics_handler_errors",
+		"The number of requests the request metrics handler failed to record the metrics of as such",
+		stats.UnitDimensionless)
+	connectionAgeM = stats.Float64(
+		"connection_age",
+		"How long the connection serving a request has been open when the request arrived",
+		stats.UnitSeconds)
+
+	// causeKey tags why a queued request was cancelled.
+	causeKey = tag.MustNewKey("cause")
+	// reasonKey tags why a request was dropped.
+	reasonKey = tag.MustNewKey("reason")
+	// cacheStatusKey tags whether the response was served from a cache.
+	cacheStatusKey = tag.MustNewKey("cache_status")
+	// connectionKey tags whether the request arrived on a new connection.
+	connectionKey = tag.MustNewKey("connection")
+	// upstreamStatusKey tags the status code returned by the user container.
+	upstreamStatusKey = tag.MustNewKey("upstream_status")
+	// fromClassKey and toClassKey tag the status code classes of a rewritten
+	// response before and after the rewrite.
+	fromClassKey = tag.MustNewKey("from_class")
+	toClassKey   = tag.MustNewKey("to_class")
+	// actionKey tags what was done with a request facing backpressure.
+	actionKey = tag.MustNewKey("action")
+	// trailerKey tags the name of a missing trailer.
+	trailerKey = tag.MustNewKey("trailer")
+	// trailerValueKey tags the value of the allowlisted trailer.
+	trailerValueKey = tag.MustNewKey("trailer_value")
+	// outcomeKey tags whether a request waiting in the breaker was admitted,
+	// and how the retries of a request ended.
+	outcomeKey = tag.MustNewKey("outcome")
+	// queuedKey tags whether the request waited in the breaker's queue.
+	queuedKey = tag.MustNewKey("queued")
+	// contentTypeKey tags the media type of the response.
+	contentTypeKey = tag.MustNewKey("content_type")
+	// upstreamErrorKey tags the category of the error reaching the user
+	// container.
+	upstreamErrorKey = tag.MustNewKey("upstream_error")
+	// retryExhaustedKey tags whether all retries to reach the user container
+	// failed.
+	retryExhaustedKey = tag.MustNewKey("retry_exhausted")
+	// latencyUnitKey tags the unit request_latencies are recorded in.
+	latencyUnitKey = tag.MustNewKey("unit")
+	// protocolKey tags whether the request was a gRPC or plain HTTP request,
+	// or its HTTP version.
+	protocolKey = tag.MustNewKey("protocol")
 )
 
+const (
+	// Values of the cache_status tag.
+	cacheStatusHit  = "hit"
+	cacheStatusMiss = "miss"
+	cacheStatusNone = "none"
+
+	// Values of the connection tag.
+	connectionNew    = "new"
+	connectionReused = "reused"
+
+	// Values of the outcome tag.
+	waitOutcomeAdmitted = "admitted"
+	waitOutcomeRejected = "rejected"
+
+	// upstreamStatusNone is the upstream_status of requests that didn't get
+	// a response from the user container.
+	upstreamStatusNone = "none"
+
+	// Values of the content_type tag for responses without a content type
+	// and with one that isn't allowlisted.
+	contentTypeNone  = "none"
+	contentTypeOther = "other"
+
+	// Values of the trailer_value tag for responses without the trailer and
+	// with a value that isn't allowlisted.
+	trailerValueNone  = "none"
+	trailerValueOther = "other"
+
+	// Values of the upstream_error tag.
+	upstreamErrorNone              = "none"
+	upstreamErrorConnectionRefused = "connection_refused"
+	upstreamErrorConnectionReset   = "connection_reset"
+	upstreamErrorTimeout           = "timeout"
+	upstreamErrorCancelled         = "cancelled"
+	upstreamErrorOther             = "other"
+
+	// LatencyUnitMilliseconds and LatencyUnitMicroseconds are the units
+	// request_latencies can be recorded in.
+	LatencyUnitMilliseconds = stats.UnitMilliseconds
+	LatencyUnitMicroseconds = "us"
+)
+
+type requestMetricsStateKey struct{}
+
+// requestMetricsState carries observations made by the handlers wrapped by the
+// request metrics handler back to it, so they can be recorded alongside the
+// request's own metrics. Handlers below the timeout handler might still run
+// when the request metrics handler returns, hence the locking.
+type requestMetricsState struct {
+	mux               sync.Mutex
+	cancellationCause string
+	dropReason        string
+	burstAdmission    bool
+	queued            bool
+	cost              int
+	afterRestart      bool
+	upstreamStatus    int
+	slowUpstream      bool
+	resizeInduced     bool
+	retryExhausted    bool
+	proxyRetries      int
+	retryOutcome      string
+	upstreamError     string
+	backpressure      string
+	upstreamTTFB      time.Duration
+	hasUpstreamTTFB   bool
+	responseTTFB      time.Duration
+	hasResponseTTFB   bool
+	stageMarks        [numStageMarks]time.Time
+	deadline          time.Time
+	propagation       string
+	rewrittenFrom     int
+	rewrittenTo       int
+}
+
+// requestMetricsStateFrom returns the requestMetricsState attached to the
+// request context by the request metrics handler, or nil.
+func requestMetricsStateFrom(ctx context.Context) *requestMetricsState {
+	state, _ := ctx.Value(requestMetricsStateKey{}).(*requestMetricsState)
+	return state
+}
+
+func (s *requestMetricsState) setCancellationCause(cause string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cancellationCause = cause
+}
+
+func (s *requestMetricsState) getCancellationCause() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.cancellationCause
+}
+
+func (s *requestMetricsState) setDropReason(reason string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.dropReason = reason
+}
+
+func (s *requestMetricsState) getDropReason() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.dropReason
+}
+
+func (s *requestMetricsState) setBurstAdmission() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.burstAdmission = true
+}
+
+func (s *requestMetricsState) getBurstAdmission() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.burstAdmission
+}
+
+func (s *requestMetricsState) setQueued() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.queued = true
+}
+
+func (s *requestMetricsState) getQueued() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.queued
+}
+
+func (s *requestMetricsState) setCost(cost int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cost = cost
+}
+
+func (s *requestMetricsState) getCost() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.cost
+}
+
+func (s *requestMetricsState) setAfterRestart() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.afterRestart = true
+}
+
+func (s *requestMetricsState) getAfterRestart() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.afterRestart
+}
+
+// setDeadlinePropagation records whether the request's deadline was passed to
+// the user container, as deadlinePropagated or deadlineNotPropagated.
+func (s *requestMetricsState) setDeadlinePropagation(propagation string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.propagation = propagation
+}
+
+func (s *requestMetricsState) getDeadlinePropagation() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.propagation
+}
+
+func (s *requestMetricsState) setSlowUpstreamQueueing() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.slowUpstream = true
+}
+
+func (s *requestMetricsState) getSlowUpstreamQueueing() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.slowUpstream
+}
+
+func (s *requestMetricsState) setResizeInducedWait() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.resizeInduced = true
+}
+
+func (s *requestMetricsState) getResizeInducedWait() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.resizeInduced
+}
+
+// setUpstreamError keeps the category of the first error reaching the user
+// container.
+func (s *requestMetricsState) setUpstreamError(category string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.upstreamError == "" {
+		s.upstreamError = category
+	}
+}
+
+func (s *requestMetricsState) getUpstreamError() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.upstreamError
+}
+
+func (s *requestMetricsState) setBackpressureAction(action string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.backpressure = action
+}
+
+func (s *requestMetricsState) getBackpressureAction() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.backpressure
+}
+
+func (s *requestMetricsState) setStatusRewrite(from, to int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.rewrittenFrom, s.rewrittenTo = from, to
+}
+
+// getStatusRewrite returns the original and the rewritten status code, or
+// zeros if the status code wasn't rewritten.
+func (s *requestMetricsState) getStatusRewrite() (int, int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.rewrittenFrom, s.rewrittenTo
+}
+
+func (s *requestMetricsState) setRetryExhausted() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.retryExhausted = true
+}
+
+func (s *requestMetricsState) getRetryExhausted() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.retryExhausted
+}
+
+// setProxyRetries records how often the request was sent to the user
+// container again and how that ended.
+func (s *requestMetricsState) setProxyRetries(retries int, outcome string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.proxyRetries, s.retryOutcome = retries, outcome
+}
+
+func (s *requestMetricsState) getProxyRetries() (int, string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.proxyRetries, s.retryOutcome
+}
+
+// setUpstreamTTFB keeps the time to first byte of the last attempt to reach
+// the user container.
+func (s *requestMetricsState) setUpstreamTTFB(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.upstreamTTFB = d
+	s.hasUpstreamTTFB = true
+}
+
+func (s *requestMetricsState) getUpstreamTTFB() (time.Duration, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.upstreamTTFB, s.hasUpstreamTTFB
+}
+
+// setResponseTTFB keeps the time to first byte of the last attempt to reach
+// the user container, including getting a connection.
+func (s *requestMetricsState) setResponseTTFB(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.responseTTFB = d
+	s.hasResponseTTFB = true
+}
+
+func (s *requestMetricsState) getResponseTTFB() (time.Duration, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.responseTTFB, s.hasResponseTTFB
+}
+
+func (s *requestMetricsState) setUpstreamStatus(code int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.upstreamStatus = code
+}
+
+func (s *requestMetricsState) getUpstreamStatus() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.upstreamStatus
+}
+
+// UpstreamStatusTransport wraps the transport to the user container to pass
+// the status codes it returns to the request metrics handler, which can then
+// record them even if queue-proxy responds with a different status.
+func UpstreamStatusTransport(next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err == nil {
+			if state := requestMetricsStateFrom(r.Context()); state != nil {
+				state.setUpstreamStatus(resp.StatusCode)
+			}
+		}
+		return resp, err
+	})
+}
+
+// UpstreamErrorTransport wraps the transport to the user container to pass
+// the category of the first error reaching it to the request metrics handler.
+func UpstreamErrorTransport(next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err != nil {
+			if state := requestMetricsStateFrom(r.Context()); state != nil {
+				state.setUpstreamError(upstreamErrorCategory(err))
+			}
+		}
+		return resp, err
+	})
+}
+
+// UpstreamTTFBTransport wraps the transport to the user container to pass the
+// time from having sent the request headers to receiving the first byte of the
+// response to the request metrics handler. This isolates the time the user
+// container takes to respond from the queueing and connection setup. It also
+// passes the time from getting a connection, dialing it if needed, to the
+// first byte, which includes the connection setup but still excludes the
+// transfer of the response body.
+func UpstreamTTFBTransport(next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		state := requestMetricsStateFrom(r.Context())
+		if state == nil {
+			return next.RoundTrip(r)
+		}
+		// The hooks run on the transport's write and read goroutines.
+		var (
+			mux        sync.Mutex
+			connecting time.Time
+			sent       time.Time
+		)
+		trace := &httptrace.ClientTrace{
+			GetConn: func(string) {
+				mux.Lock()
+				defer mux.Unlock()
+				connecting = time.Now()
+			},
+			WroteHeaders: func() {
+				mux.Lock()
+				defer mux.Unlock()
+				sent = time.Now()
+			},
+			GotFirstResponseByte: func() {
+				mux.Lock()
+				defer mux.Unlock()
+				if !sent.IsZero() {
+					state.setUpstreamTTFB(time.Since(sent))
+				}
+				if !connecting.IsZero() {
+					state.setResponseTTFB(time.Since(connecting))
+				}
+			},
+		}
+		return next.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+	})
+}
+
+// upstreamErrorCategory maps an error returned by the transport to one of the
+// bounded values of the upstream_error tag.
+func upstreamErrorCategory(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return upstreamErrorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return upstreamErrorConnectionReset
+	case errors.Is(err, context.Canceled):
+		return upstreamErrorCancelled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return upstreamErrorTimeout
+	default:
+		return upstreamErrorOther
+	}
+}
+
+// recordDrop marks the request as dropped for the given reason, to be
+// recorded by the request metrics handler.
+func recordDrop(r *http.Request, reason string) {
+	if state := requestMetricsStateFrom(r.Context()); state != nil {
+		state.setDropReason(reason)
+	}
+}
+
 type requestMetricsHandler struct {
 	next     http.Handler
 	statsCtx context.Context
+	clock    clock.PassiveClock
+
+	// cacheStatusHeader is the response header to derive the cache_status
+	// tag from, if not empty.
+	cacheStatusHeader string
+	// connectionReuse enables the connection tag.
+	connectionReuse bool
+	// connectionAge enables the connection_age metric.
+	connectionAge bool
+	// responseFlushTime enables the response_flush_time metric.
+	responseFlushTime bool
+	// bodySizes enables the request_bytes and response_bytes metrics.
+	bodySizes bool
+	// upstreamStatus enables the upstream_status tag.
+	upstreamStatus bool
+	// retryExhausted enables the retry_exhausted tag.
+	retryExhausted bool
+	// upstreamError enables the upstream_error tag.
+	upstreamError bool
+	// contentTypes are the media types the content_type tag distinguishes,
+	// the tag is enabled if not nil.
+	contentTypes map[string]struct{}
+	// socketInfo reads the retransmits for tcp_retransmits, which is enabled
+	// if set.
+	socketInfo SocketInfoProvider
+	// queueTimeRatio observes the requests for aggregate_queue_time_ratio,
+	// which is enabled if set.
+	queueTimeRatio *QueueTimeRatioReporter
+	// exemplars enables attaching the request's trace context to
+	// request_latencies.
+	exemplars bool
+	// handlerErrors enables the metrics_handler_errors metric.
+	handlerErrors bool
+	// grpcStatus enables the protocol tag and gRPC status codes as response
+	// codes.
+	grpcStatus bool
+	// protocolTag enables the protocol tag with the HTTP version, taken from
+	// protocolHeader if set.
+	protocolTag    bool
+	protocolHeader string
+	// generationHeader, if set, carries the generation requests are meant
+	// for, counted in generation_mismatch_count unless it's generation.
+	generationHeader string
+	generation       string
+	// largeResponseBytes, if positive, is the response body size above which
+	// responses are counted in large_response_count.
+	largeResponseBytes int64
+	// cpuTime enables the request_cpu_time metric.
+	cpuTime bool
+	// routeTags are the route tags told apart, others are collapsed into
+	// routeTagOverflow, if not nil.
+	routeTags map[string]struct{}
+	// latencyUnit is the unit of request_latencies, tagged as such if set.
+	latencyUnit string
+	// queuedTag enables the queued tag on request_latencies.
+	queuedTag bool
+	// edgeLatency enables the edge_latency metric.
+	edgeLatency bool
+	// upstreamTTFB enables the upstream_ttfb metric.
+	upstreamTTFB bool
+	// responseTTFB enables the response_time_to_first_byte metric.
+	responseTTFB bool
+	// stageDurations enables the request_stage_duration metric.
+	stageDurations bool
+	// requestCost enables the request_cost metric.
+	requestCost bool
+	// deadlineFraction enables the pre_admission_deadline_fraction metric.
+	deadlineFraction bool
+	// deadlinePropagation enables the deadline_propagated_count and
+	// deadline_not_propagated_count metrics.
+	deadlinePropagation bool
+	// expectedTrailers are the trailers whose absence is counted.
+	expectedTrailers []string
+	// valueTrailer is the trailer whose value the trailer_value tag carries,
+	// the tag is enabled if not empty. trailerValues are the values it
+	// distinguishes.
+	valueTrailer  string
+	trailerValues map[string]struct{}
+}
+
+// RequestMetricsOption configures optional behavior of the request metrics
+// handler.
+type RequestMetricsOption func(*requestMetricsHandler)
+
+// WithCacheStatusHeader makes the request metrics handler tag request_count
+// with whether the response was served from a cache, as indicated by the
+// given response header, e.g. X-Cache or Age.
+func WithCacheStatusHeader(header string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.cacheStatusHeader = http.CanonicalHeaderKey(header)
+	}
 }
 
 type appRequestMetricsHandler struct {
 	next     http.Handler
 	statsCtx context.Context
+	clock    clock.PassiveClock
 	breaker  *Breaker
 }
 
+// WithConnectionReuseTag makes the request metrics handler tag request_count
+// with whether the request was the first on its connection or reused it. This
+// requires ConnContext to be set on the server.
+func WithConnectionReuseTag() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.connectionReuse = true
+	}
+}
+
+// WithConnectionAge makes the request metrics handler record connection_age,
+// how long the connection serving a request has been open when the request
+// arrived. This requires ConnContext to be set on the server.
+func WithConnectionAge() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.connectionAge = true
+	}
+}
+
+// WithResponseFlushTime makes the request metrics handler record
+// response_flush_time, the time spent handing the response to the client,
+// which tells slow clients apart from a slow user container.
+func WithResponseFlushTime() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.responseFlushTime = true
+	}
+}
+
+// WithBodySizes makes the request metrics handler record request_bytes and
+// response_bytes, the size of the request body read from the client and of
+// the response body written to it, tagged like request_count. Bodies of
+// unknown length are covered, too.
+func WithBodySizes() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.bodySizes = true
+	}
+}
+
+// WithUpstreamStatusTag makes the request metrics handler tag request_count
+// with the status code returned by the user container, independent of the
+// response_code returned to the client. This requires the transport to the
+// user container to be wrapped with UpstreamStatusTransport.
+func WithUpstreamStatusTag() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.upstreamStatus = true
+	}
+}
+
+// WithContentTypeTag makes the request metrics handler tag request_count with
+// the media type of the response's Content-Type header, without parameters.
+// Only the given media types are told apart, others are tagged as other,
+// which bounds the tag's cardinality.
+func WithContentTypeTag(allowlist []string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.contentTypes = make(map[string]struct{}, len(allowlist))
+		for _, t := range allowlist {
+			h.contentTypes[strings.ToLower(t)] = struct{}{}
+		}
+	}
+}
+
+// WithTCPRetransmits makes the request metrics handler record the number of
+// TCP segments retransmitted on the request's connection since the previous
+// request on it finished, as read by the given provider, in tcp_retransmits.
+// Connections the provider has no statistics for aren't recorded. This
+// requires ConnContext to be set on the server.
+func WithTCPRetransmits(p SocketInfoProvider) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.socketInfo = p
+	}
+}
+
+// WithQueueTimeRatio makes the request metrics handler pass the time every
+// request waited in the breaker's queue and the time it took overall to the
+// given reporter, which records the aggregate_queue_time_ratio of all
+// requests.
+func WithQueueTimeRatio(r *QueueTimeRatioReporter) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.queueTimeRatio = r
+	}
+}
+
+// WithLatencyExemplars makes the request metrics handler attach the trace
+// context of requests carrying a W3C traceparent header to request_latencies
+// as an exemplar of the bucket the latency falls into. This lets tracing
+// backends link latency buckets to representative traces. Requests without a
+// trace context are recorded as usual.
+func WithLatencyExemplars() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.exemplars = true
+	}
+}
+
+// WithGRPCStatus makes the request metrics handler record the gRPC status of
+// gRPC requests, told apart by their application/grpc content type, from the
+// grpc-status trailer as their response_code, classified like the HTTP status
+// code it conventionally maps to, e.g. 14 (Unavailable) as 5xx. gRPC responses
+// without a valid status and other requests keep their HTTP status code. The
+// request metrics are tagged with the protocol, grpc or http, to tell the
+// codes apart.
+func WithGRPCStatus() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.grpcStatus = true
+	}
+}
+
+// WithProtocolTag makes the request metrics handler tag the request metrics
+// with the protocol, the request's HTTP version, e.g. HTTP/2.0 or HTTP/3.0,
+// and other for unexpected versions. Since queue-proxy doesn't serve HTTP/3
+// itself, the version is taken from the given header, e.g. set by the edge
+// proxy that terminated the connection, if the request has it. Combined with
+// WithGRPCStatus, gRPC requests are still tagged as grpc.
+func WithProtocolTag(header string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.protocolTag = true
+		h.protocolHeader = header
+	}
+}
+
+// WithGenerationMismatch makes the request metrics handler count the requests
+// whose header carries another generation than the given one, the pod's own,
+// in generation_mismatch_count. Such requests were routed to the wrong pod.
+// Requests without the header aren't counted.
+func WithGenerationMismatch(header, generation string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.generationHeader = header
+		h.generation = generation
+	}
+}
+
+// WithLargeResponseThreshold makes the request metrics handler count the
+// responses whose body exceeds the given number of bytes in
+// large_response_count, tagged with the route tag. This spots endpoints
+// answering with accidentally large responses, e.g. unpaginated lists.
+func WithLargeResponseThreshold(bytes int64) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.largeResponseBytes = bytes
+	}
+}
+
+// WithCPUTime makes the request metrics handler record the CPU time the
+// goroutine serving a request used in request_cpu_time, with the same tags as
+// request_latencies. Compared to the latency, it tells CPU-heavy requests
+// from those waiting on IO. This locks the goroutine to its OS thread for the
+// duration of the request. On platforms that can't measure the CPU time of a
+// thread, nothing is recorded.
+func WithCPUTime() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.cpuTime = true
+	}
+}
+
+// WithMetricsHandlerErrors makes the request metrics handler count the
+// requests it failed to record the metrics of as such, e.g. because of an
+// invalid route tag, in metrics_handler_errors. Such requests are served
+// regardless.
+func WithMetricsHandlerErrors() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.handlerErrors = true
+	}
+}
+
+// WithRouteTagAllowlist makes the request metrics handler record only the
+// given route tags as such, collapsing any other tag into __overflow__, which
+// bounds the cardinality of the metrics tagged with the route tag. Tags not
+// named by the request, like DEFAULT, are kept. A nil allowlist keeps all
+// tags.
+func WithRouteTagAllowlist(allowlist []string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		if allowlist == nil {
+			return
+		}
+		h.routeTags = make(map[string]struct{}, len(allowlist))
+		for _, t := range allowlist {
+			h.routeTags[t] = struct{}{}
+		}
+	}
+}
+
+// WithUpstreamErrorTag makes the request metrics handler tag request_count
+// with the category of the first error reaching the user container, e.g.
+// connection_refused or timeout, or none. This requires the transport to the
+// user container to be wrapped with UpstreamErrorTransport.
+func WithUpstreamErrorTag() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.upstreamError = true
+	}
+}
+
+// WithRetryExhaustedTag makes the request metrics handler tag request_count
+// with whether the request failed on every attempt to reach the user
+// container, as opposed to failing on its first attempt or succeeding. This
+// requires the transport to the user container to be wrapped with
+// RetryTransport.
+func WithRetryExhaustedTag() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.retryExhausted = true
+	}
+}
+
+// WithLatencyUnit makes the request metrics handler record request_latencies
+// in the given unit, LatencyUnitMilliseconds or LatencyUnitMicroseconds, and
+// tag them with it so that scrapers know the unit.
+func WithLatencyUnit(unit string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.latencyUnit = unit
+	}
+}
+
+// WithQueuedTag makes the request metrics handler tag request_latencies with
+// whether the request waited in the breaker's queue before being admitted, so
+// that the latency of queued and immediately admitted requests can be told
+// apart.
+func WithQueuedTag() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.queuedTag = true
+	}
+}
+
+// WithEdgeLatency makes the request metrics handler record edge_latency, the
+// time from the connection being accepted, or from the first byte of a later
+// request on it being received, to the response completing. Unlike
+// request_latencies this includes the time spent reading and parsing the
+// request headers. This requires the server's listener to be wrapped with
+// EdgeTimingListener and ConnContext to be set on the server. HTTP/2 requests
+// share their connection and aren't recorded.
+func WithEdgeLatency() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.edgeLatency = true
+	}
+}
+
+// WithUpstreamTTFB makes the request metrics handler record upstream_ttfb, the
+// time the user container took to send the first byte of its response. This
+// requires the transport to the user container to be wrapped with
+// UpstreamTTFBTransport.
+func WithUpstreamTTFB() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.upstreamTTFB = true
+	}
+}
+
+// WithResponseTimeToFirstByte makes the request metrics handler record
+// response_time_to_first_byte, the time from getting a connection to the user
+// container to receiving the first byte of its response, tagged like
+// request_latencies. Unlike request_latencies, it excludes the queueing and
+// the transfer of the response body. This requires the transport to the user
+// container to be wrapped with UpstreamTTFBTransport.
+func WithResponseTimeToFirstByte() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.responseTTFB = true
+	}
+}
+
+// WithStageDurations makes the request metrics handler record
+// request_stage_duration, the time each request spent in the middleware in
+// front of the breaker, waiting for admission, waiting for the user
+// container's response and writing the response, tagged by stage. This
+// requires the transport to the user container to be wrapped with
+// StageTimingTransport.
+func WithStageDurations() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.stageDurations = true
+	}
+}
+
+// WithRequestCostDistribution makes the request metrics handler record
+// request_cost, the number of concurrency slots each admitted request
+// consumed in the breaker (see WithRequestCost), showing the mix of light and
+// heavy requests.
+func WithRequestCostDistribution() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.requestCost = true
+	}
+}
+
+// WithPreAdmissionDeadlineFraction makes the request metrics handler record
+// pre_admission_deadline_fraction, the fraction of the time to a request's
+// deadline it spent waiting for admission by the breaker, showing whether
+// queueing alone puts deadlines at risk. Requests without a deadline aren't
+// recorded.
+func WithPreAdmissionDeadlineFraction() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.deadlineFraction = true
+	}
+}
+
+// WithDeadlinePropagation makes the request metrics handler count the
+// requests reaching the user container with their remaining deadline in
+// deadline_propagated_count and the others in deadline_not_propagated_count.
+// This requires the transport to the user container to be wrapped with
+// DeadlinePropagationTransport.
+func WithDeadlinePropagation() RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.deadlinePropagation = true
+	}
+}
+
+// WithExpectedTrailers makes the request metrics handler count responses
+// lacking any of the given trailers in trailers_missing_count, tagged with the
+// missing trailer. Missing trailers often indicate truncated responses.
+func WithExpectedTrailers(names []string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.expectedTrailers = make([]string, 0, len(names))
+		for _, n := range names {
+			h.expectedTrailers = append(h.expectedTrailers, http.CanonicalHeaderKey(n))
+		}
+	}
+}
+
+// WithTrailerValueTag makes the request metrics handler tag request_count with
+// the value of the given response trailer, e.g. grpc-status. Only the given
+// values are told apart, others are tagged as other, which bounds the tag's
+// cardinality.
+func WithTrailerValueTag(name string, allowlist []string) RequestMetricsOption {
+	return func(h *requestMetricsHandler) {
+		h.valueTrailer = http.CanonicalHeaderKey(name)
+		h.trailerValues = make(map[string]struct{}, len(allowlist))
+		for _, v := range allowlist {
+			h.trailerValues[v] = struct{}{}
+		}
+	}
+}
+
 // NewRequestMetricsHandler creates an http.Handler that emits request metrics.
 func NewRequestMetricsHandler(next http.Handler,
-	ns, service, config, rev, pod string, annotations map[string]string, labels map[string]string) (http.Handler, error) {
+	ns, service, config, rev, pod string, annotations map[string]string, labels map[string]string,
+	opts ...RequestMetricsOption) (http.Handler, error) {
+	h := &requestMetricsHandler{next: next, clock: clock.RealClock{}}
+	for _, opt := range opts {
+		opt(h)
+	}
+
 	keys := []tag.Key{metrics.PodKey, metrics.ContainerKey, metrics.ResponseCodeKey, metrics.ResponseCodeClassKey, metrics.RouteTagKey}
+	if h.grpcStatus || h.protocolTag {
+		keys = append(keys, protocolKey)
+	}
+	latencyView := &view.View{
+		Description: "The response time in millisecond",
+		Measure:     responseTimeInMsecM,
+		Aggregation: defaultLatencyDistribution,
+		TagKeys:     keys,
+	}
+	switch h.latencyUnit {
+	case "":
+	case LatencyUnitMilliseconds:
+		latencyView.TagKeys = append(keys[:len(keys):len(keys)], latencyUnitKey)
+	case LatencyUnitMicroseconds:
+		// The view keeps the name of the millisecond measure so dashboards
+		// find it, the measure and the unit tag tell the unit apart.
+		latencyView.Name = responseTimeInMsecM.Name()
+		latencyView.Description = "The response time in microsecond"
+		latencyView.Measure = responseTimeInUsecM
+		latencyView.Aggregation = microsLatencyDistribution
+		latencyView.TagKeys = append(keys[:len(keys):len(keys)], latencyUnitKey)
+	default:
+		return nil, fmt.Errorf("unsupported latency unit %q", h.latencyUnit)
+	}
+	if h.queuedTag {
+		tagKeys := latencyView.TagKeys
+		latencyView.TagKeys = append(tagKeys[:len(tagKeys):len(tagKeys)], queuedKey)
+	}
+
+	countKeys := keys[:len(keys):len(keys)]
+	if h.cacheStatusHeader != "" {
+		countKeys = append(countKeys, cacheStatusKey)
+	}
+	if h.connectionReuse {
+		countKeys = append(countKeys, connectionKey)
+	}
+	if h.upstreamStatus {
+		countKeys = append(countKeys, upstreamStatusKey)
+	}
+	if h.retryExhausted {
+		countKeys = append(countKeys, retryExhaustedKey)
+	}
+	if h.upstreamError {
+		countKeys = append(countKeys, upstreamErrorKey)
+	}
+	if h.contentTypes != nil {
+		countKeys = append(countKeys, contentTypeKey)
+	}
+	if h.valueTrailer != "" {
+		countKeys = append(countKeys, trailerValueKey)
+	}
+	if h.edgeLatency {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The time from the request starting to arrive on its connection to the response completing in millisecond",
+			Measure:     edgeLatencyM,
+			Aggregation: defaultLatencyDistribution,
+			TagKeys:     keys,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.stageDurations {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The time a request spent in each stage of the queue-proxy pipeline in millisecond",
+			Measure:     requestStageDurationM,
+			Aggregation: defaultLatencyDistribution,
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, stageKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.requestCost {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The number of concurrency slots an admitted request consumed",
+			Measure:     requestCostM,
+			Aggregation: requestCostDistribution,
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.deadlineFraction {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The fraction of the time to a request's deadline spent waiting for admission by the breaker",
+			Measure:     preAdmissionDeadlineFractionM,
+			Aggregation: preAdmissionDeadlineFractionDistribution,
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.deadlinePropagation {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The number of requests whose remaining deadline was passed to the user-container",
+			Measure:     deadlinePropagatedCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}, &view.View{
+			Description: "The number of requests passed to the user-container without a deadline",
+			Measure:     deadlineNotPropagatedCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.handlerErrors {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The number of requests the request metrics handler failed to record the metrics of as such",
+			Measure:     metricsHandlerErrorsM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.socketInfo != nil {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The number of TCP segments retransmitted on the connection while serving the request",
+			Measure:     tcpRetransmitsM,
+			Aggregation: retransmitsDistribution,
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.generationHeader != "" {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The number of requests meant for another generation of the revision than the pod's",
+			Measure:     generationMismatchCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.cpuTime && cpuTimeSupported {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The CPU time the queue-proxy used serving the request in millisecond",
+			Measure:     requestCPUTimeM,
+			Aggregation: defaultLatencyDistribution,
+			TagKeys:     keys,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.largeResponseBytes > 0 {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The number of responses whose body exceeded the configured size",
+			Measure:     largeResponseCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, metrics.RouteTagKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.upstreamTTFB {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The time from sending the request to the user-container to receiving the first response byte in millisecond",
+			Measure:     upstreamTTFBM,
+			Aggregation: defaultLatencyDistribution,
+			TagKeys:     keys,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.connectionAge {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "How long the connection serving a request has been open when the request arrived",
+			Measure:     connectionAgeM,
+			Aggregation: connectionAgeDistribution,
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.bodySizes {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The size of the request body read from the client",
+			Measure:     requestBytesM,
+			Aggregation: bytesDistribution,
+			TagKeys:     keys,
+		}, &view.View{
+			Description: "The size of the response body written to the client",
+			Measure:     responseBytesM,
+			Aggregation: bytesDistribution,
+			TagKeys:     keys,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.responseFlushTime {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The time spent handing the response to the client in millisecond",
+			Measure:     responseFlushTimeInMsecM,
+			Aggregation: defaultLatencyDistribution,
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if h.responseTTFB {
+		if err := pkgmetrics.RegisterResourceView(&view.View{
+			Description: "The time from getting a connection to the user-container, dialing it if needed, to receiving the first response byte in millisecond",
+			Measure:     responseTTFBM,
+			Aggregation: defaultLatencyDistribution,
+			TagKeys:     keys,
+		}); err != nil {
+			return nil, err
+		}
+	}
 	if err := pkgmetrics.RegisterResourceView(
 		&view.View{
 			Description: "The number of requests that are routed to queue-proxy",
 			Measure:     requestCountM,
 			Aggregation: view.Count(),
-			TagKeys:     keys,
+			TagKeys:     countKeys,
 		},
+		latencyView,
 		&view.View{
-			Description: "The response time in millisecond",
-			Measure:     responseTimeInMsecM,
-			Aggregation: defaultLatencyDistribution,
+			Description: "The number of probe requests that are routed to queue-proxy",
+			Measure:     probeRequestCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		},
+		&view.View{
+			Description: "The number of requests that left the queue before being admitted",
+			Measure:     queueCancellationCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, causeKey},
+		},
+		&view.View{
+			Description: "The number of requests rejected by queue-proxy before reaching user-container",
+			Measure:     droppedRequestCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, reasonKey, metrics.RouteTagKey},
+		},
+		&view.View{
+			Description: "The number of requests admitted using the breaker's burst capacity",
+			Measure:     burstAdmissionCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		},
+		&view.View{
+			Description: "The number of requests among the first ones served after the user-container restarted",
+			Measure:     afterRestartRequestCountM,
+			Aggregation: view.Count(),
 			TagKeys:     keys,
 		},
+		&view.View{
+			Description: "The number of times requests were sent to the user-container again after a retryable failure",
+			Measure:     proxyRetriesM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, outcomeKey},
+		},
+		&view.View{
+			Description: "The number of requests whose client asked for the connection to be closed afterwards",
+			Measure:     clientCloseRequestedCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		},
+		&view.View{
+			Description: "The number of requests that queued because the user-container was slow to serve the admitted requests",
+			Measure:     slowUpstreamQueueingCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		},
+		&view.View{
+			Description: "The number of requests that queued because the breaker's capacity was reduced",
+			Measure:     resizeInducedWaitCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		},
+		&view.View{
+			Description: "The number of requests whose body wasn't buffered because the buffering budget was exhausted",
+			Measure:     bufferingBackpressureCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, actionKey},
+		},
+		&view.View{
+			Description: "The number of responses lacking an expected trailer",
+			Measure:     trailersMissingCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, trailerKey},
+		},
+		&view.View{
+			Description: "The number of responses whose status code was rewritten by queue-proxy",
+			Measure:     statusRewriteCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, fromClassKey, toClassKey},
+		},
+		&view.View{
+			Description: "The number of requests with a negative measured latency, recorded as zero",
+			Measure:     latencyAnomalyCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+		},
 	); err != nil {
 		return nil, err
 	}
@@ -98,42 +1411,489 @@ func NewRequestMetricsHandler(next http.Handler,
 		return nil, err
 	}
 
-	return &requestMetricsHandler{
-		next:     next,
-		statsCtx: ctx,
-	}, nil
+	if h.latencyUnit != "" {
+		ctx, err = tag.New(ctx, tag.Upsert(latencyUnitKey, h.latencyUnit))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h.statsCtx = ctx
+	return h, nil
+}
+
+// latencyContext returns ctx with the tags only request_latencies carries.
+func (h *requestMetricsHandler) latencyContext(ctx context.Context, state *requestMetricsState) context.Context {
+	if h.queuedTag {
+		ctx, _ = tag.New(ctx, tag.Upsert(queuedKey, strconv.FormatBool(state.getQueued())))
+	}
+	return ctx
+}
+
+// latencyOptions returns the options to record request_latencies of r with,
+// i.e. an exemplar of the request's trace context if enabled and present.
+func (h *requestMetricsHandler) latencyOptions(r *http.Request) []stats.Options {
+	if !h.exemplars {
+		return nil
+	}
+	sc, ok := (&tracecontext.HTTPFormat{}).SpanContextFromRequest(r)
+	if !ok {
+		return nil
+	}
+	return []stats.Options{stats.WithAttachments(metricdata.Attachments{
+		metricdata.AttachmentKeySpanContext: sc,
+	})}
+}
+
+// latency returns the measurement of the request latency d in the configured
+// unit.
+func (h *requestMetricsHandler) latency(d time.Duration) stats.Measurement {
+	if h.latencyUnit == LatencyUnitMicroseconds {
+		return responseTimeInUsecM.M(float64(d.Microseconds()))
+	}
+	return responseTimeInMsecM.M(float64(d.Milliseconds()))
+}
+
+// measureLatency returns the time passed since start on the monotonic clock.
+// A negative latency hints at a bug, it's counted as an anomaly and clamped
+// to zero so that it doesn't skew the latency distributions. The anomaly view
+// is registered by the request metrics handler.
+func measureLatency(ctx context.Context, c clock.PassiveClock, start time.Time) time.Duration {
+	latency := c.Since(start)
+	if latency < 0 {
+		pkgmetrics.Record(ctx, latencyAnomalyCountM.M(1))
+		return 0
+	}
+	return latency
+}
+
+// scaleBounds returns the bucket bounds multiplied by factor.
+func scaleBounds(bounds []float64, factor float64) []float64 {
+	ret := make([]float64, len(bounds))
+	for i, b := range bounds {
+		ret[i] = b * factor
+	}
+	return ret
 }
 
 func (h *requestMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var flush *flushTimer
+	if h.responseFlushTime {
+		flush = &flushTimer{ResponseWriter: w}
+		w = flush
+	}
 	rr := pkghttp.NewResponseRecorder(w, http.StatusOK)
-	startTime := time.Now()
+	startTime := h.clock.Now()
+
+	connection := ""
+	conn := connInfoFrom(r.Context())
+	if conn != nil {
+		connection = connectionReused
+		if conn.requests.Inc() == 1 {
+			connection = connectionNew
+		}
+		if h.connectionAge && !network.IsProbe(r) {
+			pkgmetrics.Record(h.statsCtx, connectionAgeM.M(startTime.Sub(conn.accepted).Seconds()))
+		}
+	}
+
+	// Read before the wrapped handlers get to prune hop-by-hop headers.
+	closeRequested := httpguts.HeaderValuesContainsToken(r.Header["Connection"], "close")
+	var generationMismatch bool
+	if h.generationHeader != "" {
+		generation := r.Header.Get(h.generationHeader)
+		generationMismatch = generation != "" && generation != h.generation
+	}
+
+	state := &requestMetricsState{}
+	r = r.WithContext(context.WithValue(r.Context(), requestMetricsStateKey{}, state))
+	var body *countingBody
+	if h.bodySizes {
+		body = &countingBody{}
+		if r.Body != nil && r.Body != http.NoBody {
+			// Without a body, the request isn't given one, which would make
+			// it look like one of unknown length.
+			body.ReadCloser = r.Body
+			r.Body = body
+		}
+	}
+
+	var cpu cpuTimer
+	if h.cpuTime {
+		cpu = startCPUTimer()
+	}
 
 	defer func() {
-		// Filter probe requests for revision metrics.
+		cpuTime, cpuTimeOK := cpu.stop()
+
+		// Filter probe requests for revision metrics, only counting them
+		// separately.
 		if network.IsProbe(r) {
+			pkgmetrics.Record(h.statsCtx, probeRequestCountM.M(1))
 			return
 		}
 
 		// If ServeHTTP panics, recover, record the failure and panic again.
 		err := recover()
-		latency := time.Since(startTime)
-		routeTag := GetRouteTagNameFromRequest(r)
+		latency := measureLatency(h.statsCtx, h.clock, startTime)
+		routeTag := h.routeTag(GetRouteTagNameFromRequest(r))
 		if err != nil {
-			ctx := metrics.AugmentWithResponseAndRouteTag(h.statsCtx,
-				http.StatusInternalServerError, routeTag)
-			pkgmetrics.RecordBatch(ctx, requestCountM.M(1),
-				responseTimeInMsecM.M(float64(latency.Milliseconds())))
+			ctx := h.augmentWithResponseAndRouteTag(r, nil, http.StatusInternalServerError, routeTag)
+			pkgmetrics.RecordBatch(h.latencyContext(ctx, state), requestCountM.M(1), h.latency(latency))
+			recordBodySizes(ctx, body, rr)
 			panic(err)
 		}
-		ctx := metrics.AugmentWithResponseAndRouteTag(h.statsCtx,
-			rr.ResponseCode, routeTag)
-		pkgmetrics.RecordBatch(ctx, requestCountM.M(1),
-			responseTimeInMsecM.M(float64(latency.Milliseconds())))
+		ctx := h.augmentWithResponseAndRouteTag(r, rr.Header(), rr.ResponseCode, routeTag)
+		pkgmetrics.Record(h.latencyContext(ctx, state), h.latency(latency), h.latencyOptions(r)...)
+		recordBodySizes(ctx, body, rr)
+		if h.largeResponseBytes > 0 && int64(rr.ResponseSize) > h.largeResponseBytes {
+			pkgmetrics.Record(ctx, largeResponseCountM.M(1))
+		}
+		if cpuTimeOK {
+			pkgmetrics.Record(ctx, requestCPUTimeM.M(float64(cpuTime)/float64(time.Millisecond)))
+		}
+		if h.socketInfo != nil && conn != nil {
+			if total, ok := h.socketInfo.Retransmits(conn.conn); ok {
+				// Concurrent HTTP/2 requests share the connection, each
+				// one records what the others didn't yet.
+				pkgmetrics.Record(h.statsCtx, tcpRetransmitsM.M(int64(total-conn.retransmits.Swap(total))))
+			}
+		}
+		if h.edgeLatency && conn != nil && conn.edge != nil && r.ProtoMajor == 1 {
+			edge := measureLatency(h.statsCtx, h.clock, conn.edge.requestStart())
+			conn.edge.markIdle()
+			pkgmetrics.Record(ctx, edgeLatencyM.M(durationMillis(edge)))
+		}
+		if h.queueTimeRatio != nil {
+			h.queueTimeRatio.observe(state.queueWait(startTime.Add(latency)), latency)
+		}
+		if h.stageDurations {
+			for stage, d := range state.stageDurations(startTime, startTime.Add(latency)) {
+				ctx, _ := tag.New(h.statsCtx, tag.Upsert(stageKey, stage))
+				pkgmetrics.Record(ctx, requestStageDurationM.M(durationMillis(d)))
+			}
+		}
+		if h.requestCost {
+			if cost := state.getCost(); cost > 0 {
+				pkgmetrics.Record(h.statsCtx, requestCostM.M(int64(cost)))
+			}
+		}
+		if h.deadlineFraction {
+			if f, ok := state.preAdmissionDeadlineFraction(); ok {
+				pkgmetrics.Record(h.statsCtx, preAdmissionDeadlineFractionM.M(f))
+			}
+		}
+		if h.deadlinePropagation {
+			switch state.getDeadlinePropagation() {
+			case deadlinePropagated:
+				pkgmetrics.Record(h.statsCtx, deadlinePropagatedCountM.M(1))
+			case deadlineNotPropagated:
+				pkgmetrics.Record(h.statsCtx, deadlineNotPropagatedCountM.M(1))
+			}
+		}
+		if h.upstreamTTFB {
+			if ttfb, ok := state.getUpstreamTTFB(); ok {
+				pkgmetrics.Record(ctx, upstreamTTFBM.M(durationMillis(ttfb)))
+			}
+		}
+		if h.responseTTFB {
+			if ttfb, ok := state.getResponseTTFB(); ok {
+				pkgmetrics.Record(ctx, responseTTFBM.M(durationMillis(ttfb)))
+			}
+		}
+		if h.cacheStatusHeader != "" {
+			status := cacheStatus(h.cacheStatusHeader, rr.Header().Get(h.cacheStatusHeader))
+			ctx, _ = tag.New(ctx, tag.Upsert(cacheStatusKey, status))
+		}
+		if h.connectionReuse && connection != "" {
+			ctx, _ = tag.New(ctx, tag.Upsert(connectionKey, connection))
+		}
+		if h.upstreamStatus {
+			status := upstreamStatusNone
+			if code := state.getUpstreamStatus(); code != 0 {
+				status = strconv.Itoa(code)
+			}
+			ctx, _ = tag.New(ctx, tag.Upsert(upstreamStatusKey, status))
+		}
+		if h.retryExhausted {
+			ctx, _ = tag.New(ctx, tag.Upsert(retryExhaustedKey, strconv.FormatBool(state.getRetryExhausted())))
+		}
+		if h.upstreamError {
+			category := state.getUpstreamError()
+			if category == "" {
+				category = upstreamErrorNone
+			}
+			ctx, _ = tag.New(ctx, tag.Upsert(upstreamErrorKey, category))
+		}
+		if h.contentTypes != nil {
+			ctx, _ = tag.New(ctx, tag.Upsert(contentTypeKey, h.contentType(rr.Header().Get("Content-Type"))))
+		}
+		if h.valueTrailer != "" {
+			ctx, _ = tag.New(ctx, tag.Upsert(trailerValueKey, h.trailerValue(rr.Header())))
+		}
+		for _, name := range h.expectedTrailers {
+			if _, ok := trailer(rr.Header(), name); !ok {
+				ctx, _ := tag.New(h.statsCtx, tag.Upsert(trailerKey, strings.ToLower(name)))
+				pkgmetrics.Record(ctx, trailersMissingCountM.M(1))
+			}
+		}
+		pkgmetrics.Record(ctx, requestCountM.M(1))
+		if state.getAfterRestart() {
+			pkgmetrics.Record(ctx, afterRestartRequestCountM.M(1))
+		}
+		if flush != nil {
+			pkgmetrics.Record(h.statsCtx, responseFlushTimeInMsecM.M(durationMillis(flush.elapsed)))
+		}
+
+		if cause := state.getCancellationCause(); cause != "" {
+			ctx, _ := tag.New(h.statsCtx, tag.Upsert(causeKey, cause))
+			pkgmetrics.Record(ctx, queueCancellationCountM.M(1))
+		}
+		if reason := state.getDropReason(); reason != "" {
+			// An invalid route tag fails this just like it failed tagging
+			// the request's other metrics, which was counted already.
+			ctx, _ := tag.New(h.statsCtx, tag.Upsert(reasonKey, reason), tag.Upsert(metrics.RouteTagKey, routeTag))
+			pkgmetrics.Record(ctx, droppedRequestCountM.M(1))
+		}
+		if state.getBurstAdmission() {
+			pkgmetrics.Record(h.statsCtx, burstAdmissionCountM.M(1))
+		}
+		if generationMismatch {
+			pkgmetrics.Record(h.statsCtx, generationMismatchCountM.M(1))
+		}
+		if closeRequested {
+			pkgmetrics.Record(h.statsCtx, clientCloseRequestedCountM.M(1))
+		}
+		if retries, outcome := state.getProxyRetries(); retries > 0 {
+			ctx, _ := tag.New(h.statsCtx, tag.Upsert(outcomeKey, outcome))
+			for i := 0; i < retries; i++ {
+				pkgmetrics.Record(ctx, proxyRetriesM.M(1))
+			}
+		}
+		if state.getSlowUpstreamQueueing() {
+			pkgmetrics.Record(h.statsCtx, slowUpstreamQueueingCountM.M(1))
+		}
+		if state.getResizeInducedWait() {
+			pkgmetrics.Record(h.statsCtx, resizeInducedWaitCountM.M(1))
+		}
+		if from, to := state.getStatusRewrite(); from != 0 {
+			ctx, _ := tag.New(h.statsCtx, tag.Upsert(fromClassKey, pkgmetrics.ResponseCodeClass(from)),
+				tag.Upsert(toClassKey, pkgmetrics.ResponseCodeClass(to)))
+			pkgmetrics.Record(ctx, statusRewriteCountM.M(1))
+		}
+		if action := state.getBackpressureAction(); action != "" {
+			ctx, _ := tag.New(h.statsCtx, tag.Upsert(actionKey, action))
+			pkgmetrics.Record(ctx, bufferingBackpressureCountM.M(1))
+		}
 	}()
 
 	h.next.ServeHTTP(rr, r)
 }
 
+// recordBodySizes records the size of the request and response bodies, if
+// body counts the request body's bytes.
+func recordBodySizes(ctx context.Context, body *countingBody, rr *pkghttp.ResponseRecorder) {
+	if body == nil {
+		return
+	}
+	// The response recorder counts the bytes actually written, so this
+	// covers responses of unknown length, too.
+	pkgmetrics.RecordBatch(ctx, requestBytesM.M(body.n.Load()), responseBytesM.M(int64(rr.ResponseSize)))
+}
+
+// countingBody counts the bytes read from the request body it wraps. Handlers
+// behind the timeout handler might still read it when the request metrics
+// handler returns, hence the atomic counter.
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// augmentWithResponseAndRouteTag returns the stats context tagged with the
+// response code and the route tag, and the protocol if enabled. With the
+// protocol, the response code of gRPC requests is taken from the response's
+// trailers, if header is set. Taken from a request header, the route tag might
+// not be a valid tag value, e.g. contain non-ASCII characters, in which case
+// the request's metrics are recorded without these tags. The failure is
+// counted in metrics_handler_errors if enabled, the request is served anyway.
+func (h *requestMetricsHandler) augmentWithResponseAndRouteTag(r *http.Request, header http.Header, responseCode int, routeTag string) context.Context {
+	code, class := strconv.Itoa(responseCode), pkgmetrics.ResponseCodeClass(responseCode)
+	mutators := make([]tag.Mutator, 0, 4)
+	if h.grpcStatus || h.protocolTag {
+		protocol := protocolHTTP
+		if h.protocolTag {
+			protocol = httpVersion(r, h.protocolHeader)
+		}
+		if h.grpcStatus && isGRPC(r) {
+			protocol = protocolGRPC
+			if status, httpStatus, ok := grpcStatus(header); ok {
+				code, class = strconv.Itoa(status), pkgmetrics.ResponseCodeClass(httpStatus)
+			}
+		}
+		mutators = append(mutators, tag.Upsert(protocolKey, protocol))
+	}
+	mutators = append(mutators,
+		tag.Upsert(metrics.ResponseCodeKey, code),
+		tag.Upsert(metrics.ResponseCodeClassKey, class),
+		tag.Upsert(metrics.RouteTagKey, routeTag))
+	ctx, err := tag.New(h.statsCtx, mutators...)
+	if err != nil && h.handlerErrors {
+		pkgmetrics.Record(h.statsCtx, metricsHandlerErrorsM.M(1))
+	}
+	return ctx
+}
+
+// routeTag returns the given route tag, unless it's to be collapsed into
+// routeTagOverflow.
+func (h *requestMetricsHandler) routeTag(name string) string {
+	if h.routeTags == nil {
+		return name
+	}
+	switch name {
+	case defaultTagName, undefinedTagName, disabledTagName:
+		return name
+	}
+	if _, ok := h.routeTags[name]; ok {
+		return name
+	}
+	return routeTagOverflow
+}
+
+// contentType maps the value of the Content-Type response header to one of
+// the bounded values of the content_type tag.
+func (h *requestMetricsHandler) contentType(value string) string {
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return contentTypeNone
+	}
+	if _, ok := h.contentTypes[value]; ok {
+		return value
+	}
+	return contentTypeOther
+}
+
+// trailerValue maps the value of the configured trailer to one of the bounded
+// values of the trailer_value tag.
+func (h *requestMetricsHandler) trailerValue(header http.Header) string {
+	value, ok := trailer(header, h.valueTrailer)
+	if !ok {
+		return trailerValueNone
+	}
+	if _, ok := h.trailerValues[value]; ok {
+		return value
+	}
+	return trailerValueOther
+}
+
+// trailer returns the value of the given response trailer from the header
+// map the handlers wrote to, whether it was announced in the Trailer header
+// or not. Trailers sent as headers, like in trailers-only gRPC responses,
+// count as well.
+func trailer(header http.Header, name string) (string, bool) {
+	if v, ok := header[http.TrailerPrefix+name]; ok && len(v) > 0 {
+		return v[0], true
+	}
+	if v, ok := header[name]; ok && len(v) > 0 {
+		return v[0], true
+	}
+	return "", false
+}
+
+// cacheStatus maps the value of the given cache status response header to one
+// of the bounded values of the cache_status tag. The Age header denotes a hit
+// if positive, other headers like X-Cache or Cache-Status a hit or miss if
+// their value mentions it.
+func cacheStatus(header, value string) string {
+	if value == "" {
+		return cacheStatusNone
+	}
+	if header == "Age" {
+		if age, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			if age > 0 {
+				return cacheStatusHit
+			}
+			return cacheStatusMiss
+		}
+		return cacheStatusNone
+	}
+
+	value = strings.ToLower(value)
+	switch {
+	case strings.Contains(value, "miss"):
+		return cacheStatusMiss
+	case strings.Contains(value, "hit"):
+		return cacheStatusHit
+	default:
+		return cacheStatusNone
+	}
+}
+
+// NewBreakerCapacityRecorder returns a function to be used as the breaker's
+// OnCapacityChange, recording every applied capacity in a distribution so
+// that capacity changes within a reporting interval are visible.
+func NewBreakerCapacityRecorder(ns, service, config, rev, pod string) (func(int), error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The capacities applied to the breaker",
+		Measure:     breakerCapacityChangesM,
+		Aggregation: breakerCapacityDistribution,
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return func(capacity int) {
+		pkgmetrics.Record(ctx, breakerCapacityChangesM.M(int64(capacity)))
+	}, nil
+}
+
+// NewBreakerWaitRecorder returns a function to be used as the breaker's
+// OnWait, recording the time requests spent in the breaker by whether they
+// were admitted. Rejected requests never reach the request handlers, so the
+// breaker reports the waits itself.
+func NewBreakerWaitRecorder(ns, service, config, rev, pod string) (func(time.Duration, bool), error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The time requests spent in the breaker until being admitted or rejected in millisecond",
+		Measure:     breakerWaitLatenciesM,
+		Aggregation: defaultLatencyDistribution,
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, outcomeKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	admittedCtx, err := tag.New(ctx, tag.Upsert(outcomeKey, waitOutcomeAdmitted))
+	if err != nil {
+		return nil, err
+	}
+	rejectedCtx, err := tag.New(ctx, tag.Upsert(outcomeKey, waitOutcomeRejected))
+	if err != nil {
+		return nil, err
+	}
+	return func(wait time.Duration, admitted bool) {
+		ctx := rejectedCtx
+		if admitted {
+			ctx = admittedCtx
+		}
+		pkgmetrics.Record(ctx, breakerWaitLatenciesM.M(durationMillis(wait)))
+	}, nil
+}
+
 // NewAppRequestMetricsHandler creates an http.Handler that emits request metrics.
 func NewAppRequestMetricsHandler(next http.Handler, b *Breaker,
 	ns, service, config, rev, pod string, annotations map[string]string, labels map[string]string) (http.Handler, error) {
@@ -148,11 +1908,16 @@ func NewAppRequestMetricsHandler(next http.Handler, b *Breaker,
 		Measure:     appResponseTimeInMsecM,
 		Aggregation: defaultLatencyDistribution,
 		TagKeys:     keys,
+	}, queueDepthView(), &view.View{
+		Description: "The current number of requests the breaker admits concurrently",
+		Measure:     breakerCapacityM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
 	}, &view.View{
-		Description: "The number of items queued at this queue proxy.",
-		Measure:     queueDepthM,
+		Description: "The number of requests the breaker is to admit concurrently once done resizing",
+		Measure:     breakerTargetCapacityM,
 		Aggregation: view.LastValue(),
-		TagKeys:     keys,
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
 	}); err != nil {
 		return nil, err
 	}
@@ -165,16 +1930,19 @@ func NewAppRequestMetricsHandler(next http.Handler, b *Breaker,
 	return &appRequestMetricsHandler{
 		next:     next,
 		statsCtx: ctx,
+		clock:    clock.RealClock{},
 		breaker:  b,
 	}, nil
 }
 
 func (h *appRequestMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rr := pkghttp.NewResponseRecorder(w, http.StatusOK)
-	startTime := time.Now()
+	startTime := h.clock.Now()
 
 	if h.breaker != nil {
-		pkgmetrics.Record(h.statsCtx, queueDepthM.M(int64(h.breaker.InFlight())))
+		pkgmetrics.RecordBatch(h.statsCtx, queueDepthM.M(int64(h.breaker.Pending())),
+			breakerCapacityM.M(int64(h.breaker.Capacity())),
+			breakerTargetCapacityM.M(int64(h.breaker.TargetCapacity())))
 	}
 	defer func() {
 		// Filter probe requests for revision metrics.
@@ -184,7 +1952,7 @@ func (h *appRequestMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 
 		// If ServeHTTP panics, recover, record the failure and panic again.
 		err := recover()
-		latency := time.Since(startTime)
+		latency := measureLatency(h.statsCtx, h.clock, startTime)
 		if err != nil {
 			ctx := metrics.AugmentWithResponse(h.statsCtx, http.StatusInternalServerError)
 			pkgmetrics.RecordBatch(ctx, appRequestCountM.M(1),
@@ -203,6 +1971,9 @@ const (
 	defaultTagName   = "DEFAULT"
 	undefinedTagName = "UNDEFINED"
 	disabledTagName  = "DISABLED"
+	// routeTagOverflow is the route tag of the requests whose tag isn't
+	// allowlisted, see WithRouteTagAllowlist.
+	routeTagOverflow = "__overflow__"
 )
 
 // GetRouteTagNameFromRequest extracts the value of the tag header from http.Request
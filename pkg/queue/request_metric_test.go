@@ -18,14 +18,33 @@ package queue
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/metric/metricdata"
 	"go.opencensus.io/resource"
+	"go.opencensus.io/trace"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
 	network "knative.dev/networking/pkg"
 	"knative.dev/pkg/metrics/metricstest"
 	_ "knative.dev/pkg/metrics/testing"
+	pkgnet "knative.dev/pkg/network"
+	"knative.dev/serving/pkg/http/handler"
 	"knative.dev/serving/pkg/metrics"
 )
 
@@ -72,11 +91,92 @@ func TestRequestMetricsHandler(t *testing.T) {
 	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, wantTags).WithResource(wantResource))
 	metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("request_latencies", 1, wantTags).WithResource(wantResource))
 
-	// A probe request should not be recorded.
+	metricstest.AssertNoMetric(t, "probe_request_count")
+
+	// A probe request should not be recorded, only counted as a probe.
 	req.Header.Set(network.ProbeHeaderName, "activator")
 	handler.ServeHTTP(resp, req)
+	handler.ServeHTTP(resp, req)
 	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, wantTags).WithResource(wantResource))
 	metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("request_latencies", 1, wantTags).WithResource(wantResource))
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("probe_request_count", 2, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}).WithResource(wantResource))
+}
+
+func TestRequestMetricsHandlerLatencyUnit(t *testing.T) {
+	for _, unit := range []string{LatencyUnitMilliseconds, LatencyUnitMicroseconds} {
+		t.Run(unit, func(t *testing.T) {
+			defer reset()
+			baseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			handler, err := NewRequestMetricsHandler(baseHandler, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithLatencyUnit(unit))
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+
+			metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("request_latencies", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      "200",
+				metrics.LabelResponseCodeClass: "2xx",
+				"route_tag":                    disabledTagName,
+				"unit":                         unit,
+			}))
+			// OpenCensus only exports a few well-known units, so check the
+			// measure itself too.
+			if got := handler.(*requestMetricsHandler).latency(time.Second).Measure().Unit(); got != unit {
+				t.Errorf("Unit = %q, want: %q", got, unit)
+			}
+		})
+	}
+}
+
+func TestRequestMetricsHandlerLatencyAnomaly(t *testing.T) {
+	defer reset()
+	fc := clock.NewFakeClock(time.Now())
+	skew := false
+	baseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skew {
+			fc.SetTime(fc.Now().Add(-time.Second))
+		} else {
+			fc.Step(time.Second)
+		}
+	})
+	handler, err := NewRequestMetricsHandler(baseHandler, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	handler.(*requestMetricsHandler).clock = fc
+
+	// A regular request is no anomaly.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.AssertNoMetric(t, "latency_anomaly_count")
+
+	// A clock going backwards is counted and the latency clamped to zero.
+	skew = true
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("latency_anomaly_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+	d := metricstest.GetOneMetric("request_latencies").Values[0].Distribution
+	if got, want := d.Count, int64(2); got != want {
+		t.Errorf("request_latencies count = %d, want: %d", got, want)
+	}
+	if got, want := d.Sum, float64(time.Second.Milliseconds()); got != want {
+		t.Errorf("request_latencies sum = %v, want: %v", got, want)
+	}
+}
+
+func TestRequestMetricsHandlerInvalidLatencyUnit(t *testing.T) {
+	defer reset()
+	if _, err := NewRequestMetricsHandler(nil /*next*/, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithLatencyUnit("ns")); err == nil {
+		t.Error("Expected an error for an unsupported latency unit")
+	}
 }
 
 func TestRequestMetricsHandlerWithEnablingTagOnRequestMetrics(t *testing.T) {
@@ -142,11 +242,1203 @@ func TestRequestMetricsHandlerWithEnablingTagOnRequestMetrics(t *testing.T) {
 	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, wantTags).WithResource(wantResource))
 }
 
+func TestRequestMetricsHandlerRouteTagAllowlist(t *testing.T) {
+	defer reset()
+	handler, err := NewRequestMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil, WithRouteTagAllowlist([]string{"blue"}))
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	for _, tag := range []string{"blue", "green", "red", ""} {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		if tag != "" {
+			req.Header.Set(network.TagHeaderName, tag)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// byRouteTag returns the number of requests recorded in the given metric
+	// per route tag.
+	byRouteTag := func(name string) map[string]int64 {
+		metricstest.EnsureRecorded()
+		got := make(map[string]int64)
+		for _, v := range metricstest.GetOneMetric(name).Values {
+			if v.Distribution != nil {
+				got[v.Tags[metrics.LabelRouteTag]] = v.Distribution.Count
+			} else {
+				got[v.Tags[metrics.LabelRouteTag]] = *v.Int64
+			}
+		}
+		return got
+	}
+	want := map[string]int64{"blue": 1, routeTagOverflow: 2, disabledTagName: 1}
+	for _, name := range []string{"request_count", "request_latencies"} {
+		// The metrics are recorded asynchronously.
+		var got map[string]int64
+		if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+			got = byRouteTag(name)
+			return cmp.Equal(got, want), nil
+		}); err != nil {
+			t.Errorf("%s by route tag (-want, +got): %s", name, cmp.Diff(want, got))
+		}
+	}
+}
+
+func TestRequestMetricsHandlerLatencyExemplars(t *testing.T) {
+	defer reset()
+	handler, err := NewRequestMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil, WithLatencyExemplars())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// exemplars returns the exemplars of request_latencies.
+	exemplars := func() []*metricdata.Exemplar {
+		metricstest.EnsureRecorded()
+		var ret []*metricdata.Exemplar
+		for _, b := range metricstest.GetOneMetric("request_latencies").Values[0].Distribution.Buckets {
+			if b.Exemplar != nil {
+				ret = append(ret, b.Exemplar)
+			}
+		}
+		return ret
+	}
+
+	// Requests without a trace context behave as usual.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+	req.Header.Set("traceparent", "not a trace context")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got := exemplars(); len(got) != 0 {
+		t.Errorf("Got %d exemplars, want none", len(got))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, targetURI, nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	got := exemplars()
+	if len(got) != 1 {
+		t.Fatalf("Got %d exemplars, want 1", len(got))
+	}
+	sc, ok := got[0].Attachments[metricdata.AttachmentKeySpanContext].(trace.SpanContext)
+	if !ok {
+		t.Fatalf("Exemplar attachments = %v, want a span context", got[0].Attachments)
+	}
+	if got, want := sc.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+		t.Errorf("TraceID = %s, want: %s", got, want)
+	}
+	if got, want := sc.SpanID.String(), "00f067aa0ba902b7"; got != want {
+		t.Errorf("SpanID = %s, want: %s", got, want)
+	}
+}
+
+func TestRequestMetricsHandlerErrors(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served"))
+	})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithMetricsHandlerErrors())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.AssertNoMetric(t, "metrics_handler_errors")
+
+	// A route tag that isn't a valid tag value fails tagging the metrics,
+	// not the request.
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+	req.Header.Set(network.TagHeaderName, "caf\u00e9")
+	handler.ServeHTTP(resp, req)
+	if got, want := resp.Code, http.StatusOK; got != want {
+		t.Errorf("StatusCode = %d, want: %d", got, want)
+	}
+	if got, want := resp.Body.String(), "served"; got != want {
+		t.Errorf("Body = %q, want: %q", got, want)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("metrics_handler_errors", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+}
+
+func TestRequestMetricsHandlerQueueCancellation(t *testing.T) {
+	expired := func() context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	pastDeadline := func() context.Context {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		timeout   time.Duration
+		drain     bool
+		wantCause string
+	}{{
+		name:      "client cancelled",
+		ctx:       expired(),
+		wantCause: cancellationCauseClient,
+	}, {
+		name:      "deadline",
+		ctx:       pastDeadline(),
+		wantCause: cancellationCauseDeadline,
+	}, {
+		name:      "queue timeout",
+		ctx:       context.Background(),
+		timeout:   time.Millisecond,
+		wantCause: cancellationCauseQueueTimeout,
+	}, {
+		name:      "drain",
+		ctx:       context.Background(),
+		drain:     true,
+		wantCause: cancellationCauseDrain,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			// No capacity, so every request waits in the queue.
+			breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0,
+				QueueTimeout: test.timeout})
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+			handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			if test.drain {
+				go func() {
+					for breaker.InFlight() != 1 {
+						time.Sleep(time.Millisecond)
+					}
+					breaker.Drain(context.Background())
+				}()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, targetURI, nil).WithContext(test.ctx)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("queue_cancellation_count", 1, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+				"cause":                    test.wantCause,
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerBurstAdmission(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 2, InitialCapacity: 2, BurstCapacity: 1})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	var wg sync.WaitGroup
+	serve := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+		}()
+		<-entered
+	}
+
+	// Requests within the capacity are no burst admissions.
+	serve()
+	serve()
+	release <- struct{}{}
+	release <- struct{}{}
+	wg.Wait()
+	metricstest.AssertNoMetric(t, "burst_admission_count")
+
+	// Exceeding the capacity uses the burst slot.
+	serve()
+	serve()
+	serve()
+	close(release)
+	wg.Wait()
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("burst_admission_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+}
+
+func TestRequestMetricsHandlerQueuedTag(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil, WithQueuedTag())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	var wg sync.WaitGroup
+	serve := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+		}()
+	}
+
+	// The first request is admitted right away, the second waits for it.
+	serve()
+	<-entered
+	serve()
+	if err := wait.PollImmediate(time.Millisecond, time.Second, func() (bool, error) {
+		return breaker.InFlight() == 2, nil
+	}); err != nil {
+		t.Fatal("Second request never queued:", err)
+	}
+	release <- struct{}{}
+	<-entered
+	release <- struct{}{}
+	wg.Wait()
+
+	tags := func(queued string) map[string]string {
+		return map[string]string{
+			metrics.LabelPodName:           "pod",
+			metrics.LabelContainerName:     "queue-proxy",
+			metrics.LabelResponseCode:      "200",
+			metrics.LabelResponseCodeClass: "2xx",
+			"route_tag":                    disabledTagName,
+			"queued":                       queued,
+		}
+	}
+	metricstest.AssertMetricRequiredOnly(t,
+		metricstest.DistributionCountOnlyMetric("request_latencies", 1, tags("false")),
+		metricstest.DistributionCountOnlyMetric("request_latencies", 1, tags("true")))
+}
+
+func TestRequestMetricsHandlerRequestCost(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 8, InitialCapacity: 8,
+		CostDeadlineScheduling: true})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithRequestCostDistribution())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// Costs beyond the breaker's maximum concurrency are capped to it.
+	for _, cost := range []int{1, 1, 3, 8, 20} {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(WithRequestCost(req.Context(), cost)))
+	}
+
+	metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("request_cost", 5, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+	d := metricstest.GetOneMetric("request_cost").Values[0].Distribution
+	if got, want := d.Sum, 21.; got != want {
+		t.Errorf("Sum = %v, want: %v", got, want)
+	}
+	// Buckets are 1, 2, 4, 8, ..., the upper bounds being exclusive.
+	for i, want := range []int64{0, 2, 1, 0, 2} {
+		if got := d.Buckets[i].Count; got != want {
+			t.Errorf("Buckets[%d].Count = %d, want: %d", i, got, want)
+		}
+	}
+}
+
+func TestRequestMetricsHandlerBodySizes(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		// Streamed without a Content-Length.
+		w.Write([]byte("abc"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("defg"))
+	})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithBodySizes())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, targetURI, bytes.NewBufferString("hello world")))
+	if !resp.Flushed {
+		t.Error("Response wasn't flushed")
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+
+	wantTags := map[string]string{
+		metrics.LabelPodName:           "pod",
+		metrics.LabelContainerName:     "queue-proxy",
+		metrics.LabelResponseCode:      "200",
+		metrics.LabelResponseCodeClass: "2xx",
+		metrics.LabelRouteTag:          disabledTagName,
+	}
+	for name, want := range map[string]float64{"request_bytes": 11, "response_bytes": 14} {
+		metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric(name, 2, wantTags))
+		if got := metricstest.GetOneMetric(name).Values[0].Distribution.Sum; got != want {
+			t.Errorf("%s sum = %v, want: %v", name, got, want)
+		}
+	}
+}
+
+func TestRequestMetricsHandlerBodySizesDisabled(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b, err := ioutil.ReadAll(r.Body); err != nil || string(b) != "hello world" {
+			t.Errorf("ReadAll() = %q, %v, want: %q", b, err, "hello world")
+		}
+		w.Write([]byte("abc"))
+	})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, targetURI, bytes.NewBufferString("hello world")))
+	metricstest.AssertNoMetric(t, "request_bytes", "response_bytes")
+}
+
+func TestRequestMetricsHandlerClientCloseRequested(t *testing.T) {
+	defer reset()
+	handler, err := NewRequestMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// Keep-alive clients aren't counted.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+	req.Header.Set("Connection", "keep-alive")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	metricstest.AssertNoMetric(t, "client_close_requested_count")
+
+	for _, value := range []string{"close", "Upgrade, Close"} {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		req.Header.Set("Connection", value)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("client_close_requested_count", 2, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+}
+
+func TestRequestMetricsHandlerSlowUpstreamQueueing(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next,
+		WithSlowUpstreamDetection(10*time.Millisecond, 20*time.Millisecond))
+	handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	var wg sync.WaitGroup
+	serve := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+		}()
+	}
+
+	// Requests served right away, even by a slow upstream, didn't queue.
+	serve()
+	<-entered
+	time.Sleep(30 * time.Millisecond)
+	release <- struct{}{}
+	wg.Wait()
+	metricstest.AssertNoMetric(t, "slow_upstream_queueing_count")
+
+	// A request queued behind a slow request is counted.
+	serve()
+	<-entered
+	serve()
+	time.Sleep(30 * time.Millisecond)
+	release <- struct{}{}
+	<-entered
+	release <- struct{}{}
+	wg.Wait()
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("slow_upstream_queueing_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+}
+
+func TestRequestMetricsHandlerNoQueueCancellation(t *testing.T) {
+	defer reset()
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// An admitted request that fails is not a cancellation.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.AssertNoMetric(t, "queue_cancellation_count")
+}
+
+func TestRequestMetricsHandlerFlushTime(t *testing.T) {
+	defer reset()
+	chunk := make([]byte, 1<<20)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stream more than the socket buffers can hold.
+		for i := 0; i < 32; i++ {
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+		}
+	})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithResponseFlushTime())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	// Only start reading after a while to make the handler block.
+	const readDelay = 200 * time.Millisecond
+	time.Sleep(readDelay)
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		t.Fatal("Failed to read the response:", err)
+	}
+
+	var values []metricstest.Value
+	for start := time.Now(); len(values) == 0 && time.Since(start) < 10*time.Second; {
+		// The metric is recorded after the last byte is sent.
+		time.Sleep(10 * time.Millisecond)
+		metricstest.EnsureRecorded()
+		if m := metricstest.GetMetric("response_flush_time"); len(m) == 1 {
+			values = m[0].Values
+		}
+	}
+	if len(values) != 1 {
+		t.Fatal("Expected a single response_flush_time value, got:", values)
+	}
+	// Part of the read delay is spent waiting for the socket buffers to fill.
+	if got, want := values[0].Distribution.Sum, float64(readDelay.Milliseconds()/2); got < want {
+		t.Errorf("response_flush_time = %vms, want at least %vms", got, want)
+	}
+}
+
+func TestRequestMetricsHandlerFlushTimeDisabled(t *testing.T) {
+	defer reset()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abc"))
+		w.(http.Flusher).Flush()
+	})
+	handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if !resp.Flushed {
+		t.Error("Response wasn't flushed")
+	}
+	metricstest.AssertNoMetric(t, "response_flush_time")
+}
+
+func TestRequestMetricsHandlerCacheStatus(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		header string
+		want   string
+	}{{
+		name:   "hit",
+		header: "HIT",
+		want:   cacheStatusHit,
+	}, {
+		name:   "miss",
+		header: "MISS",
+		want:   cacheStatusMiss,
+	}, {
+		name: "absent",
+		want: cacheStatusNone,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.header != "" {
+					w.Header().Set("X-Cache", test.header)
+				}
+			})
+			handler, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithCacheStatusHeader("x-cache"))
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      "200",
+				metrics.LabelResponseCodeClass: "2xx",
+				metrics.LabelRouteTag:          disabledTagName,
+				"cache_status":                 test.want,
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+	}{{
+		name:        "json",
+		contentType: "application/json; charset=utf-8",
+		want:        "application/json",
+	}, {
+		name:        "image",
+		contentType: "Image/PNG",
+		want:        "image/png",
+	}, {
+		name: "absent",
+		want: contentTypeNone,
+	}, {
+		name:        "not allowlisted",
+		contentType: "text/html",
+		want:        contentTypeOther,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+			contentType := test.contentType
+			baseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if contentType != "" {
+					w.Header().Set("Content-Type", contentType)
+				}
+			})
+			handler, err := NewRequestMetricsHandler(baseHandler, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithContentTypeTag([]string{"application/json", "image/png"}))
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      "200",
+				metrics.LabelResponseCodeClass: "2xx",
+				metrics.LabelRouteTag:          disabledTagName,
+				"content_type":                 test.want,
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		code        int
+		trailers    map[string]string
+		undeclared  bool
+		wantCode    string
+		wantClass   string
+		wantProto   string
+	}{{
+		name:        "ok",
+		contentType: "application/grpc",
+		trailers:    map[string]string{"Grpc-Status": "0"},
+		wantCode:    "0",
+		wantClass:   "2xx",
+		wantProto:   "grpc",
+	}, {
+		name:        "server error",
+		contentType: "application/grpc+proto",
+		trailers:    map[string]string{"Grpc-Status": "14", "Grpc-Message": "unavailable"},
+		wantCode:    "14",
+		wantClass:   "5xx",
+		wantProto:   "grpc",
+	}, {
+		name:        "client error without announcement",
+		contentType: "application/grpc",
+		trailers:    map[string]string{"Grpc-Status": "5"},
+		undeclared:  true,
+		wantCode:    "5",
+		wantClass:   "4xx",
+		wantProto:   "grpc",
+	}, {
+		name:        "invalid status",
+		contentType: "application/grpc",
+		trailers:    map[string]string{"Grpc-Status": "42"},
+		wantCode:    "200",
+		wantClass:   "2xx",
+		wantProto:   "grpc",
+	}, {
+		name:        "no status",
+		contentType: "application/grpc",
+		code:        http.StatusServiceUnavailable,
+		wantCode:    "503",
+		wantClass:   "5xx",
+		wantProto:   "grpc",
+	}, {
+		name:        "http",
+		contentType: "application/json",
+		trailers:    map[string]string{"Grpc-Status": "14"},
+		wantCode:    "200",
+		wantClass:   "2xx",
+		wantProto:   "http",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			code, trailers, undeclared := test.code, test.trailers, test.undeclared
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !undeclared {
+					for k := range trailers {
+						w.Header().Add("Trailer", k)
+					}
+				}
+				if code != 0 {
+					w.WriteHeader(code)
+				}
+				io.WriteString(w, "body")
+				for k, v := range trailers {
+					if undeclared {
+						k = http.TrailerPrefix + k
+					}
+					w.Header().Set(k, v)
+				}
+			})
+			h, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithGRPCStatus())
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, targetURI, nil)
+			req.Header.Set("Content-Type", test.contentType)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			wantTags := map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      test.wantCode,
+				metrics.LabelResponseCodeClass: test.wantClass,
+				metrics.LabelRouteTag:          disabledTagName,
+				"protocol":                     test.wantProto,
+			}
+			metricstest.AssertMetricRequiredOnly(t,
+				metricstest.IntMetric("request_count", 1, wantTags),
+				metricstest.DistributionCountOnlyMetric("request_latencies", 1, wantTags))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerTrailers(t *testing.T) {
+	tests := []struct {
+		name        string
+		trailers    map[string]string
+		undeclared  bool
+		wantMissing []string
+		wantValue   string
+	}{{
+		name:      "present",
+		trailers:  map[string]string{"Grpc-Status": "0", "Grpc-Message": ""},
+		wantValue: "0",
+	}, {
+		name:       "present without announcement",
+		trailers:   map[string]string{"Grpc-Status": "14", "Grpc-Message": "unavailable"},
+		undeclared: true,
+		wantValue:  "14",
+	}, {
+		name:        "value not allowlisted",
+		trailers:    map[string]string{"Grpc-Status": "42"},
+		wantMissing: []string{"grpc-message"},
+		wantValue:   trailerValueOther,
+	}, {
+		name:        "missing",
+		wantMissing: []string{"grpc-message", "grpc-status"},
+		wantValue:   trailerValueNone,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			trailers, undeclared := test.trailers, test.undeclared
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !undeclared {
+					for k := range trailers {
+						w.Header().Add("Trailer", k)
+					}
+				}
+				io.WriteString(w, "body")
+				for k, v := range trailers {
+					if undeclared {
+						k = http.TrailerPrefix + k
+					}
+					w.Header().Set(k, v)
+				}
+			})
+			h, err := NewRequestMetricsHandler(next, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithExpectedTrailers([]string{"grpc-status", "grpc-message"}),
+				WithTrailerValueTag("grpc-status", []string{"0", "14"}))
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+
+			want := []metricstest.Metric{
+				metricstest.IntMetric("request_count", 1, map[string]string{
+					metrics.LabelPodName:           "pod",
+					metrics.LabelContainerName:     "queue-proxy",
+					metrics.LabelResponseCode:      "200",
+					metrics.LabelResponseCodeClass: "2xx",
+					metrics.LabelRouteTag:          disabledTagName,
+					"trailer_value":                test.wantValue,
+				}),
+			}
+			for _, name := range test.wantMissing {
+				want = append(want, metricstest.IntMetric("trailers_missing_count", 1, map[string]string{
+					metrics.LabelPodName:       "pod",
+					metrics.LabelContainerName: "queue-proxy",
+					"trailer":                  name,
+				}))
+			}
+			metricstest.AssertMetricRequiredOnly(t, want...)
+			if len(test.wantMissing) == 0 {
+				metricstest.AssertNoMetric(t, "trailers_missing_count")
+			}
+		})
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	for _, test := range []struct {
+		header, value, want string
+	}{
+		{"X-Cache", "", cacheStatusNone},
+		{"X-Cache", "HIT", cacheStatusHit},
+		{"X-Cache", "Hit from cloudfront", cacheStatusHit},
+		{"X-Cache", "TCP_MISS", cacheStatusMiss},
+		{"X-Cache", "BYPASS", cacheStatusNone},
+		{"Cache-Status", "ExampleCache; hit", cacheStatusHit},
+		{"Cache-Status", "ExampleCache; fwd=uri-miss", cacheStatusMiss},
+		{"Age", "120", cacheStatusHit},
+		{"Age", "0", cacheStatusMiss},
+		{"Age", "soon", cacheStatusNone},
+	} {
+		if got := cacheStatus(test.header, test.value); got != test.want {
+			t.Errorf("cacheStatus(%q, %q) = %q, want: %q", test.header, test.value, got, test.want)
+		}
+	}
+}
+
+func TestRequestMetricsHandlerUpstreamStatus(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal("Failed to parse upstream URL:", err)
+	}
+
+	tests := []struct {
+		name         string
+		stall        bool
+		unreachable  bool
+		wantCode     string
+		wantUpstream string
+	}{{
+		name:         "passed through",
+		wantCode:     "200",
+		wantUpstream: "200",
+	}, {
+		// The upstream responded in time but queue-proxy didn't manage to
+		// write the response before the timeout.
+		name:         "timeout after upstream response",
+		stall:        true,
+		wantCode:     "504",
+		wantUpstream: "200",
+	}, {
+		name:         "upstream not reached",
+		unreachable:  true,
+		wantCode:     "502",
+		wantUpstream: upstreamStatusNone,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			transport := UpstreamStatusTransport(http.DefaultTransport)
+			if test.unreachable {
+				transport = UpstreamStatusTransport(pkgnet.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+					return nil, errors.New("connection refused")
+				}))
+			}
+			stall := test.stall
+			proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				out := r.Clone(r.Context())
+				out.URL, out.RequestURI = upstreamURL, ""
+				resp, err := transport.RoundTrip(out)
+				if err != nil {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				resp.Body.Close()
+				if stall {
+					// Never get to pass on the response.
+					<-r.Context().Done()
+					return
+				}
+				w.WriteHeader(resp.StatusCode)
+			})
+			timeout := handler.NewTimeToFirstByteTimeoutHandler(proxy, "timeout", 100*time.Millisecond)
+			h, err := NewRequestMetricsHandler(timeout, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithUpstreamStatusTag())
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      test.wantCode,
+				metrics.LabelResponseCodeClass: test.wantCode[:1] + "xx",
+				metrics.LabelRouteTag:          disabledTagName,
+				"upstream_status":              test.wantUpstream,
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerUpstreamTTFB(t *testing.T) {
+	defer reset()
+
+	// The upstream thinks before responding, then takes its time to write
+	// the body, which doesn't count towards the TTFB.
+	const think = 100 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(think)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(think)
+		io.WriteString(w, "done")
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal("Failed to parse upstream URL:", err)
+	}
+
+	transport := UpstreamTTFBTransport(http.DefaultTransport)
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		out.URL, out.RequestURI = upstreamURL, ""
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil, WithUpstreamTTFB())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.EnsureRecorded()
+	values := metricstest.GetOneMetric("upstream_ttfb").Values
+	if len(values) != 1 {
+		t.Fatalf("Got %d upstream_ttfb time series, want 1", len(values))
+	}
+	if got, want := values[0].Tags[metrics.LabelResponseCode], "200"; got != want {
+		t.Errorf("upstream_ttfb response_code = %q, want: %q", got, want)
+	}
+	d := values[0].Distribution
+	if got, want := d.Count, int64(1); got != want {
+		t.Fatalf("upstream_ttfb count = %d, want: %d", got, want)
+	}
+	if got, want := d.Sum, float64(think.Milliseconds()); got < want || got >= 2*want {
+		t.Errorf("upstream_ttfb = %vms, want in [%v, %v)ms", got, want, 2*want)
+	}
+}
+
+func TestRequestMetricsHandlerUpstreamTTFBNotReached(t *testing.T) {
+	defer reset()
+	transport := UpstreamTTFBTransport(pkgnet.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}))
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := transport.RoundTrip(r.Clone(r.Context())); err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithUpstreamTTFB(), WithResponseTimeToFirstByte())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.AssertNoMetric(t, "upstream_ttfb", "response_time_to_first_byte")
+}
+
+func TestRequestMetricsHandlerResponseTimeToFirstByte(t *testing.T) {
+	defer reset()
+
+	// The body streamed after the first byte doesn't count.
+	const think = 100 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(think)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(think)
+		io.WriteString(w, "done")
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal("Failed to parse upstream URL:", err)
+	}
+
+	// A fresh transport, so that the connection is dialed.
+	transport := UpstreamTTFBTransport(&http.Transport{})
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		out.URL, out.RequestURI = upstreamURL, ""
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil, WithResponseTimeToFirstByte())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	metricstest.EnsureRecorded()
+	values := metricstest.GetOneMetric("response_time_to_first_byte").Values
+	if len(values) != 1 {
+		t.Fatalf("Got %d response_time_to_first_byte time series, want 1", len(values))
+	}
+	wantTags := map[string]string{
+		metrics.LabelPodName:           "pod",
+		metrics.LabelContainerName:     "queue-proxy",
+		metrics.LabelResponseCode:      "200",
+		metrics.LabelResponseCodeClass: "2xx",
+		metrics.LabelRouteTag:          disabledTagName,
+	}
+	for k, want := range wantTags {
+		if got := values[0].Tags[k]; got != want {
+			t.Errorf("response_time_to_first_byte %s = %q, want: %q", k, got, want)
+		}
+	}
+	d := values[0].Distribution
+	if got, want := d.Count, int64(1); got != want {
+		t.Fatalf("response_time_to_first_byte count = %d, want: %d", got, want)
+	}
+	if got, want := d.Sum, float64(think.Milliseconds()); got < want || got >= 2*want {
+		t.Errorf("response_time_to_first_byte = %vms, want in [%v, %v)ms", got, want, 2*want)
+	}
+}
+
+// timeoutError is a net.Error timing out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRequestMetricsHandlerUpstreamError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+		want     string
+	}{{
+		name:     "no error",
+		wantCode: "200",
+		want:     upstreamErrorNone,
+	}, {
+		name:     "connection refused",
+		err:      &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		wantCode: "502",
+		want:     upstreamErrorConnectionRefused,
+	}, {
+		name:     "connection reset",
+		err:      &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+		wantCode: "502",
+		want:     upstreamErrorConnectionReset,
+	}, {
+		name:     "connection closed",
+		err:      io.EOF,
+		wantCode: "502",
+		want:     upstreamErrorConnectionReset,
+	}, {
+		name:     "network timeout",
+		err:      &net.OpError{Op: "read", Err: timeoutError{}},
+		wantCode: "502",
+		want:     upstreamErrorTimeout,
+	}, {
+		name:     "deadline exceeded",
+		err:      context.DeadlineExceeded,
+		wantCode: "502",
+		want:     upstreamErrorTimeout,
+	}, {
+		name:     "cancelled",
+		err:      context.Canceled,
+		wantCode: "502",
+		want:     upstreamErrorCancelled,
+	}, {
+		name:     "other",
+		err:      errors.New("malformed HTTP response"),
+		wantCode: "502",
+		want:     upstreamErrorOther,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			upstreamErr := test.err
+			transport := UpstreamErrorTransport(pkgnet.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				if upstreamErr != nil {
+					return nil, upstreamErr
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}))
+			proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resp, err := transport.RoundTrip(r.Clone(r.Context()))
+				if err != nil {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.WriteHeader(resp.StatusCode)
+			})
+			h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithUpstreamErrorTag())
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      test.wantCode,
+				metrics.LabelResponseCodeClass: test.wantCode[:1] + "xx",
+				metrics.LabelRouteTag:          disabledTagName,
+				"upstream_error":               test.want,
+			}))
+		})
+	}
+}
+
+func TestBreakerCapacityRecorder(t *testing.T) {
+	defer reset()
+	record, err := NewBreakerCapacityRecorder("ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create recorder:", err)
+	}
+	b := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 100, InitialCapacity: 10,
+		OnCapacityChange: record})
+
+	// All capacities within the interval are recorded, not just the last one.
+	for _, c := range []int{20, 5, 10} {
+		b.UpdateConcurrency(c)
+	}
+
+	metricstest.EnsureRecorded()
+	values := metricstest.GetOneMetric("breaker_capacity_distribution").Values
+	if len(values) != 1 {
+		t.Fatalf("Got %d breaker_capacity_distribution time series, want 1", len(values))
+	}
+	d := values[0].Distribution
+	if got, want := d.Count, int64(4); got != want {
+		t.Errorf("Count = %d, want: %d", got, want)
+	}
+	if got, want := d.Sum, float64(10+20+5+10); got != want {
+		t.Errorf("Sum = %v, want: %v", got, want)
+	}
+	// Buckets are [0,1), [1,2), [2,5), [5,10), [10,20), [20,50), ...
+	for i, want := range map[int]int64{3: 1, 4: 2, 5: 1} {
+		if got := d.Buckets[i].Count; got != want {
+			t.Errorf("Bucket %d count = %d, want: %d", i, got, want)
+		}
+	}
+}
+
 func reset() {
 	metricstest.Unregister(
 		requestCountM.Name(), appRequestCountM.Name(),
 		responseTimeInMsecM.Name(), appResponseTimeInMsecM.Name(),
-		queueDepthM.Name())
+		queueDepthM.Name(), queueCancellationCountM.Name(),
+		breakerCapacityM.Name(), breakerTargetCapacityM.Name(), burstAdmissionCountM.Name(),
+		breakerCapacityChangesM.Name(), slowUpstreamQueueingCountM.Name(), resizeInducedWaitCountM.Name(), generationMismatchCountM.Name(), largeResponseCountM.Name(), responseTimeInUsecM.Name(),
+		requestCPUTimeM.Name(),
+		latencyAnomalyCountM.Name(), probeRequestCountM.Name(), bufferingBackpressureCountM.Name(),
+		edgeLatencyM.Name(), upstreamTTFBM.Name(), responseTTFBM.Name(), requestStageDurationM.Name(),
+		statusRewriteCountM.Name(), trailersMissingCountM.Name(), requestCostM.Name(),
+		afterRestartRequestCountM.Name(), preAdmissionDeadlineFractionM.Name(), breakerWaitLatenciesM.Name(),
+		deadlinePropagatedCountM.Name(), deadlineNotPropagatedCountM.Name(),
+		requestBytesM.Name(), responseBytesM.Name(), clientCloseRequestedCountM.Name(), proxyRetriesM.Name(),
+		tcpRetransmitsM.Name(), metricsHandlerErrorsM.Name(),
+		droppedRequestCountM.Name(), connectionAgeM.Name(), responseFlushTimeInMsecM.Name())
 }
 
 func TestRequestMetricsHandlerPanickingHandler(t *testing.T) {
@@ -299,6 +1591,68 @@ func TestAppRequestMetricsHandler(t *testing.T) {
 	metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("app_request_latencies", 1, wantTags).WithResource(wantResource))
 }
 
+func TestBreakerWaitRecorder(t *testing.T) {
+	defer reset()
+	const wait = 50 * time.Millisecond
+	record, err := NewBreakerWaitRecorder("ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create recorder:", err)
+	}
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0, OnWait: record})
+	baseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, baseHandler)
+
+	// Two requests wait for capacity, a third one finds the queue full.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+		}()
+	}
+	for breaker.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+	time.Sleep(wait)
+	breaker.UpdateConcurrency(1)
+	wg.Wait()
+
+	wantResource := &resource.Resource{
+		Type: "knative_revision",
+		Labels: map[string]string{
+			metrics.LabelNamespaceName:     "ns",
+			metrics.LabelRevisionName:      "rev",
+			metrics.LabelServiceName:       "svc",
+			metrics.LabelConfigurationName: "cfg",
+		},
+	}
+	tags := func(outcome string) map[string]string {
+		return map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+			"outcome":                  outcome,
+		}
+	}
+	metricstest.AssertMetricRequiredOnly(t,
+		metricstest.DistributionCountOnlyMetric("breaker_wait_latencies", 2, tags(waitOutcomeAdmitted)).WithResource(wantResource),
+		metricstest.DistributionCountOnlyMetric("breaker_wait_latencies", 1, tags(waitOutcomeRejected)).WithResource(wantResource))
+	for _, v := range metricstest.GetOneMetric("breaker_wait_latencies").Values {
+		d := v.Distribution
+		if v.Tags["outcome"] == waitOutcomeAdmitted && d.Sum < 2*float64(wait.Milliseconds()) {
+			t.Errorf("Admitted waits = %vms, want at least %vms", d.Sum, 2*wait.Milliseconds())
+		}
+		if v.Tags["outcome"] == waitOutcomeRejected && d.Sum >= float64(wait.Milliseconds()) {
+			t.Errorf("Rejected wait = %vms, want less than %vms", d.Sum, wait.Milliseconds())
+		}
+	}
+}
+
 func BenchmarkRequestMetricsHandler(b *testing.B) {
 	baseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 	handler, _ := NewRequestMetricsHandler(baseHandler, "ns", "svc", "cfg", "rev", "pod",
@@ -349,3 +1703,352 @@ func BenchmarkAppRequestMetricsHandler(b *testing.B) {
 		})
 	})
 }
+
+func TestRequestMetricsHandlerGenerationMismatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation string
+		want       int64
+	}{{
+		name: "no header",
+	}, {
+		name:       "matching generation",
+		generation: "3",
+	}, {
+		name:       "mismatching generation",
+		generation: "2",
+		want:       1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+			h, err := NewRequestMetricsHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+				"ns", "svc", "cfg", "rev", "pod", nil, nil, WithGenerationMismatch("K-Generation", "3"))
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+			if test.generation != "" {
+				req.Header.Set("K-Generation", test.generation)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if test.want == 0 {
+				metricstest.AssertNoMetric(t, "generation_mismatch_count")
+				return
+			}
+			metricstest.AssertMetric(t, metricstest.IntMetric("generation_mismatch_count", test.want, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerLargeResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int
+		want  int64
+	}{{
+		name:  "under the threshold",
+		sizes: []int{10, 1024},
+	}, {
+		name:  "over the threshold",
+		sizes: []int{1025, 4096},
+		want:  2,
+	}, {
+		name:  "mixed",
+		sizes: []int{10, 2048, 1024},
+		want:  1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+			h, err := NewRequestMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+				w.Write(make([]byte, size))
+			}), "ns", "svc", "cfg", "rev", "pod", nil, nil, WithLargeResponseThreshold(1024))
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			for _, size := range test.sizes {
+				req := httptest.NewRequest(http.MethodGet, targetURI+"?size="+strconv.Itoa(size), nil)
+				req.Header.Set(network.TagHeaderName, "canary")
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			if test.want == 0 {
+				metricstest.AssertNoMetric(t, "large_response_count")
+				return
+			}
+			metricstest.AssertMetric(t, metricstest.IntMetric("large_response_count", test.want, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+				metrics.LabelRouteTag:      "canary",
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerProtocolTag(t *testing.T) {
+	tests := []struct {
+		name        string
+		proto       string
+		header      string
+		contentType string
+		grpc        bool
+		want        string
+	}{{
+		name:  "http/1.1",
+		proto: "HTTP/1.1",
+		want:  "HTTP/1.1",
+	}, {
+		name:  "http/2",
+		proto: "HTTP/2.0",
+		want:  "HTTP/2.0",
+	}, {
+		name:   "http/3 from the header",
+		proto:  "HTTP/1.1",
+		header: "h3",
+		want:   "HTTP/3.0",
+	}, {
+		name:  "http/3",
+		proto: "HTTP/3.0",
+		want:  "HTTP/3.0",
+	}, {
+		name:  "unexpected",
+		proto: "HTTP/4.2",
+		want:  "other",
+	}, {
+		name:   "unexpected header",
+		proto:  "HTTP/1.1",
+		header: "spdy/3",
+		want:   "other",
+	}, {
+		name:        "grpc",
+		proto:       "HTTP/2.0",
+		contentType: "application/grpc",
+		grpc:        true,
+		want:        "grpc",
+	}, {
+		name:  "http with grpc",
+		proto: "HTTP/3.0",
+		grpc:  true,
+		want:  "HTTP/3.0",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+			opts := []RequestMetricsOption{WithProtocolTag("X-Forwarded-Proto-Version")}
+			if test.grpc {
+				opts = append(opts, WithGRPCStatus())
+			}
+			h, err := NewRequestMetricsHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+				"ns", "svc", "cfg", "rev", "pod", nil, nil, opts...)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+			req.Proto = test.proto
+			if test.header != "" {
+				req.Header.Set("X-Forwarded-Proto-Version", test.header)
+			}
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      "200",
+				metrics.LabelResponseCodeClass: "2xx",
+				metrics.LabelRouteTag:          disabledTagName,
+				"protocol":                     test.want,
+			}))
+		})
+	}
+}
+
+func TestRequestMetricsHandlerCPUTime(t *testing.T) {
+	if !cpuTimeSupported {
+		t.Skip("The platform can't measure the CPU time of a thread")
+	}
+	defer reset()
+
+	const busy = 50 * time.Millisecond
+	h, err := NewRequestMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("busy") == "" {
+			time.Sleep(busy)
+			return
+		}
+		// Spin until the thread used the CPU for as long.
+		start, _ := threadCPUTime()
+		for {
+			if now, _ := threadCPUTime(); now-start >= busy {
+				return
+			}
+		}
+	}), "ns", "svc", "cfg", "rev", "pod", nil, nil, WithCPUTime())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	serve := func(route, query string) {
+		req := httptest.NewRequest(http.MethodGet, targetURI+query, nil)
+		req.Header.Set(network.TagHeaderName, route)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve("busy", "?busy=true")
+	serve("idle", "")
+
+	sums := map[string]float64{}
+	for _, v := range metricstest.GetOneMetric("request_cpu_time").Values {
+		if v.Distribution == nil || v.Distribution.Count != 1 {
+			t.Fatalf("Value for %v = %v, want a distribution of one request", v.Tags, v.Distribution)
+		}
+		sums[v.Tags[metrics.LabelRouteTag]] = v.Distribution.Sum
+	}
+	if got, want := sums["busy"], float64(busy/time.Millisecond); got < want {
+		t.Errorf("CPU time of the busy request = %vms, want at least %vms", got, want)
+	}
+	// Sleeping takes hardly any CPU time.
+	if got, want := sums["idle"], float64(busy/time.Millisecond)/2; got >= want {
+		t.Errorf("CPU time of the idle request = %vms, want less than %vms", got, want)
+	}
+}
+
+func TestMetricsEnvironment(t *testing.T) {
+	defer reset()
+	if err := metrics.SetEnvironment("staging"); err != nil {
+		t.Fatal("SetEnvironment() =", err)
+	}
+	defer metrics.SetEnvironment("")
+
+	h, err := NewRequestMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+
+	wantTags := map[string]string{
+		metrics.LabelPodName:           "pod",
+		metrics.LabelContainerName:     "queue-proxy",
+		metrics.LabelResponseCode:      "200",
+		metrics.LabelResponseCodeClass: "2xx",
+		metrics.LabelRouteTag:          disabledTagName,
+	}
+	wantResource := &resource.Resource{
+		Type: "knative_revision",
+		Labels: map[string]string{
+			metrics.LabelNamespaceName:     "ns",
+			metrics.LabelRevisionName:      "rev",
+			metrics.LabelServiceName:       "svc",
+			metrics.LabelConfigurationName: "cfg",
+			metrics.LabelEnvironment:       "staging",
+		},
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, wantTags).WithResource(wantResource))
+	metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("request_latencies", 1, wantTags).WithResource(wantResource))
+
+	// Metrics other than the request metrics are labelled as well.
+	reset()
+	s, err := NewQueueDepthSampler(NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 1}),
+		"ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create sampler:", err)
+	}
+	s.sample()
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("queue_depth", 0, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}).WithResource(wantResource))
+}
+
+func TestQueueTimeRatioReporter(t *testing.T) {
+	defer metricstest.Unregister(aggregateQueueTimeRatioM.Name())
+
+	r, err := NewQueueTimeRatioReporter("ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	assertRatio := func(want float64) {
+		t.Helper()
+		metricstest.AssertMetricRequiredOnly(t, metricstest.FloatMetric("aggregate_queue_time_ratio", want, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}))
+	}
+
+	// Nothing is recorded without requests.
+	r.report()
+	metricstest.AssertNoMetric(t, "aggregate_queue_time_ratio")
+
+	// The ratio is of the totals, not the average of the requests' ratios.
+	r.observe(time.Second, 4*time.Second)
+	r.observe(3*time.Second, 4*time.Second)
+	r.observe(0, 2*time.Second)
+	r.report()
+	assertRatio(0.4)
+
+	// Every interval starts over, intervals without requests keep the last
+	// ratio.
+	r.report()
+	assertRatio(0.4)
+	r.observe(0, time.Second)
+	r.report()
+	assertRatio(0)
+}
+
+func TestRequestMetricsHandlerQueueTimeRatio(t *testing.T) {
+	defer reset()
+	defer metricstest.Unregister(aggregateQueueTimeRatioM.Name())
+
+	r, err := NewQueueTimeRatioReporter("ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+	const unit = 50 * time.Millisecond
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(unit)
+	})
+	// The breaker has no capacity until after the request arrived.
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithQueueTimeRatio(r))
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	ratio := func() float64 {
+		t.Helper()
+		r.report()
+		metricstest.EnsureRecorded()
+		return *metricstest.GetOneMetric("aggregate_queue_time_ratio").Values[0].Float64
+	}
+
+	// The request waits as long as it's served.
+	time.AfterFunc(unit, func() { breaker.UpdateConcurrency(1) })
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	if got, want := ratio(), 0.5; math.Abs(got-want) > 0.15 {
+		t.Errorf("aggregate_queue_time_ratio = %v, want about %v", got, want)
+	}
+
+	// A request timing out in the queue spent all its time there.
+	breaker.UpdateConcurrency(0)
+	req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+	ctx, cancel := context.WithTimeout(req.Context(), unit)
+	defer cancel()
+	h.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	if got, want := ratio(), 1.0; math.Abs(got-want) > 0.15 {
+		t.Errorf("aggregate_queue_time_ratio = %v, want about %v", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "go.uber.org/atomic"
+
+// resizeTracker remembers the capacity the breaker had before it was last
+// shrunk, to tell requests that wait because of the shrink from those that
+// wait because of the load.
+type resizeTracker struct {
+	// shrinks counts the shrinks, shrunkFrom is the highest capacity since
+	// the breaker last had at least that much again, or 0.
+	shrinks    atomic.Uint64
+	shrunkFrom atomic.Int64
+}
+
+// resizeSnapshot is the state of a resizeTracker when a request started
+// waiting for capacity.
+type resizeSnapshot struct {
+	shrinks uint64
+	// admissible is whether the request would have been admitted without
+	// waiting at the capacity before the shrink.
+	admissible bool
+}
+
+// resized records the capacity of the breaker changing from old to size.
+func (t *resizeTracker) resized(old, size int) {
+	if size < old {
+		if int64(old) > t.shrunkFrom.Load() {
+			t.shrunkFrom.Store(int64(old))
+		}
+		t.shrinks.Inc()
+	} else if int64(size) >= t.shrunkFrom.Load() {
+		t.shrunkFrom.Store(0)
+	}
+}
+
+// snapshot returns the state of the tracker for a request that's about to
+// acquire capacity, with inFlight requests in the breaker including it.
+func (t *resizeTracker) snapshot(inFlight int64) resizeSnapshot {
+	return resizeSnapshot{
+		shrinks:    t.shrinks.Load(),
+		admissible: inFlight <= t.shrunkFrom.Load(),
+	}
+}
+
+// induced returns whether a request that had to wait since s was taken did
+// so because of a shrink: either it would have been admitted right away
+// before the shrink, or the breaker shrank while it waited.
+func (t *resizeTracker) induced(s resizeSnapshot) bool {
+	return s.admissible || t.shrinks.Load() != s.shrinks
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	pkgnet "knative.dev/pkg/network"
+)
+
+// RetryTransport wraps the transport to the user container to retry requests
+// up to retries times, backoff apart, if the connection was refused. Since
+// such requests never reached the user container, retrying them is safe as
+// long as their body can be sent again. Requests that fail on every attempt
+// are marked for the retry_exhausted tag of the request metrics handler.
+func RetryTransport(retries int, backoff time.Duration, next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		for attempt := 0; attempt < retries && retryable(r, err); attempt++ {
+			select {
+			case <-r.Context().Done():
+				return nil, r.Context().Err()
+			case <-time.After(backoff):
+			}
+			if r.GetBody != nil {
+				body, berr := r.GetBody()
+				if berr != nil {
+					return nil, berr
+				}
+				r.Body = body
+			}
+			resp, err = next.RoundTrip(r)
+			if attempt == retries-1 && retryable(r, err) {
+				if state := requestMetricsStateFrom(r.Context()); state != nil {
+					state.setRetryExhausted()
+				}
+			}
+		}
+		return resp, err
+	})
+}
+
+// retryable returns whether the request failed with err can be sent again.
+func retryable(r *http.Request, err error) bool {
+	if err == nil || !errors.Is(err, syscall.ECONNREFUSED) {
+		return false
+	}
+	return replayable(r)
+}
+
+// replayable returns whether the request's body can be sent again.
+func replayable(r *http.Request) bool {
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+const (
+	// Values of the outcome tag of proxy_retries.
+	retryOutcomeSuccess   = "success"
+	retryOutcomeExhausted = "exhausted"
+	retryOutcomeError     = "error"
+)
+
+// RetryConfig configures retrying requests the user container failed in a
+// way that suggests it isn't ready yet, e.g. during warmup, see Transport.
+type RetryConfig struct {
+	// MaxAttempts is the number of times a request is sent at most,
+	// including the first attempt.
+	MaxAttempts int
+
+	// AttemptTimeout, if positive, bounds every attempt. Attempts timing out
+	// are retried.
+	AttemptTimeout time.Duration
+
+	// Backoff is the time waited between attempts.
+	Backoff time.Duration
+
+	// RetryStatus returns whether a response with the given status code is
+	// to be retried. If nil, only 503 responses are.
+	RetryStatus func(code int) bool
+
+	// Methods are the HTTP methods retried on top of the idempotent GET,
+	// HEAD, PUT and DELETE.
+	Methods []string
+}
+
+// Transport wraps the transport to the user container to send requests up to
+// MaxAttempts times if the connection was refused, the attempt timed out or
+// the response status is to be retried. Only requests of idempotent or
+// explicitly allowed methods whose body can be sent again are retried. The
+// retries of a request are passed to the request metrics handler for the
+// proxy_retries metric, and requests that fail on every attempt are marked
+// for the retry_exhausted tag.
+func (c RetryConfig) Transport(next http.RoundTripper) http.RoundTripper {
+	methods := map[string]struct{}{
+		http.MethodGet:    {},
+		http.MethodHead:   {},
+		http.MethodPut:    {},
+		http.MethodDelete: {},
+	}
+	for _, m := range c.Methods {
+		methods[strings.ToUpper(m)] = struct{}{}
+	}
+	retryStatus := c.RetryStatus
+	if retryStatus == nil {
+		retryStatus = func(code int) bool { return code == http.StatusServiceUnavailable }
+	}
+
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if _, ok := methods[r.Method]; !ok {
+			return next.RoundTrip(r)
+		}
+
+		var (
+			resp    *http.Response
+			err     error
+			retries int
+		)
+		for attempt := 1; ; attempt++ {
+			resp, err = c.attempt(next, r)
+			var retry bool
+			switch {
+			case err == nil:
+				retry = retryStatus(resp.StatusCode)
+			case errors.Is(err, syscall.ECONNREFUSED):
+				retry = true
+			case errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil:
+				// Only the attempt timed out.
+				retry = true
+			}
+			retry = retry && replayable(r)
+			if !retry || attempt >= c.MaxAttempts {
+				if retries > 0 {
+					outcome := retryOutcomeSuccess
+					switch {
+					case retry:
+						outcome = retryOutcomeExhausted
+					case err != nil:
+						outcome = retryOutcomeError
+					}
+					if state := requestMetricsStateFrom(r.Context()); state != nil {
+						state.setProxyRetries(retries, outcome)
+						if retry {
+							state.setRetryExhausted()
+						}
+					}
+				}
+				return resp, err
+			}
+
+			if resp != nil {
+				// Drain the body so that the connection can be reused.
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			select {
+			case <-r.Context().Done():
+				return nil, r.Context().Err()
+			case <-time.After(c.Backoff):
+			}
+			if r.GetBody != nil {
+				body, berr := r.GetBody()
+				if berr != nil {
+					return nil, berr
+				}
+				r.Body = body
+			}
+			retries++
+		}
+	})
+}
+
+// attempt sends the request once, bounded by the attempt timeout if any.
+func (c RetryConfig) attempt(next http.RoundTripper, r *http.Request) (*http.Response, error) {
+	if c.AttemptTimeout <= 0 {
+		return next.RoundTrip(r)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), c.AttemptTimeout)
+	resp, err := next.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout covers reading the body, too.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of the request it's the response body of
+// once closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
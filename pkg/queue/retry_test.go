@@ -0,0 +1,326 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+	"knative.dev/pkg/metrics/metricstest"
+	pkgnet "knative.dev/pkg/network"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var errRefused = fmt.Errorf("dial tcp: %w", syscall.ECONNREFUSED)
+
+// failingTransport refuses the first failures connections and responds with
+// 200 afterwards, counting the attempts.
+func failingTransport(failures int, attempts *atomic.Int32) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if int(attempts.Inc()) <= failures {
+			return nil, errRefused
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		body         bool
+		wantAttempts int32
+		wantErr      bool
+	}{{
+		name:         "first attempt succeeds",
+		wantAttempts: 1,
+	}, {
+		name:         "retry succeeds",
+		failures:     2,
+		wantAttempts: 3,
+	}, {
+		name:         "retries exhausted",
+		failures:     10,
+		wantAttempts: 4,
+		wantErr:      true,
+	}, {
+		name:         "body can't be replayed",
+		failures:     1,
+		body:         true,
+		wantAttempts: 1,
+		wantErr:      true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := atomic.NewInt32(0)
+			transport := RetryTransport(3, 0 /*backoff*/, failingTransport(test.failures, attempts))
+
+			req := httptest.NewRequest(http.MethodPost, targetURI, nil)
+			if test.body {
+				req.Body = ioutil.NopCloser(strings.NewReader("body"))
+			}
+			_, err := transport.RoundTrip(req)
+			if (err != nil) != test.wantErr {
+				t.Errorf("RoundTrip() = %v, want error: %v", err, test.wantErr)
+			}
+			if got := attempts.Load(); got != test.wantAttempts {
+				t.Errorf("Attempts = %d, want: %d", got, test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRequestMetricsHandlerRetryExhausted(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		wantCode  string
+		wantTag   string
+	}{{
+		name:      "first attempt success",
+		transport: failingTransport(0, atomic.NewInt32(0)),
+		wantCode:  "200",
+		wantTag:   "false",
+	}, {
+		name: "first attempt failure",
+		transport: pkgnet.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("not retryable")
+		}),
+		wantCode: "502",
+		wantTag:  "false",
+	}, {
+		name:      "retries exhausted",
+		transport: failingTransport(10, atomic.NewInt32(0)),
+		wantCode:  "502",
+		wantTag:   "true",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			transport := RetryTransport(2, 0 /*backoff*/, test.transport)
+			proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resp, err := transport.RoundTrip(r.Clone(r.Context()))
+				if err != nil {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.WriteHeader(resp.StatusCode)
+			})
+			h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+				WithRetryExhaustedTag())
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("request_count", 1, map[string]string{
+				metrics.LabelPodName:           "pod",
+				metrics.LabelContainerName:     "queue-proxy",
+				metrics.LabelResponseCode:      test.wantCode,
+				metrics.LabelResponseCodeClass: test.wantCode[:1] + "xx",
+				metrics.LabelRouteTag:          disabledTagName,
+				"retry_exhausted":              test.wantTag,
+			}))
+		})
+	}
+}
+
+// statusTransport responds with 503 to the first failures requests and with
+// 200 afterwards, counting the attempts.
+func statusTransport(failures int, attempts *atomic.Int32) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if int(attempts.Inc()) <= failures {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+}
+
+func TestRetryConfigTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       RetryConfig
+		method       string
+		transport    func(*atomic.Int32) http.RoundTripper
+		wantAttempts int32
+		wantStatus   int
+		wantErr      bool
+	}{{
+		name:   "status retry succeeds",
+		config: RetryConfig{MaxAttempts: 3},
+		method: http.MethodGet,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return statusTransport(2, attempts)
+		},
+		wantAttempts: 3,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:   "status retries exhausted",
+		config: RetryConfig{MaxAttempts: 3},
+		method: http.MethodGet,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return statusTransport(10, attempts)
+		},
+		wantAttempts: 3,
+		wantStatus:   http.StatusServiceUnavailable,
+	}, {
+		name:   "connection refused",
+		config: RetryConfig{MaxAttempts: 3},
+		method: http.MethodPut,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return failingTransport(1, attempts)
+		},
+		wantAttempts: 2,
+		wantStatus:   http.StatusOK,
+	}, {
+		name: "status not retried",
+		config: RetryConfig{
+			MaxAttempts: 3,
+			RetryStatus: func(code int) bool { return code == http.StatusBadGateway },
+		},
+		method: http.MethodGet,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return statusTransport(10, attempts)
+		},
+		wantAttempts: 1,
+		wantStatus:   http.StatusServiceUnavailable,
+	}, {
+		name:   "non-idempotent method",
+		config: RetryConfig{MaxAttempts: 3},
+		method: http.MethodPost,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return statusTransport(10, attempts)
+		},
+		wantAttempts: 1,
+		wantStatus:   http.StatusServiceUnavailable,
+	}, {
+		name:   "allowed method",
+		config: RetryConfig{MaxAttempts: 3, Methods: []string{"post"}},
+		method: http.MethodPost,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return statusTransport(1, attempts)
+		},
+		wantAttempts: 2,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:   "attempt timeout",
+		config: RetryConfig{MaxAttempts: 3, AttemptTimeout: 10 * time.Millisecond},
+		method: http.MethodGet,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if attempts.Inc() == 1 {
+					<-r.Context().Done()
+					return nil, r.Context().Err()
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			})
+		},
+		wantAttempts: 2,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:   "unretryable error",
+		config: RetryConfig{MaxAttempts: 3},
+		method: http.MethodGet,
+		transport: func(attempts *atomic.Int32) http.RoundTripper {
+			return pkgnet.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				attempts.Inc()
+				return nil, errors.New("not retryable")
+			})
+		},
+		wantAttempts: 1,
+		wantErr:      true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := atomic.NewInt32(0)
+			transport := test.config.Transport(test.transport(attempts))
+
+			resp, err := transport.RoundTrip(httptest.NewRequest(test.method, targetURI, nil))
+			if (err != nil) != test.wantErr {
+				t.Errorf("RoundTrip() = %v, want error: %v", err, test.wantErr)
+			}
+			if err == nil {
+				defer resp.Body.Close()
+				if resp.StatusCode != test.wantStatus {
+					t.Errorf("StatusCode = %d, want: %d", resp.StatusCode, test.wantStatus)
+				}
+			}
+			if got := attempts.Load(); got != test.wantAttempts {
+				t.Errorf("Attempts = %d, want: %d", got, test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRequestMetricsHandlerProxyRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		wantRetries int64
+		wantOutcome string
+	}{{
+		name:        "retry succeeds",
+		failures:    1,
+		wantRetries: 1,
+		wantOutcome: retryOutcomeSuccess,
+	}, {
+		name:        "retries exhausted",
+		failures:    10,
+		wantRetries: 2,
+		wantOutcome: retryOutcomeExhausted,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			transport := RetryConfig{MaxAttempts: 3}.Transport(statusTransport(test.failures, atomic.NewInt32(0)))
+			proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resp, err := transport.RoundTrip(r.Clone(r.Context()))
+				if err != nil {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				resp.Body.Close()
+				w.WriteHeader(resp.StatusCode)
+			})
+			h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+			metricstest.AssertMetric(t, metricstest.IntMetric("proxy_retries", test.wantRetries, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+				"outcome":                  test.wantOutcome,
+			}))
+		})
+	}
+}
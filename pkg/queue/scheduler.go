@@ -0,0 +1,309 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type requestCostKey struct{}
+
+// WithRequestCost returns a context marking the request as occupying cost
+// concurrency slots in a breaker using cost-deadline scheduling. Requests
+// without a cost occupy a single slot.
+func WithRequestCost(ctx context.Context, cost int) context.Context {
+	return context.WithValue(ctx, requestCostKey{}, cost)
+}
+
+// requestCost returns the cost attached to ctx by WithRequestCost or 1.
+func requestCost(ctx context.Context) int {
+	if cost, ok := ctx.Value(requestCostKey{}).(int); ok {
+		return cost
+	}
+	return 1
+}
+
+// costDeadlineScheduler hands out concurrency slots to requests of varying
+// cost. Whenever capacity frees up, it admits the queued request with the
+// nearest deadline among those whose cost fits the free capacity, and repeats
+// until nothing fits anymore. Ties are broken deterministically by preferring
+// the cheaper request and then the one that was queued first. Requests
+// without a deadline go after all requests with one.
+//
+// With a fair share, the scheduler instead admits the fitting request with
+// the lowest fair share tag, ignoring deadlines, to implement
+// QueueModeWeightedFair.
+type costDeadlineScheduler struct {
+	maxCapacity int
+	fair        *fairShare
+
+	mux      sync.Mutex
+	capacity int
+	inUse    int
+	seq      uint64
+	waiters  []*schedulerWaiter
+
+	// queue tracks the requests waiting, if set.
+	queue *queueTracker
+
+	// free holds the waiters to reuse if pooled, see preallocate.
+	pooled bool
+	free   []*schedulerWaiter
+}
+
+type schedulerWaiter struct {
+	cost     int
+	deadline time.Time
+	seq      uint64
+
+	// tag is the waiter's fair share tag, if the scheduler has a fair share.
+	tag float64
+
+	// ready receives a token once the waiter is admitted.
+	ready    chan struct{}
+	admitted bool
+}
+
+func newSchedulerWaiter() *schedulerWaiter {
+	return &schedulerWaiter{ready: make(chan struct{}, 1)}
+}
+
+func newCostDeadlineScheduler(maxCapacity, initialCapacity int) *costDeadlineScheduler {
+	return &costDeadlineScheduler{
+		maxCapacity: maxCapacity,
+		capacity:    initialCapacity,
+	}
+}
+
+// preallocate makes the scheduler allocate room for n waiters up front and
+// reuse the waiters once their requests are done, so that queueing requests
+// doesn't allocate as long as no more than n wait at once.
+func (s *costDeadlineScheduler) preallocate(n int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.pooled = true
+	s.waiters = make([]*schedulerWaiter, 0, n)
+	s.free = make([]*schedulerWaiter, n)
+	for i := range s.free {
+		s.free[i] = newSchedulerWaiter()
+	}
+}
+
+// costOf returns the cost of the request with the given context, capped to
+// the maximum capacity so that every request can eventually be admitted.
+func (s *costDeadlineScheduler) costOf(ctx context.Context) int {
+	cost := requestCost(ctx)
+	if cost < 1 {
+		return 1
+	}
+	if cost > s.maxCapacity && s.maxCapacity > 0 {
+		return s.maxCapacity
+	}
+	return cost
+}
+
+// tryAcquire takes cost slots if they are free and returns whether it did.
+func (s *costDeadlineScheduler) tryAcquire(cost int) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.capacity-s.inUse < cost {
+		return false
+	}
+	s.inUse += cost
+	return true
+}
+
+// acquireUntil waits until cost slots are assigned to the caller, giving up
+// when ctx is done or stop is closed. A nil stop channel never stops.
+func (s *costDeadlineScheduler) acquireUntil(ctx context.Context, cost int, deadline time.Time, stop <-chan struct{}) error {
+	_, err := s.acquireQueued(ctx, cost, deadline, stop)
+	return err
+}
+
+// acquireQueued is like acquireUntil, but also returns whether the caller had
+// to wait because it wasn't admitted right away.
+func (s *costDeadlineScheduler) acquireQueued(ctx context.Context, cost int, deadline time.Time, stop <-chan struct{}) (bool, error) {
+	w := s.enqueue(cost, deadline, requestTenant(ctx))
+	if s.pooled {
+		defer s.recycle(w)
+	}
+	select {
+	case <-w.ready:
+		return false, nil
+	default:
+	}
+	if s.queue != nil {
+		s.queue.enter()
+		defer s.queue.leave()
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return true, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-stop:
+		err = errSemaphoreStopped
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if w.admitted {
+		// We were admitted while giving up, pass the slots on.
+		s.inUse -= w.cost
+		s.dispatch()
+	} else {
+		s.remove(w)
+		if s.fair != nil && len(s.waiters) == 0 {
+			s.fair.idle()
+		}
+	}
+	return true, err
+}
+
+// enqueue adds a waiter of the given tenant to the queue and admits waiters
+// if possible.
+func (s *costDeadlineScheduler) enqueue(cost int, deadline time.Time, tenant string) *schedulerWaiter {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.seq++
+	var w *schedulerWaiter
+	if n := len(s.free); n > 0 {
+		w = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		w = newSchedulerWaiter()
+	}
+	w.cost, w.deadline, w.seq, w.tag, w.admitted = cost, deadline, s.seq, 0, false
+	if s.fair != nil {
+		w.tag = s.fair.tag(tenant, cost)
+	}
+	s.waiters = append(s.waiters, w)
+	s.dispatch()
+	return w
+}
+
+// recycle returns a waiter that left the queue to the pool, unless the pool
+// is full already.
+func (s *costDeadlineScheduler) recycle(w *schedulerWaiter) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	// The waiter might have been admitted while giving up.
+	select {
+	case <-w.ready:
+	default:
+	}
+	if len(s.free) < cap(s.free) {
+		s.free = append(s.free, w)
+	}
+}
+
+// release returns cost slots and admits waiters if possible.
+func (s *costDeadlineScheduler) release(cost int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.inUse < cost {
+		panic("release and acquire are not paired")
+	}
+	s.inUse -= cost
+	s.dispatch()
+}
+
+// updateCapacity updates the capacity to the desired size.
+func (s *costDeadlineScheduler) updateCapacity(size int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.capacity = size
+	s.dispatch()
+}
+
+// Capacity is the capacity of the scheduler.
+func (s *costDeadlineScheduler) Capacity() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.capacity
+}
+
+// dispatch admits the best fitting waiters until none fits the free capacity.
+// The caller must hold the lock.
+func (s *costDeadlineScheduler) dispatch() {
+	for {
+		free := s.capacity - s.inUse
+		best := -1
+		for i, w := range s.waiters {
+			if w.cost <= free && (best < 0 || s.before(w, s.waiters[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+
+		w := s.waiters[best]
+		s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+		s.inUse += w.cost
+		w.admitted = true
+		// Every waiter is admitted once, so this never blocks.
+		w.ready <- struct{}{}
+		if s.fair != nil {
+			s.fair.admit(w.tag)
+			if len(s.waiters) == 0 {
+				s.fair.idle()
+			}
+		}
+	}
+}
+
+// remove drops a waiter from the queue. The caller must hold the lock.
+func (s *costDeadlineScheduler) remove(w *schedulerWaiter) {
+	for i, o := range s.waiters {
+		if o == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// before returns whether w is to be admitted before o, by their fair share
+// tags if the scheduler has a fair share.
+func (s *costDeadlineScheduler) before(w, o *schedulerWaiter) bool {
+	switch {
+	case s.fair == nil:
+		return w.before(o)
+	case w.tag != o.tag:
+		return w.tag < o.tag
+	default:
+		return w.seq < o.seq
+	}
+}
+
+// before returns whether w is to be admitted before o.
+func (w *schedulerWaiter) before(o *schedulerWaiter) bool {
+	switch {
+	case w.deadline.IsZero() != o.deadline.IsZero():
+		return o.deadline.IsZero()
+	case !w.deadline.Equal(o.deadline):
+		return w.deadline.Before(o.deadline)
+	case w.cost != o.cost:
+		return w.cost < o.cost
+	default:
+		return w.seq < o.seq
+	}
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCostDeadlineSchedulerOrder(t *testing.T) {
+	s := newCostDeadlineScheduler(4, 4)
+	hold := s.enqueue(4, time.Time{}, "")
+	if !hold.admitted {
+		t.Fatal("Request fitting the free capacity was not admitted")
+	}
+
+	now := time.Now()
+	waiters := map[string]*schedulerWaiter{
+		"a": s.enqueue(3, now.Add(1*time.Second), ""),
+		"b": s.enqueue(1, now.Add(3*time.Second), ""),
+		"c": s.enqueue(2, now.Add(2*time.Second), ""),
+		"d": s.enqueue(1, time.Time{}, ""),
+		"e": s.enqueue(1, now.Add(2*time.Second), ""),
+		"f": s.enqueue(1, now.Add(3*time.Second), ""),
+	}
+	admitted := func() []string {
+		var names []string
+		for name, w := range waiters {
+			select {
+			case <-w.ready:
+				names = append(names, name)
+				delete(waiters, name)
+			default:
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got := admitted(); len(got) != 0 {
+		t.Fatalf("Admitted %v without free capacity", got)
+	}
+
+	for _, step := range []struct {
+		name    string
+		release int
+		want    []string
+	}{{
+		// a has the nearest deadline, of the rest only e fits and has the
+		// nearest deadline of those.
+		name:    "release all",
+		release: 4,
+		want:    []string{"a", "e"},
+	}, {
+		// c has the nearest deadline, b is queued before f.
+		name:    "release a",
+		release: 3,
+		want:    []string{"b", "c"},
+	}, {
+		name:    "release e",
+		release: 1,
+		want:    []string{"f"},
+	}, {
+		// Requests without a deadline go last.
+		name:    "release c",
+		release: 2,
+		want:    []string{"d"},
+	}} {
+		s.release(step.release)
+		if got := admitted(); !cmp.Equal(got, step.want) {
+			t.Errorf("%s: admitted = %v, want: %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestCostDeadlineSchedulerWaiterOrder(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		w, o schedulerWaiter
+		want bool
+	}{{
+		name: "nearer deadline",
+		w:    schedulerWaiter{cost: 3, deadline: now, seq: 2},
+		o:    schedulerWaiter{cost: 1, deadline: now.Add(time.Second), seq: 1},
+		want: true,
+	}, {
+		name: "deadline before none",
+		w:    schedulerWaiter{cost: 3, deadline: now.Add(time.Hour), seq: 2},
+		o:    schedulerWaiter{cost: 1, seq: 1},
+		want: true,
+	}, {
+		name: "none after deadline",
+		w:    schedulerWaiter{cost: 1, seq: 1},
+		o:    schedulerWaiter{cost: 3, deadline: now, seq: 2},
+		want: false,
+	}, {
+		name: "same deadline, cheaper",
+		w:    schedulerWaiter{cost: 1, deadline: now, seq: 2},
+		o:    schedulerWaiter{cost: 2, deadline: now, seq: 1},
+		want: true,
+	}, {
+		name: "same deadline and cost, queued first",
+		w:    schedulerWaiter{cost: 1, deadline: now, seq: 1},
+		o:    schedulerWaiter{cost: 1, deadline: now, seq: 2},
+		want: true,
+	}, {
+		name: "no deadlines, cheaper",
+		w:    schedulerWaiter{cost: 1, seq: 2},
+		o:    schedulerWaiter{cost: 2, seq: 1},
+		want: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.w.before(&test.o); got != test.want {
+				t.Errorf("before() = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCostDeadlineSchedulerCancel(t *testing.T) {
+	s := newCostDeadlineScheduler(2, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.acquireUntil(ctx, 2, time.Time{}, nil); err != context.Canceled {
+		t.Fatalf("acquireUntil() = %v, want: %v", err, context.Canceled)
+	}
+	stop := make(chan struct{})
+	close(stop)
+	if err := s.acquireUntil(context.Background(), 2, time.Time{}, stop); err != errSemaphoreStopped {
+		t.Fatalf("acquireUntil() = %v, want: %v", err, errSemaphoreStopped)
+	}
+
+	// The abandoned requests neither linger in the queue nor hold capacity.
+	if got := len(s.waiters); got != 0 {
+		t.Errorf("len(waiters) = %d, want: 0", got)
+	}
+	if !s.tryAcquire(1) {
+		t.Error("tryAcquire() = false, want: true")
+	}
+}
+
+func TestCostDeadlineSchedulerCostOf(t *testing.T) {
+	s := newCostDeadlineScheduler(4, 4)
+	for cost, want := range map[int]int{-1: 1, 0: 1, 1: 1, 3: 3, 4: 4, 10: 4} {
+		if got := s.costOf(WithRequestCost(context.Background(), cost)); got != want {
+			t.Errorf("costOf(%d) = %d, want: %d", cost, got, want)
+		}
+	}
+	if got, want := s.costOf(context.Background()), 1; got != want {
+		t.Errorf("costOf() = %d, want: %d", got, want)
+	}
+}
+
+func TestBreakerCostDeadlineScheduling(t *testing.T) {
+	for _, preallocate := range []bool{false, true} {
+		t.Run(fmt.Sprint("preallocate=", preallocate), func(t *testing.T) {
+			testBreakerCostDeadlineScheduling(t, preallocate)
+		})
+	}
+}
+
+func testBreakerCostDeadlineScheduling(t *testing.T, preallocate bool) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 3, InitialCapacity: 0,
+		CostDeadlineScheduling: true, PreallocateWaiters: preallocate})
+
+	order := make(chan string, 3)
+	done := make(chan struct{})
+	request := func(name string, cost int, timeout time.Duration) {
+		ctx, cancel := context.WithTimeout(WithRequestCost(context.Background(), cost), timeout)
+		defer cancel()
+		if err := b.Maybe(ctx, func() {
+			order <- name
+			<-done
+		}); err != nil {
+			t.Errorf("Maybe(%s) = %v", name, err)
+		}
+	}
+
+	// Queue the requests one by one to make their order deterministic.
+	for i, r := range []struct {
+		name    string
+		cost    int
+		timeout time.Duration
+	}{
+		{"late", 1, time.Hour},
+		{"expensive", 2, time.Minute},
+		{"soon", 1, time.Minute},
+	} {
+		go request(r.name, r.cost, r.timeout)
+		for b.InFlight() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Only a single slot becomes available so the expensive request can't be
+	// admitted despite its deadline.
+	b.UpdateConcurrency(1)
+	if got, want := <-order, "soon"; got != want {
+		t.Errorf("First admitted = %s, want: %s", got, want)
+	}
+	b.UpdateConcurrency(3)
+	if got, want := <-order, "expensive"; got != want {
+		t.Errorf("Second admitted = %s, want: %s", got, want)
+	}
+	close(done)
+	if got, want := <-order, "late"; got != want {
+		t.Errorf("Third admitted = %s, want: %s", got, want)
+	}
+}
+
+func TestCostDeadlineSchedulerPreallocate(t *testing.T) {
+	s := newCostDeadlineScheduler(1, 1)
+	s.preallocate(2)
+	pool := map[*schedulerWaiter]bool{}
+	for _, w := range s.free {
+		pool[w] = true
+	}
+	assertPool := func() {
+		t.Helper()
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		if got, want := len(s.free), 2; got != want {
+			t.Fatalf("len(free) = %d, want: %d", got, want)
+		}
+		for _, w := range s.free {
+			if !pool[w] {
+				t.Error("Waiter was not preallocated")
+			}
+			if len(w.ready) != 0 {
+				t.Error("Recycled waiter is still marked ready")
+			}
+		}
+	}
+
+	// Admitted right away.
+	if err := s.acquireUntil(context.Background(), 1, time.Time{}, nil); err != nil {
+		t.Fatal("acquireUntil() =", err)
+	}
+	assertPool()
+
+	// Admitted after waiting.
+	done := make(chan error)
+	go func() {
+		done <- s.acquireUntil(context.Background(), 1, time.Time{}, nil)
+	}()
+	waitForWaiters(t, s, 1)
+	s.release(1)
+	if err := <-done; err != nil {
+		t.Fatal("acquireUntil() =", err)
+	}
+	assertPool()
+
+	// Admitted while giving up.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- s.acquireUntil(ctx, 1, time.Time{}, nil)
+	}()
+	waitForWaiters(t, s, 1)
+	s.mux.Lock()
+	cancel()
+	// Give the waiter time to notice the cancellation and block on the lock.
+	time.Sleep(10 * time.Millisecond)
+	s.inUse--
+	s.dispatch()
+	s.mux.Unlock()
+	if err := <-done; err == nil {
+		// The waiter saw it was admitted before it saw the cancellation.
+		s.release(1)
+	}
+	assertPool()
+	if got := s.inUse; got != 0 {
+		t.Errorf("inUse = %d, want: 0", got)
+	}
+
+	// Queueing reuses the waiters rather than allocating.
+	if got := testing.AllocsPerRun(100, func() {
+		s.acquireUntil(context.Background(), 1, time.Time{}, nil)
+		s.release(1)
+	}); got != 0 {
+		t.Errorf("Allocations per request = %v, want: 0", got)
+	}
+}
+
+// waitForWaiters waits until n waiters are queued in s.
+func waitForWaiters(t *testing.T, s *costDeadlineScheduler, n int) {
+	t.Helper()
+	for i := 0; ; i++ {
+		s.mux.Lock()
+		got := len(s.waiters)
+		s.mux.Unlock()
+		if got == n {
+			return
+		}
+		if i == 5000 {
+			t.Fatalf("len(waiters) = %d, want: %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkBreakerQueueing(b *testing.B) {
+	op := func() {
+		// Keep the slot long enough for the other requests to queue.
+		runtime.Gosched()
+	}
+	for _, preallocate := range []bool{false, true} {
+		breaker := NewBreaker(BreakerParams{QueueDepth: 10000, MaxConcurrency: 1, InitialCapacity: 1,
+			CostDeadlineScheduling: true, PreallocateWaiters: preallocate})
+		b.Run(fmt.Sprint("preallocate=", preallocate), func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					breaker.Maybe(context.Background(), op)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	network "knative.dev/networking/pkg"
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+const (
+	// dropReasonLoadShed is the dropped_request_count reason for requests
+	// shed by the LatencyShedHandler.
+	dropReasonLoadShed = "load_shed"
+
+	// latencySmoothing is the weight of a new observation in the moving
+	// average of the latency.
+	latencySmoothing = 0.2
+
+	// maxShedProbability leaves some requests through even under heavy
+	// overload, so that the latency keeps being observed and shedding stops
+	// once it recovers.
+	maxShedProbability = 0.9
+)
+
+var shedProbabilityM = stats.Float64(
+	"shed_probability",
+	"The probability of a request being shed due to high latency",
+	stats.UnitDimensionless)
+
+// LatencyShedder computes the probability to shed requests from a moving
+// average of the request latency. The probability grows linearly from 0 at
+// the target latency to maxShedProbability at twice the target latency.
+type LatencyShedder struct {
+	target   time.Duration
+	statsCtx context.Context
+	random   func() float64
+
+	mux         sync.Mutex
+	average     float64
+	probability float64
+}
+
+// NewLatencyShedder creates a LatencyShedder for the given target latency,
+// recording the shed_probability gauge for the given revision.
+func NewLatencyShedder(target time.Duration, ns, service, config, rev, pod string) (*LatencyShedder, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The probability of a request being shed due to high latency",
+		Measure:     shedProbabilityM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LatencyShedder{
+		target:   target,
+		statsCtx: ctx,
+		random:   rand.Float64,
+	}, nil
+}
+
+// Probability returns the current probability to shed a request.
+func (s *LatencyShedder) Probability() float64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.probability
+}
+
+// observe updates the moving average latency with the latency of a served
+// request and recomputes the shed probability.
+func (s *LatencyShedder) observe(latency time.Duration) {
+	s.mux.Lock()
+	if s.average == 0 {
+		s.average = float64(latency)
+	} else {
+		s.average += latencySmoothing * (float64(latency) - s.average)
+	}
+	p := (s.average - float64(s.target)) / float64(s.target)
+	if p < 0 {
+		p = 0
+	} else if p > maxShedProbability {
+		p = maxShedProbability
+	}
+	s.probability = p
+	s.mux.Unlock()
+
+	pkgmetrics.Record(s.statsCtx, shedProbabilityM.M(p))
+}
+
+// shouldShed returns whether to shed the next request.
+func (s *LatencyShedder) shouldShed() bool {
+	p := s.Probability()
+	return p > 0 && s.random() < p
+}
+
+// LatencyShedHandler rejects requests with a 503 with the probability computed
+// by the shedder and feeds the latency of the other requests back into it.
+func LatencyShedHandler(s *LatencyShedder, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if network.IsKubeletProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.shouldShed() {
+			recordDrop(r, dropReasonLoadShed)
+			http.Error(w, "request shed due to high latency", http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		s.observe(time.Since(start))
+	})
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestLatencyShedder(t *testing.T) {
+	defer metricstest.Unregister(shedProbabilityM.Name())
+	s, err := NewLatencyShedder(100*time.Millisecond, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create shedder:", err)
+	}
+
+	assertProbability := func(want float64) {
+		t.Helper()
+		got := s.Probability()
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("Probability() = %v, want: %v", got, want)
+		}
+		// The gauge tracks the computed probability.
+		metricstest.AssertMetricRequiredOnly(t, metricstest.FloatMetric("shed_probability", got, map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+		}))
+	}
+
+	// Below the target nothing is shed.
+	s.observe(50 * time.Millisecond)
+	assertProbability(0)
+
+	// The average moves toward higher latencies: 50 + 0.2*(250-50) = 90.
+	s.observe(250 * time.Millisecond)
+	assertProbability(0)
+	// 90 + 0.2*(390-90) = 150, i.e. 50% above the target.
+	s.observe(390 * time.Millisecond)
+	assertProbability(0.5)
+	// 150 + 0.2*(900-150) = 300, which is capped.
+	s.observe(900 * time.Millisecond)
+	assertProbability(maxShedProbability)
+
+	// Once latencies recover, shedding stops.
+	for i := 0; i < 20; i++ {
+		s.observe(10 * time.Millisecond)
+	}
+	assertProbability(0)
+}
+
+func TestLatencyShedHandler(t *testing.T) {
+	defer reset()
+	defer metricstest.Unregister(shedProbabilityM.Name())
+	s, err := NewLatencyShedder(100*time.Millisecond, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create shedder:", err)
+	}
+	random := 0.0
+	s.random = func() float64 { return random }
+
+	latency := 200 * time.Millisecond
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+	})
+	handler, err := NewRequestMetricsHandler(LatencyShedHandler(s, next), "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+		return rec.Code
+	}
+
+	// The first request is slow, which makes the shedder shed.
+	if got, want := serve(), http.StatusOK; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+	if s.Probability() == 0 {
+		t.Fatal("Probability() = 0 after a slow request")
+	}
+	if got, want := serve(), http.StatusServiceUnavailable; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      disabledTagName,
+		"reason":                   dropReasonLoadShed,
+	}))
+
+	// Requests are let through with the remaining probability.
+	random = maxShedProbability
+	latency = 0
+	if got, want := serve(), http.StatusOK; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+}
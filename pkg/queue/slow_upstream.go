@@ -0,0 +1,62 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// serviceTimeSmoothing is the weight of the latest service time in the
+// moving average kept by the slowUpstreamDetector.
+const serviceTimeSmoothing = 0.2
+
+// slowUpstreamDetector tells queueing caused by a slow user container apart
+// from queueing caused by genuine demand. A request that waited long for a
+// slot while the admitted requests took long to be served waited because of
+// the user container, not because of the number of requests.
+type slowUpstreamDetector struct {
+	queueWait   time.Duration
+	serviceTime time.Duration
+
+	mux sync.Mutex
+	// avgServiceTime is the moving average of the time admitted requests
+	// held their slot.
+	avgServiceTime time.Duration
+}
+
+// observe adds the service time of a request that released its slot.
+func (d *slowUpstreamDetector) observe(serviceTime time.Duration) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.avgServiceTime == 0 {
+		d.avgServiceTime = serviceTime
+		return
+	}
+	d.avgServiceTime += time.Duration(serviceTimeSmoothing * float64(serviceTime-d.avgServiceTime))
+}
+
+// slow returns whether a request that waited for the given time before being
+// admitted was held up by a slow user container.
+func (d *slowUpstreamDetector) slow(wait time.Duration) bool {
+	if wait < d.queueWait {
+		return false
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.avgServiceTime >= d.serviceTime
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	pkgnet "knative.dev/pkg/network"
+)
+
+var (
+	requestStageDurationM = stats.Float64(
+		"request_stage_duration",
+		"The time a request spent in each stage of the queue-proxy pipeline in millisecond",
+		stats.UnitMilliseconds)
+	preAdmissionDeadlineFractionM = stats.Float64(
+		"pre_admission_deadline_fraction",
+		"The fraction of the time to a request's deadline spent waiting for admission by the breaker",
+		stats.UnitDimensionless)
+
+	// preAdmissionDeadlineFractionDistribution covers fractions from
+	// negligible waits to waits consuming the whole deadline.
+	preAdmissionDeadlineFractionDistribution = view.Distribution(
+		0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1)
+
+	// stageKey tags the pipeline stage a duration was spent in.
+	stageKey = tag.MustNewKey("stage")
+)
+
+const (
+	// Values of the stage tag.
+	stagePreBreaker    = "pre_breaker"
+	stageQueueWait     = "queue_wait"
+	stageUpstream      = "upstream"
+	stageResponseWrite = "response_write"
+)
+
+// stageMark denotes a point in time separating two stages of the pipeline.
+type stageMark int
+
+const (
+	// markProxyEntered is when the request reached the ProxyHandler, after
+	// the middleware in front of the breaker.
+	markProxyEntered stageMark = iota
+	// markAdmitted is when the request was admitted by the breaker.
+	markAdmitted
+	// markUpstreamSent is when the request was passed to the transport to the
+	// user container.
+	markUpstreamSent
+	// markUpstreamResponded is when the transport returned the response
+	// headers of the user container.
+	markUpstreamResponded
+
+	numStageMarks
+)
+
+// markStage records the current time as the given mark. It's a no-op on a nil
+// state so callers don't have to check whether request metrics are enabled.
+func (s *requestMetricsState) markStage(m stageMark) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stageMarks[m] = now
+}
+
+// setDeadline records the deadline of the request when it reached the
+// ProxyHandler. It's a no-op on a nil state.
+func (s *requestMetricsState) setDeadline(deadline time.Time) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.deadline = deadline
+}
+
+// preAdmissionDeadlineFraction returns the fraction of the time between the
+// request reaching the ProxyHandler and its deadline that it spent waiting for
+// admission, if it had a deadline and was admitted.
+func (s *requestMetricsState) preAdmissionDeadlineFraction() (float64, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entered, admitted := s.stageMarks[markProxyEntered], s.stageMarks[markAdmitted]
+	if s.deadline.IsZero() || entered.IsZero() || admitted.IsZero() {
+		return 0, false
+	}
+	budget := s.deadline.Sub(entered)
+	if budget <= 0 {
+		return 0, false
+	}
+	return float64(admitted.Sub(entered)) / float64(budget), true
+}
+
+// queueWait returns the time the request waited in the breaker's queue if it
+// completed at end. Requests that were never admitted waited until then.
+func (s *requestMetricsState) queueWait(end time.Time) time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entered, admitted := s.stageMarks[markProxyEntered], s.stageMarks[markAdmitted]
+	switch {
+	case entered.IsZero():
+		return 0
+	case admitted.IsZero():
+		return end.Sub(entered)
+	default:
+		return admitted.Sub(entered)
+	}
+}
+
+// stageDurations returns the durations of the stages of a request that
+// started at start and completed at end. Stages whose bounds weren't marked,
+// e.g. because the request was rejected before reaching the user container,
+// are left out.
+func (s *requestMetricsState) stageDurations(start, end time.Time) map[string]time.Duration {
+	s.mux.Lock()
+	marks := s.stageMarks
+	s.mux.Unlock()
+
+	durations := make(map[string]time.Duration, 4)
+	add := func(stage string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() && !to.Before(from) {
+			durations[stage] = to.Sub(from)
+		}
+	}
+	add(stagePreBreaker, start, marks[markProxyEntered])
+	add(stageQueueWait, marks[markProxyEntered], marks[markAdmitted])
+	add(stageUpstream, marks[markUpstreamSent], marks[markUpstreamResponded])
+	add(stageResponseWrite, marks[markUpstreamResponded], end)
+	return durations
+}
+
+// StageTimingTransport wraps the transport to the user container to pass the
+// time spent waiting for the user container's response to the request metrics
+// handler. It should wrap any retrying transport so that the upstream stage
+// covers all attempts.
+func StageTimingTransport(next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		state := requestMetricsStateFrom(r.Context())
+		state.markStage(markUpstreamSent)
+		resp, err := next.RoundTrip(r)
+		if err == nil {
+			state.markStage(markUpstreamResponded)
+		}
+		return resp, err
+	})
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	network "knative.dev/networking/pkg"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestRequestMetricsHandlerStageDurations(t *testing.T) {
+	defer reset()
+
+	// Every stage is delayed by a multiple of the unit, so that the stages
+	// can be told apart.
+	const unit = 50 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(3 * unit)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal("Failed to parse upstream URL:", err)
+	}
+
+	transport := StageTimingTransport(http.DefaultTransport)
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		out.URL, out.RequestURI = upstreamURL, ""
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		time.Sleep(4 * unit)
+		io.WriteString(w, "done")
+	})
+	// The breaker has no capacity until after the request arrived.
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, app)
+	middleware := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(unit)
+		time.AfterFunc(2*unit, func() { breaker.UpdateConcurrency(1) })
+		proxy.ServeHTTP(w, r)
+	})
+	h, err := NewRequestMetricsHandler(middleware, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithStageDurations())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+
+	metricstest.EnsureRecorded()
+	got := make(map[string]float64)
+	for _, v := range metricstest.GetOneMetric("request_stage_duration").Values {
+		if v.Distribution.Count != 1 {
+			t.Errorf("Stage %s recorded %d times, want once", v.Tags["stage"], v.Distribution.Count)
+		}
+		got[v.Tags["stage"]] = v.Distribution.Sum
+	}
+	for stage, factor := range map[string]int{
+		stagePreBreaker:    1,
+		stageQueueWait:     2,
+		stageUpstream:      3,
+		stageResponseWrite: 4,
+	} {
+		want := float64((time.Duration(factor) * unit).Milliseconds())
+		if d, ok := got[stage]; !ok {
+			t.Errorf("Stage %s wasn't recorded", stage)
+		} else if d < want || d >= want+float64(unit.Milliseconds()) {
+			t.Errorf("Stage %s = %vms, want in [%v, %v)ms", stage, d, want, want+float64(unit.Milliseconds()))
+		}
+	}
+}
+
+func TestRequestMetricsHandlerStageDurationsRejected(t *testing.T) {
+	defer reset()
+
+	breaker := NewBreaker(BreakerParams{QueueDepth: 1, MaxConcurrency: 1, InitialCapacity: 0})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithStageDurations())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	// A request timing out in the queue only spent time before the breaker.
+	req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Millisecond)
+	defer cancel()
+	h.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+	metricstest.EnsureRecorded()
+	values := metricstest.GetOneMetric("request_stage_duration").Values
+	if len(values) != 1 || values[0].Tags["stage"] != stagePreBreaker {
+		t.Errorf("Got stages %v, want only %s", values, stagePreBreaker)
+	}
+}
+
+func TestRequestMetricsHandlerPreAdmissionDeadlineFraction(t *testing.T) {
+	defer reset()
+
+	const deadline = 400 * time.Millisecond
+	breaker := NewBreaker(BreakerParams{QueueDepth: 2, MaxConcurrency: 1, InitialCapacity: 0})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next)
+	h, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil,
+		WithPreAdmissionDeadlineFraction())
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	serve := func(ctx context.Context) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil).WithContext(ctx))
+	}
+
+	// Both requests wait for half the deadline, but only the one with a
+	// deadline is recorded.
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	done := make(chan struct{}, 2)
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		go func(ctx context.Context) {
+			serve(ctx)
+			done <- struct{}{}
+		}(ctx)
+	}
+	for breaker.InFlight() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(deadline / 2)
+	breaker.UpdateConcurrency(1)
+	<-done
+	<-done
+
+	// A request admitted right away consumes next to nothing.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	serve(ctx)
+
+	metricstest.AssertMetricRequiredOnly(t, metricstest.DistributionCountOnlyMetric("pre_admission_deadline_fraction", 2, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+	}))
+	d := metricstest.GetOneMetric("pre_admission_deadline_fraction").Values[0].Distribution
+	if got := d.Buckets[0].Count; got != 1 {
+		t.Errorf("Requests below 1%% of the deadline = %d, want: 1", got)
+	}
+	if d.Sum < 0.5 || d.Sum >= 1 {
+		t.Errorf("Sum = %v, want in [0.5, 1)", d.Sum)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+)
+
+var _ http.Flusher = (*statusRewriteWriter)(nil)
+
+// StatusRewriteHandler replaces the status codes of responses according to
+// the given mapping, e.g. to return 503 instead of 502 so that clients retry.
+// Rewrites are counted by the request metrics handler by status code class.
+func StatusRewriteHandler(rewrites map[int]int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&statusRewriteWriter{ResponseWriter: w, r: r, rewrites: rewrites}, r)
+	})
+}
+
+// statusRewriteWriter rewrites the status code when the headers are written.
+type statusRewriteWriter struct {
+	http.ResponseWriter
+
+	r           *http.Request
+	rewrites    map[int]int
+	wroteHeader bool
+}
+
+func (w *statusRewriteWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if to, ok := w.rewrites[code]; ok && to != code {
+		if state := requestMetricsStateFrom(w.r.Context()); state != nil {
+			state.setStatusRewrite(code, to)
+		}
+		code = to
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRewriteWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush flushes the buffer to the client.
+func (w *statusRewriteWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestStatusRewriteHandler(t *testing.T) {
+	defer reset()
+
+	rewrites := map[int]int{
+		http.StatusBadGateway:     http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout: http.StatusServiceUnavailable,
+		http.StatusNotFound:       http.StatusOK,
+		http.StatusTeapot:         http.StatusTeapot,
+	}
+	var code int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code != 0 {
+			w.WriteHeader(code)
+		}
+		io.WriteString(w, "body")
+	})
+	h, err := NewRequestMetricsHandler(StatusRewriteHandler(rewrites, next), "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	for _, test := range []struct {
+		code, want int
+	}{
+		{code: http.StatusBadGateway, want: http.StatusServiceUnavailable},
+		{code: http.StatusGatewayTimeout, want: http.StatusServiceUnavailable},
+		{code: http.StatusNotFound, want: http.StatusOK},
+		// Identity mappings and unmapped codes aren't rewrites.
+		{code: http.StatusTeapot, want: http.StatusTeapot},
+		{code: http.StatusInternalServerError, want: http.StatusInternalServerError},
+		// Implicit status codes are subject to rewrites too.
+		{code: 0, want: http.StatusOK},
+	} {
+		code = test.code
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+		if rec.Code != test.want {
+			t.Errorf("Code for %d = %d, want: %d", test.code, rec.Code, test.want)
+		}
+		if got, want := rec.Body.String(), "body"; got != want {
+			t.Errorf("Body = %q, want: %q", got, want)
+		}
+	}
+
+	tags := func(from, to string) map[string]string {
+		return map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+			"from_class":               from,
+			"to_class":                 to,
+		}
+	}
+	metricstest.AssertMetricRequiredOnly(t,
+		metricstest.IntMetric("status_rewrite_count", 2, tags("5xx", "5xx")),
+		metricstest.IntMetric("status_rewrite_count", 1, tags("4xx", "2xx")))
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	pkgmetrics "knative.dev/pkg/metrics"
+	"knative.dev/serving/pkg/metrics"
+)
+
+var tagQueueSaturationRatioM = stats.Float64(
+	"tag_queue_saturation_ratio",
+	"The fraction of the queue of a route tag's breaker in use",
+	stats.UnitDimensionless)
+
+// TagBreakers partitions the breaker by route tag: requests for each tag of
+// an allowlist are limited by a breaker of their own, so that a backed up
+// tagged revision doesn't hold up the others. The allowlist bounds the number
+// of breakers and thereby the cardinality of the per-tag metrics.
+type TagBreakers struct {
+	queueDepth int
+	breakers   map[string]*Breaker
+}
+
+// NewTagBreakers creates a breaker with the given parameters for each of the
+// given route tags. The breakers track their concurrency for
+// SaturationRatios.
+func NewTagBreakers(params BreakerParams, tags []string) *TagBreakers {
+	params.TrackConcurrency = true
+	breakers := make(map[string]*Breaker, len(tags))
+	for _, t := range tags {
+		breakers[t] = NewBreaker(params)
+	}
+	return &TagBreakers{
+		queueDepth: params.QueueDepth,
+		breakers:   breakers,
+	}
+}
+
+// breakerFor returns the breaker dedicated to the request's route tag, or nil
+// if the tag isn't allowlisted.
+func (tb *TagBreakers) breakerFor(r *http.Request) *Breaker {
+	return tb.breakers[GetRouteTagNameFromRequest(r)]
+}
+
+// SaturationRatios returns the fraction of each tag's queue that is in use.
+func (tb *TagBreakers) SaturationRatios() map[string]float64 {
+	ret := make(map[string]float64, len(tb.breakers))
+	for t, b := range tb.breakers {
+		ret[t] = float64(b.Queued()) / float64(tb.queueDepth)
+	}
+	return ret
+}
+
+// TagQueueSaturationReporter records the queue saturation of TagBreakers per
+// route tag.
+type TagQueueSaturationReporter struct {
+	statsCtx context.Context
+	breakers *TagBreakers
+}
+
+// NewTagQueueSaturationReporter creates a TagQueueSaturationReporter
+// recording the tag_queue_saturation_ratio metric for the given revision.
+func NewTagQueueSaturationReporter(tb *TagBreakers, ns, service, config, rev, pod string) (*TagQueueSaturationReporter, error) {
+	if err := pkgmetrics.RegisterResourceView(&view.View{
+		Description: "The fraction of the queue of a route tag's breaker in use",
+		Measure:     tagQueueSaturationRatioM,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{metrics.PodKey, metrics.ContainerKey, metrics.RouteTagKey},
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, err := metrics.PodRevisionContext(pod, "queue-proxy", ns, service, config, rev, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &TagQueueSaturationReporter{
+		statsCtx: ctx,
+		breakers: tb,
+	}, nil
+}
+
+// Run records the saturation of every tag's queue every period until ctx is
+// done.
+func (r *TagQueueSaturationReporter) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report records the current saturation of every tag's queue.
+func (r *TagQueueSaturationReporter) report() {
+	for t, ratio := range r.breakers.SaturationRatios() {
+		ctx, _ := tag.New(r.statsCtx, tag.Upsert(metrics.RouteTagKey, t))
+		pkgmetrics.Record(ctx, tagQueueSaturationRatioM.M(ratio))
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	network "knative.dev/networking/pkg"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestTagQueueSaturation(t *testing.T) {
+	defer metricstest.Unregister(tagQueueSaturationRatioM.Name())
+
+	params := BreakerParams{QueueDepth: 4, MaxConcurrency: 1, InitialCapacity: 1}
+	breaker := NewBreaker(params)
+	tb := NewTagBreakers(params, []string{"blue", "green"})
+	reporter, err := NewTagQueueSaturationReporter(tb, "ns", "svc", "cfg", "rev", "pod")
+	if err != nil {
+		t.Fatal("Failed to create reporter:", err)
+	}
+
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	handler := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next,
+		WithTagBreakers(tb))
+
+	var wg sync.WaitGroup
+	serve := func(tag string, n int) {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+				req.Header.Set(network.TagHeaderName, tag)
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}()
+		}
+	}
+	// One request of each tag is in flight, the rest queues.
+	serve("blue", 3)
+	serve("green", 2)
+	// Tags outside the allowlist share the passed breaker.
+	serve("red", 4)
+
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return tb.breakers["blue"].InFlight() == 3 && tb.breakers["green"].InFlight() == 2 &&
+			breaker.InFlight() == 4, nil
+	}); err != nil {
+		t.Fatal("Requests never queued:", err)
+	}
+
+	reporter.report()
+	tags := func(tag string) map[string]string {
+		return map[string]string{
+			metrics.LabelPodName:       "pod",
+			metrics.LabelContainerName: "queue-proxy",
+			metrics.LabelRouteTag:      tag,
+		}
+	}
+	metricstest.AssertMetricRequiredOnly(t,
+		metricstest.FloatMetric("tag_queue_saturation_ratio", 0.5, tags("blue")),
+		metricstest.FloatMetric("tag_queue_saturation_ratio", 0.25, tags("green")))
+
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	// TagDrainPath is the admin path to drain and resume route tags on.
+	TagDrainPath = "/drain-tag"
+
+	// dropReasonTagDraining is the dropped_request_count reason for requests
+	// rejected because their route tag is draining.
+	dropReasonTagDraining = "tag_draining"
+)
+
+// TagDrain holds the route tags that are draining: new requests for them are
+// rejected, while the requests already admitted run to completion. Requests of
+// other tags aren't affected.
+type TagDrain struct {
+	mux      sync.RWMutex
+	draining map[string]struct{}
+}
+
+// NewTagDrain creates a TagDrain without any draining tags.
+func NewTagDrain() *TagDrain {
+	return &TagDrain{draining: make(map[string]struct{})}
+}
+
+// Drain stops the admission of requests for the given route tag.
+func (d *TagDrain) Drain(tag string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.draining[tag] = struct{}{}
+}
+
+// Resume admits requests for the given route tag again.
+func (d *TagDrain) Resume(tag string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	delete(d.draining, tag)
+}
+
+// Draining returns whether the given route tag is draining.
+func (d *TagDrain) Draining(tag string) bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	_, ok := d.draining[tag]
+	return ok
+}
+
+// Tags returns the draining route tags, sorted.
+func (d *TagDrain) Tags() []string {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	tags := make([]string, 0, len(d.draining))
+	for t := range d.draining {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// ServeHTTP drains the route tag given by the tag query parameter on POST and
+// resumes it on DELETE. The draining tags are returned as JSON.
+func (d *TagDrain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tag := r.URL.Query().Get("tag")
+		if tag == "" {
+			http.Error(w, "missing tag query parameter", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			d.Drain(tag)
+		case http.MethodDelete:
+			d.Resume(tag)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Tags())
+}
+
+// rejectTagDraining fails a request whose route tag is draining.
+func rejectTagDraining(w http.ResponseWriter, r *http.Request) {
+	recordDrop(r, dropReasonTagDraining)
+	http.Error(w, "route tag is draining", http.StatusServiceUnavailable)
+}
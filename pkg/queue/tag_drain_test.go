@@ -0,0 +1,136 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	network "knative.dev/networking/pkg"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestTagDrain(t *testing.T) {
+	defer reset()
+
+	// Requests block until released, those for the in-flight tag signal
+	// their admission.
+	release := make(chan struct{})
+	admitted := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(network.TagHeaderName) == "inflight" {
+			admitted <- struct{}{}
+			<-release
+		}
+	})
+	breaker := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1,
+		TrackConcurrency: true})
+	drain := NewTagDrain()
+	proxy := ProxyHandler(breaker, network.NewRequestStats(time.Now()), false /*tracingEnabled*/, next,
+		WithTagDrain(drain))
+	handler, err := NewRequestMetricsHandler(proxy, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	serve := func(tag string) int {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		req.Header.Set(network.TagHeaderName, tag)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// An in-flight request holds the only slot, a canary request queues
+	// behind it.
+	inFlightCode := make(chan int)
+	go func() { inFlightCode <- serve("inflight") }()
+	<-admitted
+	canaryCode := make(chan int)
+	go func() { canaryCode <- serve("canary") }()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) {
+		return breaker.Queued() == 1, nil
+	}); err != nil {
+		t.Fatal("Canary request never queued:", err)
+	}
+
+	// Draining the tags lets the in-flight request finish but rejects the
+	// queued one.
+	drain.Drain("canary")
+	drain.Drain("inflight")
+	close(release)
+	if got, want := <-inFlightCode, http.StatusOK; got != want {
+		t.Errorf("In-flight code = %d, want: %d", got, want)
+	}
+	if got, want := <-canaryCode, http.StatusServiceUnavailable; got != want {
+		t.Errorf("Queued canary code = %d, want: %d", got, want)
+	}
+
+	// New requests of a draining tag are rejected, others flow freely.
+	if got, want := serve("canary"), http.StatusServiceUnavailable; got != want {
+		t.Errorf("Canary code = %d, want: %d", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		if got, want := serve("stable"), http.StatusOK; got != want {
+			t.Errorf("Stable code = %d, want: %d", got, want)
+		}
+	}
+
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 2, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      "canary",
+		"reason":                   dropReasonTagDraining,
+	}))
+
+	// Resumed tags are admitted again.
+	drain.Resume("canary")
+	if got, want := serve("canary"), http.StatusOK; got != want {
+		t.Errorf("Resumed canary code = %d, want: %d", got, want)
+	}
+}
+
+func TestTagDrainServeHTTP(t *testing.T) {
+	drain := NewTagDrain()
+	serve := func(method, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		drain.ServeHTTP(rec, httptest.NewRequest(method, TagDrainPath+query, nil))
+		return rec
+	}
+
+	serve(http.MethodPost, "?tag=b")
+	serve(http.MethodPost, "?tag=a")
+	serve(http.MethodDelete, "?tag=b")
+	if got, want := strings.TrimSpace(serve(http.MethodGet, "").Body.String()), `["a"]`; got != want {
+		t.Errorf("Draining tags = %s, want: %s", got, want)
+	}
+	if !drain.Draining("a") || drain.Draining("b") {
+		t.Errorf("Draining(a), Draining(b) = %v, %v, want: true, false", drain.Draining("a"), drain.Draining("b"))
+	}
+
+	if got, want := serve(http.MethodPost, "").Code, http.StatusBadRequest; got != want {
+		t.Errorf("Code without tag = %d, want: %d", got, want)
+	}
+	if got, want := serve(http.MethodPut, "?tag=a").Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("PUT code = %d, want: %d", got, want)
+	}
+}
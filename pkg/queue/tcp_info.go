@@ -0,0 +1,33 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "net"
+
+// SocketInfoProvider reads statistics the platform keeps about TCP sockets.
+type SocketInfoProvider interface {
+	// Retransmits returns the number of segments retransmitted on the given
+	// connection since it was established. It returns false if the platform
+	// or the connection doesn't support it.
+	Retransmits(c net.Conn) (uint32, bool)
+}
+
+// NewSocketInfoProvider returns the SocketInfoProvider of the platform. On
+// platforms without TCP_INFO, it never returns any statistics.
+func NewSocketInfoProvider() SocketInfoProvider {
+	return tcpInfoProvider{}
+}
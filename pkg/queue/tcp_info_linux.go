@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// tcpInfoProvider reads the socket statistics from TCP_INFO.
+type tcpInfoProvider struct{}
+
+func (tcpInfoProvider) Retransmits(c net.Conn) (uint32, bool) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var (
+		info  syscall.TCPInfo
+		errno syscall.Errno
+	)
+	if err := raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(info))
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	}); err != nil || errno != 0 {
+		// E.g. not a TCP socket.
+		return 0, false
+	}
+	return info.Total_retrans, true
+}
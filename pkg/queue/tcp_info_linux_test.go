@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTCPInfoProvider(t *testing.T) {
+	server := httptest.NewServer(nil)
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal("Failed to dial:", err)
+	}
+	defer conn.Close()
+
+	p := NewSocketInfoProvider()
+	if _, ok := p.Retransmits(conn); !ok {
+		t.Error("Retransmits(TCP) failed")
+	}
+
+	// Connections other than TCP sockets degrade gracefully.
+	client, other := net.Pipe()
+	defer client.Close()
+	defer other.Close()
+	if _, ok := p.Retransmits(client); ok {
+		t.Error("Retransmits(pipe) succeeded")
+	}
+}
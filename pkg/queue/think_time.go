@@ -0,0 +1,107 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"k8s.io/apimachinery/pkg/util/clock"
+	network "knative.dev/networking/pkg"
+)
+
+// dropReasonTooFrequent is the dropped_request_count reason for requests
+// rejected by the think time handler.
+const dropReasonTooFrequent = "too_frequent"
+
+type thinkTimeHandler struct {
+	next      http.Handler
+	interval  time.Duration
+	keyHeader string
+	clock     clock.PassiveClock
+
+	// mux makes checking and updating a client's last admission atomic.
+	mux sync.Mutex
+	// lastAdmission maps client keys to the time they were last admitted.
+	lastAdmission *lru.Cache
+}
+
+// NewThinkTimeHandler returns an http.Handler that enforces a minimum interval
+// between admitted requests of the same client, rejecting requests arriving
+// too soon with a 429. Clients are identified by the value of keyHeader, or
+// by their IP if keyHeader is empty or missing. At most maxClients clients are
+// tracked, the least recently seen ones are forgotten.
+func NewThinkTimeHandler(next http.Handler, interval time.Duration, keyHeader string, maxClients int) (http.Handler, error) {
+	return newThinkTimeHandler(next, interval, keyHeader, maxClients, clock.RealClock{})
+}
+
+func newThinkTimeHandler(next http.Handler, interval time.Duration, keyHeader string, maxClients int, clock clock.PassiveClock) (*thinkTimeHandler, error) {
+	cache, err := lru.New(maxClients)
+	if err != nil {
+		return nil, err
+	}
+	return &thinkTimeHandler{
+		next:          next,
+		interval:      interval,
+		keyHeader:     keyHeader,
+		clock:         clock,
+		lastAdmission: cache,
+	}, nil
+}
+
+func (h *thinkTimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !network.IsKubeletProbe(r) && !h.admit(h.clientKey(r)) {
+		recordDrop(r, dropReasonTooFrequent)
+		http.Error(w, "too many requests from this client", http.StatusTooManyRequests)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// admit returns whether the client may be admitted now and records the
+// admission if so.
+func (h *thinkTimeHandler) admit(key string) bool {
+	now := h.clock.Now()
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if v, ok := h.lastAdmission.Get(key); ok && now.Sub(v.(time.Time)) < h.interval {
+		return false
+	}
+	h.lastAdmission.Add(key, now)
+	return true
+}
+
+// clientKey identifies the client sending the request.
+func (h *thinkTimeHandler) clientKey(r *http.Request) string {
+	if h.keyHeader != "" {
+		if key := r.Header.Get(h.keyHeader); key != "" {
+			return key
+		}
+	}
+	return clientIP(r)
+}
+
+// clientIP returns the IP of the client sending the request.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
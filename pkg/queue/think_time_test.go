@@ -0,0 +1,114 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestThinkTimeHandler(t *testing.T) {
+	defer reset()
+	const interval = time.Second
+
+	fc := clock.NewFakeClock(time.Now())
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h, err := newThinkTimeHandler(next, interval, "X-Client", 2, fc)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	handler, err := NewRequestMetricsHandler(h, "ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	serve := func(client, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, targetURI, nil)
+		req.RemoteAddr = remoteAddr
+		if client != "" {
+			req.Header.Set("X-Client", client)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert := func(got, want int) {
+		t.Helper()
+		if got != want {
+			t.Errorf("Code = %d, want: %d", got, want)
+		}
+	}
+
+	// Rapid fire from a single client is rejected.
+	assert(serve("a", "10.0.0.1:1234"), http.StatusOK)
+	assert(serve("a", "10.0.0.2:1234"), http.StatusTooManyRequests)
+	assert(serve("a", "10.0.0.3:1234"), http.StatusTooManyRequests)
+	// Other clients aren't affected, clients without a key are told apart
+	// by their IP.
+	assert(serve("b", "10.0.0.1:1234"), http.StatusOK)
+	assert(serve("", "10.0.0.4:1234"), http.StatusOK)
+	assert(serve("", "10.0.0.4:4321"), http.StatusTooManyRequests)
+
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 3, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      disabledTagName,
+		"reason":                   dropReasonTooFrequent,
+	}))
+
+	// Spaced requests are admitted.
+	fc.Step(interval)
+	assert(serve("a", "10.0.0.1:1234"), http.StatusOK)
+	fc.Step(interval - time.Millisecond)
+	assert(serve("a", "10.0.0.1:1234"), http.StatusTooManyRequests)
+	fc.Step(time.Millisecond)
+	assert(serve("a", "10.0.0.1:1234"), http.StatusOK)
+}
+
+func TestThinkTimeHandlerEviction(t *testing.T) {
+	fc := clock.NewFakeClock(time.Now())
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h, err := newThinkTimeHandler(next, time.Minute, "X-Client", 2, fc)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	for _, client := range []string{"a", "b", "c"} {
+		if !h.admit(client) {
+			t.Fatalf("Client %s wasn't admitted", client)
+		}
+	}
+	if got, want := h.lastAdmission.Len(), 2; got != want {
+		t.Errorf("Tracked clients = %d, want: %d", got, want)
+	}
+	// The least recently seen client was forgotten.
+	if !h.admit("a") {
+		t.Error("Evicted client a wasn't admitted")
+	}
+}
+
+func TestThinkTimeHandlerInvalidSize(t *testing.T) {
+	if _, err := NewThinkTimeHandler(nil /*next*/, time.Second, "", 0); err == nil {
+		t.Error("Expected an error for a zero sized cache")
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"knative.dev/pkg/websocket"
+)
+
+const (
+	// ServerTimingHeader is the response header carrying the queue wait and
+	// application time of a request, see https://www.w3.org/TR/server-timing/.
+	ServerTimingHeader = "Server-Timing"
+
+	// QueueWaitHeader is the response header carrying the time in milliseconds
+	// the request waited in the queue before being admitted.
+	QueueWaitHeader = "X-Estimated-Queue-Wait"
+)
+
+var (
+	_ http.Flusher  = (*timingWriter)(nil)
+	_ http.Hijacker = (*timingWriter)(nil)
+)
+
+// timingWriter adds the timing headers enabled in the proxy options to the
+// response right before the headers are written.
+type timingWriter struct {
+	http.ResponseWriter
+
+	serverTiming bool
+	queueWait    bool
+
+	start       time.Time
+	admitted    time.Time
+	wroteHeader bool
+	hijacked    bool
+}
+
+// newTimingWriter returns a timingWriter if any timing header is enabled in
+// the given options, or nil otherwise.
+func newTimingWriter(w http.ResponseWriter, o proxyOptions) *timingWriter {
+	if !o.serverTiming && !o.queueWait {
+		return nil
+	}
+	return &timingWriter{
+		ResponseWriter: w,
+		serverTiming:   o.serverTiming,
+		queueWait:      o.queueWait,
+		start:          time.Now(),
+	}
+}
+
+// admit marks the point in time the request was admitted by the breaker.
+// It's a no-op on a nil writer so callers don't have to check whether
+// timing headers are enabled.
+func (w *timingWriter) admit() {
+	if w != nil {
+		w.admitted = time.Now()
+	}
+}
+
+// finish makes sure the timing headers are sent even if the handler didn't
+// write anything. It's a no-op on a nil writer.
+func (w *timingWriter) finish() {
+	if w != nil && !w.wroteHeader && !w.hijacked {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.setHeader()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush flushes the buffer to the client.
+func (w *timingWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack calls Hijack() on the wrapped http.ResponseWriter if it implements
+// http.Hijacker interface.
+func (w *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := websocket.HijackIfPossible(w.ResponseWriter)
+	if err == nil {
+		w.hijacked = true
+	}
+	return c, rw, err
+}
+
+func (w *timingWriter) setHeader() {
+	now := time.Now()
+	var queue, app time.Duration
+	if w.admitted.IsZero() {
+		// The breaker rejected the request, all time was spent in the queue.
+		queue = now.Sub(w.start)
+	} else {
+		queue = w.admitted.Sub(w.start)
+		app = now.Sub(w.admitted)
+	}
+	if w.serverTiming {
+		w.Header().Add(ServerTimingHeader, serverTimingValue(queue, app))
+	}
+	if w.queueWait {
+		w.Header().Set(QueueWaitHeader, strconv.FormatFloat(durationMillis(queue), 'f', -1, 64))
+	}
+}
+
+// serverTimingValue formats the queue wait and application time as
+// Server-Timing metrics, e.g. "queue;dur=0.12, app;dur=52.3".
+func serverTimingValue(queue, app time.Duration) string {
+	b := make([]byte, 0, 32)
+	b = append(b, "queue;dur="...)
+	b = strconv.AppendFloat(b, durationMillis(queue), 'f', -1, 64)
+	b = append(b, ", app;dur="...)
+	b = strconv.AppendFloat(b, durationMillis(app), 'f', -1, 64)
+	return string(b)
+}
+
+// durationMillis returns d in milliseconds with microsecond precision.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
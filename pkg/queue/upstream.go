@@ -0,0 +1,143 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+
+	"go.uber.org/atomic"
+	network "knative.dev/networking/pkg"
+	pkgnet "knative.dev/pkg/network"
+)
+
+const dropReasonUpstreamDown = "upstream_down"
+
+// UpstreamTracker tracks whether the user container accepts connections.
+// After threshold consecutive refused connections the upstream is considered
+// down until a success, typically a passing readiness probe, is reported.
+type UpstreamTracker struct {
+	threshold int32
+	failures  atomic.Int32
+
+	// reset is set once a connection to the upstream was reset, which
+	// together with a following success hints at a restart.
+	reset atomic.Bool
+
+	// afterRestart is the number of requests to mark after a restart,
+	// pendingAfterRestart the number left to mark since the last one.
+	afterRestart        int32
+	pendingAfterRestart atomic.Int32
+}
+
+// NewUpstreamTracker creates an UpstreamTracker considering the upstream down
+// after threshold consecutive refused connections.
+func NewUpstreamTracker(threshold int) *UpstreamTracker {
+	return &UpstreamTracker{threshold: int32(threshold)}
+}
+
+// ReportFailure records a refused connection to the upstream.
+func (t *UpstreamTracker) ReportFailure() {
+	t.failures.Inc()
+}
+
+// MarkAfterRestart makes the tracker mark the first n requests reaching the
+// upstream after it came back from being down, or after a connection reset,
+// so that the request metrics handler counts them as served after a restart.
+// It must be called before the tracker is used.
+func (t *UpstreamTracker) MarkAfterRestart(n int) {
+	t.afterRestart = int32(n)
+}
+
+// ReportSuccess records that the upstream is reachable.
+func (t *UpstreamTracker) ReportSuccess() {
+	// Avoid contending on the cache line in the common case.
+	if t.failures.Load() != 0 && t.failures.Swap(0) >= t.threshold {
+		t.restarted()
+	}
+	if t.reset.Load() && t.reset.CAS(true, false) {
+		t.restarted()
+	}
+}
+
+// ReportReset records a connection to the upstream being reset.
+func (t *UpstreamTracker) ReportReset() {
+	if t.afterRestart > 0 {
+		t.reset.Store(true)
+	}
+}
+
+func (t *UpstreamTracker) restarted() {
+	if t.afterRestart > 0 {
+		t.pendingAfterRestart.Store(t.afterRestart)
+	}
+}
+
+// takeAfterRestart returns whether a request reaching the upstream is among
+// the first ones after a restart.
+func (t *UpstreamTracker) takeAfterRestart() bool {
+	for {
+		n := t.pendingAfterRestart.Load()
+		if n <= 0 {
+			return false
+		}
+		if t.pendingAfterRestart.CAS(n, n-1) {
+			return true
+		}
+	}
+}
+
+// Down returns whether the upstream is considered unreachable.
+func (t *UpstreamTracker) Down() bool {
+	return t.failures.Load() >= t.threshold
+}
+
+// Transport wraps the given transport to report the outcome of connecting to
+// the upstream to the tracker.
+func (t *UpstreamTracker) Transport(next http.RoundTripper) http.RoundTripper {
+	return pkgnet.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		switch {
+		case err == nil:
+			t.ReportSuccess()
+			if t.takeAfterRestart() {
+				if state := requestMetricsStateFrom(r.Context()); state != nil {
+					state.setAfterRestart()
+				}
+			}
+		case errors.Is(err, syscall.ECONNREFUSED):
+			t.ReportFailure()
+		case errors.Is(err, syscall.ECONNRESET):
+			t.ReportReset()
+		}
+		return resp, err
+	})
+}
+
+// UpstreamDownHandler fails requests with 503 without queueing them while the
+// tracker considers the upstream down.
+func UpstreamDownHandler(t *UpstreamTracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.Down() && !network.IsKubeletProbe(r) {
+			recordDrop(r, dropReasonUpstreamDown)
+			http.Error(w, "user container is not accepting connections", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
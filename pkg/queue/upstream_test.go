@@ -0,0 +1,195 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+
+	"go.uber.org/atomic"
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestUpstreamDownHandler(t *testing.T) {
+	defer reset()
+
+	// Reserve a port nobody listens on to get connections refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	target := atomic.NewString(closedAddr)
+	tracker := NewUpstreamTracker(2)
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = target.Load()
+		},
+		Transport: tracker.Transport(http.DefaultTransport),
+		ErrorLog:  log.New(ioutil.Discard, "", 0),
+	}
+	handler, err := NewRequestMetricsHandler(UpstreamDownHandler(tracker, proxy),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, targetURI, nil))
+		return rec.Code
+	}
+
+	// Refused connections are proxied until the threshold is reached.
+	for i := 0; i < 2; i++ {
+		if got, want := serve(), http.StatusBadGateway; got != want {
+			t.Errorf("Code = %d, want: %d", got, want)
+		}
+	}
+	metricstest.AssertNoMetric(t, "dropped_request_count")
+
+	// Then requests fail fast, even though the upstream came back.
+	target.Store(upstream.Listener.Addr().String())
+	if got, want := serve(), http.StatusServiceUnavailable; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+		metrics.LabelPodName:       "pod",
+		metrics.LabelContainerName: "queue-proxy",
+		metrics.LabelRouteTag:      disabledTagName,
+		"reason":                   dropReasonUpstreamDown,
+	}))
+
+	// Once a probe succeeds, requests are proxied again.
+	tracker.ReportSuccess()
+	if got, want := serve(), http.StatusOK; got != want {
+		t.Errorf("Code = %d, want: %d", got, want)
+	}
+}
+
+func TestUpstreamTracker(t *testing.T) {
+	tracker := NewUpstreamTracker(2)
+	tracker.ReportFailure()
+	if tracker.Down() {
+		t.Error("Down() = true after a single failure")
+	}
+	tracker.ReportFailure()
+	if !tracker.Down() {
+		t.Error("Down() = false after reaching the threshold")
+	}
+	tracker.ReportSuccess()
+	if tracker.Down() {
+		t.Error("Down() = true after a success")
+	}
+
+	// Failures need to be consecutive.
+	tracker.ReportFailure()
+	tracker.ReportSuccess()
+	tracker.ReportFailure()
+	if tracker.Down() {
+		t.Error("Down() = true after non-consecutive failures")
+	}
+}
+
+func TestUpstreamTrackerAfterRestart(t *testing.T) {
+	defer reset()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	target := atomic.NewString(upstream.Listener.Addr().String())
+	tracker := NewUpstreamTracker(2)
+	tracker.MarkAfterRestart(2)
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = target.Load()
+		},
+		Transport: tracker.Transport(http.DefaultTransport),
+		ErrorLog:  log.New(ioutil.Discard, "", 0),
+	}
+	handler, err := NewRequestMetricsHandler(UpstreamDownHandler(tracker, proxy),
+		"ns", "svc", "cfg", "rev", "pod", nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create handler:", err)
+	}
+	serve := func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, targetURI, nil))
+	}
+
+	// Requests before any restart aren't marked.
+	serve()
+	metricstest.AssertNoMetric(t, "after_restart_request_count")
+
+	// The upstream goes down and comes back, as reported by a probe.
+	target.Store(closedAddr)
+	serve()
+	serve()
+	target.Store(upstream.Listener.Addr().String())
+	tracker.ReportSuccess()
+
+	// Only the first requests afterwards are marked.
+	for i := 0; i < 3; i++ {
+		serve()
+	}
+	metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("after_restart_request_count", 2, map[string]string{
+		metrics.LabelPodName:           "pod",
+		metrics.LabelContainerName:     "queue-proxy",
+		metrics.LabelResponseCode:      "200",
+		metrics.LabelResponseCodeClass: "2xx",
+		metrics.LabelRouteTag:          disabledTagName,
+	}))
+}
+
+func TestUpstreamTrackerReset(t *testing.T) {
+	tracker := NewUpstreamTracker(2)
+	tracker.MarkAfterRestart(1)
+
+	tracker.ReportSuccess()
+	if tracker.takeAfterRestart() {
+		t.Error("takeAfterRestart() = true without a restart")
+	}
+
+	// A reset followed by a success is taken as a restart.
+	tracker.ReportReset()
+	tracker.ReportSuccess()
+	if !tracker.takeAfterRestart() {
+		t.Error("takeAfterRestart() = false after a reset")
+	}
+	if tracker.takeAfterRestart() {
+		t.Error("takeAfterRestart() = true beyond the marked requests")
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+)
+
+// dropReasonURITooLong is the dropped_request_count reason for requests
+// rejected by the URILengthLimitHandler.
+const dropReasonURITooLong = "uri_too_long"
+
+// URILengthLimitHandler rejects requests whose request-URI, i.e. the path
+// including the query string, is longer than maxLength bytes with a 414.
+func URILengthLimitHandler(maxLength int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.RequestURI()) > maxLength {
+			recordDrop(r, dropReasonURITooLong)
+			http.Error(w, http.StatusText(http.StatusRequestURITooLong), http.StatusRequestURITooLong)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
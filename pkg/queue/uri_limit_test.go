@@ -0,0 +1,87 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"knative.dev/pkg/metrics/metricstest"
+	"knative.dev/serving/pkg/metrics"
+)
+
+func TestURILengthLimitHandler(t *testing.T) {
+	const maxLength = 20
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+	}{{
+		name:     "under limit",
+		target:   "http://example.com/short",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "at limit",
+		target:   "http://example.com/" + strings.Repeat("a", maxLength-1),
+		wantCode: http.StatusOK,
+	}, {
+		name:     "long host",
+		target:   "http://" + strings.Repeat("a", maxLength) + ".com/",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "long path",
+		target:   "http://example.com/" + strings.Repeat("a", maxLength),
+		wantCode: http.StatusRequestURITooLong,
+	}, {
+		name:     "long query string",
+		target:   "http://example.com/short?q=" + strings.Repeat("a", maxLength),
+		wantCode: http.StatusRequestURITooLong,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer reset()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			handler, err := NewRequestMetricsHandler(URILengthLimitHandler(maxLength, next),
+				"ns", "svc", "cfg", "rev", "pod", nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create handler:", err)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if rec.Code != test.wantCode {
+				t.Errorf("Code = %d, want: %d", rec.Code, test.wantCode)
+			}
+
+			if test.wantCode == http.StatusOK {
+				metricstest.AssertNoMetric(t, "dropped_request_count")
+				return
+			}
+			metricstest.AssertMetricRequiredOnly(t, metricstest.IntMetric("dropped_request_count", 1, map[string]string{
+				metrics.LabelPodName:       "pod",
+				metrics.LabelContainerName: "queue-proxy",
+				metrics.LabelRouteTag:      disabledTagName,
+				"reason":                   dropReasonURITooLong,
+			}))
+		})
+	}
+}
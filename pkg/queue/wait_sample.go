@@ -0,0 +1,79 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// waitSample keeps the most recent waits for admission in a ring buffer of
+// fixed size.
+type waitSample struct {
+	mux   sync.Mutex
+	waits []time.Duration
+	next  int
+	full  bool
+}
+
+func newWaitSample(size int) *waitSample {
+	return &waitSample{waits: make([]time.Duration, size)}
+}
+
+// add records a wait, replacing the oldest one if the sample is full.
+func (s *waitSample) add(wait time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.waits[s.next] = wait
+	s.next++
+	if s.next == len(s.waits) {
+		s.next = 0
+		s.full = true
+	}
+}
+
+// percentiles returns the nearest-rank percentiles ps, each in [0, 1], of the
+// sampled waits, or zeros if there are none.
+func (s *waitSample) percentiles(ps ...float64) []time.Duration {
+	s.mux.Lock()
+	n := s.next
+	if s.full {
+		n = len(s.waits)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, s.waits[:n])
+	s.mux.Unlock()
+
+	ret := make([]time.Duration, len(ps))
+	if n == 0 {
+		return ret
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	for i, p := range ps {
+		rank := int(p*float64(n)+0.5) - 1
+		if rank < 0 {
+			rank = 0
+		} else if rank >= n {
+			rank = n - 1
+		}
+		ret[i] = sorted[rank]
+	}
+	return ret
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2026 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWaitSamplePercentiles(t *testing.T) {
+	s := newWaitSample(1000)
+	if got := s.percentiles(0.5); got[0] != 0 {
+		t.Errorf("Percentile of an empty sample = %v, want: 0", got[0])
+	}
+
+	// Waits of 1ms to 1000ms in random order.
+	for _, i := range rand.Perm(1000) {
+		s.add(time.Duration(i+1) * time.Millisecond)
+	}
+	got := s.percentiles(0.5, 0.9, 0.99, 1)
+	want := []time.Duration{500 * time.Millisecond, 900 * time.Millisecond, 990 * time.Millisecond, time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Percentile %d = %v, want: %v", i, got[i], want[i])
+		}
+	}
+
+	// Only the most recent waits are kept.
+	for i := 0; i < 1000; i++ {
+		s.add(time.Millisecond)
+	}
+	if got := s.percentiles(1); got[0] != time.Millisecond {
+		t.Errorf("Max after overwriting = %v, want: %v", got[0], time.Millisecond)
+	}
+}
+
+func TestBreakerWaitPercentiles(t *testing.T) {
+	b := NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1})
+	if p50, p90, p99 := b.WaitPercentiles(); p50 != 0 || p90 != 0 || p99 != 0 {
+		t.Errorf("WaitPercentiles() without a sample = %v, %v, %v, want zeros", p50, p90, p99)
+	}
+
+	b = NewBreaker(BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1, WaitSampleSize: 10})
+	// Nine requests are admitted right away, one waits for the first.
+	const hold = 50 * time.Millisecond
+	entered := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Maybe(context.Background(), func() {
+			close(entered)
+			time.Sleep(hold)
+		})
+	}()
+	<-entered
+	b.Maybe(context.Background(), func() {})
+	<-done
+	for i := 0; i < 8; i++ {
+		b.Maybe(context.Background(), func() {})
+	}
+
+	p50, p90, p99 := b.WaitPercentiles()
+	const tolerance = 10 * time.Millisecond
+	if p50 > tolerance || p90 > tolerance {
+		t.Errorf("p50, p90 = %v, %v, want at most %v", p50, p90, tolerance)
+	}
+	if p99 < hold-tolerance {
+		t.Errorf("p99 = %v, want at least %v", p99, hold-tolerance)
+	}
+}
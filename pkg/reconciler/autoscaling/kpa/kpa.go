@@ -234,6 +234,16 @@ func reportMetrics(pa *autoscalingv1alpha1.PodAutoscaler, pc podCounts) {
 	pkgmetrics.RecordBatch(ctx, stats...)
 }
 
+// reportDryRun records the scale the autoscaler would have applied to the PA's
+// scale target if it was not in dry run.
+func reportDryRun(pa *autoscalingv1alpha1.PodAutoscaler, desiredScale int32) {
+	serviceLabel := pa.Labels[serving.ServiceLabelKey] // This might be empty.
+	configLabel := pa.Labels[serving.ConfigurationLabelKey]
+
+	ctx := metrics.RevisionContext(pa.Namespace, serviceLabel, configLabel, pa.Name, pa.GetAnnotations(), pa.GetLabels())
+	pkgmetrics.Record(ctx, dryRunDesiredPodCountM.M(int64(desiredScale)))
+}
+
 // computeActiveCondition updates the status of a PA given the current scale (got), desired scale (want)
 // active threshold (min), and the current status, as per the following table:
 //
@@ -1824,6 +1824,22 @@ func TestMetricsReporter(t *testing.T) {
 	metricstest.AssertMetricRequiredOnly(t, wantMetrics...)
 }
 
+func TestDryRunMetricsReporter(t *testing.T) {
+	pa := kpa(testNamespace, testRevision)
+	wantResource := &resource.Resource{
+		Type: "knative_revision",
+		Labels: map[string]string{
+			metrics.LabelRevisionName:      testRevision,
+			metrics.LabelNamespaceName:     testNamespace,
+			metrics.LabelServiceName:       pa.Labels[serving.ServiceLabelKey],
+			metrics.LabelConfigurationName: pa.Labels[serving.ConfigurationLabelKey],
+		},
+	}
+	reportDryRun(pa, 42)
+	metricstest.AssertMetricRequiredOnly(t,
+		metricstest.IntMetric("autoscaler_dry_run_desired_pods", 42, nil).WithResource(wantResource))
+}
+
 func TestResolveScrapeTarget(t *testing.T) {
 	pa := kpa(testNamespace, testRevision, WithPAMetricsService("echo"))
 	tc := &testConfigStore{config: defaultConfig()}
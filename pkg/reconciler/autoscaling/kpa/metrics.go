@@ -44,6 +44,10 @@ var (
 		"terminating_pods",
 		"Number of pods that are terminating currently",
 		stats.UnitDimensionless)
+	dryRunDesiredPodCountM = stats.Int64(
+		"autoscaler_dry_run_desired_pods",
+		"Number of pods autoscaler would request from Kubernetes if it was not in dry run",
+		stats.UnitDimensionless)
 )
 
 func init() {
@@ -80,6 +84,11 @@ func register() {
 			Measure:     terminatingPodCountM,
 			Aggregation: view.LastValue(),
 		},
+		&view.View{
+			Description: "Number of pods autoscaler would request from Kubernetes if it was not in dry run",
+			Measure:     dryRunDesiredPodCountM,
+			Aggregation: view.LastValue(),
+		},
 	); err != nil {
 		panic(err)
 	}
@@ -66,6 +66,11 @@ func MakeDecider(pa *autoscalingv1alpha1.PodAutoscaler, config *autoscalerconfig
 		scaleDownDelay = sdd
 	}
 
+	minScale, _ := pa.ScaleBounds(config)
+	warmFloor, _ := pa.WarmFloor()
+	warmFloorCooldown, _ := pa.WarmFloorCooldown()
+	warmFloorDecay, _ := pa.WarmFloorDecay()
+
 	return &scaling.Decider{
 		ObjectMeta: *pa.ObjectMeta.DeepCopy(),
 		Spec: scaling.DeciderSpec{
@@ -81,6 +86,10 @@ func MakeDecider(pa *autoscalingv1alpha1.PodAutoscaler, config *autoscalerconfig
 			ScaleDownDelay:      scaleDownDelay,
 			InitialScale:        GetInitialScale(config, pa),
 			Reachable:           pa.Spec.Reachability != autoscalingv1alpha1.ReachabilityUnreachable,
+			MinScale:            minScale,
+			WarmFloor:           warmFloor,
+			WarmFloorCooldown:   warmFloorCooldown,
+			WarmFloorDecay:      warmFloorDecay,
 		},
 	}
 }
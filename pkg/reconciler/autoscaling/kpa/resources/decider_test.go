@@ -151,6 +151,22 @@ func TestMakeDecider(t *testing.T) {
 			return &c
 		},
 		want: decider(withTarget(100.0), withPanicThreshold(2.0), withTotal(100), withScaleDownDelay(10*time.Minute), withDeciderScaleDownDelayAnnotation("10m")),
+	}, {
+		name: "with warm floor",
+		pa: pa(func(pa *v1alpha1.PodAutoscaler) {
+			pa.Annotations[autoscaling.MinScaleAnnotationKey] = "1"
+			pa.Annotations[autoscaling.WarmFloorAnnotationKey] = "5"
+			pa.Annotations[autoscaling.WarmFloorCooldownAnnotationKey] = "10m"
+		}),
+		want: decider(withTarget(100.0), withPanicThreshold(2.0), withTotal(100),
+			func(d *scaling.Decider) {
+				d.Spec.MinScale = 1
+				d.Spec.WarmFloor = 5
+				d.Spec.WarmFloorCooldown = 10 * time.Minute
+				d.Annotations[autoscaling.MinScaleAnnotationKey] = "1"
+				d.Annotations[autoscaling.WarmFloorAnnotationKey] = "5"
+				d.Annotations[autoscaling.WarmFloorCooldownAnnotationKey] = "10m"
+			}),
 	}, {
 		name: "with initial scale",
 		pa: pa(func(pa *v1alpha1.PodAutoscaler) {
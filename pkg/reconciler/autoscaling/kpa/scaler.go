@@ -343,6 +343,10 @@ func (ks *scaler) scale(ctx context.Context, pa *autoscalingv1alpha1.PodAutoscal
 		desiredScale = newScale
 	}
 
+	if pa.DryRun() {
+		return ks.dryRun(ctx, pa, desiredScale)
+	}
+
 	desiredScale, shouldApplyScale := ks.handleScaleToZero(ctx, pa, sks, desiredScale)
 	if !shouldApplyScale {
 		return desiredScale, nil
@@ -364,3 +368,20 @@ func (ks *scaler) scale(ctx context.Context, pa *autoscalingv1alpha1.PodAutoscal
 	logger.Infof("Scaling from %d to %d", currentScale, desiredScale)
 	return desiredScale, ks.applyScale(ctx, pa, desiredScale, ps)
 }
+
+// dryRun reports the desired scale of a PA in dry run and keeps its scale
+// target at the current scale instead of applying it.
+func (ks *scaler) dryRun(ctx context.Context, pa *autoscalingv1alpha1.PodAutoscaler, desiredScale int32) (int32, error) {
+	ps, err := resources.GetScaleResource(pa.Namespace, pa.Spec.ScaleTargetRef, ks.listerFactory)
+	if err != nil {
+		return desiredScale, fmt.Errorf("failed to get scale target %v: %w", pa.Spec.ScaleTargetRef, err)
+	}
+
+	currentScale := int32(1)
+	if ps.Spec.Replicas != nil {
+		currentScale = *ps.Spec.Replicas
+	}
+	reportDryRun(pa, desiredScale)
+	logging.FromContext(ctx).Infof("Dry run: would scale from %d to %d", currentScale, desiredScale)
+	return currentScale, nil
+}
@@ -367,6 +367,20 @@ func TestScaler(t *testing.T) {
 			paMarkInactive(k, time.Now().Add(-gracePeriod))
 			WithReachabilityUnknown(k)
 		},
+	}, {
+		// The decider asks for no pods for an idle revision scaling on RPS,
+		// the scaler holds it at its minimum scale as for concurrency.
+		label:         "idle RPS revision is held at minScale",
+		startReplicas: 3,
+		scaleTo:       0,
+		minScale:      2,
+		wantReplicas:  2,
+		wantScaling:   true,
+		paMutation: func(k *autoscalingv1alpha1.PodAutoscaler) {
+			paMarkActive(k, time.Now())
+			WithReachabilityReachable(k)
+			WithMetricAnnotation(autoscaling.RPS)(k)
+		},
 	}, {
 		label:         "scales up",
 		startReplicas: 1,
@@ -431,6 +445,17 @@ func TestScaler(t *testing.T) {
 			k.Status.MarkScaleTargetInitialized()
 			k.Annotations[autoscaling.InitialScaleAnnotationKey] = "2"
 		},
+	}, {
+		label:         "initial scale attained, not re-applied to the desired scale",
+		startReplicas: 5,
+		scaleTo:       3,
+		wantReplicas:  3,
+		wantScaling:   true,
+		paMutation: func(k *autoscalingv1alpha1.PodAutoscaler) {
+			paMarkActive(k, time.Now())
+			k.Status.MarkScaleTargetInitialized()
+			k.Annotations[autoscaling.InitialScaleAnnotationKey] = "5"
+		},
 	}, {
 		label:         "haven't scaled to initial scale, override desired scale with initial scale",
 		startReplicas: 0,
@@ -465,6 +490,26 @@ func TestScaler(t *testing.T) {
 		configMutator: func(c *config.Config) {
 			c.Autoscaler.AllowZeroInitialScale = true
 		},
+	}, {
+		label:         "dry run does not scale up",
+		startReplicas: 1,
+		scaleTo:       5,
+		wantReplicas:  1,
+		wantScaling:   false,
+		paMutation: func(k *autoscalingv1alpha1.PodAutoscaler) {
+			paMarkActive(k, time.Now())
+			k.Annotations[autoscaling.DryRunAnnotationKey] = "true"
+		},
+	}, {
+		label:         "dry run does not scale to zero",
+		startReplicas: 1,
+		scaleTo:       0,
+		wantReplicas:  1,
+		wantScaling:   false,
+		paMutation: func(k *autoscalingv1alpha1.PodAutoscaler) {
+			paMarkActive(k, time.Now().Add(-gracePeriod))
+			k.Annotations[autoscaling.DryRunAnnotationKey] = "true"
+		},
 	}}
 
 	for _, test := range tests {
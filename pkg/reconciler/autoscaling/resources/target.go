@@ -55,6 +55,13 @@ func ResolveMetricTarget(pa *v1alpha1.PodAutoscaler, config *autoscalerconfig.Co
 			total = math.Min(annotationTarget, float64(pa.Spec.ContainerConcurrency))
 		}
 	}
+	// The RPS target annotation wins over the generic one, so that a revision
+	// can carry targets for both metrics.
+	if pa.Metric() == autoscaling.RPS {
+		if rpsTarget, ok := pa.TargetRPS(); ok {
+			total = rpsTarget
+		}
+	}
 
 	if v, ok := pa.TargetUtilization(); ok {
 		tu = v
@@ -170,6 +170,22 @@ func TestResolveMetricTarget(t *testing.T) {
 		pa:         pa(WithMetricAnnotation(autoscaling.RPS), WithTargetAnnotation("300")),
 		wantTarget: 210,
 		wantTotal:  300,
+	}, {
+		name:       "RPS: with RPS target annotation",
+		pa:         pa(WithMetricAnnotation(autoscaling.RPS), WithTargetRPSAnnotation("100")),
+		wantTarget: 70,
+		wantTotal:  100,
+	}, {
+		name: "RPS: RPS target annotation wins over target annotation",
+		pa: pa(WithMetricAnnotation(autoscaling.RPS), WithTargetAnnotation("10"),
+			WithTargetRPSAnnotation("100"), WithTUAnnotation("50")),
+		wantTarget: 50,
+		wantTotal:  100,
+	}, {
+		name:       "concurrency: RPS target annotation is ignored",
+		pa:         pa(WithTargetAnnotation("10"), WithTargetRPSAnnotation("100")),
+		wantTarget: 10,
+		wantTotal:  10,
 	}}
 
 	for _, tc := range cases {
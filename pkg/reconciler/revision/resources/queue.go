@@ -133,6 +133,33 @@ func createQueueResources(cfg *deployment.Config, annotations map[string]string,
 		}
 	}
 
+	for _, r := range []struct {
+		Name    corev1.ResourceName
+		Request string
+		Limit   string
+	}{{
+		Name:    corev1.ResourceCPU,
+		Request: serving.QueueSideCarCPURequestAnnotation,
+		Limit:   serving.QueueSideCarCPULimitAnnotation,
+	}, {
+		Name:    corev1.ResourceMemory,
+		Request: serving.QueueSideCarMemoryRequestAnnotation,
+		Limit:   serving.QueueSideCarMemoryLimitAnnotation,
+	}} {
+		request, hasRequest := quantityFromAnnotation(annotations, r.Request)
+		if hasRequest {
+			resourceRequests[r.Name] = request
+		}
+		if q, ok := quantityFromAnnotation(annotations, r.Limit); ok {
+			resourceLimits[r.Name] = q
+			// A limit below the default request lowers the request with it,
+			// as the pod would be invalid otherwise.
+			if current, ok := resourceRequests[r.Name]; !hasRequest && ok && current.Cmp(q) > 0 {
+				resourceRequests[r.Name] = q
+			}
+		}
+	}
+
 	resources := corev1.ResourceRequirements{
 		Requests: resourceRequests,
 	}
@@ -143,6 +170,20 @@ func createQueueResources(cfg *deployment.Config, annotations map[string]string,
 	return resources
 }
 
+// quantityFromAnnotation returns the resource quantity of the given
+// annotation, if it's set and valid.
+func quantityFromAnnotation(annotations map[string]string, key string) (resource.Quantity, bool) {
+	v, ok := annotations[key]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	q, err := resource.ParseQuantity(v)
+	if err != nil || q.Sign() < 0 {
+		return resource.Quantity{}, false
+	}
+	return q, true
+}
+
 func computeResourceRequirements(resourceQuantity *resource.Quantity, fraction float64, boundary resourceBoundary) (bool, resource.Quantity) {
 	if resourceQuantity.IsZero() {
 		return false, resource.Quantity{}
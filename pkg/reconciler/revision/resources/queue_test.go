@@ -483,6 +483,119 @@ func TestMakeQueueContainerWithPercentageAnnotation(t *testing.T) {
 				corev1.ResourceCPU: resource.MustParse("25m"),
 			}
 		}),
+	}, {
+		name: "resource quantities in annotations",
+		rev: revision("bar", "foo",
+			func(revision *v1.Revision) {
+				revision.Annotations = map[string]string{
+					serving.QueueSideCarCPURequestAnnotation:         "500m",
+					serving.QueueSideCarCPULimitAnnotation:           "2",
+					serving.QueueSideCarMemoryLimitAnnotation:        "256Mi",
+					serving.QueueSideCarResourcePercentageAnnotation: "20",
+				}
+				revision.Spec.PodSpec.Containers = []corev1.Container{{
+					Name:           servingContainerName,
+					ReadinessProbe: testProbe,
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceMemory: resource.MustParse("2Gi"),
+							corev1.ResourceCPU:    resource.MustParse("2"),
+						},
+					},
+				}}
+			}),
+		dc: deployment.Config{
+			ProgressDeadline:          5678 * time.Second,
+			QueueSidecarCPURequest:    resourcePtr(resource.MustParse("25m")),
+			QueueSidecarMemoryRequest: resourcePtr(resource.MustParse("50Mi")),
+		},
+		want: queueContainer(func(c *corev1.Container) {
+			c.Env = env(map[string]string{})
+			// The quantities take precedence over the percentage and the
+			// config, which still applies to the rest.
+			c.Resources.Requests = corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("50Mi"),
+			}
+			c.Resources.Limits = corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("256Mi"),
+			}
+		}),
+	}, {
+		name: "limit in annotations below the default request",
+		rev: revision("bar", "foo",
+			func(revision *v1.Revision) {
+				revision.Annotations = map[string]string{
+					serving.QueueSideCarCPULimitAnnotation: "10m",
+				}
+				revision.Spec.PodSpec.Containers = []corev1.Container{{
+					Name:           servingContainerName,
+					ReadinessProbe: testProbe,
+				}}
+			}),
+		dc: deployment.Config{
+			ProgressDeadline:          5678 * time.Second,
+			QueueSidecarCPURequest:    resourcePtr(resource.MustParse("25m")),
+			QueueSidecarMemoryRequest: resourcePtr(resource.MustParse("50Mi")),
+		},
+		want: queueContainer(func(c *corev1.Container) {
+			c.Env = env(map[string]string{})
+			c.Resources.Requests = corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("50Mi"),
+			}
+			c.Resources.Limits = corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("10m"),
+			}
+		}),
+	}, {
+		name: "limit in annotations above the default request",
+		rev: revision("bar", "foo",
+			func(revision *v1.Revision) {
+				revision.Annotations = map[string]string{
+					serving.QueueSideCarCPULimitAnnotation: "100m",
+				}
+				revision.Spec.PodSpec.Containers = []corev1.Container{{
+					Name:           servingContainerName,
+					ReadinessProbe: testProbe,
+				}}
+			}),
+		dc: deployment.Config{
+			ProgressDeadline:       5678 * time.Second,
+			QueueSidecarCPURequest: resourcePtr(resource.MustParse("25m")),
+		},
+		want: queueContainer(func(c *corev1.Container) {
+			c.Env = env(map[string]string{})
+			c.Resources.Requests = corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("25m"),
+			}
+			c.Resources.Limits = corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("100m"),
+			}
+		}),
+	}, {
+		name: "invalid resource quantity in annotations uses defaults",
+		rev: revision("bar", "foo",
+			func(revision *v1.Revision) {
+				revision.Annotations = map[string]string{
+					serving.QueueSideCarCPURequestAnnotation: "lots",
+				}
+				revision.Spec.PodSpec.Containers = []corev1.Container{{
+					Name:           servingContainerName,
+					ReadinessProbe: testProbe,
+				}}
+			}),
+		dc: deployment.Config{
+			ProgressDeadline:       5678 * time.Second,
+			QueueSidecarCPURequest: resourcePtr(resource.MustParse("25m")),
+		},
+		want: queueContainer(func(c *corev1.Container) {
+			c.Env = env(map[string]string{})
+			c.Resources.Requests = corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("25m"),
+			}
+		}),
 	}, {
 		name: "resources percentage in annotations bigger than than math.MaxInt64",
 		rev: revision("bar", "foo",
@@ -170,6 +170,13 @@ func WithTargetAnnotation(target string) PodAutoscalerOption {
 	return withAnnotationValue(autoscaling.TargetAnnotationKey, target)
 }
 
+// WithTargetRPSAnnotation returns a PodAutoscalerOption which sets
+// the PodAutoscaler autoscaling.knative.dev/targetRPS annotation to the
+// provided value.
+func WithTargetRPSAnnotation(target string) PodAutoscalerOption {
+	return withAnnotationValue(autoscaling.TargetRPSAnnotationKey, target)
+}
+
 // WithTUAnnotation returns a PodAutoscalerOption which sets
 // the PodAutoscaler autoscaling.knative.dev/targetUtilizationPercentage
 // annotation to the provided value.